@@ -1,8 +1,13 @@
 #!/bin/bash
 
-go get github.com/dchest/uniuri
-go get github.com/go-redis/redis
-go get github.com/google/jsonapi
+# dependencies are pinned in go.mod and go.sum
+go mod download
 
-CGO_ENABLED=0 GOOS=linux go build -a -installsuffix cgo -o main .
+VERSION=$(git describe --tags --always --dirty 2>/dev/null || echo dev)
+COMMIT=$(git rev-parse --short HEAD 2>/dev/null || echo unknown)
+BUILD_DATE=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+CONFIG=github.com/tyrm/ph-weather/internal/config
 
+CGO_ENABLED=0 GOOS=linux go build -a -installsuffix cgo \
+  -ldflags "-X ${CONFIG}.Version=${VERSION} -X ${CONFIG}.Commit=${COMMIT} -X ${CONFIG}.BuildDate=${BUILD_DATE}" \
+  -o main .
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/cache"
+	"github.com/tyrm/ph-weather/internal/config"
+)
+
+// withAdminToken requires the configured ADMIN_TOKEN as a bearer token
+func (env *Env) withAdminToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(response http.ResponseWriter, request *http.Request) {
+		token := strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(env.config().AdminToken)) != 1 {
+			makeErrorResponse(response, 401, "a valid admin token is required", 0)
+			return
+		}
+		next(response, request)
+	}
+}
+
+// adminHandler wraps an admin operation with authentication and the admin rate limit
+func (env *Env) adminHandler(handler http.HandlerFunc) http.HandlerFunc {
+	return env.withRateLimit("admin", env.withAdminToken(handler))
+}
+
+// adminWriteHandler is adminHandler for operations that change state, whose
+// bodies must be JSON:API
+func (env *Env) adminWriteHandler(handler http.HandlerFunc) http.HandlerFunc {
+	return env.adminHandler(withJSONAPIContentType(handler))
+}
+
+// handlePurgeSunPhase evicts a cached day, and the WU responses it was built
+// from, so the next request refetches it
+func (env *Env) handlePurgeSunPhase(response http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+
+	day, err := time.Parse(dateFormat, query.Get("date"))
+	if err != nil {
+		makeErrorResponse(response, 400, "date must be formatted as YYYY-MM-DD", 0)
+		return
+	}
+
+	// location is a configured name or a WU location query
+	location := env.config().WUndergroundLocation
+	wuQuery := location
+	if name := query.Get("location"); env.config().Locations[name] != "" {
+		location, wuQuery = name, env.config().Locations[name]
+	} else if name != "" {
+		location, err = config.NormalizeLocation(name)
+		if err != nil {
+			makeErrorResponse(response, 400, err.Error(), 0)
+			return
+		}
+		wuQuery = location
+	}
+
+	cacheKeys := []string{
+		env.sunPhaseCacheKey(location, day),
+		env.cacheKey("sun_phase_v2", location, day),
+		env.cacheKey("astronomy", location, day),
+		env.cacheKey("moon_phase_v2", location, day),
+		env.cacheKey("wu_astronomy", location, day),
+	}
+	for _, cacheKey := range cacheKeys {
+		cacheKeys = append(cacheKeys, cache.StaleKey(cacheKey))
+	}
+	// the WU responses the entries are rebuilt from, which may be older
+	cacheKeys = append(cacheKeys,
+		env.wuBundleKey("astronomy", wuQuery, day),
+		env.wuBundleKey("geolookup", wuQuery, day),
+		env.rawKey("astronomy/geolookup", wuQuery, day),
+		env.rawKey("geolookup", wuQuery, day),
+	)
+	if err := env.cache.Delete(request.Context(), cacheKeys...); err != nil {
+		logRequest(request, "Error purging cache: %s", err)
+		makeErrorResponse(response, 500, err.Error(), 0)
+		return
+	}
+
+	logRequest(request, "Purged %v", cacheKeys)
+	response.WriteHeader(204)
+}
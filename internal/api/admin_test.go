@@ -0,0 +1,59 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPurgeSunPhase(t *testing.T) {
+	server := newTestServer(t, map[string]string{
+		"ADMIN_TOKEN":        "admin-secret",
+		"LOCATIONS":          "home:PA/Philadelphia",
+		"WU_BUNDLE_FEATURES": "alerts,astronomy,geolookup",
+	})
+
+	// the bundle caches the sun phase and keeps its WU parts
+	server.get("/weather/alerts/v1/home")
+	server.get("/weather/sun_phase/v1/home")
+	calls := server.wu.calls()
+	purged := []string{
+		server.env.sunPhaseCacheKey("home", testNow),
+		server.env.cacheKey("wu_astronomy", "home", testNow),
+		server.env.wuBundleKey("astronomy", "PA/Philadelphia", testNow),
+		server.env.wuBundleKey("geolookup", "PA/Philadelphia", testNow),
+		server.env.rawKey("astronomy/geolookup", "PA/Philadelphia", testNow),
+	}
+	for _, key := range purged {
+		if !server.redis.Exists(key) {
+			t.Fatalf("%s not cached before the purge, have %v", key, server.redis.Keys())
+		}
+	}
+
+	unauthorized := httptest.NewRequest("DELETE", "/weather/sun_phase/v1?date=2024-06-20&location=home", nil)
+	response := httptest.NewRecorder()
+	server.router.ServeHTTP(response, unauthorized)
+	if response.Code != 401 {
+		t.Errorf("purge without the admin token: status = %d, want 401", response.Code)
+	}
+
+	request := httptest.NewRequest("DELETE", "/weather/sun_phase/v1?date=2024-06-20&location=home", nil)
+	request.Header.Set("Authorization", "Bearer admin-secret")
+	response = httptest.NewRecorder()
+	server.router.ServeHTTP(response, request)
+	if response.Code != 204 {
+		t.Fatalf("purge: status = %d, want 204: %s", response.Code, response.Body)
+	}
+	for _, key := range purged {
+		if server.redis.Exists(key) {
+			t.Errorf("%s left after the purge", key)
+		}
+	}
+
+	// the next request goes back to WU rather than the old bundle
+	if response := server.get("/weather/sun_phase/v1/home"); response.Code != 200 {
+		t.Fatalf("after purge: status = %d, want 200", response.Code)
+	}
+	if server.wu.calls() != calls+1 {
+		t.Errorf("WU called %d times after the purge, want %d", server.wu.calls(), calls+1)
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/provider"
+)
+
+// alertsTTL is short since alerts are issued and lifted throughout the day
+var alertsTTL time.Duration = time.Duration(5) * time.Minute
+
+type AlertResponse struct {
+	ResponseID   string  `jsonapi:"primary,alert"`
+	Type         string  `jsonapi:"attr,type"`
+	Description  string  `jsonapi:"attr,description"`
+	Significance string  `jsonapi:"attr,significance"`
+	Message      string  `jsonapi:"attr,message"`
+	Issued       *string `jsonapi:"attr,issued_iso"`
+	Expires      *string `jsonapi:"attr,expires_iso"`
+}
+
+func (env *Env) handleAlerts(response http.ResponseWriter, request *http.Request) {
+	location, err := env.requestLocation(request)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	cacheKey := env.cacheKey("alerts", location.Key(), time.Time{})
+	env.serveCached(response, request, cacheKey, alertsTTL, env.buildAlerts(location))
+}
+
+func (env *Env) buildAlerts(location Location) func(context.Context) (interface{}, error) {
+	return func(ctx context.Context) (interface{}, error) {
+		alertsJSON, err := env.getWUApiRepose(ctx, "alerts", location)
+		if err != nil {
+			return nil, fmt.Errorf("Error fetching alerts: %w", err)
+		}
+
+		var alerts provider.WUAlerts
+		if err := json.Unmarshal([]byte(alertsJSON), &alerts); err != nil {
+			return nil, env.rawParseError("alerts", "alerts", location.Query, err)
+		}
+		responseObj := makeAlertsResponse(location, alerts, env.config().LocationTZ)
+		env.refreshedModel(ctx, location, "alerts", responseObj)
+		return responseObj, nil
+	}
+}
+
+// makeAlertsResponse always returns a slice so no active alerts is an empty array
+func makeAlertsResponse(location Location, alerts provider.WUAlerts, tz *time.Location) (responseObj []*AlertResponse) {
+	responseObj = []*AlertResponse{}
+
+	for i, alert := range alerts.Alerts {
+		responseObj = append(responseObj, &AlertResponse{
+			ResponseID:   fmt.Sprintf("%s:%s:%s:%d", location.Key(), alert.Type, alert.DateEpoch, i),
+			Type:         alert.Type,
+			Description:  alert.Description,
+			Significance: alert.Significance,
+			Message:      alert.Message,
+			Issued:       epochTime(alert.DateEpoch, tz),
+			Expires:      epochTime(alert.ExpiresEpoch, tz),
+		})
+	}
+	return
+}
+
+// epochTime formats a WU epoch string such as "1391054040", nil when unset
+func epochTime(epoch string, tz *time.Location) *string {
+	seconds, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil || seconds <= 0 {
+		return nil
+	}
+	return formatTime(time.Unix(seconds, 0).In(tz))
+}
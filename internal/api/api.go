@@ -0,0 +1,484 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/jsonapi"
+	"github.com/tyrm/ph-weather/internal/cache"
+	"github.com/tyrm/ph-weather/internal/config"
+	"github.com/tyrm/ph-weather/internal/provider"
+	"github.com/tyrm/ph-weather/internal/status"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"golang.org/x/sync/singleflight"
+)
+
+type Env struct {
+	settings atomic.Value // *config.Config, replaced on SIGHUP
+	events   *EventBroker
+	cache    *cache.Cache
+	mqtt     *MQTTPublisher
+	redis    cache.RedisCommands
+	sunTable *sunPhaseTable     // set when SUN_PHASE_SOURCE=computed
+	now      func() time.Time   // time.Now, fixed in tests to cross midnight
+	weather  *provider.Provider // calls WU or stands in for it
+	webhooks *http.Client       // through the provider's transport
+	builds   singleflight.Group // cache builds in flight, by key
+
+	// sleep waits for a duration of now, reporting false if ctx ended
+	// first. Tests advance their clock instead of waiting.
+	sleep func(ctx context.Context, d time.Duration) bool
+
+	// apiKeysInRedis is set while the Redis API key set holds keys, so they
+	// stay required when Redis can't be read
+	apiKeysInRedis atomic.Bool
+}
+
+// config is the running configuration. Callers should not hold on to it
+// across requests since a reload replaces it.
+func (env *Env) config() *config.Config {
+	return env.settings.Load().(*config.Config)
+}
+
+type SunPhaseRespose struct {
+	ResponseID     string  `jsonapi:"primary,sun_phase"`
+	SunriseM       *int    `jsonapi:"attr,sunrise_m"`
+	SunriseH       *int    `jsonapi:"attr,sunrise_h"`
+	SunsetM        *int    `jsonapi:"attr,sunset_m"`
+	SunsetH        *int    `jsonapi:"attr,sunset_h"`
+	PolarCondition string  `jsonapi:"attr,polar_condition"`
+	SolarNoon      *string `jsonapi:"attr,solar_noon_iso"`
+	Timezone       string  `jsonapi:"attr,timezone"`
+	UTCOffset      string  `jsonapi:"attr,utc_offset"`
+}
+
+// getWUApiRepose fetches WU features, through the bundle when they're all
+// in WU_BUNDLE_FEATURES, and keeps the raw response
+func (env *Env) getWUApiRepose(ctx context.Context, feature string, location Location) (resString string, resError error) {
+	resString, bundled, resError := env.getWUBundled(ctx, feature, location)
+	if !bundled {
+		resString, resError = env.weather.Fetch(ctx, feature, location.Query)
+	}
+	if resError == nil {
+		env.storeRaw(ctx, feature, location.Query, resString)
+	}
+	return
+}
+
+func (env *Env) getWUAstronomy(ctx context.Context, feature string, location Location) (response provider.WUAstronomy, resError error) {
+	astronomy, err := env.getWUApiRepose(ctx, feature, location)
+	if err != nil {
+		resError = err
+		return
+	}
+	if err := json.Unmarshal([]byte(astronomy), &response); err != nil {
+		resError = env.rawParseError(feature, feature, location.Query, err)
+	}
+	return
+}
+
+// today is the current time in the location's timezone, so days roll over at
+// the location's midnight rather than the server's
+func (env *Env) today() time.Time {
+	return env.now().In(env.config().LocationTZ)
+}
+
+// cacheKey is the cache key for a feature's data at a location, on a given
+// day unless day is zero
+func (env *Env) cacheKey(feature string, location string, day time.Time) string {
+	if day.IsZero() {
+		return env.config().RedisKey("weather", feature, location)
+	}
+	return env.config().RedisKey("weather", feature, location, fmt.Sprintf("%d-%s-%d", day.Year(), day.Month(), day.Day()))
+}
+
+// dayResourceID is the public jsonapi id for a location's data of a type on
+// a given day, such as sun_phase:2024-06-21:PA/Philadelphia, keeping the
+// cache key structure internal
+func dayResourceID(kind string, location string, day time.Time) string {
+	return kind + ":" + day.Format(dateFormat) + ":" + location
+}
+
+// sunPhaseID is the id of a location's sun phase on day. With
+// SUN_PHASE_LEGACY_IDS it is the cache key the service used as the id before
+// there were locations, or the cache key for other locations. Cached sun
+// phases keep the id they were built with until they expire.
+func (env *Env) sunPhaseID(location string, day time.Time) string {
+	if !env.config().SunPhaseLegacyIDs {
+		return dayResourceID("sun_phase", location, day)
+	}
+	if location == env.config().WUndergroundLocation {
+		return fmt.Sprintf("%sweather:sun_phase:%d-%s-%d", env.config().RedisPrefix, day.Year(), day.Month(), day.Day())
+	}
+	return env.sunPhaseCacheKey(location, day)
+}
+
+func (env *Env) sunPhaseCacheKey(location string, day time.Time) string {
+	return env.cacheKey("sun_phase", location, day)
+}
+
+// sunPhaseTTL is how long a day's sun phase is cached. A day that is over
+// can't change any more and is kept for SUN_PHASE_PAST_TTL; today and later
+// days may still be revised upstream and are kept for SUN_PHASE_TTL.
+func (env *Env) sunPhaseTTL(day time.Time) time.Duration {
+	if day.Format(dateFormat) < env.today().Format(dateFormat) {
+		return env.config().SunPhasePastTTL
+	}
+	return env.config().SunPhaseTTL
+}
+
+func (env *Env) handleSunPhase(response http.ResponseWriter, request *http.Request) {
+	location, err := env.requestLocation(request)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	day, err := env.requestDate(request)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	cacheEntry, err := env.getOrBuildCache(request, env.sunPhaseCacheKey(location.Key(), day), env.sunPhaseTTL(day), env.buildSunPhase(location, day))
+	if err != nil {
+		logRequest(request, "%s", err)
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	// whether it is day or night now is only known for today
+	if day.Format(dateFormat) == env.today().Format(dateFormat) {
+		coordinates, err := env.locationCoordinates(request.Context(), location)
+		if err != nil {
+			logRequest(request, "Error finding coordinates, twilight is reported as night: %s", err)
+		}
+		cacheEntry, err = withSunPhaseNow(cacheEntry, env.now(), coordinates)
+		if err != nil {
+			logRequest(request, "Error adding day phase: %s", err)
+			makeErrorResponse(response, 500, err.Error(), 0)
+			return
+		}
+	}
+
+	writeCacheEntry(response, request, cacheEntry)
+}
+
+func (env *Env) buildSunPhase(location Location, day time.Time) func(context.Context) (interface{}, error) {
+	return func(ctx context.Context) (interface{}, error) {
+		responseObj, _, err := env.fetchSunPhase(ctx, location, day)
+		if err == nil && day.Format(dateFormat) == env.today().Format(dateFormat) {
+			env.refreshedModel(ctx, location, "sun_phase", responseObj)
+		}
+		return responseObj, err
+	}
+}
+
+// fetchSunPhase returns the sun phase for a location on day and the
+// location's coordinates when known. WU only answers for today, other days
+// and every day with SUN_PHASE_SOURCE=computed are computed locally.
+func (env *Env) fetchSunPhase(ctx context.Context, location Location, day time.Time) (responseObj *SunPhaseRespose, coordinates *config.Coordinates, resError error) {
+	id := env.sunPhaseID(location.Key(), day)
+
+	if env.config().SunPhaseSource == config.SunPhaseSourceComputed || day.Format(dateFormat) != env.today().Format(dateFormat) {
+		coordinates, resError = env.locationCoordinates(ctx, location)
+		if resError != nil {
+			return
+		}
+		responseObj = env.computedSunPhase(location, *coordinates, day)
+		responseObj.ResponseID = id
+		return
+	}
+
+	astronomy, err := env.fetchAstronomy(ctx, location, day)
+	if err != nil {
+		resError = err
+		return
+	}
+	// kept so today's requests can tell twilight from night without a geolookup
+	if coordinates, _ = config.ParseCoordinates(astronomy.Location.Lat, astronomy.Location.Lon); coordinates != nil {
+		env.storeCoordinates(ctx, location, coordinates)
+	}
+
+	responseObj, resError = makeSunPhaseResponse(id, astronomy, day)
+	return
+}
+
+func makeSunPhaseResponse(id string, astronomy provider.WUAstronomy, day time.Time) (responseObj *SunPhaseRespose, resError error) {
+	responseObj = &SunPhaseRespose{ResponseID: id}
+	responseObj.Timezone, responseObj.UTCOffset = sunPhaseZone(day, astronomy.Location.TZLong)
+
+	if astronomy.SunPhase.Sunrise == nil || astronomy.SunPhase.Sunset == nil {
+		resError = status.Errorf(502, "upstream returned incomplete astronomy data")
+		return
+	}
+
+	responseObj.SunriseH, responseObj.SunriseM, resError = parseWUTime(*astronomy.SunPhase.Sunrise)
+	if resError != nil {
+		resError = status.Errorf(502, "Error parsing sunrise: %s", resError)
+		return
+	}
+
+	responseObj.SunsetH, responseObj.SunsetM, resError = parseWUTime(*astronomy.SunPhase.Sunset)
+	if resError != nil {
+		resError = status.Errorf(502, "Error parsing sunset: %s", resError)
+		return
+	}
+
+	// WU leaves sunrise or sunset blank when the sun doesn't cross the horizon
+	if responseObj.SunriseH == nil || responseObj.SunsetH == nil {
+		latitude, err := strconv.ParseFloat(astronomy.Location.Lat, 64)
+		if err != nil {
+			resError = fmt.Errorf("Error parsing latitude for polar condition: %s", err)
+			return
+		}
+		responseObj.PolarCondition = polarCondition(latitude, day)
+		responseObj.SunriseH, responseObj.SunriseM = nil, nil
+		responseObj.SunsetH, responseObj.SunsetM = nil, nil
+		return
+	}
+
+	// Solar noon is midway between sunrise and sunset, which may be after midnight
+	tz := sunPhaseTZ(day, astronomy.Location.TZLong)
+	sunrise, sunset := sunPhaseTimes(day, tz, *responseObj.SunriseH, *responseObj.SunriseM, *responseObj.SunsetH, *responseObj.SunsetM)
+	responseObj.SolarNoon = formatTime(sunrise.Add(sunset.Sub(sunrise) / 2))
+
+	return
+}
+
+// makeComputedSunPhaseResponse builds the sun phase from local solar math,
+// rounded to the minute like WU's
+func makeComputedSunPhaseResponse(id string, coordinates config.Coordinates, day time.Time) (responseObj *SunPhaseRespose) {
+	responseObj = &SunPhaseRespose{ResponseID: id}
+	responseObj.Timezone, responseObj.UTCOffset = sunPhaseZone(day, "")
+
+	rise, set, polar := sunCrossings(day, coordinates.Latitude, coordinates.Longitude, sunriseAltitude)
+	if polar != "" {
+		responseObj.PolarCondition = polar
+		return
+	}
+
+	sunrise, sunset := rise.Round(time.Minute), set.Round(time.Minute)
+	sunriseH, sunriseM, sunsetH, sunsetM := sunrise.Hour(), sunrise.Minute(), sunset.Hour(), sunset.Minute()
+	responseObj.SunriseH, responseObj.SunriseM = &sunriseH, &sunriseM
+	responseObj.SunsetH, responseObj.SunsetM = &sunsetH, &sunsetM
+	responseObj.SolarNoon = formatTime(sunrise.Add(sunset.Sub(sunrise) / 2))
+
+	return
+}
+
+// sunPhaseZone names the timezone the sun phase hours are in and its UTC
+// offset on day, like -0700
+func sunPhaseZone(day time.Time, tzLong string) (name string, offset string) {
+	tz := sunPhaseTZ(day, tzLong)
+	noon := time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, tz)
+	return tz.String(), noon.Format("-0700")
+}
+
+// sunPhaseTZ is the timezone a sun phase's hours are in: WU's tz_long, or
+// the timezone attribute, when it's a known zone, otherwise day's own
+func sunPhaseTZ(day time.Time, name string) *time.Location {
+	if name != "" {
+		if tz, err := time.LoadLocation(name); err == nil {
+			return tz
+		}
+	}
+	return day.Location()
+}
+
+// sunPhaseTimes places a sun phase's sunrise and sunset hours on day in tz.
+// A sunset before sunrise is after local midnight, on the next day.
+func sunPhaseTimes(day time.Time, tz *time.Location, sunriseH int, sunriseM int, sunsetH int, sunsetM int) (sunrise time.Time, sunset time.Time) {
+	sunrise = time.Date(day.Year(), day.Month(), day.Day(), sunriseH, sunriseM, 0, 0, tz)
+	sunset = time.Date(day.Year(), day.Month(), day.Day(), sunsetH, sunsetM, 0, 0, tz)
+	if sunset.Before(sunrise) {
+		sunset = sunset.AddDate(0, 0, 1)
+	}
+	return
+}
+
+// parseWUTime returns nil hour and minute when WU sends an empty time
+func parseWUTime(wuTime provider.WUTime) (hour *int, minute *int, resError error) {
+	if wuTime.Hour == "" && wuTime.Minute == "" {
+		return
+	}
+
+	h, err := strconv.Atoi(wuTime.Hour)
+	if err != nil {
+		resError = err
+		return
+	}
+	m, err := strconv.Atoi(wuTime.Minute)
+	if err != nil {
+		resError = err
+		return
+	}
+
+	hour, minute = &h, &m
+	return
+}
+
+// weatherMiddleware wraps a weather route with the shared middleware
+func (env *Env) weatherMiddleware(handler http.HandlerFunc) http.HandlerFunc {
+	return env.feedMiddleware(withContentNegotiation(handler))
+}
+
+// feedMiddleware is weatherMiddleware for routes with their own media type,
+// which skip JSON content negotiation
+func (env *Env) feedMiddleware(handler http.HandlerFunc) http.HandlerFunc {
+	return withRequestID(withRecovery(withGzip(env.withRequestTimeout(env.weatherAccess(handler)))))
+}
+
+// streamMiddleware is feedMiddleware without compression, which would hold
+// back a streamed response until it ends
+func (env *Env) streamMiddleware(handler http.HandlerFunc) http.HandlerFunc {
+	return withRequestID(withRecovery(env.weatherAccess(handler)))
+}
+
+// infoMiddleware serves information about the service itself, open to any client
+func infoMiddleware(handler http.HandlerFunc) http.HandlerFunc {
+	return withRequestID(withRecovery(withContentNegotiation(handler)))
+}
+
+// metricsMiddleware is infoMiddleware without the JSON content negotiation,
+// which would turn away scrapers accepting only text/plain and doesn't apply
+// to routes serving their own formats
+func metricsMiddleware(handler http.HandlerFunc) http.HandlerFunc {
+	return withRequestID(withRecovery(handler))
+}
+
+// weatherAccess is the access control shared by weather routes: CORS, API
+// keys and the weather rate limit. The location
+// allowlist and override checks need the route's location and are applied
+// by requestLocation.
+func (env *Env) weatherAccess(handler http.HandlerFunc) http.HandlerFunc {
+	return env.withCORS(env.withAPIKey(env.withRateLimit("weather", handler)))
+}
+
+// writePayload marshals a jsonapi model and sends it
+func writePayload(response http.ResponseWriter, request *http.Request, model interface{}) {
+	var payload bytes.Buffer
+	if err := jsonapi.MarshalPayload(&payload, model); err != nil {
+		logRequest(request, "Error marshaling response: %s", err)
+		makeErrorResponse(response, 500, err.Error(), 0)
+		return
+	}
+
+	writeDocument(response, request, payload.Bytes())
+}
+
+// makeStatusErrorResponse reports err with its status, passing on when the
+// client may retry
+func makeStatusErrorResponse(response http.ResponseWriter, err error) {
+	if retryAfter := status.RetryAfter(err); retryAfter > 0 {
+		response.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	}
+	makeErrorResponse(response, status.Code(err), err.Error(), 0)
+}
+
+func makeErrorResponse(response http.ResponseWriter, status int, detail string, code int) {
+	var title string
+	var statusStr string = strconv.Itoa(status)
+	var codeStr string
+
+	// Get Title
+	if code == 0 { // code 0 means no code
+		title = statusTitle[status]
+	} else {
+		title = codeTitle[code]
+		codeStr = strconv.Itoa(code)
+	}
+
+	// Send Response, headers must be set before WriteHeader
+	response.Header().Set("Content-Type", jsonapi.MediaType)
+	response.WriteHeader(status)
+	var requestID string = response.Header().Get("X-Request-ID") // set by withRequestID
+	var meta map[string]interface{} = map[string]interface{}{"request_id": requestID}
+	jsonapi.MarshalErrors(response, []*jsonapi.ErrorObject{{
+		ID:     requestID,
+		Title:  title,
+		Detail: detail,
+		Status: statusStr,
+		Code:   codeStr,
+		Meta:   &meta,
+	}})
+
+	return
+}
+
+// newEnv wires up an Env for a weather provider, a cache and the Redis it is
+// kept in, reading the time from the system clock. Tests may replace now. It
+// sets the package's JSON:API version and proxy trust from config.
+func newEnv(config config.Config, weather *provider.Provider, store *cache.Cache) *Env {
+	jsonapiVersion = config.JSONAPIVersion
+	trustProxy = config.TrustProxy
+
+	env := &Env{redis: store.Redis(), cache: store, weather: weather, events: newEventBroker(), now: time.Now, sleep: sleep}
+	env.webhooks = &http.Client{Timeout: webhookTimeout, Transport: otelhttp.NewTransport(weather.Transport())}
+	env.settings.Store(&config)
+	return env
+}
+
+// newRouter registers every route the service answers
+func (env *Env) newRouter(config config.Config) *Router {
+	router := NewRouter(config.BasePath)
+	if config.BasePath != "" {
+		log.Printf("Serving every route under %s", config.BasePath)
+	}
+	router.Route("/debug/vars", metricsMiddleware).Get(expvar.Handler().ServeHTTP)
+	router.Route("/version", infoMiddleware).Get(handleVersion)
+	router.Route("/readyz", infoMiddleware).Get(env.handleReady)
+	if config.AdminToken != "" {
+		router.Route("/admin/quota/v1", infoMiddleware).Get(env.adminHandler(env.handleQuota))
+		router.Route("/admin/raw/v1", metricsMiddleware).Get(env.adminHandler(env.handleRawPayload))
+	}
+	router.Route("/metrics/weather", metricsMiddleware).Get(env.handleWeatherMetrics)
+	router.Route("/grafana/", env.feedMiddleware).Get(env.handleGrafanaTest)
+	router.Route("/grafana/search", env.feedMiddleware).Method("POST", env.handleGrafanaSearch)
+	router.Route("/grafana/query", env.feedMiddleware).Method("POST", env.handleGrafanaQuery)
+
+	sunPhase := router.Route("/weather/sun_phase/v1", env.weatherMiddleware).Get(env.handleSunPhase)
+	if config.AdminToken != "" {
+		sunPhase.Delete(env.adminWriteHandler(env.handlePurgeSunPhase))
+	}
+	router.Route("/weather/sun_phase/v1/{location}", env.weatherMiddleware).Get(env.handleSunPhase)
+	router.Route("/weather/sun_phase/ical", env.feedMiddleware).Get(env.handleSunPhaseICal)
+	router.Route("/weather/sun_phase/ical/{location}", env.feedMiddleware).Get(env.handleSunPhaseICal)
+	router.Route("/weather/sun_phase/batch/v1", env.weatherMiddleware).Get(env.handleSunPhaseBatch)
+	router.Route("/weather/sun_phase/range/v1", env.weatherMiddleware).Get(env.handleSunPhaseRange)
+	router.Route("/weather/sun_phase/range/v1/{location}", env.weatherMiddleware).Get(env.handleSunPhaseRange)
+	router.Route("/weather/sun_phase/v2", env.weatherMiddleware).Get(env.handleSunPhaseV2)
+	router.Route("/weather/sun_phase/v2/{location}", env.weatherMiddleware).Get(env.handleSunPhaseV2)
+	router.Route("/weather/alerts/v1", env.weatherMiddleware).Get(env.handleAlerts)
+	router.Route("/weather/alerts/v1/{location}", env.weatherMiddleware).Get(env.handleAlerts)
+	router.Route("/weather/hourly/v1", env.weatherMiddleware).Get(env.handleHourly)
+	router.Route("/weather/hourly/v1/{location}", env.weatherMiddleware).Get(env.handleHourly)
+	router.Route("/weather/tides/v1", env.weatherMiddleware).Get(env.handleTides)
+	router.Route("/weather/tides/v1/{location}", env.weatherMiddleware).Get(env.handleTides)
+	router.Route("/weather/astronomy/v1", env.weatherMiddleware).Get(env.handleAstronomy)
+	router.Route("/weather/astronomy/v1/{location}", env.weatherMiddleware).Get(env.handleAstronomy)
+	router.Route("/weather/daylight/v1", env.weatherMiddleware).Get(env.handleDaylight)
+	router.Route("/weather/daylight/v1/{location}", env.weatherMiddleware).Get(env.handleDaylight)
+	router.Route("/weather/golden_hour/v1", env.weatherMiddleware).Get(env.handleGoldenHour)
+	router.Route("/weather/golden_hour/v1/{location}", env.weatherMiddleware).Get(env.handleGoldenHour)
+	router.Route("/weather/twilight/v1", env.weatherMiddleware).Get(env.handleTwilight)
+	router.Route("/weather/twilight/v1/{location}", env.weatherMiddleware).Get(env.handleTwilight)
+	router.Route("/weather/moon_phase/v2", env.weatherMiddleware).Get(env.handleMoonPhaseV2)
+	router.Route("/weather/moon_phase/v2/{location}", env.weatherMiddleware).Get(env.handleMoonPhaseV2)
+	router.Route("/weather/seasons/v1", env.weatherMiddleware).Get(env.handleSeasons)
+	router.Route("/weather/seasons/v1/{location}", env.weatherMiddleware).Get(env.handleSeasons)
+	router.Route("/weather/observations/v1", env.weatherMiddleware).Get(env.handleObservations)
+	router.Route("/weather/observations/v1/{location}", env.weatherMiddleware).Get(env.handleObservations)
+	router.Route("/weather/sun_position/v1", env.weatherMiddleware).Get(env.handleSunPosition)
+	router.Route("/weather/stream/v1", env.streamMiddleware).Get(env.handleStream)
+	router.Route("/weather/stream/v1/{location}", env.streamMiddleware).Get(env.handleStream)
+	return router
+}
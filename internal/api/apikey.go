@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"expvar"
+	"net/http"
+	"strings"
+
+	"github.com/tyrm/ph-weather/internal/status"
+)
+
+var requestsByKey = expvar.NewMap("http_requests_by_key")
+
+// apiKeysSet is the Redis set holding keys that can be added without a restart
+func (env *Env) apiKeysSet() string {
+	return env.config().RedisKey("api_keys")
+}
+
+// withAPIKey requires a known client key once any key is configured, either
+// in API_KEYS or in the Redis set. Keys are taken from a bearer token or the
+// api_key query parameter. The admin token is accepted as well so admin
+// operations on weather routes don't need both.
+func (env *Env) withAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(response http.ResponseWriter, request *http.Request) {
+		// OPTIONS only describes the route, clients may discover it unauthenticated
+		if request.Method == "OPTIONS" {
+			next(response, request)
+			return
+		}
+
+		key := requestAPIKey(request)
+
+		required, valid, err := env.checkAPIKey(request, key)
+		if err != nil {
+			makeStatusErrorResponse(response, err)
+			return
+		}
+		if !required {
+			next(response, request)
+			return
+		}
+		if !valid {
+			makeErrorResponse(response, 401, "a valid API key is required", 0)
+			return
+		}
+
+		keyID := apiKeyID(key)
+		requestsByKey.Add(keyID, 1)
+		ctx := context.WithValue(request.Context(), apiKeyIDKey, keyID)
+		next(response, request.WithContext(ctx))
+	}
+}
+
+// checkAPIKey reports whether a key is required and whether key is valid.
+// A key matching API_KEYS or the admin token needs no Redis. Otherwise, when
+// Redis can't be read and keys are configured, in API_KEYS or last seen in
+// the Redis set, the key can't be checked and a 503 is returned rather than
+// letting the request through.
+func (env *Env) checkAPIKey(request *http.Request, key string) (required bool, valid bool, resError error) {
+	required = len(env.config().APIKeys) > 0
+
+	if key != "" {
+		for _, configured := range env.config().APIKeys {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(configured)) == 1 {
+				return true, true, nil
+			}
+		}
+		if env.config().AdminToken != "" && subtle.ConstantTimeCompare([]byte(key), []byte(env.config().AdminToken)) == 1 {
+			return true, true, nil
+		}
+	}
+
+	count, err := env.rememberRedisAPIKeys(request.Context())
+	if err != nil {
+		logRequest(request, "Error reading API keys from Redis: %s", err)
+		if required || env.apiKeysInRedis.Load() {
+			required = true
+			resError = status.Errorf(503, "API keys can't be checked right now")
+		}
+		return
+	}
+	if count == 0 {
+		return
+	}
+	required = true
+
+	if key == "" {
+		return
+	}
+	valid, err = env.redis.SIsMember(request.Context(), env.apiKeysSet(), key).Result()
+	if err != nil {
+		logRequest(request, "Error checking API key in Redis: %s", err)
+		resError = status.Errorf(503, "API keys can't be checked right now")
+	}
+	return
+}
+
+// rememberRedisAPIKeys counts the keys in the Redis set, noting in
+// apiKeysInRedis whether there are any
+func (env *Env) rememberRedisAPIKeys(ctx context.Context) (count int64, resError error) {
+	count, resError = env.redis.SCard(ctx, env.apiKeysSet()).Result()
+	if resError == nil {
+		env.apiKeysInRedis.Store(count > 0)
+	}
+	return
+}
+
+func requestAPIKey(request *http.Request) string {
+	if authorization := request.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimPrefix(authorization, "Bearer ")
+	}
+	return request.URL.Query().Get("api_key")
+}
+
+// apiKeyID is a short hash identifying a key in logs and metrics without revealing it
+func apiKeyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:4])
+}
+
+func getAPIKeyID(ctx context.Context) string {
+	keyID, _ := ctx.Value(apiKeyIDKey).(string)
+	return keyID
+}
@@ -0,0 +1,54 @@
+package api
+
+import "testing"
+
+func TestAPIKeyRedisSet(t *testing.T) {
+	server := newTestServer(t, nil)
+	server.redis.SAdd("ph:api_keys", "redis-key")
+
+	for _, test := range []struct {
+		header []string
+		want   int
+	}{
+		{nil, 401},
+		{[]string{"Authorization", "Bearer wrong"}, 401},
+		{[]string{"Authorization", "Bearer redis-key"}, 200},
+	} {
+		if response := server.get("/weather/sun_phase/v1", test.header...); response.Code != test.want {
+			t.Errorf("%v: status = %d, want %d", test.header, response.Code, test.want)
+		}
+	}
+
+	// keys last seen in Redis are still required while it is down
+	server.redis.Close()
+	for _, header := range [][]string{nil, {"Authorization", "Bearer redis-key"}} {
+		if response := server.get("/weather/sun_phase/v1", header...); response.Code != 503 {
+			t.Errorf("Redis down, %v: status = %d, want 503", header, response.Code)
+		}
+	}
+}
+
+func TestAPIKeyRedisDown(t *testing.T) {
+	// no keys configured anywhere, nothing to check
+	open := newTestServer(t, nil)
+	open.redis.Close()
+	if response := open.get("/weather/sun_phase/v1"); response.Code != 200 {
+		t.Errorf("no keys, Redis down: status = %d, want 200", response.Code)
+	}
+
+	// API_KEYS are checked without Redis, other keys can't be
+	static := newTestServer(t, map[string]string{"API_KEYS": "static-key"})
+	static.redis.Close()
+	for _, test := range []struct {
+		header []string
+		want   int
+	}{
+		{[]string{"Authorization", "Bearer static-key"}, 200},
+		{[]string{"Authorization", "Bearer redis-key"}, 503},
+		{nil, 503},
+	} {
+		if response := static.get("/weather/sun_phase/v1", test.header...); response.Code != test.want {
+			t.Errorf("API_KEYS, Redis down, %v: status = %d, want %d", test.header, response.Code, test.want)
+		}
+	}
+}
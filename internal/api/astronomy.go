@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/cache"
+	"github.com/tyrm/ph-weather/internal/provider"
+	"github.com/tyrm/ph-weather/internal/status"
+)
+
+type AstronomyResponse struct {
+	ResponseID         string   `jsonapi:"primary,astronomy"`
+	Sunrise            *string  `jsonapi:"attr,sunrise_iso"`
+	Sunset             *string  `jsonapi:"attr,sunset_iso"`
+	SolarNoon          *string  `jsonapi:"attr,solar_noon_iso"`
+	PolarCondition     string   `jsonapi:"attr,polar_condition"`
+	Moonrise           *string  `jsonapi:"attr,moonrise_iso"`
+	Moonset            *string  `jsonapi:"attr,moonset_iso"`
+	MoonPhase          string   `jsonapi:"attr,moon_phase"`
+	AgeOfMoon          *int     `jsonapi:"attr,age_of_moon_days"`
+	PercentIlluminated *float64 `jsonapi:"attr,percent_illuminated"`
+	Fraction           *float64 `jsonapi:"attr,illuminated_fraction"`
+}
+
+// fetchAstronomy returns WU's astronomy for a location today. The upstream
+// answer is cached so the astronomy and sun phase resources share one fetch.
+func (env *Env) fetchAstronomy(ctx context.Context, location Location, day time.Time) (astronomy provider.WUAstronomy, resError error) {
+	cacheKey := env.cacheKey("wu_astronomy", location.Key(), day)
+
+	cacheVal, err := env.redis.Get(ctx, cacheKey).Result()
+	if err == nil && json.Unmarshal([]byte(cacheVal), &astronomy) == nil {
+		return
+	} else if err != nil && !cache.Miss(err) {
+		log.Printf("Error reading cache: %s", err)
+	}
+
+	// geolookup is requested alongside astronomy for the latitude used in polar detection
+	astronomy, resError = env.getWUAstronomy(ctx, "astronomy/geolookup", location)
+	if resError != nil {
+		resError = fmt.Errorf("Error fetching astronomy: %w", resError)
+		return
+	}
+
+	if body, err := json.Marshal(astronomy); err == nil {
+		if err := env.redis.Set(ctx, cacheKey, string(body), env.sunPhaseTTL(day)).Err(); err != nil {
+			log.Printf("Error commiting to cache: %s", err)
+		}
+	}
+	return
+}
+
+func makeAstronomyResponse(id string, astronomy provider.WUAstronomy, day time.Time) (responseObj *AstronomyResponse, resError error) {
+	sunPhase, err := makeSunPhaseResponse(id, astronomy, day)
+	if err != nil {
+		resError = err
+		return
+	}
+
+	responseObj = &AstronomyResponse{
+		ResponseID:     id,
+		Sunrise:        dayTime(day, sunPhase.SunriseH, sunPhase.SunriseM),
+		Sunset:         dayTime(day, sunPhase.SunsetH, sunPhase.SunsetM),
+		SolarNoon:      sunPhase.SolarNoon,
+		PolarCondition: sunPhase.PolarCondition,
+		MoonPhase:      astronomy.MoonPhase.PhaseOfMoon,
+	}
+
+	// moonrise or moonset is blank on days the moon doesn't cross the horizon
+	for _, moon := range []struct {
+		wuTime *provider.WUTime
+		field  **string
+	}{
+		{astronomy.MoonPhase.Moonrise, &responseObj.Moonrise},
+		{astronomy.MoonPhase.Moonset, &responseObj.Moonset},
+	} {
+		if moon.wuTime == nil {
+			continue
+		}
+		hour, minute, err := parseWUTime(*moon.wuTime)
+		if err != nil {
+			resError = status.Errorf(502, "Error parsing moon time: %s", err)
+			return
+		}
+		*moon.field = dayTime(day, hour, minute)
+	}
+
+	if age, err := strconv.Atoi(astronomy.MoonPhase.AgeOfMoon); err == nil {
+		responseObj.AgeOfMoon = &age
+	}
+	percent, err := parsePercentIlluminated(astronomy.MoonPhase.PercentIlluminated)
+	if err != nil {
+		resError = status.Errorf(502, "Error parsing percentIlluminated: %s", err)
+		return
+	}
+	fraction := percent / 100
+	responseObj.PercentIlluminated, responseObj.Fraction = &percent, &fraction
+	return
+}
+
+// parsePercentIlluminated reads WU's percentIlluminated, sent as a string
+// like "43". Blank or out of range values are errors rather than 0.
+func parsePercentIlluminated(value string) (percent float64, resError error) {
+	percent, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		resError = fmt.Errorf("%q is not a number", value)
+		return
+	}
+	if percent < 0 || percent > 100 {
+		resError = fmt.Errorf("%q is not a percentage", value)
+	}
+	return
+}
+
+// dayTime formats an hour and minute on day, nil when either is missing
+func dayTime(day time.Time, hour *int, minute *int) *string {
+	if hour == nil || minute == nil {
+		return nil
+	}
+	return formatTime(time.Date(day.Year(), day.Month(), day.Day(), *hour, *minute, 0, 0, day.Location()))
+}
+
+// handleAstronomy returns today's sun and moon data from WU as one resource
+func (env *Env) handleAstronomy(response http.ResponseWriter, request *http.Request) {
+	location, err := env.requestLocation(request)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	day := env.today()
+	env.serveCached(response, request, env.cacheKey("astronomy", location.Key(), day), env.sunPhaseTTL(day), env.buildAstronomy(location, day))
+}
+
+func (env *Env) buildAstronomy(location Location, day time.Time) func(context.Context) (interface{}, error) {
+	return func(ctx context.Context) (interface{}, error) {
+		astronomy, err := env.fetchAstronomy(ctx, location, day)
+		if err != nil {
+			return nil, err
+		}
+		return makeAstronomyResponse(dayResourceID("astronomy", location.Key(), day), astronomy, day)
+	}
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/jsonapi"
+	"github.com/tyrm/ph-weather/internal/config"
+	"github.com/tyrm/ph-weather/internal/status"
+)
+
+const (
+	maxBatchLocations = 10
+	batchWorkers      = 4
+)
+
+// handleSunPhaseBatch serves the v1 sun phase for several ?locations= at
+// once. Each location is fetched and cached as it would be on its own;
+// locations that fail are listed in meta.errors instead of failing the batch.
+func (env *Env) handleSunPhaseBatch(response http.ResponseWriter, request *http.Request) {
+	names := config.SplitList(request.URL.Query().Get("locations"))
+	if len(names) == 0 {
+		makeErrorResponse(response, 400, "locations is required", 0)
+		return
+	} else if len(names) > maxBatchLocations {
+		makeErrorResponse(response, 400, fmt.Sprintf("at most %d locations may be requested", maxBatchLocations), 0)
+		return
+	}
+
+	// reject bad locations before making any upstream calls
+	locations := make([]Location, len(names))
+	for i, name := range names {
+		location, err := env.lookupLocation(name)
+		if err != nil {
+			makeStatusErrorResponse(response, err)
+			return
+		}
+		locations[i] = location
+	}
+
+	today := env.today()
+	env.writeSunPhaseCollection(response, request, "batch", names, func(i int) (*jsonapi.Node, error) {
+		return env.sunPhaseNode(request, locations[i], today)
+	})
+}
+
+// writeSunPhaseCollection fetches each named item's sun phase resource,
+// batchWorkers at a time, and sends them in order as one collection. Items
+// that fail are listed under their name in meta.errors instead of failing
+// the whole response.
+func (env *Env) writeSunPhaseCollection(response http.ResponseWriter, request *http.Request, kind string, names []string, fetch func(i int) (*jsonapi.Node, error)) {
+	nodes := make([]*jsonapi.Node, len(names))
+	errs := make([]error, len(names))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < batchWorkers && w < len(names); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				nodes[i], errs[i] = fetch(i)
+			}
+		}()
+	}
+	for i := range names {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	payload := &jsonapi.ManyPayload{Data: []*jsonapi.Node{}}
+	failures := make(map[string]interface{})
+	for i, node := range nodes {
+		if errs[i] != nil {
+			logRequest(request, "Error in %s for %s: %s", kind, names[i], errs[i])
+			failures[names[i]] = map[string]interface{}{
+				"status": status.Code(errs[i]),
+				"detail": errs[i].Error(),
+			}
+			continue
+		}
+		payload.Data = append(payload.Data, node)
+	}
+	if len(failures) > 0 {
+		payload.Meta = &jsonapi.Meta{"errors": failures}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logRequest(request, "Error marshaling response: %s", err)
+		makeErrorResponse(response, 500, err.Error(), 0)
+		return
+	}
+
+	writeDocument(response, request, body)
+}
+
+// sunPhaseNode returns a location's cached v1 sun phase resource
+func (env *Env) sunPhaseNode(request *http.Request, location Location, day time.Time) (*jsonapi.Node, error) {
+	cacheEntry, err := env.getOrBuildCache(request, env.sunPhaseCacheKey(location.Key(), day), env.sunPhaseTTL(day), env.buildSunPhase(location, day))
+	if err != nil {
+		return nil, err
+	}
+
+	var payload jsonapi.OnePayload
+	if err := json.Unmarshal([]byte(cacheEntry.Body), &payload); err != nil {
+		return nil, fmt.Errorf("Error decoding cached sun phase: %s", err)
+	}
+	return payload.Data, nil
+}
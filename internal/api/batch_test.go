@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/tyrm/ph-weather/internal/provider"
+)
+
+// collection decodes a collection document's ids and meta.errors
+func collection(t *testing.T, body []byte) (ids []string, failures map[string]struct {
+	Status int    `json:"status"`
+	Detail string `json:"detail"`
+}) {
+	t.Helper()
+	var document struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+		Meta struct {
+			Errors map[string]struct {
+				Status int    `json:"status"`
+				Detail string `json:"detail"`
+			} `json:"errors"`
+		} `json:"meta"`
+	}
+	if err := json.Unmarshal(body, &document); err != nil {
+		t.Fatalf("decoding %s: %s", body, err)
+	}
+	for _, resource := range document.Data {
+		ids = append(ids, resource.ID)
+	}
+	return ids, document.Meta.Errors
+}
+
+func TestSunPhaseBatchPartialFailure(t *testing.T) {
+	server := newTestServer(t, map[string]string{"ALLOW_LOCATION_OVERRIDE": "true"})
+	server.wu.respond = func(feature string, location string) (int, string) {
+		if location == "NY/New_York" {
+			return 500, "oops"
+		}
+		body, _ := provider.MockWUResponse(feature, location, testNow)
+		return 200, body
+	}
+
+	response := server.get("/weather/sun_phase/batch/v1?locations=PA/Philadelphia,NY/New_York,NJ/Camden")
+	if response.Code != 200 {
+		t.Fatalf("status = %d, want 200: %s", response.Code, response.Body)
+	}
+	ids, failures := collection(t, response.Body.Bytes())
+	if len(ids) != 2 || ids[0] != "sun_phase:2024-06-20:PA/Philadelphia" || ids[1] != "sun_phase:2024-06-20:NJ/Camden" {
+		t.Errorf("ids = %v, want Philadelphia then Camden", ids)
+	}
+	if failure, ok := failures["NY/New_York"]; !ok || failure.Status != 502 {
+		t.Errorf("meta.errors = %v, want a 502 for NY/New_York", failures)
+	}
+}
+
+func TestSunPhaseRangeOrder(t *testing.T) {
+	server := newTestServer(t, nil)
+
+	response := server.get("/weather/sun_phase/range/v1?start=2024-06-18&end=2024-06-22")
+	if response.Code != 200 {
+		t.Fatalf("status = %d, want 200: %s", response.Code, response.Body)
+	}
+	ids, failures := collection(t, response.Body.Bytes())
+	want := []string{"18", "19", "20", "21", "22"}
+	if len(ids) != len(want) || len(failures) != 0 {
+		t.Fatalf("ids = %v, errors = %v, want five days", ids, failures)
+	}
+	for i, id := range ids {
+		if id != "sun_phase:2024-06-"+want[i]+":PA/Philadelphia" {
+			t.Errorf("ids[%d] = %s, want day %s", i, id, want[i])
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tyrm/ph-weather/internal/provider"
+	"github.com/tyrm/ph-weather/internal/status"
+)
+
+func TestWUBreakerPerEnv(t *testing.T) {
+	settings := map[string]string{"WU_BREAKER_FAILURES": "1", "WU_BREAKER_COOLDOWN": "30s"}
+	failing := newTestServer(t, settings)
+	failing.wu.respond = func(string, string) (int, string) { return 500, "" }
+	healthy := newTestServer(t, settings)
+
+	if _, err := failing.env.weather.Fetch(context.Background(), "conditions", "PA/Philadelphia"); err == nil {
+		t.Fatal("Fetch succeeded against a failing WU")
+	}
+	if _, err := failing.env.weather.Fetch(context.Background(), "conditions", "PA/Philadelphia"); status.Code(err) != 503 {
+		t.Errorf("Fetch with the breaker open = %v, want a 503", err)
+	}
+	if failing.wu.calls() != 1 {
+		t.Errorf("WU called %d times, want 1 before the breaker opened", failing.wu.calls())
+	}
+	if got := attributes(t, failing.get("/readyz"))["upstream_breaker"]; got != provider.BreakerOpen {
+		t.Errorf("readyz upstream_breaker = %v, want open", got)
+	}
+
+	// another Env's breaker is its own
+	if _, err := healthy.env.weather.Fetch(context.Background(), "conditions", "PA/Philadelphia"); err != nil {
+		t.Errorf("Fetch on another Env = %s, want it closed", err)
+	}
+	if got := attributes(t, healthy.get("/readyz"))["upstream_breaker"]; got != provider.BreakerClosed {
+		t.Errorf("readyz upstream_breaker = %v, want closed", got)
+	}
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/jsonapi"
+	"github.com/tyrm/ph-weather/internal/cache"
+	"github.com/tyrm/ph-weather/internal/config"
+)
+
+var (
+	wuBundleCalls = expvar.NewInt("wu_bundle_calls")
+	wuBundleSaved = expvar.NewInt("wu_bundle_saved_calls")
+)
+
+// wuBundleFills are the response cache entries, named as in cliFeatures, a
+// bundled feature fills, and the other features their builds need from WU.
+// Conditions fill their own entry through cachedConditions.
+var wuBundleFills = map[string]struct {
+	responses []string
+	needs     []string
+}{
+	"alerts":    {responses: []string{"alerts"}},
+	"astronomy": {responses: []string{"astronomy", "moon_phase", "sun_phase"}, needs: []string{"geolookup"}},
+	"hourly":    {responses: []string{"hourly"}},
+	"tide":      {responses: []string{"tides"}},
+}
+
+// wuBundleKey holds one feature split out of a bundled response on day, so
+// a part fetched before midnight never stands in for the next day's
+func (env *Env) wuBundleKey(feature string, location string, day time.Time) string {
+	return env.config().RedisKey("wu_bundle", feature, location, day.Format(dateFormat))
+}
+
+// getWUBundled serves a call whose features are all in WU_BUNDLE_FEATURES.
+// Features left over from an earlier bundled call are served from Redis
+// without a call. Otherwise the features requested and the rest of the
+// bundle are fetched together, split, and the other features' cache entries
+// filled from them. ok is false for calls that aren't bundled.
+func (env *Env) getWUBundled(ctx context.Context, feature string, location Location) (resString string, ok bool, resError error) {
+	bundle := map[string]bool{}
+	for _, name := range env.config().WUBundleFeatures {
+		if config.WUFeatureMembers[name] != nil {
+			bundle[name] = true
+		}
+	}
+	requested := strings.Split(feature, "/")
+	for _, name := range requested {
+		if !bundle[name] {
+			return
+		}
+	}
+	if len(bundle) < 2 {
+		return
+	}
+	ok = true
+
+	day := env.today()
+	if combined, found := env.getWUBundleParts(ctx, requested, location.Query, day); found {
+		wuBundleSaved.Add(1)
+		resString = combined
+		return
+	}
+
+	var features []string
+	for name := range bundle {
+		features = append(features, name)
+	}
+	sort.Strings(features)
+
+	resString, resError = env.weather.Fetch(ctx, strings.Join(features, "/"), location.Query)
+	if resError != nil {
+		return
+	}
+	wuBundleCalls.Add(1)
+	stored := env.storeWUBundleParts(ctx, resString, features, requested, location.Query, day)
+	env.fillBundledCaches(context.WithoutCancel(ctx), location, day, stored)
+	return
+}
+
+// getWUBundleParts combines the stored parts of the requested features,
+// found only when all of them are stored
+func (env *Env) getWUBundleParts(ctx context.Context, requested []string, location string, day time.Time) (combined string, found bool) {
+	members := map[string]json.RawMessage{}
+	for _, feature := range requested {
+		part, err := env.redis.Get(ctx, env.wuBundleKey(feature, location, day)).Result()
+		if err != nil {
+			return
+		}
+		if err := json.Unmarshal([]byte(part), &members); err != nil {
+			return
+		}
+	}
+
+	body, err := json.Marshal(members)
+	if err != nil {
+		return
+	}
+	return string(body), true
+}
+
+// storeWUBundleParts keeps each feature of a bundled response that wasn't
+// requested, returning those stored. A feature missing from the response
+// isn't stored, so its next cache miss makes its own call.
+func (env *Env) storeWUBundleParts(ctx context.Context, body string, features []string, requested []string, location string, day time.Time) (stored map[string]bool) {
+	stored = map[string]bool{}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(body), &members); err != nil {
+		// the caller reports the response it can't parse
+		return
+	}
+
+	skip := map[string]bool{}
+	for _, name := range requested {
+		skip[name] = true
+	}
+
+	for _, feature := range features {
+		if skip[feature] {
+			continue
+		}
+
+		part := map[string]json.RawMessage{}
+		if response, ok := members["response"]; ok {
+			part["response"] = response
+		}
+		complete := true
+		for _, member := range config.WUFeatureMembers[feature] {
+			value, ok := members[member]
+			if !ok {
+				complete = false
+				break
+			}
+			part[member] = value
+		}
+		if !complete {
+			log.Printf("Bundled WU response for %s has no %s, not storing it", location, feature)
+			continue
+		}
+
+		partJSON, err := json.Marshal(part)
+		if err != nil {
+			continue
+		}
+		if err := env.redis.Set(ctx, env.wuBundleKey(feature, location, day), partJSON, env.config().WUBundleTTL).Err(); err != nil {
+			log.Printf("Error storing bundled WU %s for %s: %s", feature, location, err)
+			continue
+		}
+		stored[feature] = true
+	}
+	return
+}
+
+// fillBundledCaches builds the cache entries of the features stored from a
+// bundled response, so their next request is a hit. The builds read the
+// stored parts rather than calling WU, so a feature is skipped when a part
+// its build needs wasn't stored. Entries already cached are left alone.
+func (env *Env) fillBundledCaches(ctx context.Context, location Location, day time.Time, stored map[string]bool) {
+	features := make([]string, 0, len(stored))
+	for feature := range stored {
+		features = append(features, feature)
+	}
+	sort.Strings(features)
+
+	for _, feature := range features {
+		if feature == "conditions" {
+			if _, err := env.cachedConditions(ctx, location, true); err != nil {
+				log.Printf("Error filling bundled conditions for %s: %s", location.Key(), err)
+			}
+			continue
+		}
+
+		fill := wuBundleFills[feature]
+		ready := true
+		for _, need := range fill.needs {
+			ready = ready && stored[need]
+		}
+		if !ready {
+			continue
+		}
+
+		for _, name := range fill.responses {
+			if err := env.fillCache(ctx, cliFeatures[name], location, day); err != nil {
+				log.Printf("Error filling bundled %s for %s: %s", name, location.Key(), err)
+			}
+		}
+	}
+}
+
+// fillCache builds a feature's cache entry and its stale copy unless the
+// entry is already cached
+func (env *Env) fillCache(ctx context.Context, feature cliFeature, location Location, day time.Time) error {
+	cacheKey := feature.cacheKey(env, location, day)
+	if cached, err := env.cache.Get(ctx, cacheKey); err != nil || cached != nil {
+		return err
+	}
+
+	responseObj, err := feature.build(env, location, day)(ctx)
+	if err != nil {
+		return err
+	}
+	var payload bytes.Buffer
+	if err := jsonapi.MarshalPayload(&payload, responseObj); err != nil {
+		return fmt.Errorf("Error marshaling response: %s", err)
+	}
+
+	if _, err := env.cache.Set(ctx, cacheKey, payload.String(), feature.ttl(env, day)); err != nil {
+		return err
+	}
+	_, err = env.cache.Set(ctx, cache.StaleKey(cacheKey), payload.String(), env.config().StaleTTL)
+	return err
+}
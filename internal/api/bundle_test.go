@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBundleFillsFeatureCaches(t *testing.T) {
+	server := newTestServer(t, map[string]string{"WU_BUNDLE_FEATURES": "alerts,astronomy,conditions,geolookup,hourly,tide"})
+
+	if response := server.get("/weather/alerts/v1"); response.Code != 200 {
+		t.Fatalf("alerts: status = %d, want 200: %s", response.Code, response.Body)
+	}
+	if server.wu.calls() != 1 || server.wu.features[0] != "alerts/astronomy/conditions/geolookup/hourly/tide" {
+		t.Fatalf("WU calls = %v, want one bundled call", server.wu.features)
+	}
+
+	// every other feature was cached from the bundle
+	for _, key := range []string{
+		server.env.cacheKey("hourly", "PA/Philadelphia", time.Time{}),
+		server.env.cacheKey("tides", "PA/Philadelphia", testNow),
+		server.env.cacheKey("astronomy", "PA/Philadelphia", testNow),
+		server.env.cacheKey("moon_phase_v2", "PA/Philadelphia", testNow),
+		server.env.sunPhaseCacheKey("PA/Philadelphia", testNow),
+		server.env.cacheKey("wu_conditions", "PA/Philadelphia", time.Time{}),
+	} {
+		if !server.redis.Exists(key) {
+			t.Errorf("%s not filled from the bundle", key)
+		}
+	}
+
+	for _, path := range []string{"/weather/hourly/v1", "/weather/tides/v1", "/weather/sun_phase/v1", "/weather/moon_phase/v2"} {
+		if response := server.get(path); response.Code != 200 {
+			t.Errorf("%s: status = %d, want 200", path, response.Code)
+		}
+	}
+	if server.wu.calls() != 1 {
+		t.Errorf("WU called %d times, want the one bundled call: %v", server.wu.calls(), server.wu.features)
+	}
+}
+
+func TestBundlePartsDated(t *testing.T) {
+	server := newTestServer(t, map[string]string{"WU_BUNDLE_FEATURES": "alerts,hourly"})
+	now := time.Date(2024, 6, 20, 23, 59, 0, 0, testTZ)
+	server.env.now = func() time.Time { return now }
+
+	server.get("/weather/alerts/v1")
+	if !server.redis.Exists(server.env.wuBundleKey("hourly", "PA/Philadelphia", now)) {
+		t.Fatalf("hourly part not stored, have %v", server.redis.Keys())
+	}
+
+	// after midnight the part left from the day before isn't used
+	server.env.cache.Delete(context.Background(), server.env.cacheKey("hourly", "PA/Philadelphia", time.Time{}))
+	now = now.Add(2 * time.Minute)
+	server.get("/weather/hourly/v1")
+	if server.wu.calls() != 2 {
+		t.Errorf("WU called %d times, want a new bundled call after midnight", server.wu.calls())
+	}
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/jsonapi"
+	"github.com/tyrm/ph-weather/internal/cache"
+	"github.com/tyrm/ph-weather/internal/status"
+	"github.com/tyrm/ph-weather/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// serveCached answers from the cache when possible. Otherwise build creates
+// the model, which is marshaled, cached for ttl and sent. Errors from build
+// are reported with their status.Error status, or 500.
+func (env *Env) serveCached(response http.ResponseWriter, request *http.Request, cacheKey string, ttl time.Duration, build func(context.Context) (interface{}, error)) {
+	cacheEntry, err := env.getOrBuildCache(request, cacheKey, ttl, build)
+	if err != nil {
+		logRequest(request, "%s", err)
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	// Send response
+	writeCacheEntry(response, request, cacheEntry)
+}
+
+// getOrBuildCache returns the cached entry for cacheKey, building and caching
+// it on a miss under a cluster wide lock. Concurrent misses in this instance
+// share one build. Cache errors are logged and don't fail the request.
+func (env *Env) getOrBuildCache(request *http.Request, cacheKey string, ttl time.Duration, build func(context.Context) (interface{}, error)) (cacheEntry *cache.Entry, resError error) {
+	ctx := request.Context()
+
+	cacheEntry, err := env.cache.Get(ctx, cacheKey)
+	if err != nil {
+		logRequest(request, "Error reading cache: %s", err)
+	} else if cacheEntry != nil {
+		traceCacheResult(request, "hit")
+		return
+	}
+
+	// nothing is fetched for a client that has gone away
+	if resError = status.FromContext(ctx); resError != nil {
+		return
+	}
+
+	// requests in this instance share one build of a key, so each refresh is
+	// fetched and announced once
+	leader := false
+	shared, err, _ := env.builds.Do(cacheKey, func() (interface{}, error) {
+		leader = true
+		entry, err := env.buildCache(request, cacheKey, ttl, build)
+		return sharedBuild{entry: entry, cancelled: ctx.Err() != nil}, err
+	})
+	result := shared.(sharedBuild)
+	if !leader && err != nil && result.cancelled && ctx.Err() == nil {
+		// the request that was building went away, this one still wants it
+		result.entry, err = env.buildCache(request, cacheKey, ttl, build)
+	} else if !leader {
+		traceCacheResult(request, "wait")
+	}
+	return result.entry, err
+}
+
+// sharedBuild is what getOrBuildCache hands the requests that waited on a
+// build, cancelled when the request that built it went away meanwhile
+type sharedBuild struct {
+	entry     *cache.Entry
+	cancelled bool
+}
+
+// buildCache builds and caches cacheKey under a cluster wide lock, falling
+// back to the stale copy when build fails upstream
+func (env *Env) buildCache(request *http.Request, cacheKey string, ttl time.Duration, build func(context.Context) (interface{}, error)) (cacheEntry *cache.Entry, resError error) {
+	ctx := request.Context()
+
+	// only one instance refreshes a key at a time, the others wait for its result
+	release, acquired := env.cache.Lock(ctx, cacheKey)
+	if !acquired {
+		if cacheEntry = env.cache.AwaitRefresh(ctx, cacheKey); cacheEntry != nil {
+			traceCacheResult(request, "wait")
+			return
+		}
+	} else {
+		defer release()
+	}
+	traceCacheResult(request, "miss")
+
+	buildCtx, buildSpan := tracing.Tracer.Start(ctx, "cache build", trace.WithAttributes(attribute.String("cache.key", cacheKey)))
+	responseObj, err := build(buildCtx)
+	tracing.EndSpan(buildSpan, err)
+	if err != nil {
+		resError = err
+
+		// upstream failures fall back to the last good copy, client errors don't
+		if status.Code(err) < 500 {
+			return
+		}
+		staleEntry, staleErr := env.cache.Get(ctx, cache.StaleKey(cacheKey))
+		if staleErr != nil {
+			logRequest(request, "Error reading stale cache: %s", staleErr)
+		} else if staleEntry != nil {
+			logRequest(request, "Serving stale %s: %s", cacheKey, err)
+			traceCacheResult(request, "stale")
+			staleEntry.Stale = true
+			cacheEntry, resError = staleEntry, nil
+		}
+		return
+	}
+
+	// Build Response
+	var eventPayload bytes.Buffer
+	if err := jsonapi.MarshalPayload(&eventPayload, responseObj); err != nil {
+		resError = fmt.Errorf("Error marshaling response: %s", err)
+		return
+	}
+
+	// what was built is kept even when the client has gone away meanwhile
+	storeCtx := context.WithoutCancel(ctx)
+
+	cacheEntry, err = env.cache.Set(storeCtx, cacheKey, eventPayload.String(), ttl)
+	if err != nil {
+		logRequest(request, "Error commiting to cache: %s", err)
+	}
+	if _, err := env.cache.Set(storeCtx, cache.StaleKey(cacheKey), eventPayload.String(), env.config().StaleTTL); err != nil {
+		logRequest(request, "Error commiting to stale cache: %s", err)
+	}
+	return
+}
+
+// writeCacheEntry sends a jsonapi body with its ETag, or a 304 when the
+// client already holds it
+func writeCacheEntry(response http.ResponseWriter, request *http.Request, entry *cache.Entry) {
+	// a bad field is refused even when the client holds the document
+	fieldsets := sparseFieldsets(request)
+	if err := checkSparseFieldsets(fieldsets); err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	etag := sparseFieldsetsETag(formatETag(entry.ETag, requestFormat(request)), fieldsets)
+	response.Header().Set("ETag", etag)
+	if entry.Stale {
+		response.Header().Set("Warning", `110 - "Response is Stale"`)
+	}
+
+	if cache.ETagMatches(request.Header.Get("If-None-Match"), etag) {
+		response.WriteHeader(304)
+		return
+	}
+
+	writeDocument(response, request, []byte(entry.Body))
+}
@@ -0,0 +1,295 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/jsonapi"
+	"github.com/tyrm/ph-weather/internal/cache"
+	"github.com/tyrm/ph-weather/internal/config"
+	"github.com/tyrm/ph-weather/internal/provider"
+)
+
+// cliFeature is a feature the get command can fetch, with the cache entry
+// the server keeps it under
+type cliFeature struct {
+	// dated features accept --date, the others are only known for today
+	dated    bool
+	ttl      func(env *Env, day time.Time) time.Duration
+	cacheKey func(env *Env, location Location, day time.Time) string
+	build    func(env *Env, location Location, day time.Time) func(context.Context) (interface{}, error)
+}
+
+// cliFeatures are set in init since their builds, through the WU bundle,
+// refer back to them
+var cliFeatures map[string]cliFeature
+
+func init() {
+	cliFeatures = map[string]cliFeature{
+		"sun_phase": {
+			dated: true,
+			ttl:   (*Env).sunPhaseTTL,
+			cacheKey: func(env *Env, location Location, day time.Time) string {
+				return env.sunPhaseCacheKey(location.Key(), day)
+			},
+			build: (*Env).buildSunPhase,
+		},
+		"astronomy": {
+			ttl: (*Env).sunPhaseTTL,
+			cacheKey: func(env *Env, location Location, day time.Time) string {
+				return env.cacheKey("astronomy", location.Key(), day)
+			},
+			build: (*Env).buildAstronomy,
+		},
+		"moon_phase": {
+			dated: true,
+			ttl:   (*Env).sunPhaseTTL,
+			cacheKey: func(env *Env, location Location, day time.Time) string {
+				return env.cacheKey("moon_phase_v2", location.Key(), day)
+			},
+			build: func(env *Env, location Location, day time.Time) func(context.Context) (interface{}, error) {
+				return func(ctx context.Context) (interface{}, error) {
+					return env.buildMoonPhaseV2(ctx, location, day)
+				}
+			},
+		},
+		"alerts": {
+			ttl: func(env *Env, day time.Time) time.Duration {
+				return alertsTTL
+			},
+			cacheKey: func(env *Env, location Location, day time.Time) string {
+				return env.cacheKey("alerts", location.Key(), time.Time{})
+			},
+			build: func(env *Env, location Location, day time.Time) func(context.Context) (interface{}, error) {
+				return env.buildAlerts(location)
+			},
+		},
+		"hourly": {
+			ttl: func(env *Env, day time.Time) time.Duration {
+				return env.config().HourlyTTL
+			},
+			cacheKey: func(env *Env, location Location, day time.Time) string {
+				return env.cacheKey("hourly", location.Key(), time.Time{})
+			},
+			build: func(env *Env, location Location, day time.Time) func(context.Context) (interface{}, error) {
+				return env.buildHourly(location)
+			},
+		},
+		"tides": {
+			ttl: func(env *Env, day time.Time) time.Duration {
+				return tidesTTL
+			},
+			cacheKey: func(env *Env, location Location, day time.Time) string {
+				return env.cacheKey("tides", location.Key(), day)
+			},
+			build: func(env *Env, location Location, day time.Time) func(context.Context) (interface{}, error) {
+				return env.buildTides(location, day)
+			},
+		},
+	}
+}
+
+func cliFeatureNames() string {
+	names := make([]string, 0, len(cliFeatures))
+	for name := range cliFeatures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// RunGet fetches one feature and prints it to stdout, returning the exit
+// code: 1 when the fetch fails and 2 for usage errors. WU is called through
+// transport.
+func RunGet(config config.Config, transport http.RoundTripper, args []string) int {
+	flags := flag.NewFlagSet("get", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: ph-weather get <feature> [flags]\nFeatures: %s\n", cliFeatureNames())
+		flags.PrintDefaults()
+	}
+	locationFlag := flags.String("location", "", "configured location name or WU location query, WU_LOCATION by default")
+	dateFlag := flags.String("date", "", "day as YYYY-MM-DD, today by default")
+	formatFlag := flags.String("format", "json", "output format, json or table")
+	cacheFlag := flags.Bool("cache", true, "read and fill the Redis response cache")
+
+	// the feature comes first, flags may follow it
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		flags.Usage()
+		return 2
+	}
+	name := args[0]
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	feature, ok := cliFeatures[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown feature %q, expected one of %s\n", name, cliFeatureNames())
+		return 2
+	}
+	if *formatFlag != "json" && *formatFlag != "table" {
+		fmt.Fprintf(os.Stderr, "Unknown format %q, expected json or table\n", *formatFlag)
+		return 2
+	}
+
+	client := cache.NewRedisClient(config)
+	defer client.Close()
+	useCache := *cacheFlag
+	if useCache {
+		if err := client.Ping(context.Background()).Err(); err != nil {
+			log.Printf("Redis is unavailable, fetching without the cache: %s", err)
+			useCache = false
+		}
+	}
+	env := newEnv(config, provider.New(config, client, transport), cache.New(client, config.L1CacheSize))
+
+	location := Location{Query: config.WUndergroundLocation}
+	if *locationFlag != "" {
+		var err error
+		location, err = env.lookupLocation(*locationFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid location: %s\n", err)
+			return 2
+		}
+	}
+
+	day := env.today()
+	if *dateFlag != "" {
+		if !feature.dated {
+			fmt.Fprintf(os.Stderr, "%s is only available for today\n", name)
+			return 2
+		}
+		var err error
+		day, err = time.ParseInLocation(dateFormat, *dateFlag, config.LocationTZ)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "date must be formatted as YYYY-MM-DD\n")
+			return 2
+		}
+	}
+
+	body, err := env.getFeature(feature, location, day, useCache)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error fetching %s: %s\n", name, err)
+		return 1
+	}
+
+	flat, err := flattenDocument([]byte(body))
+	if err == nil {
+		if *formatFlag == "table" {
+			err = printTable(os.Stdout, flat)
+		} else {
+			var indented bytes.Buffer
+			if err = json.Indent(&indented, flat, "", "  "); err == nil {
+				indented.WriteByte('\n')
+				_, err = indented.WriteTo(os.Stdout)
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error printing %s: %s\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+// getFeature returns a feature's jsonapi document, from the cache when
+// useCache is set and it has one
+func (env *Env) getFeature(feature cliFeature, location Location, day time.Time, useCache bool) (body string, resError error) {
+	cacheKey := feature.cacheKey(env, location, day)
+	if useCache {
+		entry, err := env.cache.Get(context.Background(), cacheKey)
+		if err != nil {
+			log.Printf("Error reading cache: %s", err)
+		} else if entry != nil {
+			body = entry.Body
+			return
+		}
+	}
+
+	responseObj, err := feature.build(env, location, day)(context.Background())
+	if err != nil {
+		resError = err
+		return
+	}
+
+	var payload bytes.Buffer
+	if err := jsonapi.MarshalPayload(&payload, responseObj); err != nil {
+		resError = fmt.Errorf("Error marshaling response: %s", err)
+		return
+	}
+	body = payload.String()
+
+	if useCache {
+		if _, err := env.cache.Set(context.Background(), cacheKey, body, feature.ttl(env, day)); err != nil {
+			log.Printf("Error commiting to cache: %s", err)
+		}
+	}
+	return
+}
+
+// printTable writes flattened resources as aligned columns, one resource as
+// attribute and value rows and a collection as one row per resource
+func printTable(w io.Writer, flat []byte) error {
+	var document interface{}
+	if err := json.Unmarshal(flat, &document); err != nil {
+		return err
+	}
+
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	switch document := document.(type) {
+	case map[string]interface{}:
+		for _, name := range sortedAttributes(document) {
+			fmt.Fprintf(table, "%s\t%s\n", name, tableValue(document[name]))
+		}
+	case []interface{}:
+		if len(document) == 0 {
+			break
+		}
+		first, _ := document[0].(map[string]interface{})
+		names := sortedAttributes(first)
+		fmt.Fprintln(table, strings.Join(names, "\t"))
+		for _, row := range document {
+			resource, _ := row.(map[string]interface{})
+			values := make([]string, len(names))
+			for i, name := range names {
+				values[i] = tableValue(resource[name])
+			}
+			fmt.Fprintln(table, strings.Join(values, "\t"))
+		}
+	}
+	return table.Flush()
+}
+
+// sortedAttributes puts the id first, then the attributes by name
+func sortedAttributes(resource map[string]interface{}) (names []string) {
+	for name := range resource {
+		if name != "id" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return append([]string{"id"}, names...)
+}
+
+func tableValue(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "-"
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		return fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/cache"
+	"github.com/tyrm/ph-weather/internal/provider"
+)
+
+// conditionsTTL is how long current conditions are cached, WU updates
+// observations every few minutes
+var conditionsTTL time.Duration = time.Duration(10) * time.Minute
+
+// cachedConditions returns a location's current conditions from the cache.
+// On a miss they are fetched from WU only when fetch is set, otherwise
+// conditions is nil.
+func (env *Env) cachedConditions(ctx context.Context, location Location, fetch bool) (conditions *provider.WUConditions, resError error) {
+	cacheKey := env.cacheKey("wu_conditions", location.Key(), time.Time{})
+
+	cacheVal, err := env.redis.Get(ctx, cacheKey).Result()
+	if err == nil {
+		conditions = &provider.WUConditions{}
+		if json.Unmarshal([]byte(cacheVal), conditions) == nil {
+			return
+		}
+	} else if !cache.Miss(err) {
+		log.Printf("Error reading cache: %s", err)
+	}
+
+	conditions = nil
+	if !fetch {
+		return
+	}
+
+	conditionsJSON, err := env.getWUApiRepose(ctx, "conditions", location)
+	if err != nil {
+		resError = fmt.Errorf("Error fetching conditions: %w", err)
+		return
+	}
+	conditions = &provider.WUConditions{}
+	if err := json.Unmarshal([]byte(conditionsJSON), conditions); err != nil {
+		conditions = nil
+		resError = env.rawParseError("conditions", "conditions", location.Query, err)
+		return
+	}
+
+	env.recordObservation(ctx, location, conditions)
+	if body, err := conditionsPayload(conditions); err == nil {
+		env.refreshed(ctx, location, "conditions", body)
+	}
+
+	if body, err := json.Marshal(conditions); err == nil {
+		if err := env.redis.Set(ctx, cacheKey, string(body), conditionsTTL).Err(); err != nil {
+			log.Printf("Error commiting to cache: %s", err)
+		}
+	}
+	return
+}
+
+// conditionsPayload is the flat JSON of the current conditions pushed to
+// MQTT and event stream clients
+func conditionsPayload(conditions *provider.WUConditions) ([]byte, error) {
+	observation := conditions.CurrentObservation
+	payload := map[string]interface{}{
+		"temperature": provider.ParseWUFloat(observation.TempC.String()),
+		"humidity":    observation.Humidity(),
+	}
+	if epoch := provider.ParseWUFloat(observation.ObservationEpoch); epoch != nil {
+		payload["observation_time_iso"] = time.Unix(int64(*epoch), 0).UTC().Format(time.RFC3339)
+	}
+	return json.Marshal(payload)
+}
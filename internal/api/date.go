@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/status"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const dateFormat = "2006-01-02"
+
+// maxDateYears bounds how far from today a requested date may be
+const maxDateYears = 5
+
+// requestDate returns the ?date= requested in the location's timezone,
+// defaulting to today. Dates more than maxDateYears away are refused.
+func (env *Env) requestDate(request *http.Request) (day time.Time, resError error) {
+	date := request.URL.Query().Get("date")
+	if date == "" {
+		day = env.today()
+	} else if day, resError = env.parseDate("date", date); resError != nil {
+		return
+	}
+
+	traceAttributes(request, attribute.String("weather.date", day.Format(dateFormat)))
+	return
+}
+
+// parseDate reads the date given for the query parameter name in the
+// location's timezone
+func (env *Env) parseDate(name string, date string) (day time.Time, resError error) {
+	today := env.today()
+
+	day, err := time.ParseInLocation(dateFormat, date, env.config().LocationTZ)
+	if err != nil {
+		resError = status.Errorf(400, "%s must be formatted as YYYY-MM-DD", name)
+		return
+	}
+
+	if day.Before(today.AddDate(-maxDateYears, 0, -1)) || day.After(today.AddDate(maxDateYears, 0, 0)) {
+		resError = status.Errorf(422, "%s must be within %d years of today", name, maxDateYears)
+	}
+	return
+}
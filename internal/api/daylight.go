@@ -0,0 +1,86 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/config"
+)
+
+// daylightHorizonDays is how far ahead to look for the next sunrise or
+// sunset, enough to get through a polar night
+const daylightHorizonDays = 190
+
+type DaylightResponse struct {
+	ResponseID  string  `jsonapi:"primary,daylight"`
+	Daytime     bool    `jsonapi:"attr,daytime"`
+	UntilChange *int64  `jsonapi:"attr,until_change_s"`
+	NextChange  *string `jsonapi:"attr,next_change_iso"`
+}
+
+// sunEvent is a sunrise (daytime true) or sunset (daytime false)
+type sunEvent struct {
+	at      time.Time
+	daytime bool
+}
+
+// makeDaylightResponse takes the state from the latest sunrise or sunset at
+// or before now, so the moment of sunrise counts as day and the moment of
+// sunset as night, and counts down to the next opposite event, which may be
+// months away near the poles
+func makeDaylightResponse(id string, coordinates config.Coordinates, now time.Time) (responseObj *DaylightResponse) {
+	responseObj = &DaylightResponse{ResponseID: id}
+	now = now.Truncate(time.Second)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	var events []sunEvent
+	for offset := -2; offset <= daylightHorizonDays; offset++ {
+		day := today.AddDate(0, 0, offset)
+		rise, set, _ := sunCrossings(day, coordinates.Latitude, coordinates.Longitude, sunriseAltitude)
+		if rise != nil {
+			events = append(events, sunEvent{rise.Truncate(time.Second), true})
+		}
+		if set != nil {
+			events = append(events, sunEvent{set.Truncate(time.Second), false})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].at.Before(events[j].at) })
+
+	// fall back to the sun's altitude when there was no recent event
+	altitude, _ := sunPosition(now, coordinates.Latitude, coordinates.Longitude)
+	responseObj.Daytime = altitude > sunriseAltitude
+	next := 0
+	for ; next < len(events) && !events[next].at.After(now); next++ {
+		responseObj.Daytime = events[next].daytime
+	}
+
+	for _, event := range events[next:] {
+		if event.daytime != responseObj.Daytime {
+			untilChange := int64(event.at.Sub(now).Seconds())
+			responseObj.UntilChange = &untilChange
+			responseObj.NextChange = formatTime(event.at)
+			break
+		}
+	}
+	return
+}
+
+// handleDaylight tells automations whether the sun is up and how long until
+// that changes, computed locally without calling WU
+func (env *Env) handleDaylight(response http.ResponseWriter, request *http.Request) {
+	location, err := env.requestLocation(request)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	coordinates, err := env.locationCoordinates(request.Context(), location)
+	if err != nil {
+		logRequest(request, "%s", err)
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	writePayload(response, request, makeDaylightResponse(location.Key(), *coordinates, env.today()))
+}
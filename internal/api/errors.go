@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+)
+
+// codeTitle maps application error codes to titles, extended by ERROR_CATALOG_FILE
+var codeTitle = map[int]string{
+	1:    "Malformed JSON Body",
+	2201: "Missing Required Attribute",
+	2202: "Requested Relationship Not Found",
+}
+
+var statusTitle = map[int]string{
+	400: "Bad Request",
+	401: "Unauthorized",
+	404: "Not Found",
+	405: "Method Not Allowed",
+	406: "Not Acceptable",
+	409: "Conflict",
+	415: "Unsupported Media Type",
+	422: "Unprocessable Entity",
+	429: "Too Many Requests",
+	499: "Client Closed Request",
+	500: "Internal Server Error",
+	502: "Bad Gateway",
+	503: "Service Unavailable",
+	504: "Gateway Timeout",
+}
+
+// loadErrorCatalog merges a JSON object of code to title, like
+// {"3001": "Location Not Configured"}, into codeTitle. Codes already in the
+// catalog are overridden. It must run before the server starts.
+func loadErrorCatalog(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var catalog map[string]string
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return fmt.Errorf("Error parsing %s: %s", path, err)
+	}
+
+	for key, title := range catalog {
+		code, err := strconv.Atoi(key)
+		if err != nil || code <= 0 {
+			return fmt.Errorf("Error parsing %s: %q is not a valid error code", path, key)
+		}
+		codeTitle[code] = title
+	}
+	return nil
+}
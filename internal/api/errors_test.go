@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/jsonapi"
+)
+
+func TestMakeErrorResponseContentType(t *testing.T) {
+	response := httptest.NewRecorder()
+	makeErrorResponse(response, 502, "upstream returned Bad Gateway", 0)
+
+	// a recorder keeps headers set after WriteHeader, the result is what was sent
+	result := response.Result()
+	if got := result.Header.Get("Content-Type"); got != jsonapi.MediaType {
+		t.Errorf("Content-Type = %q, want %s", got, jsonapi.MediaType)
+	}
+	if result.StatusCode != 502 {
+		t.Errorf("status = %d, want 502", result.StatusCode)
+	}
+}
+
+func TestErrorResponsesContentType(t *testing.T) {
+	server := newTestServer(t, nil)
+	server.wu.respond = func(feature string, location string) (int, string) {
+		return 500, ""
+	}
+
+	for _, test := range []struct {
+		path   string
+		header []string
+		status int
+	}{
+		{"/nowhere", nil, 404},
+		{"/weather/sun_phase/v1?date=someday", nil, 400},
+		{"/weather/sun_phase/v1", []string{"Accept", "text/html"}, 406},
+		{"/weather/sun_phase/v1", nil, 502},
+		{"/weather/sun_phase/v1", []string{"Accept", "application/json"}, 502},
+	} {
+		response := server.get(test.path, test.header...)
+		result := response.Result()
+		if result.StatusCode != test.status {
+			t.Errorf("%s %v: status = %d, want %d", test.path, test.header, result.StatusCode, test.status)
+		}
+		if got := result.Header.Get("Content-Type"); got != jsonapi.MediaType {
+			t.Errorf("%s %v: Content-Type = %q, want %s", test.path, test.header, got, jsonapi.MediaType)
+		}
+		if status, _ := jsonapiError(t, response); status != result.Status[:3] {
+			t.Errorf("%s %v: error status %s, response %s", test.path, test.header, status, result.Status)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/jsonapi"
+)
+
+const (
+	streamHeartbeat = 30 * time.Second
+
+	// eventBuffer is how many events a slow stream client may fall behind
+	// before further events are dropped for it
+	eventBuffer = 16
+)
+
+var streamClients = expvar.NewInt("stream_clients")
+
+// Event is fresh data for a location, as flat JSON
+type Event struct {
+	Location string
+	Feature  string
+	Data     []byte
+}
+
+// EventBroker fans refreshed data out to in-process subscribers such as
+// event stream clients
+type EventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+func newEventBroker() *EventBroker {
+	return &EventBroker{subscribers: make(map[chan Event]struct{})}
+}
+
+func (broker *EventBroker) Subscribe() chan Event {
+	ch := make(chan Event, eventBuffer)
+	broker.mu.Lock()
+	broker.subscribers[ch] = struct{}{}
+	broker.mu.Unlock()
+	return ch
+}
+
+func (broker *EventBroker) Unsubscribe(ch chan Event) {
+	broker.mu.Lock()
+	delete(broker.subscribers, ch)
+	broker.mu.Unlock()
+}
+
+// Publish never blocks, a subscriber with a full buffer misses the event
+func (broker *EventBroker) Publish(event Event) {
+	if broker == nil {
+		return
+	}
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	for ch := range broker.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// redisEvent is the envelope published to <prefix>events:<feature>
+type redisEvent struct {
+	Feature   string          `json:"feature"`
+	Location  string          `json:"location"`
+	FetchedAt string          `json:"fetched_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// refreshed announces freshly fetched data to MQTT, stream clients and,
+// with REDIS_EVENTS, Redis subscribers
+func (env *Env) refreshed(ctx context.Context, location Location, feature string, body []byte) {
+	env.mqtt.Publish(location, feature, body)
+	env.events.Publish(Event{Location: location.Key(), Feature: feature, Data: body})
+
+	if env.config().RedisEvents {
+		envelope, err := json.Marshal(redisEvent{
+			Feature:   feature,
+			Location:  location.Key(),
+			FetchedAt: env.now().UTC().Format(time.RFC3339),
+			Data:      body,
+		})
+		if err != nil {
+			log.Printf("Error marshaling %s event: %s", feature, err)
+			return
+		}
+		if err := env.redis.Publish(ctx, env.config().RedisKey("events", feature), string(envelope)).Err(); err != nil {
+			log.Printf("Error publishing %s event: %s", feature, err)
+		}
+	}
+}
+
+// refreshedModel announces a jsonapi response model as the same flat JSON
+// the API serves for application/json
+func (env *Env) refreshedModel(ctx context.Context, location Location, feature string, model interface{}) {
+	var payload bytes.Buffer
+	if err := jsonapi.MarshalPayload(&payload, model); err != nil {
+		log.Printf("Error marshaling %s event: %s", feature, err)
+		return
+	}
+	flat, err := flattenDocument(payload.Bytes())
+	if err != nil {
+		log.Printf("Error marshaling %s event: %s", feature, err)
+		return
+	}
+	env.refreshed(ctx, location, feature, flat)
+}
+
+// handleStream holds the connection open as a text/event-stream. It starts
+// with the cached sun phase and conditions, then sends each refresh of the
+// location's data, with heartbeat comments so proxies keep it open. The
+// stream never fetches, the pre-warmer keeps both cached and refreshed.
+func (env *Env) handleStream(response http.ResponseWriter, request *http.Request) {
+	location, err := env.requestLocation(request)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	flusher, ok := response.(http.Flusher)
+	if !ok {
+		makeErrorResponse(response, 500, "streaming is not supported", 0)
+		return
+	}
+
+	// subscribe before reading the cache so no refresh is missed in between
+	events := env.events.Subscribe()
+	defer env.events.Unsubscribe(events)
+	streamClients.Add(1)
+	defer streamClients.Add(-1)
+
+	response.Header().Set("Content-Type", "text/event-stream")
+	response.Header().Set("Cache-Control", "no-cache")
+	response.Header().Set("X-Accel-Buffering", "no") // keep nginx from buffering
+	response.WriteHeader(200)
+	if request.Method == "HEAD" {
+		// only the headers are sent, there is no stream to hold open. Flush
+		// so they go out without a Content-Length, as they do for GET.
+		flusher.Flush()
+		return
+	}
+
+	if entry, err := env.cache.Get(request.Context(), env.sunPhaseCacheKey(location.Key(), env.today())); err != nil {
+		logRequest(request, "Error reading cache: %s", err)
+	} else if entry != nil {
+		if flat, err := flattenDocument([]byte(entry.Body)); err == nil {
+			writeEvent(response, "sun_phase", flat)
+		}
+	}
+	if conditions, err := env.cachedConditions(request.Context(), location, false); err != nil {
+		logRequest(request, "%s", err)
+	} else if conditions != nil {
+		if body, err := conditionsPayload(conditions); err == nil {
+			writeEvent(response, "conditions", body)
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(response, ": heartbeat\n\n")
+		case event := <-events:
+			if event.Location != location.Key() {
+				continue
+			}
+			writeEvent(response, event.Feature, event.Data)
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes one event, data is compact JSON so it fits one data line
+func writeEvent(response http.ResponseWriter, name string, data []byte) {
+	fmt.Fprintf(response, "event: %s\ndata: %s\n\n", name, data)
+}
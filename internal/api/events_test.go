@@ -0,0 +1,206 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/tyrm/ph-weather/internal/provider"
+)
+
+func TestRefreshPublishesOnce(t *testing.T) {
+	server := newTestServer(t, map[string]string{"REDIS_EVENTS": "true"})
+
+	subscriber := redis.NewClient(&redis.Options{Addr: server.redis.Addr()})
+	defer subscriber.Close()
+	subscription := subscriber.Subscribe(context.Background(), "ph:events:sun_phase")
+	defer subscription.Close()
+	if _, err := subscription.Receive(context.Background()); err != nil {
+		t.Fatalf("subscribing: %s", err)
+	}
+	messages := subscription.Channel()
+
+	// WU holds its answer until every request is waiting for it
+	answer := make(chan struct{})
+	server.wu.respond = func(feature string, location string) (int, string) {
+		<-answer
+		body, _ := provider.MockWUResponse(feature, location, testNow)
+		return 200, body
+	}
+
+	var requests sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		requests.Add(1)
+		go func() {
+			defer requests.Done()
+			if response := server.get("/weather/sun_phase/v1"); response.Code != 200 {
+				t.Errorf("status = %d, want 200: %s", response.Code, response.Body)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(answer)
+	requests.Wait()
+
+	if server.wu.calls() != 1 {
+		t.Errorf("WU called %d times, want 1", server.wu.calls())
+	}
+
+	select {
+	case message := <-messages:
+		var event redisEvent
+		if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+			t.Fatalf("decoding %s: %s", message.Payload, err)
+		}
+		if event.Feature != "sun_phase" || event.Location != "PA/Philadelphia" {
+			t.Errorf("event for %s %s, want sun_phase PA/Philadelphia", event.Feature, event.Location)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event published")
+	}
+	select {
+	case message := <-messages:
+		t.Errorf("refresh published twice, again %s", message.Payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// sseEvent is one event read off an event stream
+type sseEvent struct {
+	name string
+	data string
+}
+
+// openStream connects to the event stream at path and returns the events it
+// sends until disconnect is called
+func (server *testServer) openStream(t *testing.T, path string) (events chan sseEvent, disconnect func()) {
+	t.Helper()
+	httpServer := httptest.NewServer(server.router)
+	t.Cleanup(httpServer.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	request, _ := http.NewRequestWithContext(ctx, "GET", httpServer.URL+path, nil)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		cancel()
+		t.Fatalf("connecting to %s: %s", path, err)
+	}
+	if response.StatusCode != 200 {
+		cancel()
+		t.Fatalf("%s: status = %d, want 200", path, response.StatusCode)
+	}
+
+	events = make(chan sseEvent, eventBuffer)
+	go func() {
+		defer response.Body.Close()
+		var event sseEvent
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				event.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				event.data = strings.TrimPrefix(line, "data: ")
+			case line == "" && event.name != "":
+				events <- event
+				event = sseEvent{}
+			}
+		}
+	}()
+	return events, cancel
+}
+
+// nextEvent waits for the stream's next event
+func nextEvent(t *testing.T, events chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event sent")
+	}
+	return sseEvent{}
+}
+
+func TestStreamInitialEvents(t *testing.T) {
+	server := newTestServer(t, nil)
+	server.env.prewarm(context.Background())
+	calls := server.wu.calls()
+
+	events, disconnect := server.openStream(t, "/weather/stream/v1")
+	defer disconnect()
+
+	// what the pre-warmer cached, sun phase first
+	if event := nextEvent(t, events); event.name != "sun_phase" || !strings.Contains(event.data, `"sunrise_h":6`) {
+		t.Errorf("first event = %s %s, want today's sun phase", event.name, event.data)
+	}
+	if event := nextEvent(t, events); event.name != "conditions" || !strings.Contains(event.data, `"temperature":18.5`) {
+		t.Errorf("second event = %s %s, want the conditions", event.name, event.data)
+	}
+	if server.wu.calls() != calls {
+		t.Errorf("WU called %d more times, want the stream served from the cache", server.wu.calls()-calls)
+	}
+}
+
+func TestStreamPrewarmRefresh(t *testing.T) {
+	server := newTestServer(t, nil)
+	// nothing cached yet, so nothing to start with
+	events, disconnect := server.openStream(t, "/weather/stream/v1")
+	defer disconnect()
+
+	server.env.prewarm(context.Background())
+
+	received := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		received[nextEvent(t, events).name] = true
+	}
+	if !received["sun_phase"] || !received["conditions"] {
+		t.Errorf("refreshes sent %v, want sun_phase and conditions", received)
+	}
+}
+
+func TestStreamLocationFilter(t *testing.T) {
+	server := newTestServer(t, map[string]string{"LOCATIONS": "home:PA/Philadelphia,office:NY/New_York"})
+	events, disconnect := server.openStream(t, "/weather/stream/v1/office")
+	defer disconnect()
+
+	server.env.events.Publish(Event{Location: "home", Feature: "conditions", Data: []byte(`{"for":"home"}`)})
+	server.env.events.Publish(Event{Location: "office", Feature: "conditions", Data: []byte(`{"for":"office"}`)})
+
+	if event := nextEvent(t, events); event.data != `{"for":"office"}` {
+		t.Errorf("first event = %s %s, want only office's", event.name, event.data)
+	}
+}
+
+func TestStreamDisconnect(t *testing.T) {
+	server := newTestServer(t, nil)
+	before := streamClients.Value()
+
+	_, disconnect := server.openStream(t, "/weather/stream/v1")
+	// counted before the headers are sent
+	if got := streamClients.Value(); got != before+1 {
+		t.Errorf("stream_clients while connected = %d, want %d", got, before+1)
+	}
+
+	disconnect()
+	deadline := time.Now().Add(time.Second)
+	for streamClients.Value() != before {
+		if time.Now().After(deadline) {
+			t.Fatalf("stream_clients after disconnecting = %d, want %d", streamClients.Value(), before)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	server.env.events.mu.Lock()
+	defer server.env.events.mu.Unlock()
+	if len(server.env.events.subscribers) != 0 {
+		t.Errorf("%d subscribers left after disconnecting", len(server.env.events.subscribers))
+	}
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/google/jsonapi"
+	"github.com/tyrm/ph-weather/internal/status"
+)
+
+// resourceModels are the jsonapi models the API serves, read for the fields
+// of each resource type
+var resourceModels = []interface{}{
+	AlertResponse{}, AstronomyResponse{}, DaylightResponse{}, GoldenHourResponse{},
+	HourlyResponse{}, MoonPhaseV2Response{}, ObservationResponse{}, QuotaResponse{},
+	ReadinessResponse{}, SeasonsResponse{}, SunPhaseRespose{}, SunPhaseV2Response{},
+	SunPositionResponse{}, TidesResponse{}, TwilightResponse{}, VersionResponse{},
+}
+
+// resourceFields are the attributes and relationships of each resource type,
+// from the jsonapi tags of resourceModels. Versions of a type share one set.
+var resourceFields = modelFields(resourceModels)
+
+func init() {
+	// added to today's sun phase as it is sent, see withSunPhaseNow
+	resourceFields["sun_phase"]["is_daytime"] = true
+	resourceFields["sun_phase"]["phase"] = true
+}
+
+// modelFields reads the fields of models by resource type
+func modelFields(models []interface{}) map[string]map[string]bool {
+	fields := map[string]map[string]bool{}
+	for _, model := range models {
+		modelType := reflect.TypeOf(model)
+		var resourceType string
+		var names []string
+		for i := 0; i < modelType.NumField(); i++ {
+			tag := strings.Split(modelType.Field(i).Tag.Get("jsonapi"), ",")
+			if len(tag) < 2 {
+				continue
+			}
+			switch tag[0] {
+			case "primary":
+				resourceType = tag[1]
+			case "attr", "relation":
+				names = append(names, tag[1])
+			}
+		}
+		if fields[resourceType] == nil {
+			fields[resourceType] = map[string]bool{}
+		}
+		for _, name := range names {
+			fields[resourceType][name] = true
+		}
+	}
+	return fields
+}
+
+// sparseFieldsets returns the JSON:API ?fields[type]= lists of a request by
+// resource type. An empty list asks for no fields of that type.
+func sparseFieldsets(request *http.Request) map[string][]string {
+	fieldsets := map[string][]string{}
+	for key, values := range request.URL.Query() {
+		if !strings.HasPrefix(key, "fields[") || !strings.HasSuffix(key, "]") {
+			continue
+		}
+
+		fields := []string{}
+		for _, value := range values {
+			for _, field := range strings.Split(value, ",") {
+				if field = strings.TrimSpace(field); field != "" {
+					fields = append(fields, field)
+				}
+			}
+		}
+		fieldsets[key[len("fields["):len(key)-1]] = fields
+	}
+	return fieldsets
+}
+
+// checkSparseFieldsets refuses a field its type doesn't have with a 400,
+// whether or not the document holds resources of the type. Types the API
+// doesn't serve are ignored.
+func checkSparseFieldsets(fieldsets map[string][]string) error {
+	// checked in order so the same request always names the same field
+	resourceTypes := make([]string, 0, len(fieldsets))
+	for resourceType := range fieldsets {
+		resourceTypes = append(resourceTypes, resourceType)
+	}
+	sort.Strings(resourceTypes)
+	for _, resourceType := range resourceTypes {
+		known, ok := resourceFields[resourceType]
+		if !ok {
+			continue
+		}
+		for _, field := range fieldsets[resourceType] {
+			if !known[field] {
+				return status.Errorf(400, "fields[%s]: %s is not a field of %s", resourceType, field, resourceType)
+			}
+		}
+	}
+	return nil
+}
+
+// sparseFieldsetsETag gives a document trimmed to fieldsets a validator of
+// its own, since its bytes differ from the whole document's
+func sparseFieldsetsETag(etag string, fieldsets map[string][]string) string {
+	if len(fieldsets) == 0 || etag == "" {
+		return etag
+	}
+
+	resourceTypes := make([]string, 0, len(fieldsets))
+	for resourceType := range fieldsets {
+		resourceTypes = append(resourceTypes, resourceType)
+	}
+	sort.Strings(resourceTypes)
+	hash := sha256.New()
+	for _, resourceType := range resourceTypes {
+		fields := append([]string(nil), fieldsets[resourceType]...)
+		sort.Strings(fields)
+		hash.Write([]byte(resourceType + "=" + strings.Join(fields, ",") + ";"))
+	}
+	return strings.TrimSuffix(etag, "\"") + "-fields-" + hex.EncodeToString(hash.Sum(nil))[:12] + "\""
+}
+
+// withSparseFieldsets keeps only the requested fields of each resource in a
+// document, after checkSparseFieldsets. The cache keeps the full document,
+// this runs as it's sent.
+func withSparseFieldsets(body []byte, fieldsets map[string][]string) ([]byte, error) {
+	if len(fieldsets) == 0 {
+		return body, nil
+	}
+	if err := checkSparseFieldsets(fieldsets); err != nil {
+		return nil, err
+	}
+
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(body, &document); err != nil {
+		return nil, err
+	}
+
+	members := map[string][]*jsonapi.Node{}
+	for _, member := range []string{"data", "included"} {
+		raw := bytes.TrimSpace(document[member])
+		if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+			continue
+		}
+
+		var nodes []*jsonapi.Node
+		if raw[0] == '[' {
+			if err := json.Unmarshal(raw, &nodes); err != nil {
+				return nil, err
+			}
+		} else {
+			var node jsonapi.Node
+			if err := json.Unmarshal(raw, &node); err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, &node)
+		}
+		members[member] = nodes
+	}
+
+	for member, nodes := range members {
+		for _, node := range nodes {
+			fields, ok := fieldsets[node.Type]
+			if !ok {
+				continue
+			}
+			keep := map[string]bool{}
+			for _, field := range fields {
+				keep[field] = true
+			}
+			for name := range node.Attributes {
+				if !keep[name] {
+					delete(node.Attributes, name)
+				}
+			}
+			for name := range node.Relationships {
+				if !keep[name] {
+					delete(node.Relationships, name)
+				}
+			}
+		}
+
+		var raw []byte
+		var err error
+		if bytes.TrimSpace(document[member])[0] == '[' {
+			raw, err = json.Marshal(nodes)
+		} else {
+			raw, err = json.Marshal(nodes[0])
+		}
+		if err != nil {
+			return nil, err
+		}
+		document[member] = raw
+	}
+
+	return json.Marshal(document)
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSparseFieldsets(t *testing.T) {
+	server := newTestServer(t, nil)
+
+	response := server.get("/weather/sun_phase/v1?fields[sun_phase]=sunrise_h,sunset_h,phase")
+	if response.Code != 200 {
+		t.Fatalf("status = %d, want 200: %s", response.Code, response.Body)
+	}
+	attrs := attributes(t, response)
+	if len(attrs) != 3 || attrs["sunrise_h"] == nil || attrs["sunset_h"] == nil || attrs["phase"] != DayPhaseDay {
+		t.Errorf("attributes = %v, want sunrise_h, sunset_h and phase", attrs)
+	}
+	if id := resourceID(t, response); id == "" {
+		t.Error("the id was trimmed with the attributes")
+	}
+
+	// an empty list asks for no fields
+	if attrs := attributes(t, server.get("/weather/sun_phase/v1?fields[sun_phase]=")); len(attrs) != 0 {
+		t.Errorf("attributes = %v, want none", attrs)
+	}
+}
+
+func TestSparseFieldsetsCacheHit(t *testing.T) {
+	server := newTestServer(t, nil)
+	full := server.get("/weather/sun_phase/v1")
+
+	trimmed := server.get("/weather/sun_phase/v1?fields[sun_phase]=sunrise_h")
+	if server.wu.calls() != 1 {
+		t.Errorf("WU called %d times, want the cached document trimmed", server.wu.calls())
+	}
+	if attrs := attributes(t, trimmed); len(attrs) != 1 || attrs["sunrise_h"] != float64(6) {
+		t.Errorf("attributes = %v, want only sunrise_h", attrs)
+	}
+	// the cache keeps the whole document
+	if attrs := attributes(t, server.get("/weather/sun_phase/v1")); len(attrs) != len(attributes(t, full)) {
+		t.Errorf("attributes after a sparse request = %v, want all of them", attrs)
+	}
+}
+
+func TestSparseFieldsetsNotModified(t *testing.T) {
+	server := newTestServer(t, nil)
+	fullETag := server.get("/weather/sun_phase/v1").Header().Get("ETag")
+
+	path := "/weather/sun_phase/v1?fields[sun_phase]=sunrise_h"
+	etag := server.get(path).Header().Get("ETag")
+	if etag == "" || etag == fullETag {
+		t.Fatalf("trimmed ETag = %q, want one of its own, not %q", etag, fullETag)
+	}
+	if other := server.get("/weather/sun_phase/v1?fields[sun_phase]=sunset_h").Header().Get("ETag"); other == etag {
+		t.Errorf("another fieldset has the same ETag %s", etag)
+	}
+	// the order fields are listed in doesn't change the representation
+	if same := server.get("/weather/sun_phase/v1?fields[sun_phase]=sunset_h,sunrise_h").Header().Get("ETag"); same != server.get("/weather/sun_phase/v1?fields[sun_phase]=sunrise_h,sunset_h").Header().Get("ETag") {
+		t.Error("field order changed the ETag")
+	}
+
+	if response := server.get(path, "If-None-Match", etag); response.Code != 304 {
+		t.Errorf("status with the trimmed ETag = %d, want 304", response.Code)
+	}
+	if response := server.get(path, "If-None-Match", fullETag); response.Code != 200 {
+		t.Errorf("status with the whole document's ETag = %d, want 200", response.Code)
+	}
+	if response := server.get("/weather/sun_phase/v1", "If-None-Match", etag); response.Code != 200 {
+		t.Errorf("status of the whole document with the trimmed ETag = %d, want 200", response.Code)
+	}
+}
+
+func TestSparseFieldsetsUnknown(t *testing.T) {
+	server := newTestServer(t, nil)
+	fullETag := server.get("/weather/sun_phase/v1").Header().Get("ETag")
+
+	for _, test := range []struct {
+		name   string
+		path   string
+		header []string
+		status int
+	}{
+		{name: "unknown field", path: "/weather/sun_phase/v1?fields[sun_phase]=sunrise_h,bogus", status: 400},
+		{name: "unknown field of a held document", path: "/weather/sun_phase/v1?fields[sun_phase]=bogus", header: []string{"If-None-Match", fullETag}, status: 400},
+		// no alert resources, but alert still has no such field
+		{name: "type not in the document", path: "/weather/alerts/v1?fields[alert]=bogus", status: 400},
+		{name: "known field, type not in the document", path: "/weather/alerts/v1?fields[alert]=message", status: 200},
+		{name: "type not served", path: "/weather/sun_phase/v1?fields[widget]=bogus", status: 200},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			response := server.get(test.path, test.header...)
+			if response.Code != test.status {
+				t.Fatalf("status = %d, want %d: %s", response.Code, test.status, response.Body)
+			}
+			if test.status == 400 && !strings.Contains(response.Body.String(), "bogus is not a field") {
+				t.Errorf("error doesn't name the field: %s", response.Body)
+			}
+		})
+	}
+}
+
+func TestResourceFields(t *testing.T) {
+	if _, ok := resourceFields[""]; ok {
+		t.Error("a resource model has no primary tag")
+	}
+	// v1 and v2 sun phases share the type and so its fields
+	for _, field := range []string{"sunrise_h", "sunrise", "is_daytime"} {
+		if !resourceFields["sun_phase"][field] {
+			t.Errorf("sun_phase has no %s", field)
+		}
+	}
+}
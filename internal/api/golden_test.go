@@ -0,0 +1,187 @@
+package api_test
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/tyrm/ph-weather/internal/api"
+	"github.com/tyrm/ph-weather/internal/cache"
+	"github.com/tyrm/ph-weather/internal/config"
+	"github.com/tyrm/ph-weather/internal/provider"
+)
+
+// updateGolden rewrites testdata/golden from the current responses, run
+// go test -run TestGoldenResponses -update after a deliberate change
+var updateGolden = flag.Bool("update", false, "rewrite the golden responses")
+
+// goldenHeaders are the headers recorded with each golden response
+var goldenHeaders = []string{"Content-Type", "Cache-Control", "ETag", "Allow", "Warning"}
+
+// testTZ and testNow are where and when the golden responses were
+// recorded, a summer afternoon in Philadelphia
+var testTZ, _ = time.LoadLocation("America/New_York")
+var testNow = time.Date(2024, 6, 20, 15, 0, 0, 0, testTZ)
+
+// fakeWU answers requests for /api/<key>/<feature>/q/<location>.json with
+// respond, or provider.MockWUResponse at testNow when respond is nil
+type fakeWU func(feature string, location string) (status int, body string)
+
+func (respond fakeWU) RoundTrip(request *http.Request) (*http.Response, error) {
+	_, rest, _ := strings.Cut(strings.TrimPrefix(request.URL.Path, "/api/"), "/")
+	feature, location, _ := strings.Cut(rest, "/q/")
+	location = strings.TrimSuffix(location, ".json")
+
+	status, body := 200, ""
+	if respond != nil {
+		status, body = respond(feature, location)
+	} else {
+		mock, err := provider.MockWUResponse(feature, location, testNow)
+		if err != nil {
+			return nil, err
+		}
+		body = mock
+	}
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    request,
+	}, nil
+}
+
+// newGoldenServer serves the API from api.New over HTTP, configured from the
+// environment like main does, with Redis in miniredis, WU answered by wu and
+// the clock stopped at testNow
+func newGoldenServer(t *testing.T, wu fakeWU) *httptest.Server {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	for name, value := range map[string]string{
+		"REDIS_ADDR":          mr.Addr(),
+		"REDIS_PREFIX":        "ph:",
+		"WU_KEY":              "wu-test-key-0123",
+		"WU_LOCATION":         "PA/Philadelphia",
+		"LOCATION_TZ":         "America/New_York",
+		"LOCATION_LAT":        "39.952",
+		"LOCATION_LON":        "-75.164",
+		"WU_BREAKER_FAILURES": "0",
+	} {
+		t.Setenv(name, value)
+	}
+	settings, err := config.Collect()
+	if err != nil {
+		t.Fatalf("config.Collect: %s", err)
+	}
+
+	clock := func() time.Time { return testNow }
+	client := cache.NewRedisClient(settings)
+	weather := provider.New(settings, client, http.DefaultTransport)
+	weather.SetClock(clock)
+	weather.SetTransport(wu)
+	handler := api.New(settings, weather, cache.New(client, settings.L1CacheSize))
+	handler.SetClock(clock)
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	// responses are recorded as sent, not gzipped
+	server.Client().Transport.(*http.Transport).DisableCompression = true
+	return server
+}
+
+// goldenResponse formats the status, goldenHeaders and body of response
+func goldenResponse(t *testing.T, response *http.Response) []byte {
+	t.Helper()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var golden bytes.Buffer
+	fmt.Fprintf(&golden, "%d\n", response.StatusCode)
+	for _, name := range goldenHeaders {
+		if value := response.Header.Get(name); value != "" {
+			fmt.Fprintf(&golden, "%s: %s\n", name, value)
+		}
+	}
+	golden.WriteString("\n")
+	golden.Write(body)
+	return golden.Bytes()
+}
+
+// TestGoldenResponses pins the sun phase endpoint byte for byte, so moving
+// code around can't change what clients see. It goes through api.New and a
+// real HTTP server, like clients do.
+func TestGoldenResponses(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		method  string
+		path    string
+		header  []string
+		respond fakeWU
+	}{
+		{name: "sun_phase", path: "/weather/sun_phase/v1"},
+		{name: "sun_phase_date", path: "/weather/sun_phase/v1?date=2024-06-21"},
+		{name: "sun_phase_flat", path: "/weather/sun_phase/v1", header: []string{"Accept", "application/json"}},
+		{name: "sun_phase_bad_date", path: "/weather/sun_phase/v1?date=June"},
+		{name: "sun_phase_method", method: "POST", path: "/weather/sun_phase/v1"},
+		{name: "sun_phase_upstream_error", path: "/weather/sun_phase/v1", respond: func(string, string) (int, string) {
+			return 500, "oops"
+		}},
+		{name: "not_found", path: "/weather/nowhere"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			server := newGoldenServer(t, test.respond)
+
+			method := test.method
+			if method == "" {
+				method = "GET"
+			}
+			request, err := http.NewRequest(method, server.URL+test.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// self links are built from the Host, recorded as example.com
+			request.Host = "example.com"
+			// error documents carry the request id, fix it rather than a new UUID
+			request.Header.Set("X-Request-ID", "golden-request")
+			for i := 0; i+1 < len(test.header); i += 2 {
+				request.Header.Set(test.header[i], test.header[i+1])
+			}
+			response, err := server.Client().Do(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+			got := goldenResponse(t, response)
+
+			path := filepath.Join("testdata", "golden", test.name+".txt")
+			if *updateGolden {
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%s, run with -update to create it", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("response differs from %s\ngot:\n%s\nwant:\n%s", path, got, want)
+			}
+		})
+	}
+}
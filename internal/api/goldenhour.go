@@ -0,0 +1,88 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/jsonapi"
+	"github.com/tyrm/ph-weather/internal/config"
+)
+
+const (
+	goldenHourHighAltitude = 6
+	goldenHourLowAltitude  = -4
+	blueHourLowAltitude    = -6
+)
+
+// GoldenHourResponse windows are [start, end] pairs, nil when the sun doesn't
+// cross one of the window's altitudes that day
+type GoldenHourResponse struct {
+	ResponseID        string   `jsonapi:"primary,golden_hour"`
+	Date              string   `jsonapi:"attr,date"`
+	MorningBlueHour   []string `jsonapi:"attr,morning_blue_hour"`
+	MorningGoldenHour []string `jsonapi:"attr,morning_golden_hour"`
+	EveningGoldenHour []string `jsonapi:"attr,evening_golden_hour"`
+	EveningBlueHour   []string `jsonapi:"attr,evening_blue_hour"`
+
+	unavailable map[string]string
+}
+
+// JSONAPIMeta explains windows that are missing on polar days and nights
+func (responseObj *GoldenHourResponse) JSONAPIMeta() *jsonapi.Meta {
+	if len(responseObj.unavailable) == 0 {
+		return nil
+	}
+	return &jsonapi.Meta{"polar": true, "unavailable": responseObj.unavailable}
+}
+
+func makeGoldenHourResponse(id string, coordinates config.Coordinates, day time.Time) (responseObj *GoldenHourResponse) {
+	responseObj = &GoldenHourResponse{ResponseID: id, Date: day.Format(dateFormat), unavailable: map[string]string{}}
+
+	high := crossingsAt(day, coordinates, goldenHourHighAltitude)
+	low := crossingsAt(day, coordinates, goldenHourLowAltitude)
+	blue := crossingsAt(day, coordinates, blueHourLowAltitude)
+
+	window := func(name string, start *time.Time, end *time.Time, reasons ...string) []string {
+		if start == nil || end == nil {
+			for _, reason := range reasons {
+				if reason != "" {
+					responseObj.unavailable[name] = reason
+					break
+				}
+			}
+			return nil
+		}
+		return []string{*formatTime(start.Round(time.Second)), *formatTime(end.Round(time.Second))}
+	}
+
+	responseObj.MorningBlueHour = window("morning_blue_hour", blue.rise, low.rise, blue.reason, low.reason)
+	responseObj.MorningGoldenHour = window("morning_golden_hour", low.rise, high.rise, low.reason, high.reason)
+	responseObj.EveningGoldenHour = window("evening_golden_hour", high.set, low.set, high.reason, low.reason)
+	responseObj.EveningBlueHour = window("evening_blue_hour", low.set, blue.set, low.reason, blue.reason)
+	return
+}
+
+// handleGoldenHour serves the photographers' golden and blue hour windows,
+// computed locally
+func (env *Env) handleGoldenHour(response http.ResponseWriter, request *http.Request) {
+	location, err := env.requestLocation(request)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	day, err := env.requestDate(request)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	coordinates, err := env.locationCoordinates(request.Context(), location)
+	if err != nil {
+		logRequest(request, "%s", err)
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	writePayload(response, request, makeGoldenHourResponse(dayResourceID("golden_hour", location.Key(), day), *coordinates, day))
+}
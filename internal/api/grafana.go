@@ -0,0 +1,162 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/config"
+	"github.com/tyrm/ph-weather/internal/status"
+)
+
+// grafanaMaxBody bounds the JSON a Grafana request may send
+const grafanaMaxBody = 1 << 20
+
+// grafanaMaxDayLengthDays bounds the day_length range, computed a day at a time
+const grafanaMaxDayLengthDays = 366
+
+// grafanaSeries are the metrics offered to Grafana. day_length is computed
+// per day rather than read from history.
+var grafanaSeries = append(append([]string{}, historyMetrics...), "day_length")
+
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	Targets []struct {
+		Target string `json:"target"`
+	} `json:"targets"`
+}
+
+type grafanaTimeSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// handleGrafanaTest answers the simple JSON datasource connection test
+func (env *Env) handleGrafanaTest(response http.ResponseWriter, request *http.Request) {
+	response.WriteHeader(200)
+}
+
+// handleGrafanaSearch lists the series, metric for the default location and
+// metric:name for each configured location
+func (env *Env) handleGrafanaSearch(response http.ResponseWriter, request *http.Request) {
+	targets := []string{}
+	for _, location := range env.configuredLocations() {
+		for _, series := range grafanaSeries {
+			if location.Name == "" {
+				targets = append(targets, series)
+			} else {
+				targets = append(targets, series+":"+location.Name)
+			}
+		}
+	}
+	writeJSON(response, request, targets)
+}
+
+// handleGrafanaQuery returns each target's datapoints over the range as
+// [value, unix milliseconds] pairs
+func (env *Env) handleGrafanaQuery(response http.ResponseWriter, request *http.Request) {
+	var query grafanaQuery
+	if err := json.NewDecoder(http.MaxBytesReader(response, request.Body, grafanaMaxBody)).Decode(&query); err != nil {
+		makeErrorResponse(response, 400, err.Error(), 1)
+		return
+	}
+	if query.Range.To.Before(query.Range.From) {
+		makeErrorResponse(response, 400, "range ends before it starts", 0)
+		return
+	}
+
+	results := []grafanaTimeSeries{}
+	for _, target := range query.Targets {
+		series := grafanaTimeSeries{Target: target.Target, Datapoints: [][2]float64{}}
+
+		metric, location, err := env.grafanaTarget(target.Target)
+		if err != nil {
+			makeStatusErrorResponse(response, err)
+			return
+		}
+
+		if metric == "day_length" {
+			if query.Range.To.Sub(query.Range.From) > grafanaMaxDayLengthDays*24*time.Hour {
+				makeErrorResponse(response, 400, fmt.Sprintf("day_length ranges are limited to %d days", grafanaMaxDayLengthDays), 0)
+				return
+			}
+			coordinates, err := env.locationCoordinates(request.Context(), location)
+			if err != nil {
+				logRequest(request, "%s", err)
+				makeStatusErrorResponse(response, err)
+				return
+			}
+			series.Datapoints = dayLengthPoints(*coordinates, query.Range.From.In(env.config().LocationTZ), query.Range.To)
+		} else {
+			points, err := env.metricHistory(request.Context(), metric, location, query.Range.From, query.Range.To)
+			if err != nil {
+				logRequest(request, "Error reading %s history: %s", metric, err)
+				makeErrorResponse(response, 500, err.Error(), 0)
+				return
+			}
+			for _, point := range points {
+				series.Datapoints = append(series.Datapoints, [2]float64{point.Value, float64(point.Time.UnixNano() / int64(time.Millisecond))})
+			}
+		}
+		results = append(results, series)
+	}
+	writeJSON(response, request, results)
+}
+
+// grafanaTarget splits a search target back into its metric and location
+func (env *Env) grafanaTarget(target string) (metric string, location Location, resError error) {
+	parts := strings.SplitN(target, ":", 2)
+	metric = parts[0]
+	if !containsString(grafanaSeries, metric) {
+		resError = status.Errorf(400, "unknown series %q", target)
+		return
+	}
+
+	if len(parts) == 1 {
+		location.Query = env.config().WUndergroundLocation
+		return
+	}
+	query, ok := env.config().Locations[parts[1]]
+	if !ok {
+		resError = status.Errorf(404, "location %s is not configured", parts[1])
+		return
+	}
+	location = Location{Name: parts[1], Query: query}
+	return
+}
+
+// dayLengthPoints gives one point per local day in the range, at solar noon,
+// skipping days without sunrise and sunset
+func dayLengthPoints(coordinates config.Coordinates, from time.Time, to time.Time) [][2]float64 {
+	points := [][2]float64{}
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	for ; !day.After(to); day = day.AddDate(0, 0, 1) {
+		seconds := localDayLength(day, coordinates.Latitude, coordinates.Longitude)
+		if seconds == nil {
+			continue
+		}
+		noon := solarNoon(day, coordinates.Longitude)
+		if noon.Before(from) || noon.After(to) {
+			continue
+		}
+		points = append(points, [2]float64{float64(*seconds), float64(noon.UnixNano() / int64(time.Millisecond))})
+	}
+	return points
+}
+
+// writeJSON sends a plain JSON body, for clients with their own contract
+func writeJSON(response http.ResponseWriter, request *http.Request, body interface{}) {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		logRequest(request, "Error marshaling response: %s", err)
+		makeErrorResponse(response, 500, err.Error(), 0)
+		return
+	}
+	response.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(response, string(encoded))
+}
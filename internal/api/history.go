@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/tyrm/ph-weather/internal/provider"
+)
+
+// historyMetrics are the observation values kept as history
+var historyMetrics = []string{"temperature", "humidity", "pressure", "wind_speed"}
+
+// observationRecord is the compact form of an observation kept in history
+type observationRecord struct {
+	Epoch       int64    `json:"t"`
+	Temperature *float64 `json:"temp,omitempty"`
+	Humidity    *float64 `json:"hum,omitempty"`
+	Pressure    *float64 `json:"pres,omitempty"`
+	WindSpeed   *float64 `json:"wind,omitempty"`
+	WindDir     *float64 `json:"wdir,omitempty"`
+}
+
+// value returns one of the historyMetrics, nil when it wasn't observed
+func (record observationRecord) value(metric string) *float64 {
+	switch metric {
+	case "temperature":
+		return record.Temperature
+	case "humidity":
+		return record.Humidity
+	case "pressure":
+		return record.Pressure
+	case "wind_speed":
+		return record.WindSpeed
+	}
+	return nil
+}
+
+func (env *Env) historyKey(location Location) string {
+	return env.config().RedisKey("observations", location.Key())
+}
+
+// recordObservation appends fetched conditions to the location's sorted set,
+// scored by observation time, and drops records older than
+// OBSERVATION_RETENTION. It is best effort, failures are only logged.
+func (env *Env) recordObservation(ctx context.Context, location Location, conditions *provider.WUConditions) {
+	observation := conditions.CurrentObservation
+	epoch := provider.ParseWUFloat(observation.ObservationEpoch)
+	if epoch == nil {
+		return
+	}
+
+	// the epoch in the member keeps equal readings at different times apart
+	member, err := json.Marshal(observationRecord{
+		Epoch:       int64(*epoch),
+		Temperature: provider.ParseWUFloat(observation.TempC.String()),
+		Humidity:    observation.Humidity(),
+		Pressure:    provider.ParseWUFloat(observation.PressureMB.String()),
+		WindSpeed:   provider.ParseWUFloat(observation.WindKPH.String()),
+		WindDir:     provider.ParseWUFloat(observation.WindDegrees.String()),
+	})
+	if err != nil {
+		log.Printf("Error encoding observation: %s", err)
+		return
+	}
+
+	key := env.historyKey(location)
+	if err := env.redis.ZAdd(ctx, key, &redis.Z{Score: *epoch, Member: string(member)}).Err(); err != nil {
+		log.Printf("Error recording observation: %s", err)
+		return
+	}
+	cutoff := env.now().Add(-env.config().ObservationRetention).Unix()
+	if err := env.redis.ZRemRangeByScore(ctx, key, "-inf", "("+strconv.FormatInt(cutoff, 10)).Err(); err != nil {
+		log.Printf("Error trimming observations: %s", err)
+	}
+}
+
+// observationHistory returns up to count records observed between from and
+// to, oldest first, skipping the first offset. A count of 0 is unlimited.
+func (env *Env) observationHistory(ctx context.Context, location Location, from time.Time, to time.Time, offset int64, count int64) (records []observationRecord, resError error) {
+	opt := &redis.ZRangeBy{
+		Min: strconv.FormatInt(from.Unix(), 10),
+		Max: strconv.FormatInt(to.Unix(), 10),
+	}
+	if count > 0 {
+		opt.Offset = offset
+		opt.Count = count
+	}
+	members, err := env.redis.ZRangeByScore(ctx, env.historyKey(location), opt).Result()
+	if err != nil {
+		resError = err
+		return
+	}
+
+	for _, member := range members {
+		var record observationRecord
+		if err := json.Unmarshal([]byte(member), &record); err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	return
+}
+
+// historyPoint is a recorded value and when it was observed
+type historyPoint struct {
+	Time  time.Time
+	Value float64
+}
+
+// metricHistory returns a metric's recorded values between from and to
+func (env *Env) metricHistory(ctx context.Context, metric string, location Location, from time.Time, to time.Time) (points []historyPoint, resError error) {
+	records, err := env.observationHistory(ctx, location, from, to, 0, 0)
+	if err != nil {
+		resError = err
+		return
+	}
+
+	for _, record := range records {
+		if value := record.value(metric); value != nil {
+			points = append(points, historyPoint{Time: time.Unix(record.Epoch, 0), Value: *value})
+		}
+	}
+	return
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/jsonapi"
+	"github.com/tyrm/ph-weather/internal/cache"
+	"github.com/tyrm/ph-weather/internal/provider"
+	"github.com/tyrm/ph-weather/internal/status"
+	"github.com/tyrm/ph-weather/units"
+)
+
+const maxHourlyHours = 36
+
+var hourlyUnits = map[string]units.Kind{
+	"temperature":   units.Temperature,
+	"precipitation": units.Precipitation,
+}
+
+type HourlyResponse struct {
+	ResponseID          string   `jsonapi:"primary,hourly"`
+	Time                string   `jsonapi:"attr,time_iso"`
+	Temperature         *float64 `jsonapi:"attr,temperature"`
+	Condition           string   `jsonapi:"attr,condition"`
+	PrecipitationChance *float64 `jsonapi:"attr,precipitation_chance"`
+	Precipitation       *float64 `jsonapi:"attr,precipitation"`
+}
+
+// handleHourly serves the upcoming hours' forecast, limited by ?hours= and
+// paged with ?limit= and ?offset=
+func (env *Env) handleHourly(response http.ResponseWriter, request *http.Request) {
+	location, err := env.requestLocation(request)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	system, err := env.requestUnits(request)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	hours := maxHourlyHours
+	if value := request.URL.Query().Get("hours"); value != "" {
+		hours, err = strconv.Atoi(value)
+		if err != nil || hours < 1 {
+			makeErrorResponse(response, 400, "hours must be a positive number", 0)
+			return
+		}
+		if hours > maxHourlyHours {
+			hours = maxHourlyHours
+		}
+	}
+
+	offset, limit, err := requestPage(request, maxHourlyHours, maxHourlyHours)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	cacheKey := env.cacheKey("hourly", location.Key(), time.Time{})
+	cacheEntry, err := env.getOrBuildCache(request, cacheKey, env.config().HourlyTTL, env.buildHourly(location))
+	if err != nil {
+		logRequest(request, "%s", err)
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	cacheEntry, err = limitMany(cacheEntry, hours)
+	if err == nil {
+		cacheEntry, err = pageMany(cacheEntry, request, offset, limit)
+	}
+	if err == nil {
+		cacheEntry, err = inUnits(cacheEntry, system, hourlyUnits)
+	}
+	if err != nil {
+		logRequest(request, "Error preparing hourly forecast: %s", err)
+		makeErrorResponse(response, 500, err.Error(), 0)
+		return
+	}
+
+	writeCacheEntry(response, request, cacheEntry)
+}
+
+func (env *Env) buildHourly(location Location) func(context.Context) (interface{}, error) {
+	return func(ctx context.Context) (interface{}, error) {
+		hourlyJSON, err := env.getWUApiRepose(ctx, "hourly", location)
+		if err != nil {
+			return nil, fmt.Errorf("Error fetching hourly forecast: %w", err)
+		}
+
+		var hourly provider.WUHourly
+		if err := json.Unmarshal([]byte(hourlyJSON), &hourly); err != nil {
+			return nil, env.rawParseError("hourly forecast", "hourly", location.Query, err)
+		}
+		responseObj, err := makeHourlyResponse(location, hourly, env.config().LocationTZ)
+		if err == nil {
+			env.refreshedModel(ctx, location, "hourly", responseObj)
+		}
+		return responseObj, err
+	}
+}
+
+// makeHourlyResponse keeps WU's metric values, the canonical unit system
+func makeHourlyResponse(location Location, hourly provider.WUHourly, tz *time.Location) (responseObj []*HourlyResponse, resError error) {
+	responseObj = []*HourlyResponse{}
+
+	for _, hour := range hourly.HourlyForecast {
+		epoch, err := strconv.ParseInt(hour.FCTTime.Epoch, 10, 64)
+		if err != nil {
+			resError = status.Errorf(502, "Error parsing hourly forecast time: %s", err)
+			return
+		}
+
+		responseObj = append(responseObj, &HourlyResponse{
+			ResponseID:          fmt.Sprintf("%s:%d", location.Key(), epoch),
+			Time:                time.Unix(epoch, 0).In(tz).Format(time.RFC3339),
+			Temperature:         provider.ParseWUFloat(hour.Temp.Metric),
+			Condition:           hour.Condition,
+			PrecipitationChance: provider.ParseWUFloat(hour.Pop),
+			Precipitation:       provider.ParseWUFloat(hour.QPF.Metric),
+		})
+	}
+	return
+}
+
+// limitMany keeps the first n resources of a cached many payload
+func limitMany(cacheEntry *cache.Entry, n int) (limited *cache.Entry, resError error) {
+	var payload jsonapi.ManyPayload
+	if err := json.Unmarshal([]byte(cacheEntry.Body), &payload); err != nil {
+		resError = err
+		return
+	}
+	if len(payload.Data) <= n {
+		limited = cacheEntry
+		return
+	}
+	payload.Data = payload.Data[:n]
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		resError = err
+		return
+	}
+	limited = &cache.Entry{ETag: cache.MakeETag(string(body)), Body: string(body), Stale: cacheEntry.Stale}
+	return
+}
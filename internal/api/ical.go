@@ -0,0 +1,202 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/cache"
+	"github.com/tyrm/ph-weather/internal/config"
+)
+
+const (
+	icalDays = 30
+	icalTTL  = 6 * time.Hour
+)
+
+// handleSunPhaseICal serves sunrise and sunset for the next icalDays as an
+// iCalendar feed. Events are computed locally so calendar polling doesn't use
+// WU quota, and the rendered feed is cached.
+func (env *Env) handleSunPhaseICal(response http.ResponseWriter, request *http.Request) {
+	location, err := env.requestLocation(request)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	today := env.today()
+	cacheKey := env.cacheKey("sun_phase_ical", location.Key(), today)
+
+	entry, err := env.cache.Get(request.Context(), cacheKey)
+	if err != nil {
+		logRequest(request, "Error reading cache: %s", err)
+	}
+	if entry == nil {
+		coordinates, err := env.locationCoordinates(request.Context(), location)
+		if err != nil {
+			logRequest(request, "%s", err)
+			makeStatusErrorResponse(response, err)
+			return
+		}
+
+		entry, err = env.cache.Set(request.Context(), cacheKey, makeSunPhaseCalendar(location.Key(), *coordinates, today), icalTTL)
+		if err != nil {
+			logRequest(request, "Error commiting to cache: %s", err)
+		}
+	}
+
+	response.Header().Set("ETag", entry.ETag)
+	if cache.ETagMatches(request.Header.Get("If-None-Match"), entry.ETag) {
+		response.WriteHeader(304)
+		return
+	}
+
+	response.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	response.Header().Set("Content-Disposition", "inline; filename=\"sun_phase.ics\"")
+	fmt.Fprint(response, entry.Body)
+}
+
+// makeSunPhaseCalendar renders the feed with times in today's timezone. UIDs
+// are derived from the location, date and event so updates replace events
+// rather than duplicating them.
+func makeSunPhaseCalendar(locationKey string, coordinates config.Coordinates, today time.Time) string {
+	tz := today.Location()
+	if tz.String() == "Local" {
+		// TZID and X-WR-TIMEZONE need an IANA name, which the process's local
+		// zone doesn't have when LOCATION_TZ is unset
+		tz = time.UTC
+	}
+	start := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, tz)
+	end := start.AddDate(0, 0, icalDays)
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+
+	var lines []string
+	lines = append(lines,
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//tyrm//ph-weather//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:"+icalEscape("Sunrise and sunset, "+locationKey),
+		"X-WR-TIMEZONE:"+tz.String(),
+		"REFRESH-INTERVAL;VALUE=DURATION:PT6H",
+		"X-PUBLISHED-TTL:PT6H",
+	)
+	lines = append(lines, icalTimezone(tz, start, end)...)
+
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		rise, set, _ := sunCrossings(day, coordinates.Latitude, coordinates.Longitude, sunriseAltitude)
+		if rise == nil {
+			continue
+		}
+
+		for _, event := range []struct {
+			name    string
+			summary string
+			at      time.Time
+		}{{"sunrise", "Sunrise", *rise}, {"sunset", "Sunset", *set}} {
+			lines = append(lines,
+				"BEGIN:VEVENT",
+				fmt.Sprintf("UID:%s-%s-%s@ph-weather", icalEscape(locationKey), day.Format("20060102"), event.name),
+				"DTSTAMP:"+stamp,
+				fmt.Sprintf("DTSTART;TZID=%s:%s", tz.String(), event.at.In(tz).Round(time.Minute).Format("20060102T150405")),
+				"SUMMARY:"+event.summary,
+				"TRANSP:TRANSPARENT",
+				"END:VEVENT",
+			)
+		}
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	var calendar strings.Builder
+	for _, line := range lines {
+		calendar.WriteString(icalFold(line))
+	}
+	return calendar.String()
+}
+
+// icalTimezone describes tz's offsets between start and end as a VTIMEZONE,
+// which TZID references need. Each transition gets its own component, so no
+// recurrence rules have to be derived from the zone database.
+func icalTimezone(tz *time.Location, start time.Time, end time.Time) []string {
+	lines := []string{"BEGIN:VTIMEZONE", "TZID:" + tz.String()}
+
+	component := func(at time.Time, offsetFrom int) {
+		kind := "STANDARD"
+		if at.IsDST() {
+			kind = "DAYLIGHT"
+		}
+		name, offsetTo := at.Zone()
+		lines = append(lines,
+			"BEGIN:"+kind,
+			// DTSTART is in the local time being left
+			"DTSTART:"+at.In(time.FixedZone("", offsetFrom)).Format("20060102T150405"),
+			"TZOFFSETFROM:"+icalOffset(offsetFrom),
+			"TZOFFSETTO:"+icalOffset(offsetTo),
+			"TZNAME:"+name,
+			"END:"+kind,
+		)
+	}
+
+	_, offset := start.Zone()
+	component(start, offset)
+	for day := start; day.Before(end); day = day.Add(24 * time.Hour) {
+		next := day.Add(24 * time.Hour)
+		if _, nextOffset := next.Zone(); nextOffset != offset {
+			// narrow the transition down to the second
+			low, high := day, next
+			for high.Sub(low) > time.Second {
+				mid := low.Add(high.Sub(low) / 2)
+				if _, midOffset := mid.Zone(); midOffset == offset {
+					low = mid
+				} else {
+					high = mid
+				}
+			}
+			component(high.Truncate(time.Second), offset)
+			offset = nextOffset
+		}
+	}
+
+	return append(lines, "END:VTIMEZONE")
+}
+
+func icalOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign, seconds = "-", -seconds
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds%3600/60)
+}
+
+var icalEscaper = strings.NewReplacer("\\", "\\\\", ";", "\\;", ",", "\\,", "\n", "\\n")
+
+func icalEscape(text string) string {
+	return icalEscaper.Replace(text)
+}
+
+// icalFold ends a content line with CRLF, folding it at 75 octets
+func icalFold(line string) string {
+	var folded strings.Builder
+	for len(line) > 75 {
+		cut := 75
+		if folded.Len() > 0 {
+			cut = 74 // continuation lines start with a space
+		}
+		// don't split a UTF-8 sequence
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		if folded.Len() > 0 {
+			folded.WriteString(" ")
+		}
+		folded.WriteString(line[:cut] + "\r\n")
+		line = line[cut:]
+	}
+	if folded.Len() > 0 {
+		folded.WriteString(" ")
+	}
+	folded.WriteString(line + "\r\n")
+	return folded.String()
+}
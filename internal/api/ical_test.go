@@ -0,0 +1,39 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/config"
+)
+
+func TestSunPhaseCalendarTimezone(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	coordinates := config.Coordinates{Latitude: 39.95, Longitude: -75.17}
+
+	for _, tc := range []struct {
+		name  string
+		today time.Time
+		want  string
+	}{
+		{"named zone", time.Date(2024, 6, 21, 9, 0, 0, 0, newYork), "America/New_York"},
+		{"local zone", time.Date(2024, 6, 21, 9, 0, 0, 0, time.Local), "UTC"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			calendar := makeSunPhaseCalendar("home", coordinates, tc.today)
+			if !strings.Contains(calendar, "X-WR-TIMEZONE:"+tc.want+"\r\n") {
+				t.Errorf("calendar doesn't use %s for X-WR-TIMEZONE", tc.want)
+			}
+			if !strings.Contains(calendar, "TZID:"+tc.want+"\r\n") {
+				t.Errorf("calendar doesn't define TZID %s", tc.want)
+			}
+			if strings.Contains(calendar, "Local") {
+				t.Error("calendar names the Local zone")
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// trustProxy is set from TRUST_PROXY. Only then are links built from
+// X-Forwarded-Proto and X-Forwarded-Host, which clients could otherwise forge.
+var trustProxy bool
+
+// requestLink is an absolute link to the request's path with rawQuery, on
+// the scheme and host the client used. The path already holds HTTP_BASE_PATH.
+func requestLink(request *http.Request, rawQuery string) string {
+	link := url.URL{Scheme: "http", Host: request.Host, Path: request.URL.Path, RawQuery: rawQuery}
+	if request.TLS != nil {
+		link.Scheme = "https"
+	}
+
+	if trustProxy {
+		if proto := firstForwarded(request.Header.Get("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+			link.Scheme = proto
+		}
+		if host := firstForwarded(request.Header.Get("X-Forwarded-Host")); host != "" {
+			link.Host = host
+		}
+	}
+	return link.String()
+}
+
+// firstForwarded is the value set by the proxy nearest the client when a
+// chain of proxies each appended theirs
+func firstForwarded(value string) string {
+	return strings.TrimSpace(strings.Split(value, ",")[0])
+}
+
+// withSelfLink adds {"links":{"self":...}} with the request's URL at the
+// start of a document that has no top-level links of its own
+func withSelfLink(body []byte, request *http.Request) []byte {
+	var document struct {
+		Links json.RawMessage `json:"links"`
+	}
+	if len(body) < 2 || body[0] != '{' || json.Unmarshal(body, &document) != nil || len(document.Links) > 0 {
+		return body
+	}
+
+	links, err := json.Marshal(map[string]string{"self": requestLink(request, request.URL.RawQuery)})
+	if err != nil {
+		return body
+	}
+
+	withLinks := append([]byte(`{"links":`), links...)
+	if rest := bytes.TrimSpace(body[1:]); len(rest) > 0 && rest[0] != '}' {
+		withLinks = append(withLinks, ',')
+	}
+	return append(withLinks, body[1:]...)
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithSelfLink(t *testing.T) {
+	request := httptest.NewRequest("GET", "http://weather.example/weather/sun_phase/v1?date=2024-06-21", nil)
+
+	for _, test := range []struct {
+		name string
+		body string
+		want string
+	}{
+		{"added first", `{"data":null}`, `{"links":{"self":"http://weather.example/weather/sun_phase/v1?date=2024-06-21"},"data":null}`},
+		{"empty document", `{}`, `{"links":{"self":"http://weather.example/weather/sun_phase/v1?date=2024-06-21"}}`},
+		{"own links kept", `{"links":{"next":"/page/2"},"data":[]}`, `{"links":{"next":"/page/2"},"data":[]}`},
+		{"not an object", `[1,2]`, `[1,2]`},
+		{"not JSON", `{"data":`, `{"data":`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := string(withSelfLink([]byte(test.body), request)); got != test.want {
+				t.Errorf("withSelfLink(%s) = %s, want %s", test.body, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRequestLinkProxy(t *testing.T) {
+	trust := trustProxy
+	t.Cleanup(func() { trustProxy = trust })
+
+	for _, test := range []struct {
+		name       string
+		trustProxy bool
+		tls        bool
+		forwarded  []string
+		want       string
+	}{
+		{name: "direct", want: "http://weather.internal:8080/weather/sun_phase/v1"},
+		{name: "direct TLS", tls: true, want: "https://weather.internal:8080/weather/sun_phase/v1"},
+		{
+			name:      "forwarded headers ignored",
+			forwarded: []string{"X-Forwarded-Proto", "https", "X-Forwarded-Host", "forged.example"},
+			want:      "http://weather.internal:8080/weather/sun_phase/v1",
+		},
+		{
+			name:       "trusted proxy",
+			trustProxy: true,
+			forwarded:  []string{"X-Forwarded-Proto", "https", "X-Forwarded-Host", "weather.example"},
+			want:       "https://weather.example/weather/sun_phase/v1",
+		},
+		{
+			name:       "nearest proxy of a chain",
+			trustProxy: true,
+			forwarded:  []string{"X-Forwarded-Proto", "https, http", "X-Forwarded-Host", "weather.example, lb.internal"},
+			want:       "https://weather.example/weather/sun_phase/v1",
+		},
+		{
+			name:       "unknown scheme ignored",
+			trustProxy: true,
+			forwarded:  []string{"X-Forwarded-Proto", "gopher"},
+			want:       "http://weather.internal:8080/weather/sun_phase/v1",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			trustProxy = test.trustProxy
+			request := httptest.NewRequest("GET", "http://weather.internal:8080/weather/sun_phase/v1", nil)
+			if test.tls {
+				request.TLS = &tls.ConnectionState{}
+			}
+			for i := 0; i+1 < len(test.forwarded); i += 2 {
+				request.Header.Set(test.forwarded[i], test.forwarded[i+1])
+			}
+			if got := requestLink(request, ""); got != test.want {
+				t.Errorf("requestLink = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSelfLinkBasePath(t *testing.T) {
+	for _, test := range []struct {
+		trustProxy string
+		want       string
+	}{
+		{"false", "http://example.com/api/weather/sun_phase/v1?date=2024-06-21"},
+		{"true", "https://weather.example/api/weather/sun_phase/v1?date=2024-06-21"},
+	} {
+		t.Run("TRUST_PROXY="+test.trustProxy, func(t *testing.T) {
+			server := newTestServer(t, map[string]string{"HTTP_BASE_PATH": "/api", "TRUST_PROXY": test.trustProxy})
+
+			response := server.get("/api/weather/sun_phase/v1?date=2024-06-21", "X-Forwarded-Proto", "https", "X-Forwarded-Host", "weather.example")
+			var document struct {
+				Links struct {
+					Self string `json:"self"`
+				} `json:"links"`
+			}
+			if err := json.Unmarshal(response.Body.Bytes(), &document); err != nil {
+				t.Fatalf("%s: %s", err, response.Body)
+			}
+			if document.Links.Self != test.want {
+				t.Errorf("self = %s, want %s", document.Links.Self, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/cache"
+	"github.com/tyrm/ph-weather/internal/config"
+	"github.com/tyrm/ph-weather/internal/status"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Location is a WU location query, optionally known by a configured name
+type Location struct {
+	Name  string
+	Query string
+}
+
+// Key identifies the location in cache keys and resource ids
+func (location Location) Key() string {
+	if location.Name != "" {
+		return location.Name
+	}
+	return location.Query
+}
+
+// requestLocation returns the location a request is for: a named location
+// from the path, an override, or the default WU_LOCATION. Clients may pick
+// another location with ?location= or ?lat=&lon= (or &lng=) only when overrides are
+// enabled, and only from the allowlist when one is configured.
+func (env *Env) requestLocation(request *http.Request) (location Location, resError error) {
+	defer func() {
+		if resError == nil {
+			traceAttributes(request, attribute.String("weather.location", location.Key()))
+		}
+	}()
+
+	if name := pathParam(request); name != "" {
+		query, ok := env.config().Locations[name]
+		if !ok {
+			resError = status.Errorf(404, "location %s is not configured", name)
+			return
+		}
+		location = Location{Name: name, Query: query}
+		return
+	}
+
+	query := request.URL.Query()
+	if query.Get("location") == "" && query.Get("lat") == "" && queryLongitude(query) == "" {
+		location.Query = env.config().WUndergroundLocation
+		return
+	}
+
+	var locationQuery string
+	var err error
+	if query.Get("location") != "" {
+		locationQuery, err = config.NormalizeLocation(query.Get("location"))
+	} else {
+		locationQuery, err = coordinateLocation(query.Get("lat"), queryLongitude(query))
+	}
+	if err != nil {
+		resError = &status.Error{Status: 400, Err: err}
+		return
+	}
+
+	return env.overrideLocation(locationQuery)
+}
+
+// lookupLocation resolves a configured location name or, subject to the
+// override rules, a WU location query
+func (env *Env) lookupLocation(value string) (location Location, resError error) {
+	if query, ok := env.config().Locations[value]; ok {
+		location = Location{Name: value, Query: query}
+		return
+	}
+
+	locationQuery, err := config.NormalizeLocation(value)
+	if err != nil {
+		resError = &status.Error{Status: 400, Err: err}
+		return
+	}
+	return env.overrideLocation(locationQuery)
+}
+
+// overrideLocation checks a normalized WU location query against the override rules
+func (env *Env) overrideLocation(locationQuery string) (location Location, resError error) {
+	location.Query = locationQuery
+	if locationQuery == env.config().WUndergroundLocation {
+		return
+	}
+
+	if !env.config().AllowLocationOverride {
+		resError = status.Errorf(400, "location override is disabled")
+		return
+	}
+	if len(env.config().LocationAllowlist) > 0 && !containsString(env.config().LocationAllowlist, locationQuery) {
+		resError = status.Errorf(400, "location %s is not allowed", locationQuery)
+	}
+	return
+}
+
+// queryLongitude accepts lng as well as lon, as GPS clients tend to send it
+func queryLongitude(query url.Values) string {
+	if lon := query.Get("lon"); lon != "" {
+		return lon
+	}
+	return query.Get("lng")
+}
+
+// coordinateLocation formats coordinates as a WU location query, rounded to
+// 3 decimals (about 100m) so GPS jitter doesn't fragment the cache
+func coordinateLocation(lat string, lon string) (location string, resError error) {
+	latitude, err := config.ParseCoordinate(lat, 90)
+	if err != nil {
+		resError = fmt.Errorf("lat %s", err)
+		return
+	}
+	longitude, err := config.ParseCoordinate(lon, 180)
+	if err != nil {
+		resError = fmt.Errorf("lon %s", err)
+		return
+	}
+
+	location = fmt.Sprintf("%.3f,%.3f", latitude, longitude)
+	return
+}
+
+// configuredLocations is the default location followed by the configured
+// locations by name
+func (env *Env) configuredLocations() []Location {
+	locations := []Location{{Query: env.config().WUndergroundLocation}}
+
+	names := make([]string, 0, len(env.config().Locations))
+	for name := range env.config().Locations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		locations = append(locations, Location{Name: name, Query: env.config().Locations[name]})
+	}
+	return locations
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// coordinatesTTL is how long a location's WU geolookup is trusted
+var coordinatesTTL time.Duration = time.Duration(30*24) * time.Hour
+
+// noCoordinatesTTL is how long a failed geolookup is remembered, so a
+// location WU can't place isn't looked up on every request
+var noCoordinatesTTL time.Duration = 15 * time.Minute
+
+// locationCoordinates returns where a location is. The default location uses
+// LOCATION_LAT/LOCATION_LON when set and coordinate queries are parsed
+// directly, anything else is looked up with WU's geolookup and cached. A
+// failed lookup is remembered for noCoordinatesTTL.
+func (env *Env) locationCoordinates(ctx context.Context, location Location) (coordinates *config.Coordinates, resError error) {
+	if location.Name == "" && location.Query == env.config().WUndergroundLocation && env.config().LocationCoordinates != nil {
+		return env.config().LocationCoordinates, nil
+	}
+
+	if parts := strings.Split(location.Query, ","); len(parts) == 2 {
+		if coordinates, err := config.ParseCoordinates(parts[0], parts[1]); err == nil {
+			return coordinates, nil
+		}
+	}
+
+	cacheKey := env.cacheKey("coordinates", location.Key(), time.Time{})
+	noCoordinatesKey := env.cacheKey("no_coordinates", location.Key(), time.Time{})
+	if exists, err := env.redis.Exists(ctx, noCoordinatesKey).Result(); err == nil && exists > 0 {
+		resError = fmt.Errorf("geolookup for %s failed recently", location.Key())
+		return
+	}
+
+	cacheVal, err := env.redis.Get(ctx, cacheKey).Result()
+	if err == nil {
+		if parts := strings.Split(cacheVal, ","); len(parts) == 2 {
+			if coordinates, err := config.ParseCoordinates(parts[0], parts[1]); err == nil {
+				return coordinates, nil
+			}
+		}
+	} else if !cache.Miss(err) {
+		log.Printf("Error reading coordinates cache: %s", err)
+	}
+
+	geolookup, err := env.getWUAstronomy(ctx, "geolookup", location)
+	if err == nil {
+		coordinates, err = config.ParseCoordinates(geolookup.Location.Lat, geolookup.Location.Lon)
+		if err != nil {
+			err = env.rawParseError("geolookup", "geolookup", location.Query, err)
+		}
+	} else {
+		err = fmt.Errorf("Error fetching geolookup: %w", err)
+	}
+	if err != nil {
+		resError = err
+		// a client going away says nothing about the location
+		if ctx.Err() == nil {
+			if err := env.redis.Set(ctx, noCoordinatesKey, "1", noCoordinatesTTL).Err(); err != nil {
+				log.Printf("Error commiting to cache: %s", err)
+			}
+		}
+		return
+	}
+
+	env.storeCoordinates(ctx, location, coordinates)
+	return
+}
+
+// storeCoordinates caches coordinates found for a location, such as those
+// WU sends with its astronomy, so locationCoordinates needn't look them up
+func (env *Env) storeCoordinates(ctx context.Context, location Location, coordinates *config.Coordinates) {
+	cacheVal := strconv.FormatFloat(coordinates.Latitude, 'f', -1, 64) + "," + strconv.FormatFloat(coordinates.Longitude, 'f', -1, 64)
+	if err := env.redis.Set(ctx, env.cacheKey("coordinates", location.Key(), time.Time{}), cacheVal, coordinatesTTL).Err(); err != nil {
+		log.Printf("Error commiting coordinates to cache: %s", err)
+	}
+}
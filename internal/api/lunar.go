@@ -0,0 +1,150 @@
+package api
+
+import (
+	"math"
+	"time"
+)
+
+// moonriseAltitude is the moon's center at moonrise and moonset, combining
+// refraction, the lunar disc and the moon's parallax
+const moonriseAltitude = 0.133
+
+// synodicMonth is the mean time between new moons, in days
+const synodicMonth = 29.530588861
+
+// daysSinceJ2000 returns the days since 2000-01-01 12:00 TT for t
+func daysSinceJ2000(t time.Time) float64 {
+	return float64(t.UnixNano())/float64(24*time.Hour) + 2440587.5 - 2451545
+}
+
+// moonPosition returns the moon's approximate altitude in degrees, good to a
+// few tenths of a degree, using the low precision series from the
+// Astronomical Almanac
+func moonPosition(t time.Time, latitude float64, longitude float64) (altitude float64) {
+	d := daysSinceJ2000(t)
+
+	meanLongitude := degToRad(218.316 + 13.176396*d)
+	meanAnomaly := degToRad(134.963 + 13.064993*d)
+	meanDistance := degToRad(93.272 + 13.229350*d)
+
+	eclipticLongitude := meanLongitude + degToRad(6.289)*math.Sin(meanAnomaly)
+	eclipticLatitude := degToRad(5.128) * math.Sin(meanDistance)
+
+	obliquity := degToRad(23.4397)
+	rightAscension := math.Atan2(math.Sin(eclipticLongitude)*math.Cos(obliquity)-math.Tan(eclipticLatitude)*math.Sin(obliquity), math.Cos(eclipticLongitude))
+	declination := math.Asin(math.Sin(eclipticLatitude)*math.Cos(obliquity) + math.Cos(eclipticLatitude)*math.Sin(obliquity)*math.Sin(eclipticLongitude))
+
+	siderealTime := degToRad(280.16+360.9856235*d) + degToRad(longitude)
+	hourAngle := siderealTime - rightAscension
+
+	lat := degToRad(latitude)
+	return radToDeg(math.Asin(math.Sin(lat)*math.Sin(declination) + math.Cos(lat)*math.Cos(declination)*math.Cos(hourAngle)))
+}
+
+// moonStep is how finely the day is scanned for the moon crossing the horizon
+const moonStep = 10 * time.Minute
+
+// moonCrossings returns the first moonrise and moonset on day, in day's
+// timezone. Each is nil when the moon doesn't rise or set that day, which
+// happens about once a month even away from the poles.
+func moonCrossings(day time.Time, latitude float64, longitude float64) (rise *time.Time, set *time.Time) {
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	end := start.AddDate(0, 0, 1)
+
+	previous := moonPosition(start, latitude, longitude) - moonriseAltitude
+	for t := start; t.Before(end) && (rise == nil || set == nil); t = t.Add(moonStep) {
+		next := t.Add(moonStep)
+		current := moonPosition(next, latitude, longitude) - moonriseAltitude
+
+		if (previous < 0) != (current < 0) {
+			// interpolate the crossing within the step, which is accurate to
+			// well under a minute at this resolution
+			crossing := t.Add(time.Duration(float64(moonStep) * previous / (previous - current))).Round(time.Minute)
+			if !crossing.Before(end) {
+				break
+			}
+			if previous < 0 && rise == nil {
+				rise = &crossing
+			} else if previous >= 0 && set == nil {
+				set = &crossing
+			}
+		}
+		previous = current
+	}
+	return
+}
+
+// lunarPhaseTerms are the periodic corrections from Meeus, Astronomical
+// Algorithms ch. 49: the coefficient for new moon and for full moon, the
+// power of E, then the multiples of M, M', F and the node's longitude in the
+// sine argument
+var lunarPhaseTerms = [][7]float64{
+	{-0.40720, -0.40614, 0, 0, 1, 0, 0},
+	{0.17241, 0.17302, 1, 1, 0, 0, 0},
+	{0.01608, 0.01614, 0, 0, 2, 0, 0},
+	{0.01039, 0.01043, 0, 0, 0, 2, 0},
+	{0.00739, 0.00734, 1, -1, 1, 0, 0},
+	{-0.00514, -0.00515, 1, 1, 1, 0, 0},
+	{0.00208, 0.00209, 2, 2, 0, 0, 0},
+	{-0.00111, -0.00111, 0, 0, 1, -2, 0},
+	{-0.00057, -0.00057, 0, 0, 1, 2, 0},
+	{0.00056, 0.00056, 1, 1, 2, 0, 0},
+	{-0.00042, -0.00042, 0, 0, 3, 0, 0},
+	{0.00042, 0.00042, 1, 1, 0, 2, 0},
+	{0.00038, 0.00038, 1, 1, 0, -2, 0},
+	{-0.00024, -0.00024, 1, -1, 2, 0, 0},
+	{-0.00017, -0.00017, 0, 0, 0, 0, 1},
+	{-0.00007, -0.00007, 0, 2, 1, 0, 0},
+	{0.00004, 0.00004, 0, 0, 2, -2, 0},
+	{0.00004, 0.00004, 0, 3, 0, 0, 0},
+	{0.00003, 0.00003, 0, 1, 1, -2, 0},
+	{0.00003, 0.00003, 0, 0, 2, 2, 0},
+	{-0.00003, -0.00003, 0, 1, 1, 2, 0},
+	{0.00003, 0.00003, 0, -1, 1, 2, 0},
+	{-0.00002, -0.00002, 0, -1, 1, -2, 0},
+	{-0.00002, -0.00002, 0, 1, 3, 0, 0},
+	{0.00002, 0.00002, 0, 0, 4, 0, 0},
+}
+
+// lunarPhase returns the time of new moon (whole k) or full moon (k + 0.5),
+// counting lunations from the new moon of 2000-01-06. Meeus' additional
+// planetary corrections are left out, they move the result by under a minute.
+func lunarPhase(k float64) time.Time {
+	t := k / 1236.85
+	jde := 2451550.09766 + synodicMonth*k + t*t*(0.00015437+t*(-0.000000150+t*0.00000000073))
+
+	e := 1 - t*(0.002516+t*0.0000074)
+	m := degToRad(2.5534 + 29.10535670*k - t*t*(0.0000014+t*0.00000011))
+	mPrime := degToRad(201.5643 + 385.81693528*k + t*t*(0.0107582+t*(0.00001238-t*0.000000058)))
+	f := degToRad(160.7108 + 390.67050284*k - t*t*(0.0016118+t*(0.00000227-t*0.000000011)))
+	omega := degToRad(124.7746 - 1.56375588*k + t*t*(0.0020672+t*0.00000215))
+
+	coefficient := 0
+	if k-math.Floor(k) != 0 {
+		coefficient = 1
+	}
+	for _, term := range lunarPhaseTerms {
+		argument := term[3]*m + term[4]*mPrime + term[5]*f + term[6]*omega
+		jde += term[coefficient] * math.Pow(e, term[2]) * math.Sin(argument)
+	}
+
+	unixSeconds := (jde - 2440587.5) * 86400
+	return time.Unix(0, int64(unixSeconds*float64(time.Second))).Add(-seasonDeltaT).Truncate(time.Second)
+}
+
+// nextLunarPhases returns the first new moon and full moon at or after t, in
+// t's timezone
+func nextLunarPhases(t time.Time) (newMoon time.Time, fullMoon time.Time) {
+	// start a lunation early so an estimate that runs slightly late isn't missed
+	k := math.Floor(daysSinceJ2000(t)/synodicMonth) - 1
+
+	for ; newMoon.IsZero() || fullMoon.IsZero(); k++ {
+		if at := lunarPhase(k); newMoon.IsZero() && !at.Before(t) {
+			newMoon = at.In(t.Location())
+		}
+		if at := lunarPhase(k + 0.5); fullMoon.IsZero() && !at.Before(t) {
+			fullMoon = at.In(t.Location())
+		}
+	}
+	return
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+// published new and full moons, from the USNO phases of the moon tables
+var moonEphemeris = []struct {
+	newMoon  string
+	fullMoon string
+}{
+	{"2000-01-06T18:14:00Z", "2000-01-21T04:40:00Z"},
+	{"2024-01-11T11:57:00Z", "2024-01-25T17:54:00Z"},
+	{"2024-04-08T18:21:00Z", "2024-04-23T23:49:00Z"},
+	{"2024-10-02T18:49:00Z", "2024-10-17T11:26:00Z"},
+	{"2025-03-29T10:58:00Z", "2025-04-13T00:22:00Z"},
+}
+
+func TestNextLunarPhases(t *testing.T) {
+	for _, test := range moonEphemeris {
+		newMoon, _ := time.Parse(time.RFC3339, test.newMoon)
+		fullMoon, _ := time.Parse(time.RFC3339, test.fullMoon)
+
+		// from a day before the new moon, both are next
+		gotNew, gotFull := nextLunarPhases(newMoon.Add(-24 * time.Hour))
+		if diff := gotNew.Sub(newMoon).Abs(); diff > 2*time.Minute {
+			t.Errorf("new moon after %s = %s, want %s", newMoon.Add(-24*time.Hour), gotNew, newMoon)
+		}
+		if diff := gotFull.Sub(fullMoon).Abs(); diff > 2*time.Minute {
+			t.Errorf("full moon after %s = %s, want %s", newMoon.Add(-24*time.Hour), gotFull, fullMoon)
+		}
+
+		if name := moonPhaseName(newMoon); name != "New Moon" {
+			t.Errorf("phase at %s = %s, want New Moon", newMoon, name)
+		}
+		if name := moonPhaseName(fullMoon); name != "Full Moon" {
+			t.Errorf("phase at %s = %s, want Full Moon", fullMoon, name)
+		}
+		if lit := moonIlluminated(newMoon); lit > 0.01 {
+			t.Errorf("illuminated at new moon %s = %.3f, want 0", newMoon, lit)
+		}
+		if lit := moonIlluminated(fullMoon); lit < 0.99 {
+			t.Errorf("illuminated at full moon %s = %.3f, want 1", fullMoon, lit)
+		}
+	}
+}
+
+func TestNextLunarPhasesTimezone(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, testTZ)
+	newMoon, fullMoon := nextLunarPhases(from)
+	if newMoon.Location() != testTZ || fullMoon.Location() != testTZ {
+		t.Errorf("phases in %s and %s, want %s", newMoon.Location(), fullMoon.Location(), testTZ)
+	}
+	if newMoon.Before(from) || fullMoon.Before(from) {
+		t.Errorf("phases %s and %s are before %s", newMoon, fullMoon, from)
+	}
+}
@@ -0,0 +1,277 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	apiKeyIDKey
+	pathParamKey
+	formatKey
+	routeKey
+)
+
+const maxRequestIDLength = 64
+
+var panicCount = expvar.NewInt("http_panics")
+
+// withRequestID tags each request with an ID, honoring a sane incoming
+// X-Request-ID so clients and proxies can correlate their own logs, and logs
+// the request once it's served.
+func withRequestID(next http.HandlerFunc) http.HandlerFunc {
+	return func(response http.ResponseWriter, request *http.Request) {
+		requestID := request.Header.Get("X-Request-ID")
+		if !validRequestID(requestID) {
+			requestID = newUUID()
+		}
+
+		response.Header().Set("X-Request-ID", requestID)
+		ctx := context.WithValue(request.Context(), requestIDKey, requestID)
+		request = request.WithContext(ctx)
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: response}
+		next(recorder, request)
+		if recorder.status == 0 {
+			recorder.status = 200
+		}
+		logRequest(request, "%s %s %d %s", request.Method, request.URL.Path, recorder.status, time.Since(start))
+	}
+}
+
+// statusRecorder remembers the status a handler responded with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = 200
+	}
+	return sr.ResponseWriter.Write(b)
+}
+
+// Flush passes through to the server's writer for streamed responses
+func (sr *statusRecorder) Flush() {
+	if flusher, ok := sr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// newUUID returns a random version 4 UUID
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range requestID {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func getRequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+// logRequest logs a line prefixed with the request's ID and API key hash
+func logRequest(request *http.Request, format string, v ...interface{}) {
+	tag := getRequestID(request.Context())
+	if keyID := getAPIKeyID(request.Context()); keyID != "" {
+		tag += " key=" + keyID
+	}
+	log.Printf("[%s] %s", tag, fmt.Sprintf(format, v...))
+}
+
+// withRequestTimeout cancels the request's context after REQUEST_TIMEOUT,
+// cutting short WU calls still running then
+func (env *Env) withRequestTimeout(next http.HandlerFunc) http.HandlerFunc {
+	return func(response http.ResponseWriter, request *http.Request) {
+		ctx, cancel := context.WithTimeout(request.Context(), env.config().RequestTimeout)
+		defer cancel()
+		next(response, request.WithContext(ctx))
+	}
+}
+
+// withRecovery turns a panicking handler into a 500 instead of a dropped connection
+func withRecovery(next http.HandlerFunc) http.HandlerFunc {
+	return func(response http.ResponseWriter, request *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				panicCount.Add(1)
+				logRequest(request, "Panic serving %s: %v\n%s", request.URL.Path, err, debug.Stack())
+				makeErrorResponse(response, 500, "unexpected server error", 0)
+			}
+		}()
+		next(response, request)
+	}
+}
+
+const hstsHeader = "max-age=31536000; includeSubDomains"
+
+// withHSTS tells browsers to only reach the service over HTTPS
+func withHSTS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if request.TLS != nil {
+			response.Header().Set("Strict-Transport-Security", hstsHeader)
+		}
+		next.ServeHTTP(response, request)
+	})
+}
+
+const (
+	corsAllowHeaders = "Authorization, Content-Type, If-None-Match, X-Request-ID"
+	corsMaxAge       = 600
+)
+
+// withCORS adds CORS headers for allowed origins and answers preflight
+// requests. Disallowed origins get no CORS headers and the browser blocks them.
+func (env *Env) withCORS(next http.HandlerFunc) http.HandlerFunc {
+	return func(response http.ResponseWriter, request *http.Request) {
+		origin := request.Header.Get("Origin")
+		if origin == "" || len(env.config().CORSAllowedOrigins) == 0 {
+			next(response, request)
+			return
+		}
+
+		response.Header().Add("Vary", "Origin")
+		allowed := env.corsOriginAllowed(origin)
+		preflight := request.Method == "OPTIONS" && request.Header.Get("Access-Control-Request-Method") != ""
+
+		if allowed {
+			response.Header().Set("Access-Control-Allow-Origin", origin)
+			response.Header().Set("Access-Control-Expose-Headers", "ETag, X-Request-ID")
+		}
+
+		if preflight {
+			if allowed {
+				response.Header().Set("Access-Control-Allow-Methods", routeAllow(request))
+				response.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+				response.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+			}
+			response.WriteHeader(204)
+			return
+		}
+
+		next(response, request)
+	}
+}
+
+func (env *Env) corsOriginAllowed(origin string) bool {
+	for _, allowed := range env.config().CORSAllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+const gzipMinBytes = 1024
+
+// gzipResponse buffers a response so withGzip can decide how to send it
+type gzipResponse struct {
+	http.ResponseWriter
+	body   bytes.Buffer
+	status int
+}
+
+func (gr *gzipResponse) WriteHeader(status int) {
+	if gr.status == 0 {
+		gr.status = status
+	}
+}
+
+func (gr *gzipResponse) Write(b []byte) (int, error) {
+	if gr.status == 0 {
+		gr.status = 200
+	}
+	return gr.body.Write(b)
+}
+
+// withGzip compresses bodies of at least gzipMinBytes for clients that accept
+// gzip. A strong ETag is weakened when compressed since the bytes on the wire
+// no longer match the representation it was computed from.
+func withGzip(next http.HandlerFunc) http.HandlerFunc {
+	return func(response http.ResponseWriter, request *http.Request) {
+		response.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(request) {
+			next(response, request)
+			return
+		}
+
+		gr := &gzipResponse{ResponseWriter: response}
+		next(gr, request)
+		if gr.status == 0 {
+			gr.status = 200
+		}
+
+		header := response.Header()
+		if gr.body.Len() < gzipMinBytes || header.Get("Content-Encoding") != "" {
+			response.WriteHeader(gr.status)
+			response.Write(gr.body.Bytes())
+			return
+		}
+
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		gz.Write(gr.body.Bytes())
+		gz.Close()
+
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		response.WriteHeader(gr.status)
+		response.Write(compressed.Bytes())
+	}
+}
+
+func acceptsGzip(request *http.Request) bool {
+	for _, encoding := range strings.Split(request.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(strings.TrimSpace(encoding), ";")
+		if strings.TrimSpace(parts[0]) != "gzip" {
+			continue
+		}
+		// gzip;q=0 explicitly refuses compression
+		return len(parts) < 2 || strings.TrimSpace(parts[1]) != "q=0"
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureLog collects what the service logs until the test ends
+func captureLog(t *testing.T) *bytes.Buffer {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &logged
+}
+
+func TestRecoveryPanic(t *testing.T) {
+	server := newTestServer(t, nil)
+	server.router.Route("/panic", server.env.weatherMiddleware).Get(func(response http.ResponseWriter, request *http.Request) {
+		panic("handler bug")
+	})
+	logged := captureLog(t)
+	before := panicCount.Value()
+
+	response := server.get("/panic")
+	if response.Code != 500 {
+		t.Fatalf("status = %d, want 500", response.Code)
+	}
+	if !strings.Contains(response.Body.String(), "unexpected server error") || strings.Contains(response.Body.String(), "handler bug") {
+		t.Errorf("body = %s, want a generic error", response.Body)
+	}
+	if panicCount.Value() != before+1 {
+		t.Errorf("http_panics = %d, want %d", panicCount.Value(), before+1)
+	}
+
+	requestID := response.Header().Get("X-Request-ID")
+	if !strings.Contains(logged.String(), "["+requestID+"] Panic serving /panic: handler bug") {
+		t.Errorf("panic not logged with the request ID:\n%s", logged)
+	}
+	if !strings.Contains(logged.String(), "goroutine ") || !strings.Contains(logged.String(), "middleware_test.go") {
+		t.Errorf("no stack trace logged:\n%s", logged)
+	}
+}
+
+func TestRecoveryEveryChain(t *testing.T) {
+	server := newTestServer(t, nil)
+	captureLog(t)
+	panicking := func(response http.ResponseWriter, request *http.Request) {
+		panic("handler bug")
+	}
+
+	for name, middleware := range map[string]func(http.HandlerFunc) http.HandlerFunc{
+		"weather": server.env.weatherMiddleware,
+		"feed":    server.env.feedMiddleware,
+		"stream":  server.env.streamMiddleware,
+		"info":    infoMiddleware,
+		"metrics": metricsMiddleware,
+		"none":    nil,
+	} {
+		server.router.Route("/panic/"+name, middleware).Get(panicking)
+		response := server.get("/panic/"+name, "Accept-Encoding", "gzip")
+		if response.Code != 500 {
+			t.Errorf("%s middleware: status = %d, want 500", name, response.Code)
+		}
+	}
+}
+
+func TestRecoveryAbortHandler(t *testing.T) {
+	handler := withRecovery(func(response http.ResponseWriter, request *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler passed on", err)
+		}
+	}()
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	t.Error("http.ErrAbortHandler was swallowed")
+}
+
+func TestGzip(t *testing.T) {
+	server := newTestServer(t, nil)
+
+	plain := server.get("/weather/hourly/v1")
+	if plain.Code != 200 || plain.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("without Accept-Encoding: status = %d, Content-Encoding = %q", plain.Code, plain.Header().Get("Content-Encoding"))
+	}
+	if plain.Body.Len() < gzipMinBytes {
+		t.Fatalf("hourly body is only %d bytes, too small to compress", plain.Body.Len())
+	}
+
+	compressed := server.get("/weather/hourly/v1", "Accept-Encoding", "br, gzip")
+	if compressed.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", compressed.Header().Get("Content-Encoding"))
+	}
+	if !strings.Contains(compressed.Header().Get("Vary"), "Accept-Encoding") {
+		t.Errorf("Vary = %q, want Accept-Encoding", compressed.Header().Get("Vary"))
+	}
+	reader, err := gzip.NewReader(compressed.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != plain.Body.String() {
+		t.Errorf("decompressed body differs:\n%s\n%s", body, plain.Body)
+	}
+	if want := "W/" + plain.Header().Get("ETag"); compressed.Header().Get("ETag") != want {
+		t.Errorf("compressed ETag = %s, want %s", compressed.Header().Get("ETag"), want)
+	}
+
+	if refused := server.get("/weather/hourly/v1", "Accept-Encoding", "gzip;q=0"); refused.Header().Get("Content-Encoding") != "" {
+		t.Error("compressed for gzip;q=0")
+	}
+	if small := server.get("/weather/sun_phase/v1", "Accept-Encoding", "gzip"); small.Header().Get("Content-Encoding") != "" {
+		t.Errorf("compressed a %d byte body", small.Body.Len())
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	server := newTestServer(t, nil)
+
+	first := server.get("/weather/hourly/v1")
+	etag := first.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+
+	for _, test := range []struct {
+		ifNoneMatch    string
+		acceptEncoding string
+		want           int
+	}{
+		{etag, "", 304},
+		{"W/" + etag, "", 304},
+		{`"other", ` + etag, "", 304},
+		{"*", "", 304},
+		{"W/" + etag, "gzip", 304},
+		{`"other"`, "", 200},
+	} {
+		response := server.get("/weather/hourly/v1", "If-None-Match", test.ifNoneMatch, "Accept-Encoding", test.acceptEncoding)
+		if response.Code != test.want {
+			t.Errorf("If-None-Match %s, Accept-Encoding %q: status = %d, want %d", test.ifNoneMatch, test.acceptEncoding, response.Code, test.want)
+		}
+		if test.want == 304 && response.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s: 304 with a %d byte body", test.ifNoneMatch, response.Body.Len())
+		}
+	}
+
+	// each format has its own ETag
+	flat := server.get("/weather/hourly/v1", "Accept", "application/json", "If-None-Match", etag)
+	if flat.Code != 200 || flat.Header().Get("ETag") == etag {
+		t.Errorf("application/json: status = %d, ETag = %s, want 200 with another ETag", flat.Code, flat.Header().Get("ETag"))
+	}
+}
+
+func TestCORSPreflightMethods(t *testing.T) {
+	server := newTestServer(t, map[string]string{"ADMIN_TOKEN": "admin-secret", "CORS_ALLOWED_ORIGINS": "https://dash.example"})
+
+	for path, want := range map[string]string{
+		"/weather/sun_phase/v1": "DELETE, GET, HEAD, OPTIONS",
+		"/weather/alerts/v1":    "GET, HEAD, OPTIONS",
+	} {
+		request := httptest.NewRequest("OPTIONS", path, nil)
+		request.Header.Set("Origin", "https://dash.example")
+		request.Header.Set("Access-Control-Request-Method", "DELETE")
+		response := httptest.NewRecorder()
+		server.router.ServeHTTP(response, request)
+
+		if response.Code != 204 {
+			t.Errorf("%s: status = %d, want 204", path, response.Code)
+		}
+		if got := response.Header().Get("Access-Control-Allow-Methods"); got != want {
+			t.Errorf("%s: Access-Control-Allow-Methods = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/config"
+	"github.com/tyrm/ph-weather/internal/provider"
+	"github.com/tyrm/ph-weather/internal/status"
+)
+
+// moonPhaseNames are WU's phase names for each eighth of a lunation,
+// starting at new moon
+var moonPhaseNames = []string{
+	"New Moon",
+	"Waxing Crescent",
+	"First Quarter",
+	"Waxing Gibbous",
+	"Full Moon",
+	"Waning Gibbous",
+	"Last Quarter",
+	"Waning Crescent",
+}
+
+type MoonPhaseV2Response struct {
+	ResponseID   string  `jsonapi:"primary,moon_phase"`
+	Date         string  `jsonapi:"attr,date"`
+	Phase        string  `jsonapi:"attr,phase"`
+	Moonrise     *string `jsonapi:"attr,moonrise"`
+	Moonset      *string `jsonapi:"attr,moonset"`
+	NextNewMoon  *string `jsonapi:"attr,next_new_moon"`
+	NextFullMoon *string `jsonapi:"attr,next_full_moon"`
+
+	PercentIlluminated float64 `jsonapi:"attr,percent_illuminated"`
+	Fraction           float64 `jsonapi:"attr,illuminated_fraction"`
+}
+
+var moonPhaseFields = []string{"moonrise", "moonset", "next_new_moon", "next_full_moon"}
+
+// moonAge is the days since the last new moon at t
+func moonAge(t time.Time) float64 {
+	age := math.Mod(daysSinceJ2000(t)-daysSinceJ2000(lunarPhase(0)), synodicMonth)
+	if age < 0 {
+		age += synodicMonth
+	}
+	return age
+}
+
+// moonPhaseName names the phase at t from the moon's age, centering each
+// named phase on its exact moment
+func moonPhaseName(t time.Time) string {
+	octant := int(math.Floor(moonAge(t)/synodicMonth*8+0.5)) % 8
+	return moonPhaseNames[octant]
+}
+
+// moonIlluminated approximates the lit fraction of the disc at t from the
+// moon's age, good to a few percent
+func moonIlluminated(t time.Time) float64 {
+	return (1 - math.Cos(2*math.Pi*moonAge(t)/synodicMonth)) / 2
+}
+
+// makeMoonPhaseV2Response computes the moon for day locally. The next new
+// and full moon are counted from the start of day, so a phase later that day
+// is reported as that day.
+func makeMoonPhaseV2Response(id string, coordinates config.Coordinates, day time.Time) (responseObj *MoonPhaseV2Response) {
+	midnight := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	noon := midnight.Add(12 * time.Hour)
+	responseObj = &MoonPhaseV2Response{
+		ResponseID: id,
+		Date:       day.Format(dateFormat),
+		Phase:      moonPhaseName(noon),
+	}
+	responseObj.PercentIlluminated = math.Round(moonIlluminated(noon)*1000) / 10
+	responseObj.Fraction = responseObj.PercentIlluminated / 100
+
+	rise, set := moonCrossings(day, coordinates.Latitude, coordinates.Longitude)
+	if rise != nil {
+		responseObj.Moonrise = formatTime(*rise)
+	}
+	if set != nil {
+		responseObj.Moonset = formatTime(*set)
+	}
+
+	newMoon, fullMoon := nextLunarPhases(midnight)
+	responseObj.NextNewMoon = formatTime(newMoon)
+	responseObj.NextFullMoon = formatTime(fullMoon)
+	return
+}
+
+// handleMoonPhaseV2 serves moonrise, moonset and the upcoming phases. WU's
+// rise and set times are used for today, other days are computed.
+func (env *Env) handleMoonPhaseV2(response http.ResponseWriter, request *http.Request) {
+	location, err := env.requestLocation(request)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	day, err := env.requestDate(request)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+	tz, err := env.requestTimezone(request)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+	if tz == nil {
+		tz = env.config().LocationTZ
+	}
+	cacheKey := env.cacheKey("moon_phase_v2", location.Key(), day)
+
+	cacheEntry, err := env.getOrBuildCache(request, cacheKey, env.sunPhaseTTL(day), func(ctx context.Context) (interface{}, error) {
+		return env.buildMoonPhaseV2(ctx, location, day)
+	})
+	if err != nil {
+		logRequest(request, "%s", err)
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	cacheEntry, err = inTimezone(cacheEntry, tz, moonPhaseFields...)
+	if err != nil {
+		logRequest(request, "Error converting timezone: %s", err)
+		makeErrorResponse(response, 500, err.Error(), 0)
+		return
+	}
+
+	writeCacheEntry(response, request, cacheEntry)
+}
+
+// buildMoonPhaseV2 computes the moon for day, using WU's phase, illumination
+// and rise and set times for today. When WU fails the computed moon is served.
+func (env *Env) buildMoonPhaseV2(ctx context.Context, location Location, day time.Time) (responseObj *MoonPhaseV2Response, resError error) {
+	coordinates, resError := env.locationCoordinates(ctx, location)
+	if resError != nil {
+		return
+	}
+	responseObj = makeMoonPhaseV2Response(dayResourceID("moon_phase", location.Key(), day), *coordinates, day)
+
+	if day.Format(dateFormat) != env.today().Format(dateFormat) {
+		return
+	}
+
+	astronomy, err := env.fetchAstronomy(ctx, location, day)
+	if err == nil {
+		var fromWU *MoonPhaseV2Response
+		if fromWU, err = withWUMoonPhase(*responseObj, astronomy.MoonPhase, day); err == nil {
+			responseObj = fromWU
+			return
+		}
+	}
+	if resError = status.FromContext(ctx); resError != nil {
+		responseObj = nil
+		return
+	}
+	log.Printf("Error reading the moon from WU, serving the computed moon phase: %s", err)
+	return
+}
+
+// withWUMoonPhase replaces the computed moon with WU's for today
+func withWUMoonPhase(responseObj MoonPhaseV2Response, moon provider.WUMoonPhase, day time.Time) (*MoonPhaseV2Response, error) {
+	if moon.PhaseOfMoon != "" {
+		responseObj.Phase = moon.PhaseOfMoon
+	}
+	percent, err := parsePercentIlluminated(moon.PercentIlluminated)
+	if err != nil {
+		return nil, status.Errorf(502, "Error parsing percentIlluminated: %s", err)
+	}
+	responseObj.PercentIlluminated, responseObj.Fraction = percent, percent/100
+
+	// WU sends blank times when the moon doesn't rise or set today
+	if moon.Moonrise != nil {
+		hour, minute, err := parseWUTime(*moon.Moonrise)
+		if err != nil {
+			return nil, status.Errorf(502, "Error parsing moonrise: %s", err)
+		}
+		responseObj.Moonrise = dayTime(day, hour, minute)
+	}
+	if moon.Moonset != nil {
+		hour, minute, err := parseWUTime(*moon.Moonset)
+		if err != nil {
+			return nil, status.Errorf(502, "Error parsing moonset: %s", err)
+		}
+		responseObj.Moonset = dayTime(day, hour, minute)
+	}
+	return &responseObj, nil
+}
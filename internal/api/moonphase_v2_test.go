@@ -0,0 +1,52 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMoonPhaseV2FromWU(t *testing.T) {
+	server := newTestServer(t, nil)
+
+	response := server.get("/weather/moon_phase/v2")
+	if response.Code != 200 {
+		t.Fatalf("status = %d, want 200: %s", response.Code, response.Body)
+	}
+	// MockWUResponse's moon, not the full moon computed for 2024-06-20
+	attrs := attributes(t, response)
+	if attrs["phase"] != "First Quarter" || attrs["percent_illuminated"] != float64(50) {
+		t.Errorf("phase = %v %v%%, want WU's First Quarter 50%%", attrs["phase"], attrs["percent_illuminated"])
+	}
+}
+
+func TestMoonPhaseV2WUDown(t *testing.T) {
+	server := newTestServer(t, nil)
+	server.wu.respond = func(feature string, location string) (int, string) {
+		return 500, ""
+	}
+
+	response := server.get("/weather/moon_phase/v2")
+	if response.Code != 200 {
+		t.Fatalf("status = %d, want 200: %s", response.Code, response.Body)
+	}
+	// the phase is computed for noon
+	want := moonPhaseName(time.Date(2024, 6, 20, 12, 0, 0, 0, testTZ))
+	attrs := attributes(t, response)
+	if attrs["phase"] != want {
+		t.Errorf("phase = %v, want the computed %s", attrs["phase"], want)
+	}
+	if attrs["next_full_moon"] == nil {
+		t.Error("no next_full_moon")
+	}
+}
+
+func TestMoonPhaseV2WUInvalid(t *testing.T) {
+	server := newTestServer(t, nil)
+	server.wu.respond = func(feature string, location string) (int, string) {
+		return 200, `{"moon_phase":{"percentIlluminated":"lots"}}`
+	}
+
+	if response := server.get("/weather/moon_phase/v2"); response.Code != 200 {
+		t.Fatalf("status = %d, want 200: %s", response.Code, response.Body)
+	}
+}
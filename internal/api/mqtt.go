@@ -0,0 +1,182 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/tyrm/ph-weather/internal/config"
+)
+
+const (
+	mqttQoS            = 1
+	mqttPublishTimeout = 10 * time.Second
+	mqttMaxBackoff     = 2 * time.Minute
+)
+
+var mqttTopicUnsafe = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// MQTTPublisher sends retained messages whenever fresh data is fetched, with
+// Home Assistant discovery so the sensors appear on their own. A nil
+// publisher does nothing, so callers don't check whether MQTT is configured.
+type MQTTPublisher struct {
+	client          mqtt.Client
+	topicPrefix     string
+	discoveryPrefix string
+}
+
+// haSensor is a Home Assistant sensor read from one of the published messages
+type haSensor struct {
+	id            string
+	name          string
+	feature       string
+	valueTemplate string
+	deviceClass   string
+	unit          string
+}
+
+var haSensors = []haSensor{
+	{"temperature", "Temperature", "conditions", "{{ value_json.temperature }}", "temperature", "°C"},
+	{"humidity", "Humidity", "conditions", "{{ value_json.humidity }}", "humidity", "%"},
+	{"sunrise", "Sunrise", "sun_phase", "{{ '%02d:%02d' | format(value_json.sunrise_h, value_json.sunrise_m) }}", "", ""},
+	{"sunset", "Sunset", "sun_phase", "{{ '%02d:%02d' | format(value_json.sunset_h, value_json.sunset_m) }}", "", ""},
+	{"solar_noon", "Solar noon", "sun_phase", "{{ value_json.solar_noon_iso }}", "timestamp", ""},
+}
+
+// newMQTTPublisher connects to MQTT_BROKER in the background. The client
+// retries the first connection and reconnects with backoff, republishing
+// discovery each time it connects.
+func newMQTTPublisher(config *config.Config, locations []Location) (publisher *MQTTPublisher, resError error) {
+	publisher = &MQTTPublisher{
+		topicPrefix:     config.MQTTTopicPrefix,
+		discoveryPrefix: config.MQTTDiscoveryPrefix,
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(config.MQTTBroker).
+		SetClientID(config.MQTTClientID).
+		SetUsername(config.MQTTUsername).
+		SetPassword(config.MQTTPassword).
+		SetAutoReconnect(true).
+		SetMaxReconnectInterval(mqttMaxBackoff).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(5*time.Second).
+		SetWill(publisher.availabilityTopic(), "offline", mqttQoS, true)
+
+	if config.MQTTCAFile != "" {
+		caCert, err := ioutil.ReadFile(config.MQTTCAFile)
+		if err != nil {
+			resError = fmt.Errorf("Error reading MQTT_CA_FILE: %s", err)
+			return
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			resError = fmt.Errorf("Error reading MQTT_CA_FILE: no certificates found")
+			return
+		}
+		opts.SetTLSConfig(&tls.Config{RootCAs: pool})
+	}
+
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		log.Printf("Connected to MQTT broker")
+		publisher.publish(publisher.availabilityTopic(), []byte("online"))
+		for _, location := range locations {
+			publisher.publishDiscovery(location)
+		}
+	})
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		log.Printf("Lost MQTT connection, reconnecting: %s", err)
+	})
+
+	publisher.client = mqtt.NewClient(opts)
+	publisher.client.Connect() // with retry the token only completes once connected
+	return
+}
+
+// Close marks the service offline and disconnects, letting in-flight
+// publishes finish
+func (publisher *MQTTPublisher) Close() {
+	if publisher == nil {
+		return
+	}
+	if publisher.client.IsConnected() {
+		publisher.publish(publisher.availabilityTopic(), []byte("offline")).WaitTimeout(mqttPublishTimeout)
+	}
+	publisher.client.Disconnect(uint(time.Second / time.Millisecond))
+}
+
+func (publisher *MQTTPublisher) availabilityTopic() string {
+	return publisher.topicPrefix + "/status"
+}
+
+func (publisher *MQTTPublisher) stateTopic(location Location, feature string) string {
+	return publisher.topicPrefix + "/" + mqttTopicSegment(location.Key()) + "/" + feature
+}
+
+// mqttTopicSegment keeps location keys such as CA/San_Francisco to one topic level
+func mqttTopicSegment(value string) string {
+	return mqttTopicUnsafe.ReplaceAllString(value, "_")
+}
+
+// publish sends a retained message without waiting for it. Failures are
+// logged only, they must never fail the request that fetched the data.
+func (publisher *MQTTPublisher) publish(topic string, payload []byte) mqtt.Token {
+	token := publisher.client.Publish(topic, mqttQoS, true, payload)
+	go func() {
+		if !token.WaitTimeout(mqttPublishTimeout) {
+			log.Printf("Timed out publishing to MQTT %s", topic)
+		} else if err := token.Error(); err != nil {
+			log.Printf("Error publishing to MQTT %s: %s", topic, err)
+		}
+	}()
+	return token
+}
+
+// Publish sends a location's fresh feature data as its retained state
+func (publisher *MQTTPublisher) Publish(location Location, feature string, body []byte) {
+	if publisher == nil {
+		return
+	}
+	publisher.publish(publisher.stateTopic(location, feature), body)
+}
+
+// publishDiscovery announces a location's sensors to Home Assistant
+func (publisher *MQTTPublisher) publishDiscovery(location Location) {
+	locationID := mqttTopicSegment(location.Key())
+	device := map[string]interface{}{
+		"identifiers": []string{"ph_weather_" + locationID},
+		"name":        "ph-weather " + location.Key(),
+		"model":       "ph-weather",
+	}
+
+	for _, sensor := range haSensors {
+		uniqueID := "ph_weather_" + locationID + "_" + sensor.id
+		config := map[string]interface{}{
+			"name":               sensor.name,
+			"unique_id":          uniqueID,
+			"state_topic":        publisher.stateTopic(location, sensor.feature),
+			"value_template":     sensor.valueTemplate,
+			"availability_topic": publisher.availabilityTopic(),
+			"device":             device,
+		}
+		if sensor.deviceClass != "" {
+			config["device_class"] = sensor.deviceClass
+		}
+		if sensor.unit != "" {
+			config["unit_of_measurement"] = sensor.unit
+		}
+
+		body, err := json.Marshal(config)
+		if err != nil {
+			log.Printf("Error marshaling MQTT discovery: %s", err)
+			continue
+		}
+		publisher.publish(publisher.discoveryPrefix+"/sensor/"+uniqueID+"/config", body)
+	}
+}
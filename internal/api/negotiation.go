@@ -0,0 +1,229 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/jsonapi"
+	"github.com/tyrm/ph-weather/internal/status"
+)
+
+const (
+	formatJSONAPI = "jsonapi"
+	formatJSON    = "json"
+)
+
+// negotiateFormat picks the response format for an Accept header, preferring
+// the media range with the highest q and then the first listed. ok is false
+// when nothing acceptable is on offer, including JSON:API only with media type
+// parameters.
+func negotiateFormat(accept string) (format string, ok bool) {
+	if strings.TrimSpace(accept) == "" {
+		return formatJSONAPI, true
+	}
+
+	bestQ := 0.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if value, found := params["q"]; found {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		if q <= bestQ {
+			continue
+		}
+
+		switch mediaType {
+		case jsonapi.MediaType:
+			// the spec has servers refuse JSON:API with media type parameters
+			if _, found := params["q"]; len(params) > 1 || (len(params) == 1 && !found) {
+				continue
+			}
+			format, bestQ = formatJSONAPI, q
+		case "application/*", "*/*":
+			format, bestQ = formatJSONAPI, q
+		case "application/json":
+			format, bestQ = formatJSON, q
+		}
+	}
+	return format, format != ""
+}
+
+// withContentNegotiation rejects requests that accept neither JSON:API nor
+// plain JSON, and records the chosen format for writeDocument
+func withContentNegotiation(next http.HandlerFunc) http.HandlerFunc {
+	return func(response http.ResponseWriter, request *http.Request) {
+		response.Header().Add("Vary", "Accept")
+
+		format, ok := negotiateFormat(request.Header.Get("Accept"))
+		if !ok && request.Method != "OPTIONS" {
+			makeErrorResponse(response, 406, fmt.Sprintf("supported media types are %s and application/json", jsonapi.MediaType), 0)
+			return
+		}
+
+		ctx := context.WithValue(request.Context(), formatKey, format)
+		next(response, request.WithContext(ctx))
+	}
+}
+
+// withJSONAPIContentType rejects request bodies that aren't JSON:API, or are
+// JSON:API with media type parameters, as the spec requires. Requests
+// without a body pass.
+func withJSONAPIContentType(next http.HandlerFunc) http.HandlerFunc {
+	return func(response http.ResponseWriter, request *http.Request) {
+		contentType := request.Header.Get("Content-Type")
+		if contentType == "" && request.ContentLength == 0 {
+			next(response, request)
+			return
+		}
+
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil || mediaType != jsonapi.MediaType || len(params) > 0 {
+			makeErrorResponse(response, 415, fmt.Sprintf("request bodies must be %s without media type parameters", jsonapi.MediaType), 0)
+			return
+		}
+		next(response, request)
+	}
+}
+
+// requestFormat is the format chosen by withContentNegotiation, JSON:API when
+// the route doesn't negotiate
+func requestFormat(request *http.Request) string {
+	if format, ok := request.Context().Value(formatKey).(string); ok {
+		return format
+	}
+	return formatJSONAPI
+}
+
+// formatETag gives each representation of a body its own validator
+func formatETag(etag string, format string) string {
+	if format == formatJSONAPI {
+		return etag
+	}
+	return strings.TrimSuffix(etag, "\"") + "-" + format + "\""
+}
+
+// writeDocument sends a marshaled jsonapi document in the request's format,
+// indented when the request asks for ?pretty=true
+func writeDocument(response http.ResponseWriter, request *http.Request, body []byte) {
+	writeDocumentStatus(response, request, 200, body)
+}
+
+// writeDocumentStatus is writeDocument with a status other than 200
+func writeDocumentStatus(response http.ResponseWriter, request *http.Request, code int, body []byte) {
+	body, err := withSparseFieldsets(body, sparseFieldsets(request))
+	if err != nil {
+		if status.Code(err) >= 500 {
+			logRequest(request, "Error selecting fields: %s", err)
+		}
+		// the ETag was for the whole document
+		response.Header().Del("ETag")
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	contentType := jsonapi.MediaType
+	if requestFormat(request) == formatJSON {
+		flat, err := flattenDocument(body)
+		if err != nil {
+			logRequest(request, "Error flattening response: %s", err)
+			makeErrorResponse(response, 500, err.Error(), 0)
+			return
+		}
+		body, contentType = flat, "application/json"
+	} else {
+		body = withJSONAPIMember(withSelfLink(body, request))
+	}
+
+	if pretty, _ := strconv.ParseBool(request.URL.Query().Get("pretty")); pretty {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, body, "", "  "); err != nil {
+			logRequest(request, "Error indenting response: %s", err)
+			makeErrorResponse(response, 500, err.Error(), 0)
+			return
+		}
+		indented.WriteByte('\n')
+		body = indented.Bytes()
+
+		// the bytes differ from the compact body, which is only equivalent
+		if etag := response.Header().Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			response.Header().Set("ETag", "W/"+etag)
+		}
+	}
+
+	response.Header().Set("Content-Type", contentType)
+	response.WriteHeader(code)
+	response.Write(body)
+}
+
+// jsonapiVersion is advertised in the top-level jsonapi member of every
+// document, set from JSONAPI_VERSION. Empty leaves the member out.
+var jsonapiVersion = "1.1"
+
+// withJSONAPIMember adds {"jsonapi":{"version":...}} at the start of a
+// document, keeping the rest of its bytes as they are
+func withJSONAPIMember(body []byte) []byte {
+	if jsonapiVersion == "" || len(body) < 2 || body[0] != '{' {
+		return body
+	}
+	member, err := json.Marshal(map[string]string{"version": jsonapiVersion})
+	if err != nil {
+		return body
+	}
+
+	withMember := append([]byte(`{"jsonapi":`), member...)
+	if rest := bytes.TrimSpace(body[1:]); len(rest) > 0 && rest[0] != '}' {
+		withMember = append(withMember, ',')
+	}
+	return append(withMember, body[1:]...)
+}
+
+// flattenDocument turns a jsonapi document into a plain object of the
+// resource's id and attributes, or an array of them for a collection, so
+// both formats come from the same marshaled response structs
+func flattenDocument(body []byte) ([]byte, error) {
+	var document struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(document.Data), []byte("[")) {
+		var nodes []*jsonapi.Node
+		if err := json.Unmarshal(document.Data, &nodes); err != nil {
+			return nil, err
+		}
+		flat := make([]map[string]interface{}, 0, len(nodes))
+		for _, node := range nodes {
+			flat = append(flat, flattenNode(node))
+		}
+		return json.Marshal(flat)
+	}
+
+	var node jsonapi.Node
+	if err := json.Unmarshal(document.Data, &node); err != nil {
+		return nil, err
+	}
+	return json.Marshal(flattenNode(&node))
+}
+
+func flattenNode(node *jsonapi.Node) map[string]interface{} {
+	flat := map[string]interface{}{"id": node.ID}
+	for name, value := range node.Attributes {
+		flat[name] = value
+	}
+	return flat
+}
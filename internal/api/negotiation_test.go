@@ -0,0 +1,196 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// negotiatedPaths are a request for every endpoint that negotiates its format
+var negotiatedPaths = []string{
+	"/weather/sun_phase/v1",
+	"/weather/sun_phase/v2",
+	"/weather/sun_phase/batch/v1?locations=PA/Philadelphia,NY/New_York",
+	"/weather/sun_phase/range/v1?start=2024-06-20&end=2024-06-22",
+	"/weather/alerts/v1",
+	"/weather/hourly/v1",
+	"/weather/tides/v1",
+	"/weather/astronomy/v1",
+	"/weather/daylight/v1",
+	"/weather/golden_hour/v1",
+	"/weather/twilight/v1",
+	"/weather/moon_phase/v2",
+	"/weather/seasons/v1",
+	"/weather/observations/v1",
+	"/weather/sun_position/v1",
+	"/version",
+	"/readyz",
+}
+
+func TestFlatJSONEveryEndpoint(t *testing.T) {
+	server := newTestServer(t, map[string]string{"ALLOW_LOCATION_OVERRIDE": "true"})
+
+	for _, path := range negotiatedPaths {
+		document := server.get(path)
+		flat := server.get(path, "Accept", "application/json")
+		if document.Code != 200 || flat.Code != 200 {
+			t.Errorf("%s: status %d and %d, want 200: %s", path, document.Code, flat.Code, flat.Body)
+			continue
+		}
+		if got := flat.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("%s: Content-Type = %q, want application/json", path, got)
+		}
+
+		// the flat body is each resource's id and attributes, nothing else
+		var resources struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(document.Body.Bytes(), &resources); err != nil {
+			t.Fatalf("%s: decoding %s: %s", path, document.Body, err)
+		}
+		var nodes []struct {
+			ID         string                 `json:"id"`
+			Attributes map[string]interface{} `json:"attributes"`
+		}
+		if resources.Data[0] != '[' {
+			resources.Data = append(append([]byte("["), resources.Data...), ']')
+		}
+		if err := json.Unmarshal(resources.Data, &nodes); err != nil {
+			t.Fatalf("%s: decoding data %s: %s", path, resources.Data, err)
+		}
+		var want []map[string]interface{}
+		for _, node := range nodes {
+			object := map[string]interface{}{"id": node.ID}
+			for name, value := range node.Attributes {
+				object[name] = value
+			}
+			want = append(want, object)
+		}
+
+		var got []map[string]interface{}
+		body := flat.Body.Bytes()
+		if len(body) > 0 && body[0] == '{' {
+			body = append(append([]byte("["), body...), ']')
+		}
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Fatalf("%s: decoding flat %s: %s", path, flat.Body, err)
+		}
+		if len(got) == 0 && len(want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: flat JSON differs from the document's attributes\ngot  %v\nwant %v", path, got, want)
+		}
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	for _, test := range []struct {
+		accept string
+		format string
+		ok     bool
+	}{
+		{"", formatJSONAPI, true},
+		{"*/*", formatJSONAPI, true},
+		{"application/*", formatJSONAPI, true},
+		{"application/vnd.api+json", formatJSONAPI, true},
+		{"application/json", formatJSON, true},
+		{"text/html, application/json", formatJSON, true},
+		{"application/json;q=0.5, application/vnd.api+json", formatJSONAPI, true},
+		{"application/vnd.api+json;q=0.5, application/json", formatJSON, true},
+		{"application/json, application/vnd.api+json", formatJSON, true},
+		{"application/vnd.api+json;q=0.9, */*;q=0.1", formatJSONAPI, true},
+		{"application/vnd.api+json; ext=\"https://example.com/ext\"", "", false},
+		{"application/vnd.api+json; ext=\"https://example.com/ext\", application/json;q=0.1", formatJSON, true},
+		{"text/html", "", false},
+		{"application/xml, text/plain", "", false},
+		{"application/json;q=0", "", false},
+		{"not a media type", "", false},
+	} {
+		format, ok := negotiateFormat(test.accept)
+		if format != test.format || ok != test.ok {
+			t.Errorf("negotiateFormat(%q) = %q, %t, want %q, %t", test.accept, format, ok, test.format, test.ok)
+		}
+	}
+}
+
+func TestContentNegotiation(t *testing.T) {
+	server := newTestServer(t, nil)
+
+	for accept, want := range map[string]struct {
+		status      int
+		contentType string
+	}{
+		"":                         {200, "application/vnd.api+json"},
+		"*/*":                      {200, "application/vnd.api+json"},
+		"application/vnd.api+json": {200, "application/vnd.api+json"},
+		"application/json":         {200, "application/json"},
+		"text/html":                {406, "application/vnd.api+json"},
+	} {
+		response := server.get("/weather/sun_phase/v1", "Accept", accept)
+		if response.Code != want.status || response.Header().Get("Content-Type") != want.contentType {
+			t.Errorf("Accept %q: %d %s, want %d %s", accept, response.Code, response.Header().Get("Content-Type"), want.status, want.contentType)
+		}
+		if vary := response.Header().Values("Vary"); !slices.Contains(vary, "Accept") {
+			t.Errorf("Accept %q: Vary = %v, want Accept", accept, vary)
+		}
+	}
+
+	// the 406 says what is on offer
+	response := server.get("/weather/sun_phase/v1", "Accept", "text/html")
+	if _, detail := jsonapiError(t, response); detail != "supported media types are application/vnd.api+json and application/json" {
+		t.Errorf("406 detail = %q", detail)
+	}
+}
+
+func TestJSONAPIMember(t *testing.T) {
+	for version, want := range map[string]string{
+		"":     `{"jsonapi":{"version":"1.1"},`,
+		"1.0":  `{"jsonapi":{"version":"1.0"},`,
+		"none": "",
+	} {
+		server := newTestServer(t, map[string]string{"JSONAPI_VERSION": version, "ALLOW_LOCATION_OVERRIDE": "true"})
+
+		for _, path := range negotiatedPaths {
+			response := server.get(path)
+			if want == "" {
+				if strings.Contains(response.Body.String(), `"jsonapi"`) {
+					t.Errorf("JSONAPI_VERSION=none %s: body has a jsonapi member: %.40s", path, response.Body)
+				}
+				continue
+			}
+			if !strings.HasPrefix(response.Body.String(), want) {
+				t.Errorf("JSONAPI_VERSION=%q %s: body starts %.40s, want %s", version, path, response.Body, want)
+			}
+		}
+
+		// plain JSON and errors have no jsonapi member
+		if flat := server.get("/weather/sun_phase/v1", "Accept", "application/json"); strings.Contains(flat.Body.String(), `"jsonapi"`) {
+			t.Errorf("JSONAPI_VERSION=%q: flat JSON has a jsonapi member: %s", version, flat.Body)
+		}
+	}
+}
+
+func TestWithJSONAPIMember(t *testing.T) {
+	defer func(version string) { jsonapiVersion = version }(jsonapiVersion)
+	jsonapiVersion = "1.1"
+
+	for body, want := range map[string]string{
+		`{"data":null}`:                  `{"jsonapi":{"version":"1.1"},"data":null}`,
+		`{}`:                             `{"jsonapi":{"version":"1.1"}}`,
+		`{ }`:                            `{"jsonapi":{"version":"1.1"} }`,
+		`[]`:                             `[]`,
+		`{"data":[],"meta":{"count":0}}`: `{"jsonapi":{"version":"1.1"},"data":[],"meta":{"count":0}}`,
+	} {
+		got := withJSONAPIMember([]byte(body))
+		if string(got) != want {
+			t.Errorf("withJSONAPIMember(%s) = %s, want %s", body, got, want)
+		}
+		var document map[string]interface{}
+		if body[0] == '{' && json.Unmarshal(got, &document) != nil {
+			t.Errorf("withJSONAPIMember(%s) = %s, not JSON", body, got)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/jsonapi"
+	"github.com/tyrm/ph-weather/internal/cache"
+	"github.com/tyrm/ph-weather/units"
+)
+
+const (
+	defaultObservationsLimit = 100
+	maxObservationsLimit     = 1000
+)
+
+var observationUnits = map[string]units.Kind{
+	"temperature": units.Temperature,
+	"pressure":    units.Pressure,
+	"wind_speed":  units.Speed,
+}
+
+type ObservationResponse struct {
+	ResponseID  string   `jsonapi:"primary,observation"`
+	Time        string   `jsonapi:"attr,time_iso"`
+	Temperature *float64 `jsonapi:"attr,temperature"`
+	Humidity    *float64 `jsonapi:"attr,humidity"`
+	Pressure    *float64 `jsonapi:"attr,pressure"`
+	WindSpeed   *float64 `jsonapi:"attr,wind_speed"`
+	WindDir     *float64 `jsonapi:"attr,wind_dir"`
+}
+
+// handleObservations serves recorded observations between ?from= and ?to=,
+// the last day by default, a page of ?limit= at a time from ?offset=
+func (env *Env) handleObservations(response http.ResponseWriter, request *http.Request) {
+	location, err := env.requestLocation(request)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	system, err := env.requestUnits(request)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	query := request.URL.Query()
+
+	to := env.now()
+	if value := query.Get("to"); value != "" {
+		to, err = parseObservationTime(value, env.config().LocationTZ, true)
+		if err != nil {
+			makeErrorResponse(response, 400, "to must be an RFC 3339 time or YYYY-MM-DD", 0)
+			return
+		}
+	}
+	from := to.Add(-24 * time.Hour)
+	if value := query.Get("from"); value != "" {
+		from, err = parseObservationTime(value, env.config().LocationTZ, false)
+		if err != nil {
+			makeErrorResponse(response, 400, "from must be an RFC 3339 time or YYYY-MM-DD", 0)
+			return
+		}
+	}
+	if from.After(to) {
+		makeErrorResponse(response, 400, "from must not be after to", 0)
+		return
+	}
+
+	offset, limit, err := requestPage(request, defaultObservationsLimit, maxObservationsLimit)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	// one record past the page tells whether there is a next one
+	records, err := env.observationHistory(request.Context(), location, from, to, int64(offset), int64(limit+1))
+	if err != nil {
+		logRequest(request, "Error reading observations: %s", err)
+		makeErrorResponse(response, 503, "observation history is unavailable", 0)
+		return
+	}
+	more := len(records) > limit
+	if more {
+		records = records[:limit]
+	}
+
+	payload, err := jsonapi.Marshal(makeObservationsResponse(location, records, env.config().LocationTZ))
+	if err != nil {
+		logRequest(request, "Error marshaling response: %s", err)
+		makeErrorResponse(response, 500, err.Error(), 0)
+		return
+	}
+	if many, ok := payload.(*jsonapi.ManyPayload); ok {
+		many.Links = pageLinks(request, offset, limit, more)
+	}
+
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(payload); err != nil {
+		logRequest(request, "Error marshaling response: %s", err)
+		makeErrorResponse(response, 500, err.Error(), 0)
+		return
+	}
+
+	cacheEntry, err := inUnits(&cache.Entry{ETag: cache.MakeETag(body.String()), Body: body.String()}, system, observationUnits)
+	if err != nil {
+		logRequest(request, "Error preparing observations: %s", err)
+		makeErrorResponse(response, 500, err.Error(), 0)
+		return
+	}
+
+	writeCacheEntry(response, request, cacheEntry)
+}
+
+// makeObservationsResponse always returns a slice so an empty range is an empty array
+func makeObservationsResponse(location Location, records []observationRecord, tz *time.Location) (responseObj []*ObservationResponse) {
+	responseObj = []*ObservationResponse{}
+
+	for _, record := range records {
+		responseObj = append(responseObj, &ObservationResponse{
+			ResponseID:  fmt.Sprintf("%s:%d", location.Key(), record.Epoch),
+			Time:        time.Unix(record.Epoch, 0).In(tz).Format(time.RFC3339),
+			Temperature: record.Temperature,
+			Humidity:    record.Humidity,
+			Pressure:    record.Pressure,
+			WindSpeed:   record.WindSpeed,
+			WindDir:     record.WindDir,
+		})
+	}
+	return
+}
+
+// parseObservationTime accepts an RFC 3339 time or a date in tz. A date as
+// the end of a range covers the whole day.
+func parseObservationTime(value string, tz *time.Location, end bool) (at time.Time, resError error) {
+	at, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return
+	}
+
+	at, resError = time.ParseInLocation(dateFormat, value, tz)
+	if resError == nil && end {
+		at = at.AddDate(0, 0, 1).Add(-time.Second)
+	}
+	return
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// observationsPage decodes a page of observations and the link to the next one
+func observationsPage(t *testing.T, body []byte) (records []map[string]interface{}, next string) {
+	t.Helper()
+	var document struct {
+		Data []struct {
+			Attributes map[string]interface{} `json:"attributes"`
+		} `json:"data"`
+		Links map[string]string `json:"links"`
+	}
+	if err := json.Unmarshal(body, &document); err != nil {
+		t.Fatalf("decoding %s: %s", body, err)
+	}
+	for _, resource := range document.Data {
+		records = append(records, resource.Attributes)
+	}
+	if link := document.Links["next"]; link != "" {
+		parsed, err := url.Parse(link)
+		if err != nil {
+			t.Fatalf("next link %q: %s", link, err)
+		}
+		next = parsed.RequestURI()
+	}
+	return
+}
+
+func TestObservationsRecordedByPrewarm(t *testing.T) {
+	server := newTestServer(t, map[string]string{"DEFAULT_UNITS": "metric"})
+
+	// the pre-warmer fetches conditions each time the cached ones expire
+	var times []time.Time
+	for i := 0; i < 3; i++ {
+		now := testNow.Add(time.Duration(i) * conditionsTTL)
+		server.env.now = func() time.Time { return now }
+		server.wu.now = now
+		server.env.prewarm(context.Background())
+		server.redis.FastForward(conditionsTTL)
+		times = append(times, now.Truncate(5*time.Minute))
+	}
+
+	path := "/weather/observations/v1?limit=2"
+	var records []map[string]interface{}
+	for pages := 0; path != ""; pages++ {
+		if pages == 2 {
+			t.Fatalf("more than two pages of 2 for 3 observations, next %s", path)
+		}
+		response := server.get(path)
+		if response.Code != 200 {
+			t.Fatalf("%s: status = %d, want 200: %s", path, response.Code, response.Body)
+		}
+		page, next := observationsPage(t, response.Body.Bytes())
+		if len(page) > 2 {
+			t.Errorf("%s: %d observations, want at most the limit of 2", path, len(page))
+		}
+		records = append(records, page...)
+		path = next
+	}
+
+	if len(records) != len(times) {
+		t.Fatalf("read back %d observations, want %d", len(records), len(times))
+	}
+	for i, record := range records {
+		if want := times[i].In(testTZ).Format(time.RFC3339); record["time_iso"] != want {
+			t.Errorf("observation %d time_iso = %v, want %s", i, record["time_iso"], want)
+		}
+		if record["temperature"] != 18.5 || record["humidity"] != float64(65) || record["pressure"] != float64(1015) {
+			t.Errorf("observation %d = %v, want the mock's 18.5C, 65%% and 1015mb", i, record)
+		}
+		if record["wind_speed"] != float64(12) || record["wind_dir"] != float64(270) {
+			t.Errorf("observation %d wind = %v at %v, want 12 at 270", i, record["wind_speed"], record["wind_dir"])
+		}
+	}
+}
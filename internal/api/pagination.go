@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/google/jsonapi"
+	"github.com/tyrm/ph-weather/internal/cache"
+	"github.com/tyrm/ph-weather/internal/status"
+)
+
+// requestPage returns the ?offset= and ?limit= of a paginated collection,
+// limit capped at maxLimit
+func requestPage(request *http.Request, defaultLimit int, maxLimit int) (offset int, limit int, resError error) {
+	query := request.URL.Query()
+
+	limit = defaultLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			resError = status.Errorf(400, "limit must be a positive number")
+			return
+		}
+		if limit > maxLimit {
+			limit = maxLimit
+		}
+	}
+
+	if value := query.Get("offset"); value != "" {
+		var err error
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			resError = status.Errorf(400, "offset must not be negative")
+		}
+	}
+	return
+}
+
+// pageLinks are the self, next and prev links of a page, next only when
+// more resources follow
+func pageLinks(request *http.Request, offset int, limit int, more bool) *jsonapi.Links {
+	links := jsonapi.Links{"self": pageLink(request, offset)}
+	if more {
+		links["next"] = pageLink(request, offset+limit)
+	}
+	if offset > 0 {
+		prev := offset - limit
+		if prev < 0 {
+			prev = 0
+		}
+		links["prev"] = pageLink(request, prev)
+	}
+	return &links
+}
+
+// pageLink links to the request with another offset
+func pageLink(request *http.Request, offset int) string {
+	query := request.URL.Query()
+	query.Set("offset", strconv.Itoa(offset))
+	return requestLink(request, query.Encode())
+}
+
+// pageMany keeps limit resources from offset of a cached many payload and
+// adds the page links
+func pageMany(cacheEntry *cache.Entry, request *http.Request, offset int, limit int) (paged *cache.Entry, resError error) {
+	var payload jsonapi.ManyPayload
+	if err := json.Unmarshal([]byte(cacheEntry.Body), &payload); err != nil {
+		resError = err
+		return
+	}
+
+	total := len(payload.Data)
+	if offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	payload.Data = payload.Data[offset:end]
+	payload.Links = pageLinks(request, offset, limit, end < total)
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		resError = err
+		return
+	}
+	paged = &cache.Entry{ETag: cache.MakeETag(string(body)), Body: string(body), Stale: cacheEntry.Stale}
+	return
+}
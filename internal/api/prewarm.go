@@ -0,0 +1,33 @@
+package api
+
+import (
+	"context"
+	"log"
+)
+
+// runPrewarm fetches today's sun phase and the current conditions of every
+// configured location every PREWARM_INTERVAL until ctx is done, so requests
+// find them cached and MQTT and stream clients hear of each refresh without
+// anyone asking for them
+func (env *Env) runPrewarm(ctx context.Context) {
+	for {
+		env.prewarm(ctx)
+		if !env.sleep(ctx, env.config().PrewarmInterval) {
+			return
+		}
+	}
+}
+
+// prewarm fills whatever of the locations' sun phase and conditions isn't
+// cached. Fetching them announces them through refreshed.
+func (env *Env) prewarm(ctx context.Context) {
+	day := env.today()
+	for _, location := range env.configuredLocations() {
+		if err := env.fillCache(ctx, cliFeatures["sun_phase"], location, day); err != nil {
+			log.Printf("Error pre-warming sun phase for %s: %s", location.Key(), err)
+		}
+		if _, err := env.cachedConditions(ctx, location, true); err != nil {
+			log.Printf("Error pre-warming conditions for %s: %s", location.Key(), err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/provider"
+)
+
+// drainEvents returns the events waiting on a subscription
+func drainEvents(events chan Event) (received []Event) {
+	for {
+		select {
+		case event := <-events:
+			received = append(received, event)
+		default:
+			return
+		}
+	}
+}
+
+func TestPrewarmAnnounces(t *testing.T) {
+	server := newTestServer(t, nil)
+	events := server.env.events.Subscribe()
+	defer server.env.events.Unsubscribe(events)
+
+	server.env.prewarm(context.Background())
+
+	announced := map[string]bool{}
+	for _, event := range drainEvents(events) {
+		announced[event.Location+" "+event.Feature] = true
+	}
+	for _, want := range []string{"PA/Philadelphia sun_phase", "PA/Philadelphia conditions"} {
+		if !announced[want] {
+			t.Errorf("no %s event, announced %v", want, announced)
+		}
+	}
+	if entry, _ := server.env.cache.Get(context.Background(), server.env.sunPhaseCacheKey("PA/Philadelphia", testNow)); entry == nil {
+		t.Error("today's sun phase isn't cached")
+	}
+
+	// what is still cached isn't fetched or announced again
+	calls := server.wu.calls()
+	server.env.prewarm(context.Background())
+	if server.wu.calls() != calls {
+		t.Errorf("WU called %d more times for cached data", server.wu.calls()-calls)
+	}
+	if again := drainEvents(events); len(again) != 0 {
+		t.Errorf("announced %v again", again)
+	}
+}
+
+func TestRunPrewarm(t *testing.T) {
+	server := newTestServer(t, map[string]string{"PREWARM_INTERVAL": "10m"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := &fakeClock{now: testNow, stop: testNow.Add(25 * time.Minute), cancel: cancel}
+	server.env.now = clock.Now
+	server.env.sleep = clock.Sleep
+
+	done := make(chan struct{})
+	go func() {
+		server.env.runPrewarm(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("runPrewarm didn't stop")
+	}
+
+	if len(clock.slept) != 3 {
+		t.Fatalf("slept %v, want three intervals", clock.slept)
+	}
+	for _, slept := range clock.slept {
+		if slept != 10*time.Minute {
+			t.Errorf("slept %s, want PREWARM_INTERVAL", slept)
+		}
+	}
+}
+
+func TestPrewarmIntervalConfig(t *testing.T) {
+	for value, want := range map[string]time.Duration{"": conditionsTTL, "0": 0, "1m": time.Minute} {
+		t.Setenv("PREWARM_INTERVAL", value)
+		if config := newTestServer(t, nil).env.config(); config.PrewarmInterval != want {
+			t.Errorf("PREWARM_INTERVAL=%q gives %s, want %s", value, config.PrewarmInterval, want)
+		}
+	}
+}
+
+func TestPrewarmEveryLocation(t *testing.T) {
+	server := newTestServer(t, map[string]string{"LOCATIONS": "cabin:VT/Stowe,home:PA/Philadelphia,office:NY/New_York"})
+	server.wu.respond = func(feature string, location string) (int, string) {
+		// one location failing doesn't keep the others cold
+		if location == "VT/Stowe" {
+			return 500, ""
+		}
+		body, _ := provider.MockWUResponse(feature, location, testNow)
+		return 200, body
+	}
+	logged := captureLog(t)
+
+	server.env.prewarm(context.Background())
+
+	for _, location := range []string{"PA/Philadelphia", "home", "office"} {
+		if entry, _ := server.env.cache.Get(context.Background(), server.env.sunPhaseCacheKey(location, testNow)); entry == nil {
+			t.Errorf("%s: sun phase isn't cached", location)
+		}
+		if exists := server.redis.Exists(server.env.cacheKey("wu_conditions", location, time.Time{})); !exists {
+			t.Errorf("%s: conditions aren't cached", location)
+		}
+	}
+	if !strings.Contains(logged.String(), "Error pre-warming sun phase for cabin") {
+		t.Errorf("cabin's failure wasn't logged: %s", logged)
+	}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMockProvider(t *testing.T) {
+	server := newTestServer(t, map[string]string{"WEATHER_PROVIDER": "mock"})
+
+	response := server.get("/weather/astronomy/v1", "Accept", "application/json")
+	if response.Code != 200 {
+		t.Fatalf("status = %d, want 200: %s", response.Code, response.Body)
+	}
+	var astronomy map[string]interface{}
+	if err := json.Unmarshal(response.Body.Bytes(), &astronomy); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]interface{}{
+		"sunrise_iso":  "2024-06-20T06:30:00-04:00",
+		"sunset_iso":   "2024-06-20T18:45:00-04:00",
+		"moon_phase":   "First Quarter",
+		"moonrise_iso": "2024-06-20T12:15:00-04:00",
+	} {
+		if astronomy[name] != want {
+			t.Errorf("%s = %v, want %v", name, astronomy[name], want)
+		}
+	}
+
+	response = server.get("/weather/hourly/v1", "Accept", "application/json")
+	var hours []map[string]interface{}
+	if err := json.Unmarshal(response.Body.Bytes(), &hours); err != nil {
+		t.Fatalf("decoding %s: %s", response.Body, err)
+	}
+	if len(hours) == 0 || hours[0]["time_iso"] != "2024-06-20T16:00:00-04:00" {
+		t.Errorf("hourly starts %v, want the hour after the clock", hours)
+	}
+
+	if server.wu.calls() != 0 {
+		t.Errorf("WU called %d times with the mock provider", server.wu.calls())
+	}
+}
+
+func TestFixturesProvider(t *testing.T) {
+	dir := t.TempDir()
+	astronomy := `{"sun_phase":{"sunrise":{"hour":"5","minute":"32"},"sunset":{"hour":"20","minute":"33"}},"moon_phase":{"percentIlluminated":"99"}}`
+	if err := os.WriteFile(filepath.Join(dir, "astronomy.json"), []byte(astronomy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	geolookup := `{"location":{"lat":"39.952","lon":"-75.164","tz_long":"America/New_York"}}`
+	if err := os.WriteFile(filepath.Join(dir, "geolookup.json"), []byte(geolookup), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServer(t, map[string]string{"WEATHER_PROVIDER": "fixtures", "WEATHER_FIXTURES_DIR": dir})
+
+	response := server.get("/weather/sun_phase/v1")
+	if response.Code != 200 {
+		t.Fatalf("status = %d, want 200: %s", response.Code, response.Body)
+	}
+	if attrs := attributes(t, response); attrs["sunrise_h"] != float64(5) || attrs["sunrise_m"] != float64(32) {
+		t.Errorf("sunrise = %v:%v, want the fixture's 5:32", attrs["sunrise_h"], attrs["sunrise_m"])
+	}
+
+	// a feature without a fixture is an upstream error
+	if response := server.get("/weather/tides/v1"); response.Code != 502 {
+		t.Errorf("tides without a fixture: status = %d, want 502", response.Code)
+	}
+}
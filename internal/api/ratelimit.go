@@ -0,0 +1,87 @@
+package api
+
+import (
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/cache"
+)
+
+const rateLimitWindow = 60 // seconds
+
+var rateLimitedCount = expvar.NewMap("http_rate_limited")
+
+// withRateLimit limits each client to the group's requests per minute. Counts
+// live in Redis so replicas share them; the window is approximated by
+// weighting the previous minute's count by how much of it still overlaps. A
+// limit of 0 disables limiting, and Redis errors let the request through.
+func (env *Env) withRateLimit(group string, next http.HandlerFunc) http.HandlerFunc {
+	return func(response http.ResponseWriter, request *http.Request) {
+		// read per request so a reload applies
+		limit := env.rateLimit(group)
+		if limit <= 0 {
+			next(response, request)
+			return
+		}
+
+		now := env.now().Unix()
+		window := now / rateLimitWindow
+		client := rateLimitClient(request)
+		currentKey := env.config().RedisKey("ratelimit", group, client, strconv.FormatInt(window, 10))
+		previousKey := env.config().RedisKey("ratelimit", group, client, strconv.FormatInt(window-1, 10))
+
+		current, err := env.redis.Incr(request.Context(), currentKey).Result()
+		if err != nil {
+			logRequest(request, "Error updating rate limit, allowing request: %s", err)
+			next(response, request)
+			return
+		}
+		if current == 1 {
+			env.redis.Expire(request.Context(), currentKey, 2*rateLimitWindow*time.Second)
+		}
+
+		previous, err := env.redis.Get(request.Context(), previousKey).Int64()
+		if err != nil && !cache.Miss(err) {
+			logRequest(request, "Error reading rate limit, allowing request: %s", err)
+			next(response, request)
+			return
+		}
+
+		elapsed := float64(now%rateLimitWindow) / rateLimitWindow
+		estimate := float64(previous)*(1-elapsed) + float64(current)
+		if estimate > float64(limit) {
+			rateLimitedCount.Add(group, 1)
+			response.Header().Set("Retry-After", strconv.FormatInt(rateLimitWindow-now%rateLimitWindow, 10))
+			makeErrorResponse(response, 429, fmt.Sprintf("rate limit of %d requests per minute exceeded", limit), 0)
+			return
+		}
+
+		next(response, request)
+	}
+}
+
+// rateLimit is the requests per minute RATE_LIMIT_ADMIN or RATE_LIMIT_WEATHER
+// allows the group
+func (env *Env) rateLimit(group string) int {
+	if group == "admin" {
+		return env.config().RateLimitAdmin
+	}
+	return env.config().RateLimitWeather
+}
+
+// rateLimitClient identifies the client a request counts against, preferring
+// its API key over its address
+func rateLimitClient(request *http.Request) string {
+	if keyID := getAPIKeyID(request.Context()); keyID != "" {
+		return "key:" + keyID
+	}
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
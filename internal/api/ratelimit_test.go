@@ -0,0 +1,45 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	server := newTestServer(t, map[string]string{"RATE_LIMIT_WEATHER": "2"})
+	now := time.Date(2024, 6, 20, 15, 0, 0, 0, testTZ)
+	server.env.now = func() time.Time { return now }
+
+	for i := 1; i <= 2; i++ {
+		if response := server.get("/weather/sun_phase/v1"); response.Code != 200 {
+			t.Fatalf("request %d: status = %d, want 200", i, response.Code)
+		}
+	}
+	limited := server.get("/weather/sun_phase/v1")
+	if limited.Code != 429 {
+		t.Fatalf("status over the limit = %d, want 429", limited.Code)
+	}
+	if got := limited.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60 at the start of the minute", got)
+	}
+
+	// a reloaded limit applies to the next request
+	config := *server.env.config()
+	config.RateLimitWeather = 10
+	server.env.settings.Store(&config)
+	if response := server.get("/weather/sun_phase/v1"); response.Code != 200 {
+		t.Errorf("status after raising the limit = %d, want 200", response.Code)
+	}
+
+	// the window follows env.now, two minutes on the old counts are gone
+	lowered := *server.env.config()
+	lowered.RateLimitWeather = 1
+	server.env.settings.Store(&lowered)
+	now = now.Add(2 * time.Minute)
+	if response := server.get("/weather/sun_phase/v1"); response.Code != 200 {
+		t.Errorf("status in a new window = %d, want 200", response.Code)
+	}
+	if response := server.get("/weather/sun_phase/v1"); response.Code != 429 {
+		t.Errorf("status over the lowered limit = %d, want 429", response.Code)
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/cache"
+	"github.com/tyrm/ph-weather/internal/status"
+)
+
+// rawKey holds the latest raw WU response for a feature, location and day
+func (env *Env) rawKey(feature string, location string, day time.Time) string {
+	return env.config().RedisKey("raw", "wu", feature, location, day.Format(dateFormat))
+}
+
+// storeRaw keeps a gzipped copy of a WU response for RAW_PAYLOAD_TTL so a
+// response that fails to parse can be inspected
+func (env *Env) storeRaw(ctx context.Context, feature string, location string, body string) {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write([]byte(body))
+	if err := writer.Close(); err != nil {
+		log.Printf("Error compressing raw %s: %s", feature, err)
+		return
+	}
+
+	key := env.rawKey(feature, location, env.today())
+	if err := env.redis.Set(ctx, key, compressed.String(), env.config().RawPayloadTTL).Err(); err != nil {
+		log.Printf("Error storing raw %s: %s", feature, err)
+	}
+}
+
+// rawParseError reports a WU response that didn't parse along with where
+// its raw payload is kept
+func (env *Env) rawParseError(what string, feature string, location string, err error) error {
+	return status.Errorf(502, "Error parsing %s (raw payload at %s): %s", what, env.rawKey(feature, location, env.today()), err)
+}
+
+// handleRawPayload returns the latest raw WU response for ?feature=,
+// ?location= and ?date=, today's for the default location by default
+func (env *Env) handleRawPayload(response http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+
+	feature := query.Get("feature")
+	if feature == "" {
+		makeErrorResponse(response, 400, "feature is required", 0)
+		return
+	}
+
+	location := Location{Query: env.config().WUndergroundLocation}
+	if value := query.Get("location"); value != "" {
+		var err error
+		location, err = env.lookupLocation(value)
+		if err != nil {
+			makeStatusErrorResponse(response, err)
+			return
+		}
+	}
+
+	day := env.today()
+	if value := query.Get("date"); value != "" {
+		var err error
+		day, err = time.Parse(dateFormat, value)
+		if err != nil {
+			makeErrorResponse(response, 400, "date must be formatted as YYYY-MM-DD", 0)
+			return
+		}
+	}
+
+	key := env.rawKey(feature, location.Query, day)
+	compressed, err := env.redis.Get(request.Context(), key).Result()
+	if cache.Miss(err) {
+		makeErrorResponse(response, 404, "no raw payload stored at "+key, 0)
+		return
+	} else if err != nil {
+		logRequest(request, "Error reading raw payload: %s", err)
+		makeErrorResponse(response, 500, err.Error(), 0)
+		return
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader([]byte(compressed)))
+	if err == nil {
+		var body []byte
+		if body, err = ioutil.ReadAll(reader); err == nil {
+			response.Header().Set("Content-Type", "application/json")
+			response.Write(body)
+			return
+		}
+	}
+	logRequest(request, "Error decompressing raw payload: %s", err)
+	makeErrorResponse(response, 500, err.Error(), 0)
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/jsonapi"
+	"github.com/tyrm/ph-weather/internal/config"
+	"github.com/tyrm/ph-weather/internal/provider"
+)
+
+// startupCheck is the outcome of checking WU_KEY and WU_LOCATION against WU
+type startupCheck struct {
+	sync.RWMutex
+	ran     bool
+	checked time.Time
+	err     error
+}
+
+var startupCheckResult startupCheck
+
+type ReadinessResponse struct {
+	ResponseID        string  `jsonapi:"primary,readiness"`
+	Status            string  `jsonapi:"attr,status"`
+	Redis             string  `jsonapi:"attr,redis"`
+	StartupCheck      string  `jsonapi:"attr,startup_check"`
+	StartupCheckError *string `jsonapi:"attr,startup_check_error"`
+	StartupCheckedAt  *string `jsonapi:"attr,startup_checked_iso"`
+	UpstreamBreaker   string  `jsonapi:"attr,upstream_breaker"`
+	Version           string  `jsonapi:"attr,version"`
+	Commit            string  `jsonapi:"attr,commit"`
+}
+
+// checkUpstream makes one geolookup for the default location, which fails
+// on a bad key or an unknown location, and records the outcome for /readyz
+func (env *Env) checkUpstream(ctx context.Context) error {
+	err := env.wuGeolookupCheck(ctx)
+
+	startupCheckResult.Lock()
+	startupCheckResult.ran, startupCheckResult.checked, startupCheckResult.err = true, time.Now(), err
+	startupCheckResult.Unlock()
+
+	if err != nil {
+		log.Printf("Startup check failed for %s: %s", env.config().WUndergroundLocation, err)
+	} else {
+		log.Printf("Startup check passed for %s", env.config().WUndergroundLocation)
+	}
+	return err
+}
+
+func (env *Env) wuGeolookupCheck(ctx context.Context) error {
+	body, err := env.weather.Fetch(ctx, "geolookup", env.config().WUndergroundLocation)
+	if err != nil {
+		return err
+	}
+
+	// WU reports a bad key or location in band with a 200
+	var geolookup struct {
+		Response struct {
+			Error *struct {
+				Type        string `json:"type"`
+				Description string `json:"description"`
+			} `json:"error"`
+		} `json:"response"`
+		Location *provider.WULocation `json:"location"`
+	}
+	if err := json.Unmarshal([]byte(body), &geolookup); err != nil {
+		return fmt.Errorf("Error parsing geolookup: %s", err)
+	}
+	if upstreamErr := geolookup.Response.Error; upstreamErr != nil {
+		return fmt.Errorf("upstream reported %s: %s", upstreamErr.Type, upstreamErr.Description)
+	}
+	if geolookup.Location == nil {
+		return fmt.Errorf("upstream returned no location")
+	}
+	return nil
+}
+
+// handleReady reports 503 when Redis can't be reached. A failed startup
+// check or an open WU circuit breaker only marks the service degraded,
+// since cached and computed data can still be served.
+func (env *Env) handleReady(response http.ResponseWriter, request *http.Request) {
+	responseObj := &ReadinessResponse{
+		ResponseID:   "readiness",
+		Status:       "ready",
+		Redis:        "ok",
+		StartupCheck: "skipped",
+		Version:      config.Version,
+		Commit:       config.Commit,
+	}
+
+	startupCheckResult.RLock()
+	if startupCheckResult.ran {
+		responseObj.StartupCheckedAt = formatTime(startupCheckResult.checked.UTC())
+		responseObj.StartupCheck = "ok"
+		if startupCheckResult.err != nil {
+			detail := startupCheckResult.err.Error()
+			responseObj.StartupCheck, responseObj.StartupCheckError = "failed", &detail
+			responseObj.Status = "degraded"
+		}
+	}
+	startupCheckResult.RUnlock()
+
+	responseObj.UpstreamBreaker = env.weather.BreakerState()
+	if responseObj.UpstreamBreaker != provider.BreakerClosed {
+		responseObj.Status = "degraded"
+	}
+
+	status := 200
+	if err := env.redis.Ping(request.Context()).Err(); err != nil {
+		logRequest(request, "Readiness check failed to reach Redis: %s", err)
+		responseObj.Redis, responseObj.Status = "unavailable", "unavailable"
+		status = 503
+	}
+
+	var payload bytes.Buffer
+	if err := jsonapi.MarshalPayload(&payload, responseObj); err != nil {
+		logRequest(request, "Error marshaling response: %s", err)
+		makeErrorResponse(response, 500, err.Error(), 0)
+		return
+	}
+	writeDocumentStatus(response, request, status, payload.Bytes())
+}
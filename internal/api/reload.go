@@ -0,0 +1,96 @@
+package api
+
+import (
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/tyrm/ph-weather/internal/config"
+)
+
+// reloadableConfigFields can change on SIGHUP since they are read per
+// request. Everything else is wired up at startup and needs a restart. There
+// is no log level setting to reload, the service always logs the same way.
+// Webhook URLs and offsets are read when a day's webhooks are scheduled, so
+// changes to them apply from the next midnight.
+var reloadableConfigFields = map[string]bool{
+	"AllowLocationOverride": true,
+	"APIKeys":               true,
+	"CORSAllowedOrigins":    true,
+	"DefaultUnits":          true,
+	"HourlyTTL":             true,
+	"HTTPUserAgent":         true,
+	"LocationAllowlist":     true,
+	"ObservationRetention":  true,
+	"RateLimitAdmin":        true,
+	"RateLimitWeather":      true,
+	"RawPayloadTTL":         true,
+	"RequestTimeout":        true,
+	"StaleTTL":              true,
+	"SunPhasePastTTL":       true,
+	"SunPhaseTTL":           true,
+	"WeatherMetricsFetch":   true,
+	"WebhookSunriseOffset":  true,
+	"WebhookSunriseURL":     true,
+	"WebhookSunsetOffset":   true,
+	"WebhookSunsetURL":      true,
+	"WUBundleFeatures":      true,
+	"WUBundleTTL":           true,
+	"WUDailyBudget":         true,
+	"WUDailyBudgetWarn":     true,
+}
+
+// reloadConfig re-reads the config file and environment and applies the
+// reloadable settings that changed. An invalid configuration is logged and
+// the running one kept. Values aren't logged since some are secrets.
+func (env *Env) reloadConfig() {
+	loaded, err := config.Collect()
+	if err != nil {
+		log.Printf("Error reloading configuration, keeping the running one: %s", err)
+		return
+	}
+
+	running := env.config()
+	next := *running
+	var changed, restart []string
+
+	nextValue := reflect.ValueOf(&next).Elem()
+	loadedValue := reflect.ValueOf(loaded)
+	for i := 0; i < nextValue.NumField(); i++ {
+		name := nextValue.Type().Field(i).Name
+		if reflect.DeepEqual(nextValue.Field(i).Interface(), loadedValue.Field(i).Interface()) {
+			continue
+		}
+		if !reloadableConfigFields[name] {
+			restart = append(restart, name)
+			continue
+		}
+		nextValue.Field(i).Set(loadedValue.Field(i))
+		changed = append(changed, name)
+	}
+
+	// webhooks only run when a URL was set at startup
+	if running.WebhookSunriseURL == "" && running.WebhookSunsetURL == "" &&
+		(next.WebhookSunriseURL != "" || next.WebhookSunsetURL != "") {
+		restart = append(restart, "webhooks")
+	}
+
+	for _, name := range changed {
+		if strings.HasPrefix(name, "Webhook") {
+			log.Printf("Webhook changes apply from the next day's schedule, today's webhooks keep their URLs and times")
+			break
+		}
+	}
+
+	if len(restart) > 0 {
+		log.Printf("Warning: configuration changes need a restart to apply: %s", strings.Join(restart, ", "))
+	}
+	if len(changed) == 0 {
+		log.Printf("Reloaded configuration, nothing to apply")
+		return
+	}
+
+	env.settings.Store(&next)
+	env.weather.SetConfig(next)
+	log.Printf("Reloaded configuration, applied changes to %s", strings.Join(changed, ", "))
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReloadConfig(t *testing.T) {
+	server := newTestServer(t, map[string]string{"RATE_LIMIT_WEATHER": "10", "HTTP_PORT": "5000"})
+	logged := captureLog(t)
+
+	t.Setenv("RATE_LIMIT_WEATHER", "20")
+	t.Setenv("STALE_TTL", "1h")
+	t.Setenv("HTTP_PORT", "6000")
+	t.Setenv("REDIS_PREFIX", "other:")
+	server.env.reloadConfig()
+
+	config := server.env.config()
+	// read per request, applied
+	if config.RateLimitWeather != 20 || config.StaleTTL != time.Hour {
+		t.Errorf("RateLimitWeather, StaleTTL = %d, %s, want 20, 1h", config.RateLimitWeather, config.StaleTTL)
+	}
+	if !strings.Contains(logged.String(), "applied changes to RateLimitWeather, StaleTTL") {
+		t.Errorf("applied changes not logged: %s", logged)
+	}
+	// wired up at startup, warned and kept
+	if config.HTTPPort != "5000" || config.RedisPrefix != "ph:" {
+		t.Errorf("HTTPPort, RedisPrefix = %q, %q, want the running 5000, ph:", config.HTTPPort, config.RedisPrefix)
+	}
+	if !strings.Contains(logged.String(), "need a restart to apply: HTTPPort, RedisPrefix") {
+		t.Errorf("restart warning not logged: %s", logged)
+	}
+}
+
+func TestReloadConfigWebhooks(t *testing.T) {
+	t.Run("changed", func(t *testing.T) {
+		server := newTestServer(t, map[string]string{"WEBHOOK_SUNSET_URL": "http://hooks.example/sunset"})
+		logged := captureLog(t)
+
+		t.Setenv("WEBHOOK_SUNSET_OFFSET", "-15m")
+		server.env.reloadConfig()
+
+		if got := server.env.config().WebhookSunsetOffset; got != -15*time.Minute {
+			t.Errorf("WebhookSunsetOffset = %s, want -15m", got)
+		}
+		if !strings.Contains(logged.String(), "next day's schedule") {
+			t.Errorf("not told the change waits for the next day: %s", logged)
+		}
+	})
+
+	t.Run("first URL", func(t *testing.T) {
+		// the scheduler only starts when a URL is set at startup
+		server := newTestServer(t, nil)
+		logged := captureLog(t)
+
+		t.Setenv("WEBHOOK_SUNRISE_URL", "http://hooks.example/sunrise")
+		server.env.reloadConfig()
+
+		if !strings.Contains(logged.String(), "need a restart to apply: webhooks") {
+			t.Errorf("restart warning not logged: %s", logged)
+		}
+	})
+}
+
+func TestReloadConfigInvalid(t *testing.T) {
+	server := newTestServer(t, map[string]string{"RATE_LIMIT_WEATHER": "10"})
+	running := server.env.config()
+	logged := captureLog(t)
+
+	t.Setenv("RATE_LIMIT_WEATHER", "20")
+	t.Setenv("STALE_TTL", "soon")
+	server.env.reloadConfig()
+
+	if server.env.config() != running {
+		t.Error("an invalid configuration replaced the running one")
+	}
+	if !strings.Contains(logged.String(), "keeping the running one") {
+		t.Errorf("invalid configuration not logged: %s", logged)
+	}
+}
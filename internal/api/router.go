@@ -0,0 +1,195 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Router matches exact paths and handles method dispatch for every route, so
+// handlers only see the methods they registered for. A path may end in a
+// single {param} segment, read back with pathParam.
+type Router struct {
+	basePath    string
+	routes      map[string]*Route
+	paramRoutes map[string]*Route
+	notFound    http.HandlerFunc
+}
+
+// Route holds the handlers for one path. Its middleware wraps the whole
+// dispatch, including the generated OPTIONS and 405 responses.
+type Route struct {
+	pattern    string
+	handlers   map[string]http.HandlerFunc
+	middleware func(http.HandlerFunc) http.HandlerFunc
+}
+
+// NewRouter creates a router serving every route under basePath
+func NewRouter(basePath string) *Router {
+	return &Router{
+		basePath:    basePath,
+		routes:      make(map[string]*Route),
+		paramRoutes: make(map[string]*Route),
+		notFound: withRequestID(withRecovery(func(response http.ResponseWriter, request *http.Request) {
+			makeErrorResponse(response, 404, request.URL.Path, 0)
+		})),
+	}
+}
+
+// Route registers a path. Middleware should recover from panics, see
+// withRecovery, and may be nil to only recover.
+func (router *Router) Route(path string, middleware func(http.HandlerFunc) http.HandlerFunc) *Route {
+	route := &Route{pattern: router.basePath + path, handlers: make(map[string]http.HandlerFunc), middleware: middleware}
+	if strings.HasSuffix(path, "}") {
+		router.paramRoutes[router.basePath+path[:strings.LastIndex(path, "/")+1]] = route
+	} else {
+		router.routes[router.basePath+path] = route
+	}
+	return route
+}
+
+func (route *Route) Get(handler http.HandlerFunc) *Route {
+	return route.Method("GET", handler)
+}
+
+func (route *Route) Delete(handler http.HandlerFunc) *Route {
+	return route.Method("DELETE", handler)
+}
+
+func (route *Route) Method(method string, handler http.HandlerFunc) *Route {
+	route.handlers[method] = handler
+	return route
+}
+
+func (router *Router) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	route, ok := router.routes[request.URL.Path]
+	if !ok {
+		split := strings.LastIndex(request.URL.Path, "/") + 1
+		route, ok = router.paramRoutes[request.URL.Path[:split]]
+		if !ok || split == len(request.URL.Path) {
+			router.notFound(response, request)
+			return
+		}
+		ctx := context.WithValue(request.Context(), pathParamKey, request.URL.Path[split:])
+		request = request.WithContext(ctx)
+	}
+	traceRoute(request, route.pattern)
+	request = request.WithContext(context.WithValue(request.Context(), routeKey, route))
+
+	handler := withRecovery(route.dispatch)
+	if route.middleware != nil {
+		handler = route.middleware(route.dispatch)
+	}
+	if route.headFromGet(request.Method) {
+		// wrap outside the middleware so Content-Length counts the body as
+		// GET would send it, compressed or not
+		head := &headResponse{ResponseWriter: response}
+		handler(head, request)
+		head.finish()
+		return
+	}
+	handler(response, request)
+}
+
+// headFromGet reports whether a request answers HEAD with the GET handler
+func (route *Route) headFromGet(method string) bool {
+	if method != "HEAD" {
+		return false
+	}
+	_, head := route.handlers["HEAD"]
+	_, get := route.handlers["GET"]
+	return get && !head
+}
+
+func (route *Route) dispatch(response http.ResponseWriter, request *http.Request) {
+	if handler, ok := route.handlers[request.Method]; ok {
+		handler(response, request)
+		return
+	}
+
+	switch request.Method {
+	case "HEAD":
+		// ServeHTTP has wrapped the response to discard the body
+		if handler, ok := route.handlers["GET"]; ok {
+			handler(response, request)
+			return
+		}
+	case "OPTIONS":
+		response.Header().Set("Allow", route.allow())
+		response.WriteHeader(204)
+		return
+	}
+
+	response.Header().Set("Allow", route.allow())
+	makeErrorResponse(response, 405, request.Method, 0)
+}
+
+func (route *Route) allow() string {
+	methods := []string{"OPTIONS"}
+	for method := range route.handlers {
+		methods = append(methods, method)
+		if method == "GET" {
+			methods = append(methods, "HEAD")
+		}
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+// headResponse runs a GET handler for HEAD, discarding the body but
+// counting it so Content-Length matches what GET would send
+type headResponse struct {
+	http.ResponseWriter
+	status   int
+	length   int
+	streamed bool
+}
+
+func (head *headResponse) WriteHeader(status int) {
+	if head.status == 0 {
+		head.status = status
+	}
+}
+
+func (head *headResponse) Write(b []byte) (int, error) {
+	if head.status == 0 {
+		head.status = 200
+	}
+	head.length += len(b)
+	return len(b), nil
+}
+
+// Flush sends nothing, there is no body, but lets streaming handlers that
+// need an http.Flusher answer HEAD. A flushed response has no length, as GET
+// streams it without one.
+func (head *headResponse) Flush() {
+	head.streamed = true
+}
+
+// finish sends the headers once the whole body has been counted
+func (head *headResponse) finish() {
+	if head.status == 0 {
+		head.status = 200
+	}
+	if head.status != 204 && head.status != 304 && !head.streamed && head.Header().Get("Content-Length") == "" {
+		head.Header().Set("Content-Length", strconv.Itoa(head.length))
+	}
+	head.ResponseWriter.WriteHeader(head.status)
+}
+
+// routeAllow lists the methods the request's route answers, for Allow
+// headers set by middleware
+func routeAllow(request *http.Request) string {
+	if route, ok := request.Context().Value(routeKey).(*Route); ok {
+		return route.allow()
+	}
+	return "GET, HEAD, OPTIONS"
+}
+
+// pathParam returns the {param} segment matched for the request, if any
+func pathParam(request *http.Request) string {
+	param, _ := request.Context().Value(pathParamKey).(string)
+	return param
+}
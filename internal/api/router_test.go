@@ -0,0 +1,137 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestRouterHead(t *testing.T) {
+	router := NewRouter("")
+	router.Route("/document", nil).Get(func(response http.ResponseWriter, request *http.Request) {
+		response.Header().Set("Content-Type", "application/vnd.api+json")
+		io.WriteString(response, `{"data":null}`)
+	})
+	router.Route("/stream", nil).Get(func(response http.ResponseWriter, request *http.Request) {
+		flusher, ok := response.(http.Flusher)
+		if !ok {
+			makeErrorResponse(response, 500, "streaming is not supported", 0)
+			return
+		}
+		response.Header().Set("Content-Type", "text/event-stream")
+		response.WriteHeader(200)
+		flusher.Flush()
+	})
+
+	for _, tc := range []struct {
+		path          string
+		contentType   string
+		contentLength string
+	}{
+		{"/document", "application/vnd.api+json", "13"},
+		{"/stream", "text/event-stream", ""},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest("HEAD", tc.path, nil))
+
+			if recorder.Code != 200 {
+				t.Errorf("status = %d, want 200", recorder.Code)
+			}
+			if recorder.Body.Len() != 0 {
+				t.Errorf("body = %q, want none", recorder.Body.String())
+			}
+			if got := recorder.Header().Get("Content-Type"); got != tc.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tc.contentType)
+			}
+			if got := recorder.Header().Get("Content-Length"); got != tc.contentLength {
+				t.Errorf("Content-Length = %q, want %q", got, tc.contentLength)
+			}
+		})
+	}
+}
+
+// TestHeadMatchesGet checks HEAD on the real routes sends the headers GET
+// would, with the Content-Length of the body as GET sends it
+func TestHeadMatchesGet(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		path   string
+		header []string
+	}{
+		{name: "sun_phase", path: "/weather/sun_phase/v1"},
+		{name: "sun_phase_gzip", path: "/weather/sun_phase/v1", header: []string{"Accept-Encoding", "gzip"}},
+		{name: "range_gzip", path: "/weather/sun_phase/range/v1?start=2024-06-18&end=2024-06-22", header: []string{"Accept-Encoding", "gzip"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, nil)
+			get := server.get(test.path, test.header...)
+			if get.Code != 200 {
+				t.Fatalf("GET status = %d, want 200: %s", get.Code, get.Body)
+			}
+
+			request := httptest.NewRequest("HEAD", test.path, nil)
+			for i := 0; i+1 < len(test.header); i += 2 {
+				request.Header.Set(test.header[i], test.header[i+1])
+			}
+			head := httptest.NewRecorder()
+			server.router.ServeHTTP(head, request)
+
+			if head.Code != 200 {
+				t.Errorf("HEAD status = %d, want 200", head.Code)
+			}
+			if head.Body.Len() != 0 {
+				t.Errorf("HEAD body = %q, want none", head.Body.String())
+			}
+			if got, want := head.Header().Get("Content-Length"), strconv.Itoa(get.Body.Len()); got != want {
+				t.Errorf("HEAD Content-Length = %q, want %q", got, want)
+			}
+			for _, name := range []string{"Content-Type", "Content-Encoding", "ETag", "Cache-Control", "Vary"} {
+				if got, want := head.Header().Get(name), get.Header().Get(name); got != want {
+					t.Errorf("HEAD %s = %q, GET sent %q", name, got, want)
+				}
+			}
+		})
+	}
+
+	t.Run("range_gzip_compressed", func(t *testing.T) {
+		// the case above only means something if the range is compressed
+		server := newTestServer(t, nil)
+		get := server.get("/weather/sun_phase/range/v1?start=2024-06-18&end=2024-06-22", "Accept-Encoding", "gzip")
+		if got := get.Header().Get("Content-Encoding"); got != "gzip" {
+			t.Errorf("Content-Encoding = %q, want gzip", got)
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		server := newTestServer(t, nil)
+		head := httptest.NewRecorder()
+		server.router.ServeHTTP(head, httptest.NewRequest("HEAD", "/weather/stream/v1", nil))
+
+		if head.Code != 200 {
+			t.Errorf("status = %d, want 200", head.Code)
+		}
+		if got := head.Header().Get("Content-Type"); got != "text/event-stream" {
+			t.Errorf("Content-Type = %q, want text/event-stream", got)
+		}
+		if got, ok := head.Header()["Content-Length"]; ok {
+			t.Errorf("Content-Length = %q, a stream has none", got)
+		}
+	})
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	router := NewRouter("")
+	router.Route("/document", nil).Get(func(response http.ResponseWriter, request *http.Request) {})
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("POST", "/document", nil))
+	if recorder.Code != 405 {
+		t.Errorf("status = %d, want 405", recorder.Code)
+	}
+	if got := recorder.Header().Get("Allow"); got != "GET, HEAD, OPTIONS" {
+		t.Errorf("Allow = %q, want GET, HEAD, OPTIONS", got)
+	}
+}
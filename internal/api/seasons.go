@@ -0,0 +1,156 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"time"
+)
+
+const (
+	MarchEquinox     = "march_equinox"
+	JuneSolstice     = "june_solstice"
+	SeptemberEquinox = "september_equinox"
+	DecemberSolstice = "december_solstice"
+)
+
+// seasonEvents are in calendar order, which indexes seasonMeanTerms
+var seasonEvents = []string{MarchEquinox, JuneSolstice, SeptemberEquinox, DecemberSolstice}
+
+// seasonMeanTerms are the polynomial coefficients of the mean equinoxes and
+// solstices for years 2000 to 3000, from Meeus, Astronomical Algorithms ch. 27
+var seasonMeanTerms = [][5]float64{
+	{2451623.80984, 365242.37404, 0.05169, -0.00411, -0.00057},
+	{2451716.56767, 365241.62603, 0.00325, 0.00888, -0.00030},
+	{2451810.21715, 365242.01767, -0.11575, 0.00337, 0.00078},
+	{2451900.05952, 365242.74049, -0.06223, -0.00823, 0.00032},
+}
+
+// seasonPeriodicTerms are Meeus' A, B and C corrections to the mean times
+var seasonPeriodicTerms = [][3]float64{
+	{485, 324.96, 1934.136}, {203, 337.23, 32964.467}, {199, 342.08, 20.186},
+	{182, 27.85, 445267.112}, {156, 73.14, 45036.886}, {136, 171.52, 22518.443},
+	{77, 222.54, 65928.934}, {74, 296.72, 3034.906}, {70, 243.58, 9037.513},
+	{58, 119.81, 33718.147}, {52, 297.17, 150.678}, {50, 21.02, 2281.226},
+	{45, 247.54, 29929.562}, {44, 325.15, 31555.956}, {29, 60.93, 4443.417},
+	{18, 155.12, 67555.328}, {17, 288.79, 4562.452}, {16, 198.04, 62894.029},
+	{14, 199.76, 31436.921}, {12, 95.39, 14577.848}, {12, 287.11, 31931.756},
+	{12, 320.81, 34777.259}, {9, 227.73, 1222.114}, {8, 15.45, 16859.074},
+}
+
+// seasonDeltaT approximates TT - UT for the 2020s, well inside an hour for any
+// year the API is asked about
+const seasonDeltaT = 69 * time.Second
+
+// season names for the northern hemisphere, starting at each event
+var northernSeasons = map[string]string{
+	MarchEquinox:     "spring",
+	JuneSolstice:     "summer",
+	SeptemberEquinox: "autumn",
+	DecemberSolstice: "winter",
+}
+
+var southernSeasons = map[string]string{
+	MarchEquinox:     "autumn",
+	JuneSolstice:     "winter",
+	SeptemberEquinox: "spring",
+	DecemberSolstice: "summer",
+}
+
+type SeasonsResponse struct {
+	ResponseID       string  `jsonapi:"primary,seasons"`
+	Hemisphere       string  `jsonapi:"attr,hemisphere"`
+	Season           string  `jsonapi:"attr,season"`
+	NextEvent        string  `jsonapi:"attr,next_event"`
+	NextEquinox      *string `jsonapi:"attr,next_equinox_iso"`
+	NextSolstice     *string `jsonapi:"attr,next_solstice_iso"`
+	MarchEquinox     *string `jsonapi:"attr,march_equinox_iso"`
+	JuneSolstice     *string `jsonapi:"attr,june_solstice_iso"`
+	SeptemberEquinox *string `jsonapi:"attr,september_equinox_iso"`
+	DecemberSolstice *string `jsonapi:"attr,december_solstice_iso"`
+}
+
+// seasonEvent returns the time of an equinox or solstice, by its index in
+// seasonEvents, to within a minute or so
+func seasonEvent(year int, event int) time.Time {
+	y := float64(year-2000) / 1000
+	terms := seasonMeanTerms[event]
+	jde0 := terms[0] + y*(terms[1]+y*(terms[2]+y*(terms[3]+y*terms[4])))
+
+	t := (jde0 - 2451545) / 36525
+	w := degToRad(35999.373*t - 2.47)
+	deltaLambda := 1 + 0.0334*math.Cos(w) + 0.0007*math.Cos(2*w)
+
+	var s float64
+	for _, term := range seasonPeriodicTerms {
+		s += term[0] * math.Cos(degToRad(term[1]+term[2]*t))
+	}
+	jde := jde0 + 0.00001*s/deltaLambda
+
+	unixSeconds := (jde - 2440587.5) * 86400
+	return time.Unix(0, int64(unixSeconds*float64(time.Second))).Add(-seasonDeltaT).Truncate(time.Second)
+}
+
+// makeSeasonsResponse fills in the events still ahead this year, the next
+// equinox and solstice even when they fall next year, and the current season
+// for the hemisphere of latitude
+func makeSeasonsResponse(id string, latitude float64, now time.Time) (responseObj *SeasonsResponse) {
+	responseObj = &SeasonsResponse{
+		ResponseID: id,
+		Hemisphere: "northern",
+	}
+	seasons := northernSeasons
+	if latitude < 0 {
+		responseObj.Hemisphere = "southern"
+		seasons = southernSeasons
+	}
+
+	thisYear := map[string]**string{
+		MarchEquinox:     &responseObj.MarchEquinox,
+		JuneSolstice:     &responseObj.JuneSolstice,
+		SeptemberEquinox: &responseObj.SeptemberEquinox,
+		DecemberSolstice: &responseObj.DecemberSolstice,
+	}
+
+	// the season before the first event of the year began last December
+	responseObj.Season = seasons[DecemberSolstice]
+	for year := now.Year(); year <= now.Year()+1; year++ {
+		for i, name := range seasonEvents {
+			at := seasonEvent(year, i).In(now.Location())
+			if !at.After(now) {
+				responseObj.Season = seasons[name]
+				continue
+			}
+
+			if responseObj.NextEvent == "" {
+				responseObj.NextEvent = name
+			}
+			if year == now.Year() {
+				*thisYear[name] = formatTime(at)
+			}
+			if i%2 == 0 && responseObj.NextEquinox == nil {
+				responseObj.NextEquinox = formatTime(at)
+			} else if i%2 == 1 && responseObj.NextSolstice == nil {
+				responseObj.NextSolstice = formatTime(at)
+			}
+		}
+	}
+	return
+}
+
+// handleSeasons reports upcoming equinoxes and solstices, computed locally
+func (env *Env) handleSeasons(response http.ResponseWriter, request *http.Request) {
+	location, err := env.requestLocation(request)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	coordinates, err := env.locationCoordinates(request.Context(), location)
+	if err != nil {
+		logRequest(request, "%s", err)
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	writePayload(response, request, makeSeasonsResponse(location.Key(), coordinates.Latitude, env.today()))
+}
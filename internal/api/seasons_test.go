@@ -0,0 +1,73 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+// published equinoxes and solstices in UTC, from the USNO
+var seasonEphemeris = map[int][4]string{
+	2000: {"2000-03-20T07:35:00Z", "2000-06-21T01:48:00Z", "2000-09-22T17:27:00Z", "2000-12-21T13:37:00Z"},
+	2023: {"2023-03-20T21:24:00Z", "2023-06-21T14:58:00Z", "2023-09-23T06:50:00Z", "2023-12-22T03:27:00Z"},
+	2024: {"2024-03-20T03:06:00Z", "2024-06-20T20:51:00Z", "2024-09-22T12:44:00Z", "2024-12-21T09:20:00Z"},
+	2025: {"2025-03-20T09:01:00Z", "2025-06-21T02:42:00Z", "2025-09-22T18:19:00Z", "2025-12-21T15:03:00Z"},
+	2026: {"2026-03-20T14:46:00Z", "2026-06-21T08:24:00Z", "2026-09-23T00:05:00Z", "2026-12-21T20:50:00Z"},
+}
+
+func TestSeasonEvent(t *testing.T) {
+	for year, events := range seasonEphemeris {
+		for i, published := range events {
+			want, _ := time.Parse(time.RFC3339, published)
+			if got := seasonEvent(year, i); got.Sub(want).Abs() > 3*time.Minute {
+				t.Errorf("%s %d = %s, want %s", seasonEvents[i], year, got.UTC().Format(time.RFC3339), published)
+			}
+		}
+	}
+}
+
+func TestSeasonsHemisphere(t *testing.T) {
+	sydney, _ := time.LoadLocation("Australia/Sydney")
+
+	for _, test := range []struct {
+		name       string
+		latitude   float64
+		now        time.Time
+		hemisphere string
+		season     string
+		next       string
+	}{
+		{"Philadelphia in January", 39.952, time.Date(2024, 1, 15, 12, 0, 0, 0, testTZ), "northern", "winter", MarchEquinox},
+		{"Philadelphia before the solstice", 39.952, time.Date(2024, 6, 20, 16, 0, 0, 0, testTZ), "northern", "spring", JuneSolstice},
+		{"Philadelphia after the solstice", 39.952, time.Date(2024, 6, 20, 17, 0, 0, 0, testTZ), "northern", "summer", SeptemberEquinox},
+		{"Philadelphia after the December solstice", 39.952, time.Date(2024, 12, 25, 12, 0, 0, 0, testTZ), "northern", "winter", MarchEquinox},
+		{"Sydney in January", -33.87, time.Date(2024, 1, 15, 12, 0, 0, 0, sydney), "southern", "summer", MarchEquinox},
+		{"Sydney in May", -33.87, time.Date(2024, 5, 1, 12, 0, 0, 0, sydney), "southern", "autumn", JuneSolstice},
+		{"Sydney in July", -33.87, time.Date(2024, 7, 1, 12, 0, 0, 0, sydney), "southern", "winter", SeptemberEquinox},
+		{"Sydney in October", -33.87, time.Date(2024, 10, 1, 12, 0, 0, 0, sydney), "southern", "spring", DecemberSolstice},
+	} {
+		responseObj := makeSeasonsResponse("id", test.latitude, test.now)
+		if responseObj.Hemisphere != test.hemisphere || responseObj.Season != test.season || responseObj.NextEvent != test.next {
+			t.Errorf("%s: %s %s, next %s, want %s %s, next %s", test.name,
+				responseObj.Hemisphere, responseObj.Season, responseObj.NextEvent, test.hemisphere, test.season, test.next)
+		}
+	}
+}
+
+func TestSeasonsResponseYearEnd(t *testing.T) {
+	// after the December solstice nothing is left this year, the next
+	// equinox and solstice are next year's
+	responseObj := makeSeasonsResponse("id", 39.952, time.Date(2024, 12, 25, 12, 0, 0, 0, testTZ))
+	if responseObj.MarchEquinox != nil || responseObj.DecemberSolstice != nil {
+		t.Errorf("events left in 2024: %v, %v", responseObj.MarchEquinox, responseObj.DecemberSolstice)
+	}
+	if responseObj.NextEquinox == nil {
+		t.Fatal("no next equinox")
+	}
+	want, _ := time.Parse(time.RFC3339, seasonEphemeris[2025][0])
+	if got, err := time.Parse(time.RFC3339, *responseObj.NextEquinox); err != nil || got.Sub(want).Abs() > 3*time.Minute {
+		t.Errorf("next equinox = %s, want %s", *responseObj.NextEquinox, want.In(testTZ).Format(time.RFC3339))
+	}
+	if responseObj.NextSolstice == nil || (*responseObj.NextSolstice)[:10] != "2025-06-20" {
+		t.Errorf("next solstice = %v, want 2025-06-20 in Philadelphia", responseObj.NextSolstice)
+	}
+}
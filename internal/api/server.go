@@ -0,0 +1,100 @@
+// Package api serves ph-weather's HTTP API: the weather routes, built from
+// the weather provider's responses and kept in the cache, and the routes
+// reporting on the service itself.
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/cache"
+	"github.com/tyrm/ph-weather/internal/config"
+	"github.com/tyrm/ph-weather/internal/provider"
+)
+
+// Server is the service's HTTP handler, with every route, its middleware and
+// the tracing and HSTS around them
+type Server struct {
+	env     *Env
+	handler http.Handler
+}
+
+// New wires up the API for settings, fetching weather from weather and
+// caching it in store. The Server can be used as it is in httptest, Start
+// does the work a running service needs besides answering requests.
+func New(settings config.Config, weather *provider.Provider, store *cache.Cache) *Server {
+	env := newEnv(settings, weather, store)
+
+	var handler http.Handler = env.newRouter(settings)
+	if settings.HSTS {
+		handler = withHSTS(handler)
+	}
+	return &Server{env: env, handler: withTracing(handler)}
+}
+
+func (server *Server) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	server.handler.ServeHTTP(response, request)
+}
+
+// SetClock replaces time.Now, so tests can fix the time
+func (server *Server) SetClock(now func() time.Time) {
+	server.env.now = now
+}
+
+// Start loads ERROR_CATALOG_FILE, runs the startup check, precomputes sun
+// phases and connects to MQTT as configured, then runs the webhooks and the
+// pre-warmer until ctx ends
+func (server *Server) Start(ctx context.Context) error {
+	env := server.env
+	settings := env.config()
+
+	if settings.ErrorCatalogFile != "" {
+		if err := loadErrorCatalog(settings.ErrorCatalogFile); err != nil {
+			return fmt.Errorf("Failed to load error catalog: %w", err)
+		}
+	}
+
+	if _, err := env.rememberRedisAPIKeys(ctx); err != nil {
+		log.Printf("Error reading API keys from Redis: %s", err)
+	}
+
+	if settings.StartupCheck {
+		if err := env.checkUpstream(ctx); err != nil && settings.StartupCheckFatal {
+			return fmt.Errorf("Startup check failed: %w", err)
+		}
+	}
+
+	if settings.SunPhaseSource == config.SunPhaseSourceComputed {
+		env.sunTable = newSunPhaseTable()
+		env.precomputeSunPhases()
+	}
+
+	if settings.MQTTBroker != "" {
+		var err error
+		env.mqtt, err = newMQTTPublisher(settings, env.configuredLocations())
+		if err != nil {
+			return fmt.Errorf("Invalid MQTT configuration: %w", err)
+		}
+	}
+
+	if settings.WebhookSunriseURL != "" || settings.WebhookSunsetURL != "" {
+		go env.runWebhooks(ctx)
+	}
+	if settings.PrewarmInterval > 0 {
+		go env.runPrewarm(ctx)
+	}
+	return nil
+}
+
+// Reload re-reads the configuration, as on SIGHUP
+func (server *Server) Reload() {
+	server.env.reloadConfig()
+}
+
+// Close disconnects from MQTT, announcing the service offline
+func (server *Server) Close() {
+	server.env.mqtt.Close()
+}
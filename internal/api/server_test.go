@@ -0,0 +1,565 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/tyrm/ph-weather/internal/cache"
+	"github.com/tyrm/ph-weather/internal/config"
+	"github.com/tyrm/ph-weather/internal/provider"
+	"github.com/tyrm/ph-weather/internal/status"
+)
+
+// testTZ and testNow are where and when every handler test runs, a summer
+// afternoon in Philadelphia
+var testTZ, _ = time.LoadLocation("America/New_York")
+var testNow = time.Date(2024, 6, 20, 15, 0, 0, 0, testTZ)
+
+const testWUKey = "wu-test-key-0123"
+
+// fakeWU answers requests meant for api.wunderground.com, with
+// MockWUResponse unless respond is set, and records each call
+type fakeWU struct {
+	sync.Mutex
+	now      time.Time
+	respond  func(feature string, location string) (status int, body string)
+	keys     []string
+	features []string
+}
+
+func (wu *fakeWU) RoundTrip(request *http.Request) (*http.Response, error) {
+	// /api/<key>/<feature>/q/<location>.json, feature may hold slashes
+	path := strings.TrimPrefix(request.URL.Path, "/api/")
+	key, rest, _ := strings.Cut(path, "/")
+	feature, location, _ := strings.Cut(rest, "/q/")
+	location = strings.TrimSuffix(location, ".json")
+
+	wu.Lock()
+	wu.keys = append(wu.keys, key)
+	wu.features = append(wu.features, feature)
+	respond := wu.respond
+	wu.Unlock()
+
+	status, body := 200, ""
+	if respond != nil {
+		status, body = respond(feature, location)
+	} else {
+		mock, err := provider.MockWUResponse(feature, location, wu.now)
+		if err != nil {
+			return nil, err
+		}
+		body = mock
+	}
+
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    request,
+	}, nil
+}
+
+// calls returns how many requests reached WU
+func (wu *fakeWU) calls() int {
+	wu.Lock()
+	defer wu.Unlock()
+	return len(wu.features)
+}
+
+// testServer is the service wired to miniredis, a fake WU and a clock
+// stopped at testNow
+type testServer struct {
+	env    *Env
+	redis  *miniredis.Miniredis
+	wu     *fakeWU
+	router *Router
+}
+
+// newTestServer configures the service from the environment like main does,
+// with settings overriding the test defaults
+func newTestServer(t *testing.T, settings map[string]string) *testServer {
+	t.Helper()
+	mr := miniredis.RunT(t)
+
+	defaults := map[string]string{
+		"REDIS_ADDR":          mr.Addr(),
+		"REDIS_PREFIX":        "ph:",
+		"WU_KEY":              testWUKey,
+		"WU_LOCATION":         "PA/Philadelphia",
+		"LOCATION_TZ":         "America/New_York",
+		"LOCATION_LAT":        "39.952",
+		"LOCATION_LON":        "-75.164",
+		"WU_BREAKER_FAILURES": "0",
+	}
+	for name, value := range settings {
+		defaults[name] = value
+	}
+	for name, value := range defaults {
+		t.Setenv(name, value)
+	}
+
+	config, err := config.Collect()
+	if err != nil {
+		t.Fatalf("config.Collect: %s", err)
+	}
+	// newEnv sets these from the config, put them back for the next test
+	version, trust := jsonapiVersion, trustProxy
+	t.Cleanup(func() { jsonapiVersion, trustProxy = version, trust })
+
+	wu := &fakeWU{now: testNow}
+	client := cache.NewRedisClient(config)
+	weather := provider.New(config, client, http.DefaultTransport)
+	weather.SetClock(func() time.Time { return testNow })
+	weather.SetTransport(wu)
+	env := newEnv(config, weather, cache.New(client, config.L1CacheSize))
+	env.now = func() time.Time { return testNow }
+
+	return &testServer{env: env, redis: mr, wu: wu, router: env.newRouter(config)}
+}
+
+// get sends a GET for target with optional header name, value pairs
+func (server *testServer) get(target string, header ...string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest("GET", target, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		request.Header.Set(header[i], header[i+1])
+	}
+	response := httptest.NewRecorder()
+	server.router.ServeHTTP(response, request)
+	return response
+}
+
+// attributes decodes the attributes of a single resource document
+func attributes(t *testing.T, response *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var document struct {
+		Data struct {
+			Attributes map[string]interface{} `json:"attributes"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &document); err != nil {
+		t.Fatalf("decoding %s: %s", response.Body, err)
+	}
+	return document.Data.Attributes
+}
+
+// resourceID returns the id of a single resource document
+func resourceID(t *testing.T, response *httptest.ResponseRecorder) string {
+	t.Helper()
+	var document struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &document); err != nil {
+		t.Fatalf("decoding %s: %s", response.Body, err)
+	}
+	return document.Data.ID
+}
+
+// jsonapiError decodes the first error of an error document
+func jsonapiError(t *testing.T, response *httptest.ResponseRecorder) (status string, detail string) {
+	t.Helper()
+	var document struct {
+		Errors []struct {
+			Status string `json:"status"`
+			Detail string `json:"detail"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &document); err != nil || len(document.Errors) == 0 {
+		t.Fatalf("decoding errors from %s: %v", response.Body, err)
+	}
+	return document.Errors[0].Status, document.Errors[0].Detail
+}
+
+func TestSunPhaseCacheMiss(t *testing.T) {
+	server := newTestServer(t, nil)
+
+	response := server.get("/weather/sun_phase/v1")
+	if response.Code != 200 {
+		t.Fatalf("status = %d, want 200: %s", response.Code, response.Body)
+	}
+	if got := attributes(t, response)["sunrise_h"]; got != float64(6) {
+		t.Errorf("sunrise_h = %v, want 6", got)
+	}
+	if server.wu.calls() != 1 {
+		t.Errorf("WU called %d times, want 1", server.wu.calls())
+	}
+	if response.Header().Get("ETag") == "" {
+		t.Error("no ETag")
+	}
+
+	key := server.env.sunPhaseCacheKey("PA/Philadelphia", testNow)
+	if !server.redis.Exists(key) {
+		t.Errorf("%s not cached, have %v", key, server.redis.Keys())
+	}
+}
+
+func TestSunPhaseCacheHit(t *testing.T) {
+	// without the L1 cache the second request has to come from Redis
+	server := newTestServer(t, map[string]string{"L1_CACHE_SIZE": "0"})
+
+	first := server.get("/weather/sun_phase/v1")
+	second := server.get("/weather/sun_phase/v1")
+	if second.Code != 200 {
+		t.Fatalf("status = %d, want 200: %s", second.Code, second.Body)
+	}
+	if server.wu.calls() != 1 {
+		t.Errorf("WU called %d times, want 1", server.wu.calls())
+	}
+	if first.Body.String() != second.Body.String() {
+		t.Errorf("cached body differs:\n%s\n%s", first.Body, second.Body)
+	}
+	if first.Header().Get("ETag") != second.Header().Get("ETag") {
+		t.Errorf("ETag changed from %s to %s", first.Header().Get("ETag"), second.Header().Get("ETag"))
+	}
+}
+
+func TestSunPhaseUpstreamError(t *testing.T) {
+	server := newTestServer(t, nil)
+	server.wu.respond = func(feature string, location string) (int, string) {
+		return 500, "oops"
+	}
+
+	response := server.get("/weather/sun_phase/v1")
+	if response.Code != 502 {
+		t.Fatalf("status = %d, want 502: %s", response.Code, response.Body)
+	}
+	if key := server.env.sunPhaseCacheKey("PA/Philadelphia", testNow); server.redis.Exists(key) {
+		t.Errorf("error was cached at %s", key)
+	}
+
+	// the next request tries WU again
+	server.wu.respond = nil
+	if response := server.get("/weather/sun_phase/v1"); response.Code != 200 {
+		t.Errorf("status after recovery = %d, want 200: %s", response.Code, response.Body)
+	}
+}
+
+func TestSunPhaseUpstreamErrorServesStale(t *testing.T) {
+	server := newTestServer(t, nil)
+	server.get("/weather/sun_phase/v1")
+
+	// the fresh copy expires, the stale one outlives it
+	server.env.cache.Delete(context.Background(), server.env.sunPhaseCacheKey("PA/Philadelphia", testNow))
+	server.redis.Del(server.env.cacheKey("wu_astronomy", "PA/Philadelphia", testNow))
+	server.wu.respond = func(feature string, location string) (int, string) {
+		return 503, ""
+	}
+
+	response := server.get("/weather/sun_phase/v1")
+	if response.Code != 200 {
+		t.Fatalf("status = %d, want 200: %s", response.Code, response.Body)
+	}
+	if response.Header().Get("Warning") == "" {
+		t.Error("stale response has no Warning")
+	}
+}
+
+func TestSunPhaseRedisDown(t *testing.T) {
+	server := newTestServer(t, map[string]string{"L1_CACHE_SIZE": "0"})
+	server.redis.Close()
+
+	// Redis errors are logged, the response is built without the cache
+	for i := 1; i <= 2; i++ {
+		response := server.get("/weather/sun_phase/v1")
+		if response.Code != 200 {
+			t.Fatalf("request %d: status = %d, want 200: %s", i, response.Code, response.Body)
+		}
+		if server.wu.calls() != i {
+			t.Errorf("request %d: WU called %d times, want %d", i, server.wu.calls(), i)
+		}
+	}
+}
+
+// blockingWU holds every WU call until its request's context ends, sending
+// the context's error on observed
+func blockingWU(started chan<- struct{}, observed chan<- error) roundTripFunc {
+	return func(request *http.Request) (*http.Response, error) {
+		started <- struct{}{}
+		<-request.Context().Done()
+		observed <- request.Context().Err()
+		return nil, request.Context().Err()
+	}
+}
+
+func TestSunPhaseClientCancelled(t *testing.T) {
+	server := newTestServer(t, nil)
+	started, observed := make(chan struct{}, 1), make(chan error, 1)
+	server.env.weather.SetTransport(blockingWU(started, observed))
+
+	// the client goes away once the WU call is under way
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	request := httptest.NewRequest("GET", "/weather/sun_phase/v1", nil).WithContext(ctx)
+	response := httptest.NewRecorder()
+	server.router.ServeHTTP(response, request)
+
+	select {
+	case err := <-observed:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("WU call saw %v, want context.Canceled", err)
+		}
+	default:
+		t.Fatal("WU call never saw the cancellation")
+	}
+	if response.Code != 499 {
+		t.Errorf("status = %d, want 499: %s", response.Code, response.Body)
+	}
+	if key := server.env.sunPhaseCacheKey("PA/Philadelphia", testNow); server.redis.Exists(key) {
+		t.Errorf("cancelled build was cached at %s", key)
+	}
+}
+
+func TestSunPhaseRequestTimeout(t *testing.T) {
+	server := newTestServer(t, map[string]string{"REQUEST_TIMEOUT": "50ms"})
+	started, observed := make(chan struct{}, 1), make(chan error, 1)
+	server.env.weather.SetTransport(blockingWU(started, observed))
+
+	response := server.get("/weather/sun_phase/v1")
+
+	select {
+	case err := <-observed:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("WU call saw %v, want context.DeadlineExceeded", err)
+		}
+	default:
+		t.Fatal("WU call never saw the deadline")
+	}
+	if response.Code != 504 {
+		t.Errorf("status = %d, want 504: %s", response.Code, response.Body)
+	}
+}
+
+func TestSunPhaseMidnight(t *testing.T) {
+	server := newTestServer(t, map[string]string{"SUN_PHASE_TTL": "6h", "SUN_PHASE_PAST_TTL": "720h"})
+	june20 := time.Date(2024, 6, 20, 0, 0, 0, 0, testTZ)
+	june21 := time.Date(2024, 6, 21, 0, 0, 0, 0, testTZ)
+
+	// a minute to midnight in Philadelphia is already June 21 in UTC
+	now := time.Date(2024, 6, 20, 23, 59, 0, 0, testTZ)
+	server.env.now = func() time.Time { return now }
+	server.wu.now = now
+
+	response := server.get("/weather/sun_phase/v1")
+	if id := resourceID(t, response); id != "sun_phase:2024-06-20:PA/Philadelphia" {
+		t.Errorf("id before midnight = %s, want June 20", id)
+	}
+	key := server.env.sunPhaseCacheKey("PA/Philadelphia", june20)
+	if ttl := server.redis.TTL(key); ttl != 6*time.Hour {
+		t.Errorf("TTL of today's %s = %s, want SUN_PHASE_TTL", key, ttl)
+	}
+
+	// two minutes later it is tomorrow, and yesterday is over
+	now = time.Date(2024, 6, 21, 0, 1, 0, 0, testTZ)
+	server.wu.now = now
+	response = server.get("/weather/sun_phase/v1")
+	if id := resourceID(t, response); id != "sun_phase:2024-06-21:PA/Philadelphia" {
+		t.Errorf("id after midnight = %s, want June 21", id)
+	}
+	if server.wu.calls() != 2 {
+		t.Errorf("WU called %d times, want once for each day", server.wu.calls())
+	}
+	if ttl := server.env.sunPhaseTTL(june20); ttl != 720*time.Hour {
+		t.Errorf("sunPhaseTTL of yesterday = %s, want SUN_PHASE_PAST_TTL", ttl)
+	}
+	if ttl := server.env.sunPhaseTTL(june21); ttl != 6*time.Hour {
+		t.Errorf("sunPhaseTTL of today = %s, want SUN_PHASE_TTL", ttl)
+	}
+}
+
+func TestResourceIDsHideCacheKeys(t *testing.T) {
+	server := newTestServer(t, map[string]string{"ENVIRONMENT": "staging"})
+
+	for path, want := range map[string]string{
+		"/weather/sun_phase/v1":  "sun_phase:2024-06-20:PA/Philadelphia",
+		"/weather/sun_phase/v2":  "sun_phase:2024-06-20:PA/Philadelphia",
+		"/weather/astronomy/v1":  "astronomy:2024-06-20:PA/Philadelphia",
+		"/weather/moon_phase/v2": "moon_phase:2024-06-20:PA/Philadelphia",
+		"/weather/daylight/v1":   "PA/Philadelphia",
+	} {
+		response := server.get(path)
+		if response.Code != 200 {
+			t.Errorf("%s: status = %d: %s", path, response.Code, response.Body)
+			continue
+		}
+		id := resourceID(t, response)
+		if id != want {
+			t.Errorf("%s: id = %q, want %s", path, id, want)
+		}
+		if strings.HasPrefix(id, "ph:") || strings.Contains(id, "staging") || strings.Contains(id, "weather:") {
+			t.Errorf("%s: id %q exposes the cache key", path, id)
+		}
+	}
+}
+
+func TestSunPhaseLegacyIDs(t *testing.T) {
+	server := newTestServer(t, map[string]string{"SUN_PHASE_LEGACY_IDS": "true", "LOCATIONS": "home:PA/Philadelphia"})
+
+	for path, want := range map[string]string{
+		// the default location keeps the id it had before there were locations
+		"/weather/sun_phase/v1":                 "ph:weather:sun_phase:2024-June-20",
+		"/weather/sun_phase/v1?date=2024-06-21": "ph:weather:sun_phase:2024-June-21",
+		"/weather/sun_phase/v1/home":            server.env.sunPhaseCacheKey("home", testNow),
+	} {
+		if id := resourceID(t, server.get(path)); id != want {
+			t.Errorf("%s: id = %q, want %q", path, id, want)
+		}
+	}
+}
+
+func TestSunPhasePartialAstronomy(t *testing.T) {
+	// WU sometimes leaves out sun_phase.sunset while the rest is there
+	server := newTestServer(t, map[string]string{"WEATHER_PROVIDER": "fixtures", "WEATHER_FIXTURES_DIR": "testdata/wu_partial"})
+
+	for _, path := range []string{"/weather/sun_phase/v1", "/weather/sun_phase/v2", "/weather/astronomy/v1"} {
+		response := server.get(path)
+		if response.Code != 502 {
+			t.Errorf("%s: status = %d, want 502: %s", path, response.Code, response.Body)
+			continue
+		}
+		if _, detail := jsonapiError(t, response); detail != "upstream returned incomplete astronomy data" {
+			t.Errorf("%s: detail = %q", path, detail)
+		}
+	}
+	if key := server.env.sunPhaseCacheKey("PA/Philadelphia", testNow); server.redis.Exists(key) {
+		t.Errorf("partial data cached at %s", key)
+	}
+
+	// the moon in the same response is complete and still served
+	response := server.get("/weather/moon_phase/v2")
+	if response.Code != 200 {
+		t.Fatalf("moon_phase: status = %d, want 200: %s", response.Code, response.Body)
+	}
+	if attrs := attributes(t, response); attrs["phase"] != "Waxing Gibbous" {
+		t.Errorf("moon phase = %v, want WU's Waxing Gibbous", attrs["phase"])
+	}
+}
+
+func TestWUMaxBodyBytes(t *testing.T) {
+	server := newTestServer(t, map[string]string{"WU_MAX_BODY_BYTES": "4096"})
+
+	mock, _ := provider.MockWUResponse("astronomy/geolookup", "PA/Philadelphia", testNow)
+	padded := func(size int) string {
+		return mock[:len(mock)-1] + `,"padding":"` + strings.Repeat("x", size-len(mock)-len(`,"padding":""`)) + `"}`
+	}
+
+	// a body of exactly the limit is read
+	server.wu.respond = func(feature string, location string) (int, string) {
+		return 200, padded(4096)
+	}
+	if _, err := server.env.weather.Fetch(context.Background(), "astronomy/geolookup", "PA/Philadelphia"); err != nil {
+		t.Errorf("Fetch with a body of the limit: %s", err)
+	}
+
+	// a byte more is refused
+	server.wu.respond = func(feature string, location string) (int, string) {
+		return 200, padded(4097)
+	}
+	_, err := server.env.weather.Fetch(context.Background(), "astronomy/geolookup", "PA/Philadelphia")
+	if status.Code(err) != 502 || !strings.Contains(fmt.Sprint(err), "exceeds 4096 bytes") {
+		t.Errorf("Fetch with an oversized body = %v, want a 502", err)
+	}
+
+	response := server.get("/weather/sun_phase/v1")
+	if response.Code != 502 {
+		t.Fatalf("status = %d, want 502: %s", response.Code, response.Body)
+	}
+	if _, detail := jsonapiError(t, response); !strings.Contains(detail, "upstream response exceeds 4096 bytes") {
+		t.Errorf("detail = %q", detail)
+	}
+}
+
+// wuAstronomy is a WU astronomy/geolookup response for a location with the
+// given sunrise and sunset, as hh:mm, in timezone tz
+func wuAstronomy(sunrise string, sunset string, lat string, lon string, tz string) string {
+	riseH, riseM, _ := strings.Cut(sunrise, ":")
+	setH, setM, _ := strings.Cut(sunset, ":")
+	return fmt.Sprintf(`{"response": {"version": "0.1"},
+		"location": {"lat": %q, "lon": %q, "tz_long": %q},
+		"sun_phase": {"sunrise": {"hour": %q, "minute": %q}, "sunset": {"hour": %q, "minute": %q}}}`,
+		lat, lon, tz, riseH, riseM, setH, setM)
+}
+
+// roundTripFunc stands in for the network in a test
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+func TestWUKeyRedacted(t *testing.T) {
+	refused := httptest.NewServer(nil)
+	refused.Close()
+
+	for name, transport := range map[string]roundTripFunc{
+		"connection refused": func(request *http.Request) (*http.Response, error) {
+			request.URL.Scheme, request.URL.Host = "http", refused.Listener.Addr().String()
+			return http.DefaultTransport.RoundTrip(request)
+		},
+		"error quoting the URL": func(request *http.Request) (*http.Response, error) {
+			return nil, fmt.Errorf("proxy refused %s", request.URL)
+		},
+		"upstream error status": func(request *http.Request) (*http.Response, error) {
+			body := "invalid key " + testWUKey
+			return &http.Response{StatusCode: 401, Status: "401 Unauthorized", Body: io.NopCloser(strings.NewReader(body)), Request: request}, nil
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server := newTestServer(t, nil)
+			server.env.weather.SetTransport(transport)
+			logged := captureLog(t)
+
+			response := server.get("/weather/sun_phase/v1")
+			if response.Code < 500 {
+				t.Errorf("status = %d, want an upstream error", response.Code)
+			}
+			if strings.Contains(response.Body.String(), testWUKey) {
+				t.Errorf("key in the response: %s", response.Body)
+			}
+			if strings.Contains(logged.String(), testWUKey) {
+				t.Errorf("key in the log:\n%s", logged)
+			}
+			if _, err := server.env.weather.Fetch(context.Background(), "astronomy", "PA/Philadelphia"); err == nil || strings.Contains(err.Error(), testWUKey) {
+				t.Errorf("Fetch error = %v, want one without the key", err)
+			}
+		})
+	}
+}
+
+func TestSunPhaseInvalidUpstreamJSON(t *testing.T) {
+	for name, body := range map[string]string{
+		"not JSON":  "<html>Service Unavailable</html>",
+		"truncated": `{"sun_phase":{"sunrise":{"hour":"5","min`,
+		"empty":     "",
+	} {
+		t.Run(name, func(t *testing.T) {
+			server := newTestServer(t, nil)
+			server.wu.respond = func(feature string, location string) (int, string) {
+				return 200, body
+			}
+
+			// never a zero-value 0:00 sunrise
+			response := server.get("/weather/sun_phase/v1")
+			if response.Code != 502 {
+				t.Fatalf("status = %d, want 502: %s", response.Code, response.Body)
+			}
+			if key := server.env.sunPhaseCacheKey("PA/Philadelphia", testNow); server.redis.Exists(key) {
+				t.Errorf("invalid response cached at %s", key)
+			}
+		})
+	}
+}
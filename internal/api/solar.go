@@ -0,0 +1,169 @@
+package api
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	PolarMidnightSun = "midnight_sun"
+	PolarNight       = "polar_night"
+)
+
+// solarDeclination approximates the sun's declination in degrees for the given day
+func solarDeclination(day time.Time) float64 {
+	return -23.44 * math.Cos(2*math.Pi/365*float64(day.YearDay()+10))
+}
+
+// polarCondition reports whether a day without sunrise or sunset is spent in
+// continuous daylight or continuous darkness. The sun stays up when it is
+// declined towards the same hemisphere as the location.
+func polarCondition(latitude float64, day time.Time) string {
+	if latitude*solarDeclination(day) > 0 {
+		return PolarMidnightSun
+	}
+	return PolarNight
+}
+
+func degToRad(deg float64) float64 { return deg * math.Pi / 180 }
+func radToDeg(rad float64) float64 { return rad * 180 / math.Pi }
+
+// julianCentury returns the Julian centuries since J2000.0 for t
+func julianCentury(t time.Time) float64 {
+	julianDay := float64(t.UnixNano())/float64(24*time.Hour) + 2440587.5
+	return (julianDay - 2451545) / 36525
+}
+
+// solarCoordinates returns the sun's declination in degrees and the equation
+// of time in minutes, following the NOAA solar calculator
+func solarCoordinates(t time.Time) (declination float64, equationOfTime float64) {
+	jc := julianCentury(t)
+
+	meanLongitude := math.Mod(280.46646+jc*(36000.76983+jc*0.0003032), 360)
+	meanAnomaly := 357.52911 + jc*(35999.05029-0.0001537*jc)
+	eccentricity := 0.016708634 - jc*(0.000042037+0.0000001267*jc)
+
+	equationOfCenter := math.Sin(degToRad(meanAnomaly))*(1.914602-jc*(0.004817+0.000014*jc)) +
+		math.Sin(degToRad(2*meanAnomaly))*(0.019993-0.000101*jc) +
+		math.Sin(degToRad(3*meanAnomaly))*0.000289
+	trueLongitude := meanLongitude + equationOfCenter
+	omega := 125.04 - 1934.136*jc
+	apparentLongitude := trueLongitude - 0.00569 - 0.00478*math.Sin(degToRad(omega))
+
+	meanObliquity := 23 + (26+(21.448-jc*(46.815+jc*(0.00059-jc*0.001813)))/60)/60
+	obliquity := meanObliquity + 0.00256*math.Cos(degToRad(omega))
+
+	declination = radToDeg(math.Asin(math.Sin(degToRad(obliquity)) * math.Sin(degToRad(apparentLongitude))))
+
+	y := math.Pow(math.Tan(degToRad(obliquity/2)), 2)
+	l0 := degToRad(meanLongitude)
+	m := degToRad(meanAnomaly)
+	equationOfTime = 4 * radToDeg(y*math.Sin(2*l0)-
+		2*eccentricity*math.Sin(m)+
+		4*eccentricity*y*math.Sin(m)*math.Cos(2*l0)-
+		0.5*y*y*math.Sin(4*l0)-
+		1.25*eccentricity*eccentricity*math.Sin(2*m))
+	return
+}
+
+// sunPosition returns the sun's geometric altitude and its azimuth clockwise
+// from north, both in degrees. Atmospheric refraction is not applied.
+func sunPosition(t time.Time, latitude float64, longitude float64) (altitude float64, azimuth float64) {
+	declination, equationOfTime := solarCoordinates(t)
+
+	utc := t.UTC()
+	minutes := float64(utc.Hour()*60+utc.Minute()) + float64(utc.Second())/60 + float64(utc.Nanosecond())/6e10
+	trueSolarTime := math.Mod(minutes+equationOfTime+4*longitude, 1440)
+	if trueSolarTime < 0 {
+		trueSolarTime += 1440
+	}
+	hourAngle := trueSolarTime/4 - 180
+
+	lat := degToRad(latitude)
+	dec := degToRad(declination)
+	cosZenith := math.Sin(lat)*math.Sin(dec) + math.Cos(lat)*math.Cos(dec)*math.Cos(degToRad(hourAngle))
+	zenith := math.Acos(math.Max(-1, math.Min(1, cosZenith)))
+	altitude = 90 - radToDeg(zenith)
+
+	denominator := math.Cos(lat) * math.Sin(zenith)
+	if math.Abs(denominator) < 1e-9 {
+		// sun at the zenith or observer at a pole, azimuth is undefined
+		return altitude, 180
+	}
+	cosAzimuth := (math.Sin(lat)*math.Cos(zenith) - math.Sin(dec)) / denominator
+	az := radToDeg(math.Acos(math.Max(-1, math.Min(1, cosAzimuth))))
+	if hourAngle > 0 {
+		azimuth = math.Mod(az+180, 360)
+	} else {
+		azimuth = math.Mod(540-az, 360)
+	}
+	return
+}
+
+// sunriseAltitude is the sun's center at sunrise and sunset, accounting for
+// refraction and the radius of the solar disc
+const sunriseAltitude = -0.833
+
+// solarNoon returns when the sun is highest on day at longitude, in day's timezone
+func solarNoon(day time.Time, longitude float64) time.Time {
+	midnight := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	noon := midnight.Add(minutesDuration(720 - 4*longitude))
+	_, equationOfTime := solarCoordinates(noon)
+	return midnight.Add(minutesDuration(720 - 4*longitude - equationOfTime)).In(day.Location())
+}
+
+// hourAngle returns the hour angle in degrees at which the sun crosses
+// altitude around t. When it never does, polar reports whether the sun stays
+// above (PolarMidnightSun) or below (PolarNight) it.
+func hourAngle(t time.Time, latitude float64, altitude float64) (angle float64, polar string) {
+	declination, _ := solarCoordinates(t)
+	lat := degToRad(latitude)
+	dec := degToRad(declination)
+
+	cosAngle := (math.Sin(degToRad(altitude)) - math.Sin(lat)*math.Sin(dec)) / (math.Cos(lat) * math.Cos(dec))
+	if cosAngle > 1 {
+		return 0, PolarNight
+	} else if cosAngle < -1 {
+		return 0, PolarMidnightSun
+	}
+	return radToDeg(math.Acos(cosAngle)), ""
+}
+
+// sunCrossings returns when the sun rises above and sets below altitude on
+// day, in day's timezone. Both are nil when it doesn't cross, with polar set
+// as in hourAngle.
+func sunCrossings(day time.Time, latitude float64, longitude float64, altitude float64) (rise *time.Time, set *time.Time, polar string) {
+	noon := solarNoon(day, longitude)
+
+	angle, polar := hourAngle(noon, latitude, altitude)
+	if polar != "" {
+		return
+	}
+
+	// refine each crossing with the declination at its estimated time
+	riseTime := noon.Add(-minutesDuration(4 * angle))
+	if refined, p := hourAngle(riseTime, latitude, altitude); p == "" {
+		riseTime = noon.Add(-minutesDuration(4 * refined))
+	}
+	setTime := noon.Add(minutesDuration(4 * angle))
+	if refined, p := hourAngle(setTime, latitude, altitude); p == "" {
+		setTime = noon.Add(minutesDuration(4 * refined))
+	}
+
+	return &riseTime, &setTime, ""
+}
+
+// localDayLength returns the computed seconds between sunrise and sunset, or
+// nil when the sun doesn't rise and set on day
+func localDayLength(day time.Time, latitude float64, longitude float64) *int64 {
+	rise, set, _ := sunCrossings(day, latitude, longitude, sunriseAltitude)
+	if rise == nil {
+		return nil
+	}
+	seconds := int64(set.Sub(*rise).Seconds())
+	return &seconds
+}
+
+func minutesDuration(minutes float64) time.Duration {
+	return time.Duration(minutes * float64(time.Minute))
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/config"
+	"github.com/tyrm/ph-weather/internal/provider"
+)
+
+func TestPolarCondition(t *testing.T) {
+	june := time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC)
+	december := time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		name     string
+		latitude float64
+		day      time.Time
+		want     string
+	}{
+		{"Tromsø in June", 69.65, june, PolarMidnightSun},
+		{"Tromsø in December", 69.65, december, PolarNight},
+		{"McMurdo in June", -77.85, june, PolarNight},
+		{"McMurdo in December", -77.85, december, PolarMidnightSun},
+	} {
+		if got := polarCondition(test.latitude, test.day); got != test.want {
+			t.Errorf("%s: polarCondition = %s, want %s", test.name, got, test.want)
+		}
+
+		rise, set, polar := sunCrossings(test.day, test.latitude, 0, sunriseAltitude)
+		if rise != nil || set != nil || polar != test.want {
+			t.Errorf("%s: sunCrossings = %v, %v, %q, want nil, nil, %s", test.name, rise, set, polar, test.want)
+		}
+		if length := localDayLength(test.day, test.latitude, 0); length != nil {
+			t.Errorf("%s: localDayLength = %d, want nil", test.name, *length)
+		}
+	}
+}
+
+func TestPolarConditionNotPolar(t *testing.T) {
+	// Philadelphia has a sunrise and sunset every day of the year
+	for day := time.Date(2024, 1, 1, 0, 0, 0, 0, testTZ); day.Year() == 2024; day = day.AddDate(0, 0, 1) {
+		if rise, set, polar := sunCrossings(day, 39.952, -75.164, sunriseAltitude); rise == nil || set == nil || polar != "" {
+			t.Fatalf("%s: sunCrossings = %v, %v, %q", day.Format(dateFormat), rise, set, polar)
+		}
+	}
+}
+
+func TestSunPhaseResponsePolar(t *testing.T) {
+	blank := &provider.WUTime{Hour: "", Minute: ""}
+	astronomy := provider.WUAstronomy{
+		Location: provider.WULocation{Lat: "69.65", Lon: "18.96", TZLong: "Europe/Oslo"},
+		SunPhase: provider.WUSunPhase{Sunrise: blank, Sunset: blank},
+	}
+	oslo, _ := time.LoadLocation("Europe/Oslo")
+
+	for day, want := range map[time.Time]string{
+		time.Date(2024, 6, 21, 0, 0, 0, 0, oslo):  PolarMidnightSun,
+		time.Date(2024, 12, 21, 0, 0, 0, 0, oslo): PolarNight,
+	} {
+		responseObj, err := makeSunPhaseResponse("tromso", astronomy, day)
+		if err != nil {
+			t.Fatalf("%s: %s", day.Format(dateFormat), err)
+		}
+		if responseObj.PolarCondition != want {
+			t.Errorf("%s: polar_condition = %q, want %s", day.Format(dateFormat), responseObj.PolarCondition, want)
+		}
+		if responseObj.SunriseH != nil || responseObj.SunsetH != nil || responseObj.SolarNoon != nil {
+			t.Errorf("%s: polar day has sunrise %v, sunset %v, solar noon %v", day.Format(dateFormat), responseObj.SunriseH, responseObj.SunsetH, responseObj.SolarNoon)
+		}
+	}
+}
+
+// sunrise and sunset in Philadelphia, from the NOAA solar calculator
+var philadelphiaSunTimes = []struct {
+	day     string
+	sunrise string
+	noon    string
+	sunset  string
+}{
+	{"2024-03-19", "07:06", "13:08", "19:13"},
+	{"2024-06-20", "05:32", "13:02", "20:32"},
+	{"2024-09-22", "06:49", "12:53", "18:57"},
+	{"2024-12-21", "07:19", "11:59", "16:38"},
+}
+
+func TestSunCrossings(t *testing.T) {
+	for _, test := range philadelphiaSunTimes {
+		day, _ := time.ParseInLocation(dateFormat, test.day, testTZ)
+		at := func(clock string) time.Time {
+			parsed, _ := time.ParseInLocation(dateFormat+" 15:04", test.day+" "+clock, testTZ)
+			return parsed
+		}
+
+		rise, set, polar := sunCrossings(day, 39.952, -75.164, sunriseAltitude)
+		if rise == nil || set == nil {
+			t.Fatalf("%s: sunCrossings found no crossing, polar %q", test.day, polar)
+		}
+		if diff := rise.Sub(at(test.sunrise)).Abs(); diff > 2*time.Minute {
+			t.Errorf("%s: sunrise = %s, want %s", test.day, rise.Format("15:04:05"), test.sunrise)
+		}
+		if diff := set.Sub(at(test.sunset)).Abs(); diff > 2*time.Minute {
+			t.Errorf("%s: sunset = %s, want %s", test.day, set.Format("15:04:05"), test.sunset)
+		}
+		if noon := solarNoon(day, -75.164); noon.Sub(at(test.noon)).Abs() > time.Minute {
+			t.Errorf("%s: solar noon = %s, want %s", test.day, noon.Format("15:04:05"), test.noon)
+		}
+		if rise.Location() != testTZ {
+			t.Errorf("%s: sunrise in %s, want the day's zone", test.day, rise.Location())
+		}
+	}
+}
+
+func TestSunPosition(t *testing.T) {
+	// near the June solstice the sun is highest at solar noon, 90 - 39.95 + 23.44 degrees up
+	noon := solarNoon(time.Date(2024, 6, 20, 0, 0, 0, 0, testTZ), -75.164)
+	altitude, azimuth := sunPosition(noon, 39.952, -75.164)
+	if altitude < 73 || altitude > 74 {
+		t.Errorf("altitude at solar noon = %.2f, want about 73.5", altitude)
+	}
+	if azimuth < 178 || azimuth > 182 {
+		t.Errorf("azimuth at solar noon = %.2f, want about 180", azimuth)
+	}
+
+	if altitude, _ := sunPosition(noon.Add(12*time.Hour), 39.952, -75.164); altitude > -20 {
+		t.Errorf("altitude at midnight = %.2f, want well below the horizon", altitude)
+	}
+}
+
+func TestGoldenHour(t *testing.T) {
+	coordinates := config.Coordinates{Latitude: 39.952, Longitude: -75.164}
+	responseObj := makeGoldenHourResponse("philadelphia", coordinates, time.Date(2024, 6, 20, 0, 0, 0, 0, testTZ))
+
+	// the windows follow each other through the day
+	var previous string
+	for _, window := range [][]string{responseObj.MorningBlueHour, responseObj.MorningGoldenHour, responseObj.EveningGoldenHour, responseObj.EveningBlueHour} {
+		if len(window) != 2 {
+			t.Fatalf("window = %v, want a start and end", window)
+		}
+		if window[0] >= window[1] || window[0] < previous {
+			t.Errorf("window %v is out of order after %s", window, previous)
+		}
+		previous = window[1]
+	}
+	if responseObj.MorningGoldenHour[0] != responseObj.MorningBlueHour[1] {
+		t.Errorf("blue hour ends at %s, golden hour starts at %s", responseObj.MorningBlueHour[1], responseObj.MorningGoldenHour[0])
+	}
+	if responseObj.JSONAPIMeta() != nil {
+		t.Errorf("meta = %v on a day with every window", responseObj.JSONAPIMeta())
+	}
+
+	// the sun never drops 4 degrees below the horizon at midsummer in Tromsø
+	tromso := makeGoldenHourResponse("tromso", config.Coordinates{Latitude: 69.65, Longitude: 18.96}, time.Date(2024, 6, 20, 0, 0, 0, 0, time.UTC))
+	if tromso.MorningBlueHour != nil || tromso.EveningGoldenHour != nil {
+		t.Errorf("Tromsø has windows %v and %v at midsummer", tromso.MorningBlueHour, tromso.EveningGoldenHour)
+	}
+	if meta := tromso.JSONAPIMeta(); meta == nil || (*meta)["polar"] != true {
+		t.Errorf("Tromsø meta = %v, want polar", meta)
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/jsonapi"
+	"github.com/tyrm/ph-weather/internal/cache"
+	"github.com/tyrm/ph-weather/internal/config"
+)
+
+const (
+	DayPhaseDay      = "day"
+	DayPhaseNight    = "night"
+	DayPhaseTwilight = "twilight"
+)
+
+// withSunPhaseNow adds is_daytime and phase to today's cached v1 sun phase,
+// comparing now in the resource's timezone against its sunrise and sunset.
+// The entry is cached without them since they change during the day.
+func withSunPhaseNow(cacheEntry *cache.Entry, now time.Time, coordinates *config.Coordinates) (converted *cache.Entry, resError error) {
+	var payload jsonapi.OnePayload
+	if err := json.Unmarshal([]byte(cacheEntry.Body), &payload); err != nil {
+		resError = err
+		return
+	}
+	if payload.Data == nil {
+		return cacheEntry, nil
+	}
+
+	attributes := payload.Data.Attributes
+	if name, ok := attributes["timezone"].(string); ok && name != "" {
+		if tz, err := time.LoadLocation(name); err == nil {
+			now = now.In(tz)
+		}
+	}
+
+	phase := dayPhase(now, attributes, coordinates)
+	attributes["is_daytime"] = phase == DayPhaseDay
+	attributes["phase"] = phase
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		resError = err
+		return
+	}
+
+	converted = &cache.Entry{ETag: cache.MakeETag(string(body)), Body: string(body), Stale: cacheEntry.Stale}
+	return
+}
+
+// dayPhase is day between sunrise and sunset, or all day under the midnight
+// sun. Otherwise it is twilight while the sun is less than 6° below the
+// horizon, which polar nights can have around noon, and night after that.
+// Without coordinates twilight can't be told from night.
+func dayPhase(now time.Time, attributes map[string]interface{}, coordinates *config.Coordinates) string {
+	if attributes["polar_condition"] == PolarMidnightSun {
+		return DayPhaseDay
+	}
+
+	sunrise, riseOK := sunPhaseClock(now, attributes, "sunrise")
+	sunset, setOK := sunPhaseClock(now, attributes, "sunset")
+	if riseOK && setOK && !now.Before(sunrise) && now.Before(sunset) {
+		return DayPhaseDay
+	}
+
+	if coordinates != nil {
+		if altitude, _ := sunPosition(now, coordinates.Latitude, coordinates.Longitude); altitude >= civilTwilightAltitude {
+			return DayPhaseTwilight
+		}
+	}
+	return DayPhaseNight
+}
+
+// sunPhaseClock reads a v1 hour and minute pair, such as sunrise_h and
+// sunrise_m, as a time on now's day
+func sunPhaseClock(now time.Time, attributes map[string]interface{}, name string) (at time.Time, ok bool) {
+	hour, hourOK := attributes[name+"_h"].(float64)
+	minute, minuteOK := attributes[name+"_m"].(float64)
+	if !hourOK || !minuteOK {
+		return
+	}
+	return time.Date(now.Year(), now.Month(), now.Day(), int(hour), int(minute), 0, 0, now.Location()), true
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/cache"
+	"github.com/tyrm/ph-weather/internal/config"
+)
+
+func TestWithSunPhaseNow(t *testing.T) {
+	philadelphia := &config.Coordinates{Latitude: 39.952, Longitude: -75.164}
+	evening := time.Date(2024, 6, 20, 20, 50, 0, 0, testTZ)
+
+	for _, test := range []struct {
+		name        string
+		now         time.Time
+		attributes  string
+		coordinates *config.Coordinates
+		phase       string
+	}{
+		{name: "day", now: testNow, coordinates: philadelphia, phase: DayPhaseDay},
+		{name: "at sunrise", now: time.Date(2024, 6, 20, 5, 32, 0, 0, testTZ), phase: DayPhaseDay},
+		{name: "at sunset", now: time.Date(2024, 6, 20, 20, 32, 0, 0, testTZ), coordinates: philadelphia, phase: DayPhaseTwilight},
+		{name: "twilight", now: evening, coordinates: philadelphia, phase: DayPhaseTwilight},
+		{name: "twilight without coordinates", now: evening, phase: DayPhaseNight},
+		{name: "night", now: time.Date(2024, 6, 20, 23, 0, 0, 0, testTZ), coordinates: philadelphia, phase: DayPhaseNight},
+		// 00:10 UTC is still 20:10 in Philadelphia
+		{name: "resource timezone", now: time.Date(2024, 6, 21, 0, 10, 0, 0, time.UTC), phase: DayPhaseDay},
+		{name: "midnight sun", now: time.Date(2024, 6, 20, 2, 0, 0, 0, testTZ), attributes: `"polar_condition": "midnight_sun"`, phase: DayPhaseDay},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			attributes := `"sunrise_h": 5, "sunrise_m": 32, "sunset_h": 20, "sunset_m": 32, "timezone": "America/New_York"`
+			if test.attributes != "" {
+				attributes = test.attributes
+			}
+			entry := &cache.Entry{ETag: "cached", Body: `{"data": {"type": "sun_phase", "id": "home", "attributes": {` + attributes + `}}}`}
+
+			converted, err := withSunPhaseNow(entry, test.now, test.coordinates)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var document struct {
+				Data struct {
+					Attributes map[string]interface{} `json:"attributes"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal([]byte(converted.Body), &document); err != nil {
+				t.Fatal(err)
+			}
+			if got := document.Data.Attributes["phase"]; got != test.phase {
+				t.Errorf("phase = %v, want %s", got, test.phase)
+			}
+			if got := document.Data.Attributes["is_daytime"]; got != (test.phase == DayPhaseDay) {
+				t.Errorf("is_daytime = %v with phase %s", got, test.phase)
+			}
+			if converted.ETag == entry.ETag {
+				t.Error("ETag unchanged by the added attributes")
+			}
+		})
+	}
+}
+
+func TestSunPhaseNowAttributes(t *testing.T) {
+	server := newTestServer(t, nil)
+
+	// testNow is 15:00, between the mock's 6:30 sunrise and 18:45 sunset
+	attrs := attributes(t, server.get("/weather/sun_phase/v1"))
+	if attrs["phase"] != DayPhaseDay || attrs["is_daytime"] != true {
+		t.Errorf("phase, is_daytime = %v, %v, want day, true", attrs["phase"], attrs["is_daytime"])
+	}
+
+	// only today has a now to compare against
+	attrs = attributes(t, server.get("/weather/sun_phase/v1?date=2024-06-21"))
+	if _, ok := attrs["phase"]; ok {
+		t.Errorf("tomorrow has a phase: %v", attrs["phase"])
+	}
+}
+
+func TestSunPhaseNowGeolookupFailureCached(t *testing.T) {
+	server := newTestServer(t, map[string]string{"LOCATIONS": "cabin:VT/Cabin"})
+	server.wu.respond = func(feature string, location string) (int, string) {
+		if feature == "geolookup" {
+			return 500, ""
+		}
+		// astronomy without a location, so the sun phase has no coordinates
+		return 200, wuAstronomy("5:20", "20:40", "", "", "America/New_York")
+	}
+
+	for i := 0; i < 3; i++ {
+		response := server.get("/weather/sun_phase/v1/cabin")
+		if response.Code != 200 {
+			t.Fatalf("status = %d, want 200: %s", response.Code, response.Body)
+		}
+		if attrs := attributes(t, response); attrs["phase"] != DayPhaseDay {
+			t.Errorf("phase = %v, want day", attrs["phase"])
+		}
+	}
+
+	geolookups := 0
+	for _, feature := range server.wu.features {
+		if feature == "geolookup" {
+			geolookups++
+		}
+	}
+	if geolookups != 1 {
+		t.Errorf("geolookup called %d times, want once while its failure is cached", geolookups)
+	}
+}
+
+func TestSunPhaseNowReusesCoordinates(t *testing.T) {
+	server := newTestServer(t, map[string]string{"LOCATIONS": "cabin:VT/Cabin"})
+	server.wu.respond = func(feature string, location string) (int, string) {
+		return 200, wuAstronomy("5:20", "20:40", "44.26", "-72.58", "America/New_York")
+	}
+
+	for i := 0; i < 3; i++ {
+		server.get("/weather/sun_phase/v1/cabin")
+	}
+	// the astronomy call's location serves every request's coordinates
+	if server.wu.calls() != 1 {
+		t.Errorf("WU called %d times (%v), want only the astronomy", server.wu.calls(), server.wu.features)
+	}
+}
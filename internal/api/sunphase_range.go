@@ -0,0 +1,60 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/jsonapi"
+)
+
+// maxRangeDays bounds how many days one sun phase range request covers
+const maxRangeDays = 14
+
+// handleSunPhaseRange serves the v1 sun phase for each day from ?start= to
+// ?end=, inclusive. Each day is fetched and cached as it would be on its
+// own; days that fail are listed in meta.errors instead of failing the range.
+func (env *Env) handleSunPhaseRange(response http.ResponseWriter, request *http.Request) {
+	location, err := env.requestLocation(request)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	query := request.URL.Query()
+	if query.Get("start") == "" || query.Get("end") == "" {
+		makeErrorResponse(response, 400, "start and end are required", 0)
+		return
+	}
+	start, err := env.parseDate("start", query.Get("start"))
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+	end, err := env.parseDate("end", query.Get("end"))
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	var days []time.Time
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		if len(days) == maxRangeDays {
+			makeErrorResponse(response, 400, fmt.Sprintf("at most %d days may be requested", maxRangeDays), 0)
+			return
+		}
+		days = append(days, day)
+	}
+	if len(days) == 0 {
+		makeErrorResponse(response, 400, "end must not be before start", 0)
+		return
+	}
+
+	dates := make([]string, len(days))
+	for i, day := range days {
+		dates[i] = day.Format(dateFormat)
+	}
+	env.writeSunPhaseCollection(response, request, "range", dates, func(i int) (*jsonapi.Node, error) {
+		return env.sunPhaseNode(request, location, days[i])
+	})
+}
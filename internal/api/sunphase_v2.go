@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/config"
+)
+
+type SunPhaseV2Response struct {
+	ResponseID            string  `jsonapi:"primary,sun_phase"`
+	Date                  string  `jsonapi:"attr,date"`
+	Sunrise               *string `jsonapi:"attr,sunrise"`
+	Sunset                *string `jsonapi:"attr,sunset"`
+	SolarNoon             *string `jsonapi:"attr,solar_noon"`
+	DayLengthSeconds      *int64  `jsonapi:"attr,day_length_seconds"`
+	DayLengthDeltaSeconds *int64  `jsonapi:"attr,day_length_delta_seconds"`
+	PolarCondition        string  `jsonapi:"attr,polar_condition"`
+	Timezone              string  `jsonapi:"attr,timezone"`
+	CivilDawn             *string `jsonapi:"attr,civil_dawn"`
+	CivilDusk             *string `jsonapi:"attr,civil_dusk"`
+	CivilReason           string  `jsonapi:"attr,civil_reason"`
+	NauticalDawn          *string `jsonapi:"attr,nautical_dawn"`
+	NauticalDusk          *string `jsonapi:"attr,nautical_dusk"`
+	NauticalReason        string  `jsonapi:"attr,nautical_reason"`
+	AstronomicalDawn      *string `jsonapi:"attr,astronomical_dawn"`
+	AstronomicalDusk      *string `jsonapi:"attr,astronomical_dusk"`
+	AstronomicalReason    string  `jsonapi:"attr,astronomical_reason"`
+}
+
+// handleSunPhaseV2 serves the sun phase with full timestamps in the
+// location's timezone, or ?tz=. The change in day length versus the previous day is
+// computed locally from the location's coordinates.
+func (env *Env) handleSunPhaseV2(response http.ResponseWriter, request *http.Request) {
+	location, err := env.requestLocation(request)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	day, err := env.requestDate(request)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+	tz, err := env.requestTimezone(request)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+	cacheKey := env.cacheKey("sun_phase_v2", location.Key(), day)
+
+	cacheEntry, err := env.getOrBuildCache(request, cacheKey, env.sunPhaseTTL(day), func(ctx context.Context) (interface{}, error) {
+		v1, coordinates, err := env.fetchSunPhase(ctx, location, day)
+		if err != nil {
+			return nil, err
+		}
+		return makeSunPhaseV2Response(v1, coordinates, day), nil
+	})
+	if err != nil {
+		logRequest(request, "%s", err)
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	cacheEntry, err = inTimezone(cacheEntry, tz, append([]string{"sunrise", "sunset", "solar_noon"}, twilightFields...)...)
+	if err != nil {
+		logRequest(request, "Error converting timezone: %s", err)
+		makeErrorResponse(response, 500, err.Error(), 0)
+		return
+	}
+
+	writeCacheEntry(response, request, cacheEntry)
+}
+
+func makeSunPhaseV2Response(v1 *SunPhaseRespose, coordinates *config.Coordinates, day time.Time) (responseObj *SunPhaseV2Response) {
+	date := day.Format(dateFormat)
+	responseObj = &SunPhaseV2Response{ResponseID: v1.ResponseID, Date: date, PolarCondition: v1.PolarCondition, Timezone: v1.Timezone}
+
+	if v1.PolarCondition == "" {
+		// the hours are in the location's timezone, not LOCATION_TZ
+		tz := sunPhaseTZ(day, v1.Timezone)
+		sunrise, sunset := sunPhaseTimes(day, tz, *v1.SunriseH, *v1.SunriseM, *v1.SunsetH, *v1.SunsetM)
+		dayLength := int64(sunset.Sub(sunrise).Seconds())
+
+		responseObj.Sunrise = formatTime(sunrise)
+		responseObj.Sunset = formatTime(sunset)
+		responseObj.SolarNoon = v1.SolarNoon
+		responseObj.DayLengthSeconds = &dayLength
+	}
+
+	// the delta compares two computed days so rounding to the minute doesn't
+	// swamp the daily change
+	if coordinates != nil {
+		dayLength := localDayLength(day, coordinates.Latitude, coordinates.Longitude)
+		previousLength := localDayLength(day.AddDate(0, 0, -1), coordinates.Latitude, coordinates.Longitude)
+		if dayLength != nil && previousLength != nil {
+			delta := *dayLength - *previousLength
+			responseObj.DayLengthDeltaSeconds = &delta
+		}
+	}
+
+	twilight := makeTwilightResponse(v1.ResponseID, coordinates, day)
+	responseObj.CivilDawn, responseObj.CivilDusk, responseObj.CivilReason = twilight.CivilDawn, twilight.CivilDusk, twilight.CivilReason
+	responseObj.NauticalDawn, responseObj.NauticalDusk, responseObj.NauticalReason = twilight.NauticalDawn, twilight.NauticalDusk, twilight.NauticalReason
+	responseObj.AstronomicalDawn, responseObj.AstronomicalDusk, responseObj.AstronomicalReason = twilight.AstronomicalDawn, twilight.AstronomicalDusk, twilight.AstronomicalReason
+
+	return
+}
+
+func formatTime(t time.Time) *string {
+	formatted := t.Format(time.RFC3339)
+	return &formatted
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tyrm/ph-weather/internal/provider"
+)
+
+// reykjavikWU answers astronomy for IS/Reykjavik around the June solstice,
+// when the sun sets after local midnight
+func reykjavikWU(feature string, location string) (int, string) {
+	if location == "IS/Reykjavik" && strings.HasPrefix(feature, "astronomy") {
+		return 200, wuAstronomy("2:55", "0:04", "64.146", "-21.942", "Atlantic/Reykjavik")
+	}
+	body, _ := provider.MockWUResponse(feature, location, testNow)
+	return 200, body
+}
+
+func TestSunPhaseV2LocationTimezone(t *testing.T) {
+	server := newTestServer(t, map[string]string{"LOCATIONS": "reykjavik:IS/Reykjavik"})
+	server.wu.respond = reykjavikWU
+
+	response := server.get("/weather/sun_phase/v2/reykjavik")
+	if response.Code != 200 {
+		t.Fatalf("status = %d, want 200: %s", response.Code, response.Body)
+	}
+	attrs := attributes(t, response)
+	for name, want := range map[string]interface{}{
+		"sunrise":            "2024-06-20T02:55:00Z",
+		"sunset":             "2024-06-21T00:04:00Z",
+		"day_length_seconds": float64(21*3600 + 9*60),
+	} {
+		if attrs[name] != want {
+			t.Errorf("%s = %v, want %v", name, attrs[name], want)
+		}
+	}
+
+	if got := attrs["timezone"]; got != "Atlantic/Reykjavik" {
+		t.Errorf("timezone = %v, want Atlantic/Reykjavik", got)
+	}
+	converted := attributes(t, server.get("/weather/sun_phase/v2/reykjavik?tz=America/New_York"))
+	if converted["sunset"] != "2024-06-20T20:04:00-04:00" {
+		t.Errorf("sunset with tz = %v, want 2024-06-20T20:04:00-04:00", converted["sunset"])
+	}
+
+	// the default location keeps LOCATION_TZ
+	attrs = attributes(t, server.get("/weather/sun_phase/v2"))
+	if attrs["sunrise"] != "2024-06-20T06:30:00-04:00" || attrs["sunset"] != "2024-06-20T18:45:00-04:00" {
+		t.Errorf("default location sunrise, sunset = %v, %v", attrs["sunrise"], attrs["sunset"])
+	}
+}
+
+func TestSunPhaseSolarNoonLateSunset(t *testing.T) {
+	server := newTestServer(t, map[string]string{"LOCATIONS": "reykjavik:IS/Reykjavik"})
+	server.wu.respond = reykjavikWU
+
+	// midway between 02:55 and 00:04 the next day
+	for _, path := range []string{"/weather/sun_phase/v1/reykjavik", "/weather/sun_phase/v2/reykjavik"} {
+		attrs := attributes(t, server.get(path))
+		name := "solar_noon"
+		if strings.Contains(path, "/v1/") {
+			name = "solar_noon_iso"
+		}
+		if attrs[name] != "2024-06-20T13:29:30Z" {
+			t.Errorf("%s: %s = %v, want 2024-06-20T13:29:30Z", path, name, attrs[name])
+		}
+	}
+}
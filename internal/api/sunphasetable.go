@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/config"
+)
+
+// sunPhaseTable holds whole years of computed sun phases for the configured
+// locations, so SUN_PHASE_SOURCE=computed serves sun phase without WU. A nil
+// sunPhaseTable stores nothing and computes every lookup.
+type sunPhaseTable struct {
+	mu    sync.RWMutex
+	years map[string][]SunPhaseRespose // by location key and year, indexed by day of the year
+}
+
+func newSunPhaseTable() *sunPhaseTable {
+	return &sunPhaseTable{years: make(map[string][]SunPhaseRespose)}
+}
+
+// lookup returns a copy of the sun phase for a location on day, computing
+// and storing day's whole year the first time it is asked for
+func (table *sunPhaseTable) lookup(location string, coordinates config.Coordinates, day time.Time) *SunPhaseRespose {
+	if table == nil {
+		return makeComputedSunPhaseResponse(dayResourceID("sun_phase", location, day), coordinates, day)
+	}
+
+	key := location + ":" + strconv.Itoa(day.Year())
+	table.mu.RLock()
+	year, ok := table.years[key]
+	table.mu.RUnlock()
+
+	if !ok {
+		year = computeSunPhaseYear(location, coordinates, day.Year(), day.Location())
+		table.mu.Lock()
+		table.years[key] = year
+		table.mu.Unlock()
+	}
+
+	responseObj := year[day.YearDay()-1]
+	return &responseObj
+}
+
+// computeSunPhaseYear computes the sun phase for every day of year in tz
+func computeSunPhaseYear(location string, coordinates config.Coordinates, year int, tz *time.Location) (days []SunPhaseRespose) {
+	for day := time.Date(year, time.January, 1, 0, 0, 0, 0, tz); day.Year() == year; day = day.AddDate(0, 0, 1) {
+		days = append(days, *makeComputedSunPhaseResponse(dayResourceID("sun_phase", location, day), coordinates, day))
+	}
+	return
+}
+
+// computedSunPhase serves a configured location's sun phase from the table,
+// other locations are computed on each call so overrides can't grow it
+func (env *Env) computedSunPhase(location Location, coordinates config.Coordinates, day time.Time) *SunPhaseRespose {
+	if !env.isConfiguredLocation(location) {
+		return makeComputedSunPhaseResponse(dayResourceID("sun_phase", location.Key(), day), coordinates, day)
+	}
+	return env.sunTable.lookup(location.Key(), coordinates, day)
+}
+
+// isConfiguredLocation reports whether location is the default or a named one
+func (env *Env) isConfiguredLocation(location Location) bool {
+	if location.Name != "" {
+		_, ok := env.config().Locations[location.Name]
+		return ok
+	}
+	return location.Query == env.config().WUndergroundLocation
+}
+
+// precomputeSunPhases fills the table with this year for every configured
+// location. Locations whose coordinates can't be found are logged and
+// computed on demand once they can.
+func (env *Env) precomputeSunPhases() {
+	today := env.today()
+	precomputed := 0
+	for _, location := range env.configuredLocations() {
+		coordinates, err := env.locationCoordinates(context.Background(), location)
+		if err != nil {
+			log.Printf("Error precomputing sun phase for %s: %s", location.Key(), err)
+			continue
+		}
+		env.sunTable.lookup(location.Key(), *coordinates, today)
+		precomputed++
+	}
+	log.Printf("Precomputed %d sun phases for %d locations", today.Year(), precomputed)
+}
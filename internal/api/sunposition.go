@@ -0,0 +1,78 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/config"
+)
+
+// SunPositionResponse angles are in degrees. Altitude duplicates elevation
+// for clients of the original response.
+type SunPositionResponse struct {
+	ResponseID string  `jsonapi:"primary,sun_position"`
+	Time       string  `jsonapi:"attr,time_iso"`
+	Latitude   float64 `jsonapi:"attr,latitude"`
+	Longitude  float64 `jsonapi:"attr,longitude"`
+	Elevation  float64 `jsonapi:"attr,elevation"`
+	Altitude   float64 `jsonapi:"attr,altitude"`
+	Azimuth    float64 `jsonapi:"attr,azimuth"`
+	SunUp      bool    `jsonapi:"attr,sun_up"`
+}
+
+// handleSunPosition computes where the sun is at ?at= (or ?time=), defaulting
+// to now, for ?lat=&lon= or the configured LOCATION_LAT/LOCATION_LON. It
+// doesn't use WU so nothing is cached.
+func (env *Env) handleSunPosition(response http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+
+	coordinates := env.config().LocationCoordinates
+	if query.Get("lat") != "" || queryLongitude(query) != "" || coordinates == nil {
+		latitude, err := config.ParseCoordinate(query.Get("lat"), 90)
+		if err != nil {
+			makeErrorResponse(response, 400, fmt.Sprintf("lat %s", err), 0)
+			return
+		}
+		longitude, err := config.ParseCoordinate(queryLongitude(query), 180)
+		if err != nil {
+			makeErrorResponse(response, 400, fmt.Sprintf("lon %s", err), 0)
+			return
+		}
+		coordinates = &config.Coordinates{Latitude: latitude, Longitude: longitude}
+	}
+
+	at := env.now()
+	queryTime := query.Get("at")
+	if queryTime == "" {
+		queryTime = query.Get("time")
+	}
+	if queryTime != "" {
+		var err error
+		at, err = time.Parse(time.RFC3339, queryTime)
+		if err != nil {
+			makeErrorResponse(response, 400, "at must be formatted as RFC3339", 0)
+			return
+		}
+	}
+
+	elevation, azimuth := sunPosition(at, coordinates.Latitude, coordinates.Longitude)
+	responseObj := &SunPositionResponse{
+		ResponseID: fmt.Sprintf("%g,%g@%d", coordinates.Latitude, coordinates.Longitude, at.Unix()),
+		Time:       at.Format(time.RFC3339),
+		Latitude:   coordinates.Latitude,
+		Longitude:  coordinates.Longitude,
+		Elevation:  roundTo(elevation, 1),
+		Altitude:   roundTo(elevation, 1),
+		Azimuth:    roundTo(azimuth, 1),
+		SunUp:      elevation > sunriseAltitude,
+	}
+
+	writePayload(response, request, responseObj)
+}
+
+func roundTo(value float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(value*scale) / scale
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/provider"
+	"github.com/tyrm/ph-weather/internal/status"
+)
+
+// tidesTTL is how long a day's tide predictions are cached
+var tidesTTL time.Duration = time.Duration(12) * time.Hour
+
+type TidesResponse struct {
+	ResponseID string  `jsonapi:"primary,tide"`
+	Type       string  `jsonapi:"attr,type"`
+	Time       string  `jsonapi:"attr,time_iso"`
+	Height     float64 `jsonapi:"attr,height"`
+	HeightUnit string  `jsonapi:"attr,height_unit"`
+}
+
+func (env *Env) handleTides(response http.ResponseWriter, request *http.Request) {
+	location, err := env.requestLocation(request)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	today := env.today()
+
+	// inland locations have no tides, remembered so WU isn't asked again
+	exists, err := env.redis.Exists(request.Context(), env.noTidesKey(location, today)).Result()
+	if err != nil {
+		logRequest(request, "Error reading cache: %s", err)
+	} else if exists > 0 {
+		makeStatusErrorResponse(response, noTidesError(location))
+		return
+	}
+
+	env.serveCached(response, request, env.cacheKey("tides", location.Key(), today), tidesTTL, env.buildTides(location, today))
+}
+
+// noTidesKey marks a location WU has no tide data for on day
+func (env *Env) noTidesKey(location Location, day time.Time) string {
+	return env.cacheKey("no_tides", location.Key(), day)
+}
+
+func noTidesError(location Location) error {
+	return status.Errorf(404, "no tide data is available for %s", location.Key())
+}
+
+func (env *Env) buildTides(location Location, day time.Time) func(context.Context) (interface{}, error) {
+	return func(ctx context.Context) (interface{}, error) {
+		tideJSON, err := env.getWUApiRepose(ctx, "tide", location)
+		if err != nil {
+			return nil, fmt.Errorf("Error fetching tides: %w", err)
+		}
+
+		var tide provider.WUTide
+		if err := json.Unmarshal([]byte(tideJSON), &tide); err != nil {
+			return nil, env.rawParseError("tides", "tide", location.Query, err)
+		}
+		responseObj, err := makeTidesResponse(location, tide, env.config().LocationTZ)
+		if status.Code(err) == 404 {
+			if err := env.redis.Set(context.WithoutCancel(ctx), env.noTidesKey(location, day), "1", tidesTTL).Err(); err != nil {
+				log.Printf("Error commiting to cache: %s", err)
+			}
+		} else if err == nil {
+			env.refreshedModel(ctx, location, "tides", responseObj)
+		}
+		return responseObj, err
+	}
+}
+
+// makeTidesResponse keeps the high and low tides from WU's summary, which
+// also lists sun and moon events
+func makeTidesResponse(location Location, tide provider.WUTide, tz *time.Location) (responseObj []*TidesResponse, resError error) {
+	for _, summary := range tide.Tide.TideSummary {
+		var tideType string
+		switch summary.Data.Type {
+		case "High Tide":
+			tideType = "high"
+		case "Low Tide":
+			tideType = "low"
+		default:
+			continue
+		}
+
+		epoch, err := strconv.ParseInt(summary.Date.Epoch, 10, 64)
+		if err != nil {
+			resError = status.Errorf(502, "Error parsing tide time: %s", err)
+			return
+		}
+		height, unit, err := parseTideHeight(summary.Data.Height)
+		if err != nil {
+			resError = status.Errorf(502, "Error parsing tide height: %s", err)
+			return
+		}
+
+		at := time.Unix(epoch, 0).In(tz)
+		responseObj = append(responseObj, &TidesResponse{
+			ResponseID: fmt.Sprintf("%s:%d", location.Key(), epoch),
+			Type:       tideType,
+			Time:       at.Format(time.RFC3339),
+			Height:     height,
+			HeightUnit: unit,
+		})
+	}
+
+	if len(responseObj) == 0 {
+		resError = noTidesError(location)
+	}
+	return
+}
+
+// parseTideHeight splits WU heights such as "5.43 ft"
+func parseTideHeight(value string) (height float64, unit string, resError error) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		resError = fmt.Errorf("%q is not a height", value)
+		return
+	}
+
+	height, resError = strconv.ParseFloat(fields[0], 64)
+	unit = fields[1]
+	return
+}
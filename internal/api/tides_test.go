@@ -0,0 +1,48 @@
+package api
+
+import "testing"
+
+func TestTidesNoneCached(t *testing.T) {
+	server := newTestServer(t, map[string]string{"LOCATIONS": "inland:PA/Harrisburg"})
+	server.wu.respond = func(feature string, location string) (int, string) {
+		return 200, `{"response": {"version": "0.1"}, "tide": {"tideSummary": []}}`
+	}
+
+	for i := 1; i <= 3; i++ {
+		response := server.get("/weather/tides/v1/inland")
+		if response.Code != 404 {
+			t.Fatalf("request %d: status = %d, want 404: %s", i, response.Code, response.Body)
+		}
+		if _, detail := jsonapiError(t, response); detail != "no tide data is available for inland" {
+			t.Errorf("request %d: detail = %q", i, detail)
+		}
+	}
+	if server.wu.calls() != 1 {
+		t.Errorf("WU called %d times for a location without tides, want 1", server.wu.calls())
+	}
+
+	key := server.env.noTidesKey(Location{Name: "inland"}, testNow)
+	if ttl := server.redis.TTL(key); ttl != tidesTTL {
+		t.Errorf("%s TTL = %s, want %s", key, ttl, tidesTTL)
+	}
+
+	// once it expires WU is asked again
+	server.redis.FastForward(tidesTTL)
+	server.get("/weather/tides/v1/inland")
+	if server.wu.calls() != 2 {
+		t.Errorf("WU called %d times after the marker expired, want 2", server.wu.calls())
+	}
+}
+
+func TestTides(t *testing.T) {
+	server := newTestServer(t, nil)
+
+	for i := 1; i <= 2; i++ {
+		if response := server.get("/weather/tides/v1"); response.Code != 200 {
+			t.Fatalf("request %d: status = %d, want 200: %s", i, response.Code, response.Body)
+		}
+	}
+	if server.wu.calls() != 1 {
+		t.Errorf("WU called %d times, want 1", server.wu.calls())
+	}
+}
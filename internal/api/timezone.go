@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/jsonapi"
+	"github.com/tyrm/ph-weather/internal/cache"
+	"github.com/tyrm/ph-weather/internal/status"
+)
+
+// requestTimezone returns the ?tz= zone, nil when absent so the location's
+// own zone is kept
+func (env *Env) requestTimezone(request *http.Request) (tz *time.Location, resError error) {
+	name := request.URL.Query().Get("tz")
+	if name == "" {
+		return
+	}
+
+	tz, err := time.LoadLocation(name)
+	if err != nil {
+		resError = status.Errorf(400, "tz %q is not a known timezone", name)
+	}
+	return
+}
+
+// inTimezone rewrites a cached single resource payload so the RFC3339 fields
+// are expressed in tz, and records the timezone in meta. A nil tz is the
+// zone named by the resource's timezone attribute. The cache keeps the
+// location-local payload so one entry serves every timezone.
+func inTimezone(cacheEntry *cache.Entry, tz *time.Location, fields ...string) (converted *cache.Entry, resError error) {
+	var payload jsonapi.OnePayload
+	if err := json.Unmarshal([]byte(cacheEntry.Body), &payload); err != nil {
+		resError = err
+		return
+	}
+
+	if tz == nil && payload.Data != nil {
+		if name, ok := payload.Data.Attributes["timezone"].(string); ok {
+			tz, _ = time.LoadLocation(name)
+		}
+	}
+	if tz == nil {
+		return cacheEntry, nil
+	}
+
+	if payload.Data != nil {
+		for _, field := range fields {
+			value, ok := payload.Data.Attributes[field].(string)
+			if !ok {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				resError = err
+				return
+			}
+			payload.Data.Attributes[field] = t.In(tz).Format(time.RFC3339)
+		}
+	}
+
+	if payload.Meta == nil {
+		payload.Meta = &jsonapi.Meta{}
+	}
+	(*payload.Meta)["timezone"] = tz.String()
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		resError = err
+		return
+	}
+
+	converted = &cache.Entry{ETag: cache.MakeETag(string(body)), Body: string(body), Stale: cacheEntry.Stale}
+	return
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// withTracing starts a server span for each request, continuing the
+// caller's trace when it sent a traceparent. Spans are named by method
+// until traceRoute names them after the matched route.
+func withTracing(handler http.Handler) http.Handler {
+	return otelhttp.NewHandler(handler, "ph-weather", otelhttp.WithSpanNameFormatter(func(_ string, request *http.Request) string {
+		return request.Method
+	}))
+}
+
+// traceRoute names the request's span after its route pattern rather than
+// its path, so every location and date share one span name
+func traceRoute(request *http.Request, pattern string) {
+	span := trace.SpanFromContext(request.Context())
+	span.SetName(request.Method + " " + pattern)
+	span.SetAttributes(attribute.String("http.route", pattern))
+}
+
+// traceCacheResult records how the cache answered on the request's span:
+// hit, miss, wait (another request or instance refreshed it) or stale
+func traceCacheResult(request *http.Request, result string) {
+	trace.SpanFromContext(request.Context()).SetAttributes(attribute.String("cache.result", result))
+}
+
+// traceAttributes records what a request is for on its span, such as the
+// location and date, so traces can be searched by them
+func traceAttributes(request *http.Request, attributes ...attribute.KeyValue) {
+	trace.SpanFromContext(request.Context()).SetAttributes(attributes...)
+}
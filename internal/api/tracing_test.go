@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/tyrm/ph-weather/internal/cache"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingSpanNamedAfterRoute(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	router := NewRouter("/api")
+	router.Route("/weather/sun_phase/v1/{location}", nil).Get(func(response http.ResponseWriter, request *http.Request) {})
+	handler := withTracing(router)
+
+	for _, path := range []string{"/api/weather/sun_phase/v1/home", "/api/weather/sun_phase/v1/cabin"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("recorded %d spans, want 2", len(spans))
+	}
+	for _, span := range spans {
+		if want := "GET /api/weather/sun_phase/v1/{location}"; span.Name() != want {
+			t.Errorf("span name = %q, want %q", span.Name(), want)
+		}
+	}
+}
+
+func TestRedisSpansUnderRequest(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	server := newTestServer(t, map[string]string{"L1_CACHE_SIZE": "0"})
+	client := redis.NewClient(&redis.Options{Addr: server.redis.Addr()})
+	client.AddHook(cache.RedisTracing{Tracer: provider.Tracer("test")})
+	server.env.redis = client
+	server.env.cache = cache.New(client, 0)
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "GET /weather/sun_phase/v1")
+	request := httptest.NewRequest("GET", "/weather/sun_phase/v1", nil).WithContext(ctx)
+	server.router.ServeHTTP(httptest.NewRecorder(), request)
+	parent.End()
+
+	// the cache read and store are traced in the request's trace, commands
+	// made while calling WU sit under the WU call's span
+	commands := map[string]bool{}
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().SpanID() == parent.SpanContext().SpanID() {
+			continue
+		}
+		if span.SpanContext().TraceID() != parent.SpanContext().TraceID() {
+			t.Errorf("span %q isn't in the request's trace", span.Name())
+		}
+		commands[span.Name()] = true
+	}
+	for _, name := range []string{"redis get", "redis set"} {
+		if !commands[name] {
+			t.Errorf("no %q span, recorded %v", name, commands)
+		}
+	}
+}
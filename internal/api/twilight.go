@@ -0,0 +1,110 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/config"
+)
+
+const (
+	civilTwilightAltitude        = -6
+	nauticalTwilightAltitude     = -12
+	astronomicalTwilightAltitude = -18
+)
+
+// Reasons a twilight has no dawn or dusk
+const (
+	TwilightSunAlwaysAbove = "sun_always_above"
+	TwilightSunAlwaysBelow = "sun_always_below"
+	TwilightNoCoordinates  = "coordinates_unavailable"
+)
+
+type TwilightResponse struct {
+	ResponseID         string  `jsonapi:"primary,twilight"`
+	Date               string  `jsonapi:"attr,date"`
+	CivilDawn          *string `jsonapi:"attr,civil_dawn"`
+	CivilDusk          *string `jsonapi:"attr,civil_dusk"`
+	CivilReason        string  `jsonapi:"attr,civil_reason"`
+	NauticalDawn       *string `jsonapi:"attr,nautical_dawn"`
+	NauticalDusk       *string `jsonapi:"attr,nautical_dusk"`
+	NauticalReason     string  `jsonapi:"attr,nautical_reason"`
+	AstronomicalDawn   *string `jsonapi:"attr,astronomical_dawn"`
+	AstronomicalDusk   *string `jsonapi:"attr,astronomical_dusk"`
+	AstronomicalReason string  `jsonapi:"attr,astronomical_reason"`
+}
+
+// twilightFields lists the timestamp attributes shared with sun phase v2
+var twilightFields = []string{
+	"civil_dawn", "civil_dusk",
+	"nautical_dawn", "nautical_dusk",
+	"astronomical_dawn", "astronomical_dusk",
+}
+
+// twilight computes when the sun crosses altitude on day. When it doesn't,
+// dawn and dusk are nil and reason says why.
+func twilight(day time.Time, coordinates *config.Coordinates, altitude float64) (dawn *string, dusk *string, reason string) {
+	if coordinates == nil {
+		reason = TwilightNoCoordinates
+		return
+	}
+
+	crossings := crossingsAt(day, *coordinates, altitude)
+	if crossings.reason != "" {
+		reason = crossings.reason
+		return
+	}
+	dawn, dusk = formatTime(crossings.rise.Round(time.Second)), formatTime(crossings.set.Round(time.Second))
+	return
+}
+
+// sunAltitudeCrossings pairs crossings with the reason they're missing
+type sunAltitudeCrossings struct {
+	rise   *time.Time
+	set    *time.Time
+	reason string
+}
+
+func crossingsAt(day time.Time, coordinates config.Coordinates, altitude float64) (crossings sunAltitudeCrossings) {
+	rise, set, polar := sunCrossings(day, coordinates.Latitude, coordinates.Longitude, altitude)
+	crossings = sunAltitudeCrossings{rise: rise, set: set}
+	switch polar {
+	case PolarMidnightSun:
+		crossings.reason = TwilightSunAlwaysAbove
+	case PolarNight:
+		crossings.reason = TwilightSunAlwaysBelow
+	}
+	return
+}
+
+func makeTwilightResponse(id string, coordinates *config.Coordinates, day time.Time) (responseObj *TwilightResponse) {
+	responseObj = &TwilightResponse{ResponseID: id, Date: day.Format(dateFormat)}
+	responseObj.CivilDawn, responseObj.CivilDusk, responseObj.CivilReason = twilight(day, coordinates, civilTwilightAltitude)
+	responseObj.NauticalDawn, responseObj.NauticalDusk, responseObj.NauticalReason = twilight(day, coordinates, nauticalTwilightAltitude)
+	responseObj.AstronomicalDawn, responseObj.AstronomicalDusk, responseObj.AstronomicalReason = twilight(day, coordinates, astronomicalTwilightAltitude)
+	return
+}
+
+// handleTwilight serves dawn and dusk for each twilight, computed locally
+func (env *Env) handleTwilight(response http.ResponseWriter, request *http.Request) {
+	location, err := env.requestLocation(request)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	day, err := env.requestDate(request)
+	if err != nil {
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	coordinates, err := env.locationCoordinates(request.Context(), location)
+	if err != nil {
+		logRequest(request, "%s", err)
+		makeStatusErrorResponse(response, err)
+		return
+	}
+
+	writePayload(response, request, makeTwilightResponse(dayResourceID("twilight", location.Key(), day), coordinates, day))
+}
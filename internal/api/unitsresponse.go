@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+
+	"github.com/google/jsonapi"
+	"github.com/tyrm/ph-weather/internal/cache"
+	"github.com/tyrm/ph-weather/internal/status"
+	"github.com/tyrm/ph-weather/units"
+)
+
+// requestUnits returns the ?units= system, or DEFAULT_UNITS when absent
+func (env *Env) requestUnits(request *http.Request) (system units.System, resError error) {
+	value := request.URL.Query().Get("units")
+	if value == "" {
+		system = env.config().DefaultUnits
+		return
+	}
+
+	system, err := units.Parse(value)
+	if err != nil {
+		resError = &status.Error{Status: 400, Err: err}
+	}
+	return
+}
+
+// inUnits rewrites a cached payload, single or many, from canonical metric
+// into system and records the units applied in meta
+func inUnits(cacheEntry *cache.Entry, system units.System, fields map[string]units.Kind) (converted *cache.Entry, resError error) {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(cacheEntry.Body), &payload); err != nil {
+		resError = err
+		return
+	}
+
+	many := len(payload["data"]) > 0 && payload["data"][0] == '['
+	var nodes []*jsonapi.Node
+	if many {
+		resError = json.Unmarshal(payload["data"], &nodes)
+	} else {
+		var node jsonapi.Node
+		resError = json.Unmarshal(payload["data"], &node)
+		nodes = []*jsonapi.Node{&node}
+	}
+	if resError != nil {
+		return
+	}
+
+	for _, node := range nodes {
+		for field, kind := range fields {
+			if value, ok := node.Attributes[field].(float64); ok {
+				node.Attributes[field] = math.Round(system.Convert(kind, value)*10) / 10
+			}
+		}
+	}
+
+	if many {
+		payload["data"], resError = json.Marshal(nodes)
+	} else {
+		payload["data"], resError = json.Marshal(nodes[0])
+	}
+	if resError != nil {
+		return
+	}
+
+	meta := jsonapi.Meta{}
+	if raw, ok := payload["meta"]; ok {
+		if resError = json.Unmarshal(raw, &meta); resError != nil {
+			return
+		}
+	}
+	meta["units"] = system.Units()
+	if payload["meta"], resError = json.Marshal(meta); resError != nil {
+		return
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		resError = err
+		return
+	}
+	converted = &cache.Entry{ETag: cache.MakeETag(string(body)), Body: string(body), Stale: cacheEntry.Stale}
+	return
+}
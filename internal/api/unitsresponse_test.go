@@ -0,0 +1,52 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestHourlyUnits(t *testing.T) {
+	server := newTestServer(t, map[string]string{"DEFAULT_UNITS": "metric"})
+
+	first := func(query string) map[string]interface{} {
+		t.Helper()
+		response := server.get("/weather/hourly/v1"+query, "Accept", "application/json")
+		if response.Code != 200 {
+			t.Fatalf("%s: status = %d: %s", query, response.Code, response.Body)
+		}
+		var hours []map[string]interface{}
+		if err := json.Unmarshal(response.Body.Bytes(), &hours); err != nil || len(hours) == 0 {
+			t.Fatalf("%s: decoding %s: %v", query, response.Body, err)
+		}
+		return hours[0]
+	}
+
+	// MockWUResponse's 16:00 is 20°C
+	if got := first("")["temperature"]; got != float64(20) {
+		t.Errorf("default temperature = %v, want 20", got)
+	}
+	if got := first("?units=imperial")["temperature"]; got != float64(68) {
+		t.Errorf("imperial temperature = %v, want 68", got)
+	}
+
+	if response := server.get("/weather/hourly/v1?units=kelvin"); response.Code != 400 {
+		t.Errorf("?units=kelvin: status = %d, want 400", response.Code)
+	}
+}
+
+func TestUnitsMeta(t *testing.T) {
+	server := newTestServer(t, nil)
+
+	response := server.get("/weather/hourly/v1?units=si")
+	var document struct {
+		Meta struct {
+			Units map[string]string `json:"units"`
+		} `json:"meta"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &document); err != nil {
+		t.Fatal(err)
+	}
+	if document.Meta.Units["speed"] != "m/s" || document.Meta.Units["system"] != "si" {
+		t.Errorf("meta units = %v, want si", document.Meta.Units)
+	}
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"expvar"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/config"
+)
+
+// startTime is when the process started
+var startTime = time.Now()
+
+func init() {
+	expvar.Publish("build", expvar.Func(func() interface{} {
+		return makeVersionResponse()
+	}))
+}
+
+type VersionResponse struct {
+	ResponseID string `jsonapi:"primary,version"`
+	Version    string `jsonapi:"attr,version" json:"version"`
+	Commit     string `jsonapi:"attr,commit" json:"commit"`
+	BuildDate  string `jsonapi:"attr,build_date" json:"build_date"`
+	GoVersion  string `jsonapi:"attr,go_version" json:"go_version"`
+	StartedAt  string `jsonapi:"attr,started_iso" json:"started_iso"`
+}
+
+func makeVersionResponse() *VersionResponse {
+	return &VersionResponse{
+		ResponseID: config.Version,
+		Version:    config.Version,
+		Commit:     config.Commit,
+		BuildDate:  config.BuildDate,
+		GoVersion:  runtime.Version(),
+		StartedAt:  startTime.UTC().Format(time.RFC3339),
+	}
+}
+
+func handleVersion(response http.ResponseWriter, request *http.Request) {
+	writePayload(response, request, makeVersionResponse())
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/jsonapi"
+	"github.com/tyrm/ph-weather/internal/cache"
+	"github.com/tyrm/ph-weather/internal/provider"
+)
+
+// weatherGauges are exported in this order, each with its help text
+var weatherGauges = []struct {
+	name string
+	help string
+}{
+	{"weather_temperature_celsius", "Current temperature."},
+	{"weather_humidity_percent", "Current relative humidity."},
+	{"weather_observation_timestamp_seconds", "When the current conditions were observed."},
+	{"weather_sunrise_timestamp_seconds", "Today's sunrise."},
+	{"weather_sunset_timestamp_seconds", "Today's sunset."},
+}
+
+// handleWeatherMetrics exports the cached weather of every location in the
+// Prometheus text format. A cold cache is only filled from WU when
+// WEATHER_METRICS_FETCH is set, so frequent scrapes don't spend WU quota;
+// otherwise the location's values are left out until a client request
+// caches them.
+func (env *Env) handleWeatherMetrics(response http.ResponseWriter, request *http.Request) {
+	fetch := env.config().WeatherMetricsFetch
+	values := make(map[string][]string)
+
+	for _, location := range env.configuredLocations() {
+		label := fmt.Sprintf("{location=\"%s\"}", prometheusEscaper.Replace(location.Key()))
+		add := func(name string, value *float64) {
+			if value != nil {
+				values[name] = append(values[name], name+label+" "+strconv.FormatFloat(*value, 'g', -1, 64))
+			}
+		}
+
+		conditions, err := env.cachedConditions(request.Context(), location, fetch)
+		if err != nil {
+			logRequest(request, "%s", err)
+		} else if conditions != nil {
+			observation := conditions.CurrentObservation
+			add("weather_temperature_celsius", provider.ParseWUFloat(observation.TempC.String()))
+			add("weather_humidity_percent", observation.Humidity())
+			add("weather_observation_timestamp_seconds", provider.ParseWUFloat(observation.ObservationEpoch))
+		}
+
+		sunrise, sunset, err := env.cachedSunTimes(request, location, fetch)
+		if err != nil {
+			logRequest(request, "%s", err)
+		}
+		add("weather_sunrise_timestamp_seconds", sunrise)
+		add("weather_sunset_timestamp_seconds", sunset)
+	}
+
+	var body strings.Builder
+	for _, gauge := range weatherGauges {
+		if len(values[gauge.name]) == 0 {
+			continue
+		}
+		fmt.Fprintf(&body, "# HELP %s %s\n# TYPE %s gauge\n", gauge.name, gauge.help, gauge.name)
+		for _, line := range values[gauge.name] {
+			body.WriteString(line + "\n")
+		}
+	}
+
+	response.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprint(response, body.String())
+}
+
+var prometheusEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+// cachedSunTimes reads today's sunrise and sunset as unix seconds from the
+// cached v1 sun phase, building it only when fetch is set. Both are nil when
+// not cached or during polar day and night. A sunset after local midnight is
+// on the next day.
+func (env *Env) cachedSunTimes(request *http.Request, location Location, fetch bool) (sunrise *float64, sunset *float64, resError error) {
+	today := env.today()
+	cacheKey := env.sunPhaseCacheKey(location.Key(), today)
+
+	var cacheEntry *cache.Entry
+	if fetch {
+		cacheEntry, resError = env.getOrBuildCache(request, cacheKey, env.sunPhaseTTL(today), env.buildSunPhase(location, today))
+	} else {
+		cacheEntry, resError = env.cache.Get(request.Context(), cacheKey)
+	}
+	if resError != nil || cacheEntry == nil {
+		return
+	}
+
+	var payload jsonapi.OnePayload
+	if err := json.Unmarshal([]byte(cacheEntry.Body), &payload); err != nil || payload.Data == nil {
+		resError = fmt.Errorf("Error decoding cached sun phase: %v", err)
+		return
+	}
+
+	// named locations may be in another timezone than LOCATION_TZ
+	attributes := payload.Data.Attributes
+	timezone, _ := attributes["timezone"].(string)
+	tz := sunPhaseTZ(today, timezone)
+	clock := func(name string) (hour int, minute int, ok bool) {
+		h, hourOK := attributes[name+"_h"].(float64)
+		m, minuteOK := attributes[name+"_m"].(float64)
+		return int(h), int(m), hourOK && minuteOK
+	}
+	sunriseH, sunriseM, riseOK := clock("sunrise")
+	sunsetH, sunsetM, setOK := clock("sunset")
+	if !riseOK || !setOK {
+		return
+	}
+
+	rise, set := sunPhaseTimes(today, tz, sunriseH, sunriseM, sunsetH, sunsetM)
+	sunriseUnix, sunsetUnix := float64(rise.Unix()), float64(set.Unix())
+	return &sunriseUnix, &sunsetUnix, nil
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/provider"
+)
+
+func TestWeatherMetricsSunTimesTimezone(t *testing.T) {
+	server := newTestServer(t, map[string]string{"LOCATIONS": "london:UK/London", "WEATHER_METRICS_FETCH": "true"})
+	server.wu.respond = func(feature string, location string) (int, string) {
+		if location == "UK/London" && strings.HasPrefix(feature, "astronomy") {
+			return 200, wuAstronomy("4:43", "21:21", "51.507", "-0.128", "Europe/London")
+		}
+		body, _ := provider.MockWUResponse(feature, location, testNow)
+		return 200, body
+	}
+
+	response := server.get("/metrics/weather")
+	if response.Code != 200 {
+		t.Fatalf("status = %d, want 200: %s", response.Code, response.Body)
+	}
+
+	london, _ := time.LoadLocation("Europe/London")
+	for name, want := range map[string]time.Time{
+		`weather_sunrise_timestamp_seconds{location="london"}`:          time.Date(2024, 6, 20, 4, 43, 0, 0, london),
+		`weather_sunset_timestamp_seconds{location="london"}`:           time.Date(2024, 6, 20, 21, 21, 0, 0, london),
+		`weather_sunrise_timestamp_seconds{location="PA/Philadelphia"}`: time.Date(2024, 6, 20, 6, 30, 0, 0, testTZ),
+	} {
+		value, ok := metricValue(response.Body.String(), name)
+		if !ok || int64(value) != want.Unix() {
+			t.Errorf("%s = %v, want %s (%d)", name, value, want, want.Unix())
+		}
+	}
+}
+
+// metricValue finds the sample named name, labels included, in a
+// Prometheus text exposition
+func metricValue(exposition string, name string) (value float64, ok bool) {
+	for _, line := range strings.Split(exposition, "\n") {
+		if sample, found := strings.CutPrefix(line, name+" "); found {
+			value, err := strconv.ParseFloat(sample, 64)
+			return value, err == nil
+		}
+	}
+	return 0, false
+}
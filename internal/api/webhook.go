@@ -0,0 +1,179 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	webhookAttempts = 3
+	webhookTimeout  = 10 * time.Second
+
+	// webhookGrace is how late an event may still fire, so a restart just
+	// after sunrise catches up but one in the afternoon doesn't open the blinds
+	webhookGrace = 10 * time.Minute
+
+	// webhookFiredTTL keeps fired markers past the day's rollover
+	webhookFiredTTL = 48 * time.Hour
+)
+
+// webhookEvent is one scheduled delivery
+type webhookEvent struct {
+	Event         string `json:"event"`
+	EventTime     string `json:"event_time"`
+	ScheduledTime string `json:"scheduled_time"`
+	Location      string `json:"location"`
+
+	url string
+	at  time.Time
+}
+
+// runWebhooks calls WEBHOOK_SUNRISE_URL and WEBHOOK_SUNSET_URL at each day's
+// sunrise and sunset for the default location, shifted by their offsets,
+// until ctx is done. A fired marker in Redis keeps restarts and other
+// instances from delivering an event twice.
+func (env *Env) runWebhooks(ctx context.Context) {
+	location := Location{Query: env.config().WUndergroundLocation}
+
+	for {
+		day := env.today()
+		events, err := env.webhookEvents(ctx, location, day)
+		if err != nil {
+			log.Printf("Error scheduling webhooks: %s", err)
+			// retry well before the next event could be due
+			if !env.sleep(ctx, webhookGrace/2) {
+				return
+			}
+			continue
+		}
+
+		for _, event := range events {
+			if env.now().Sub(event.at) > webhookGrace {
+				continue
+			}
+			if !env.sleepUntil(ctx, event.at) {
+				return
+			}
+			env.fireWebhook(ctx, day, event)
+		}
+
+		midnight := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location()).AddDate(0, 0, 1)
+		if !env.sleepUntil(ctx, midnight) {
+			return
+		}
+	}
+}
+
+// webhookEvents returns the day's configured events in time order. Days
+// without a sunrise or sunset have no events.
+func (env *Env) webhookEvents(ctx context.Context, location Location, day time.Time) (events []webhookEvent, resError error) {
+	sunPhase, _, resError := env.fetchSunPhase(ctx, location, day)
+	if resError != nil {
+		return
+	}
+
+	for _, hook := range []struct {
+		event  string
+		url    string
+		offset time.Duration
+		hour   *int
+		minute *int
+	}{
+		{"sunrise", env.config().WebhookSunriseURL, env.config().WebhookSunriseOffset, sunPhase.SunriseH, sunPhase.SunriseM},
+		{"sunset", env.config().WebhookSunsetURL, env.config().WebhookSunsetOffset, sunPhase.SunsetH, sunPhase.SunsetM},
+	} {
+		if hook.url == "" || hook.hour == nil || hook.minute == nil {
+			continue
+		}
+		eventTime := time.Date(day.Year(), day.Month(), day.Day(), *hook.hour, *hook.minute, 0, 0, day.Location())
+		at := eventTime.Add(hook.offset)
+		events = append(events, webhookEvent{
+			Event:         hook.event,
+			EventTime:     eventTime.Format(time.RFC3339),
+			ScheduledTime: at.Format(time.RFC3339),
+			Location:      location.Key(),
+			url:           hook.url,
+			at:            at,
+		})
+	}
+
+	sort.Slice(events, func(i, j int) bool { return events[i].at.Before(events[j].at) })
+	return
+}
+
+// fireWebhook claims the event's marker and delivers it, retrying failures
+// with backoff. A delivery that keeps failing is not attempted again.
+func (env *Env) fireWebhook(ctx context.Context, day time.Time, event webhookEvent) {
+	markerKey := env.cacheKey("webhook_fired", event.Location+":"+event.Event, day)
+	claimed, err := env.redis.SetNX(ctx, markerKey, event.ScheduledTime, webhookFiredTTL).Result()
+	if err != nil {
+		log.Printf("Error claiming %s webhook: %s", event.Event, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error marshaling %s webhook: %s", event.Event, err)
+		return
+	}
+
+	backoff := 5 * time.Second
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		err = env.postWebhook(ctx, event.url, body)
+		if err == nil {
+			log.Printf("Delivered %s webhook for %s", event.Event, event.ScheduledTime)
+			return
+		}
+		log.Printf("Error delivering %s webhook (attempt %d of %d): %s", event.Event, attempt, webhookAttempts, err)
+		if attempt < webhookAttempts && !env.sleep(ctx, backoff) {
+			return
+		}
+		backoff *= 2
+	}
+}
+
+func (env *Env) postWebhook(ctx context.Context, url string, body []byte) error {
+	request, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request = request.WithContext(ctx)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := env.webhooks.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", response.Status)
+	}
+	return nil
+}
+
+// sleepUntil waits until t by env's clock and reports false if ctx ended first
+func (env *Env) sleepUntil(ctx context.Context, t time.Time) bool {
+	return env.sleep(ctx, t.Sub(env.now()))
+}
+
+// sleep waits for d and reports false if ctx ended first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock stands in for env.now and env.sleep, sleeping advances it at
+// once. It cancels the run once it passes stop.
+type fakeClock struct {
+	sync.Mutex
+	now    time.Time
+	stop   time.Time
+	cancel context.CancelFunc
+	slept  []time.Duration
+}
+
+func (clock *fakeClock) Now() time.Time {
+	clock.Lock()
+	defer clock.Unlock()
+	return clock.now
+}
+
+func (clock *fakeClock) Sleep(ctx context.Context, d time.Duration) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	clock.Lock()
+	defer clock.Unlock()
+	clock.slept = append(clock.slept, d)
+	if d > 0 {
+		clock.now = clock.now.Add(d)
+	}
+	if clock.now.After(clock.stop) {
+		clock.cancel()
+		return false
+	}
+	return true
+}
+
+// webhookReceiver records the webhooks delivered to it, failing the first
+// failures of them
+type webhookReceiver struct {
+	sync.Mutex
+	failures int
+	attempts int
+	events   []webhookEvent
+}
+
+func (receiver *webhookReceiver) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	receiver.Lock()
+	defer receiver.Unlock()
+	receiver.attempts++
+	if receiver.attempts <= receiver.failures {
+		response.WriteHeader(503)
+		return
+	}
+	var event webhookEvent
+	json.NewDecoder(request.Body).Decode(&event)
+	receiver.events = append(receiver.events, event)
+}
+
+// scheduled lists the scheduled times of the delivered events
+func (receiver *webhookReceiver) scheduled() []string {
+	receiver.Lock()
+	defer receiver.Unlock()
+	var times []string
+	for _, event := range receiver.events {
+		times = append(times, event.Event+" "+event.ScheduledTime)
+	}
+	return times
+}
+
+// runWebhooks runs the scheduler from start until the clock passes stop,
+// the mock WU has sunrise at 6:30 and sunset at 18:45 every day
+func runWebhooks(t *testing.T, server *testServer, start, stop time.Time) *fakeClock {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := &fakeClock{now: start, stop: stop, cancel: cancel}
+	server.env.now = clock.Now
+	server.env.sleep = clock.Sleep
+
+	done := make(chan struct{})
+	go func() {
+		server.env.runWebhooks(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("runWebhooks didn't stop")
+	}
+	return clock
+}
+
+func newWebhookServer(t *testing.T, receiver *webhookReceiver) *testServer {
+	t.Helper()
+	hooks := httptest.NewServer(receiver)
+	t.Cleanup(hooks.Close)
+	return newTestServer(t, map[string]string{
+		"WEBHOOK_SUNRISE_URL":    hooks.URL + "/sunrise",
+		"WEBHOOK_SUNSET_URL":     hooks.URL + "/sunset",
+		"WEBHOOK_SUNSET_OFFSET":  "-15m",
+		"WEBHOOK_SUNRISE_OFFSET": "0s",
+	})
+}
+
+func TestWebhooksMidnightRollover(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := newWebhookServer(t, receiver)
+
+	// testNow is the afternoon of June 20, past sunrise but before sunset
+	runWebhooks(t, server, testNow, testNow.Add(36*time.Hour))
+
+	want := []string{
+		"sunset 2024-06-20T18:30:00-04:00",
+		"sunrise 2024-06-21T06:30:00-04:00",
+		"sunset 2024-06-21T18:30:00-04:00",
+	}
+	got := receiver.scheduled()
+	if len(got) != len(want) {
+		t.Fatalf("delivered %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("delivery %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestWebhooksRestartNoDoubleFire(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := newWebhookServer(t, receiver)
+	sunset := time.Date(2024, 6, 20, 18, 30, 0, 0, testTZ)
+
+	runWebhooks(t, server, testNow, sunset.Add(time.Minute))
+	// restarted within the grace period, the sunset is due again
+	runWebhooks(t, server, sunset.Add(2*time.Minute), sunset.Add(3*time.Minute))
+
+	if got := receiver.scheduled(); len(got) != 1 {
+		t.Errorf("delivered %q, want the sunset once", got)
+	}
+}
+
+func TestWebhooksRestartPastGrace(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := newWebhookServer(t, receiver)
+	sunset := time.Date(2024, 6, 20, 18, 30, 0, 0, testTZ)
+
+	// started well after the sunset, it isn't delivered late
+	runWebhooks(t, server, sunset.Add(webhookGrace+time.Minute), sunset.Add(time.Hour))
+
+	if got := receiver.scheduled(); len(got) != 0 {
+		t.Errorf("delivered %q, want none", got)
+	}
+}
+
+func TestWebhooksRetry(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		failures  int
+		attempts  int
+		delivered int
+		backoff   []time.Duration
+	}{
+		{name: "recovers", failures: 2, attempts: 3, delivered: 1, backoff: []time.Duration{5 * time.Second, 10 * time.Second}},
+		{name: "gives up", failures: 5, attempts: webhookAttempts, delivered: 0, backoff: []time.Duration{5 * time.Second, 10 * time.Second}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			receiver := &webhookReceiver{failures: test.failures}
+			server := newWebhookServer(t, receiver)
+			sunset := time.Date(2024, 6, 20, 18, 30, 0, 0, testTZ)
+
+			clock := runWebhooks(t, server, sunset, sunset.Add(time.Hour))
+
+			if receiver.attempts != test.attempts {
+				t.Errorf("attempts = %d, want %d", receiver.attempts, test.attempts)
+			}
+			if len(receiver.events) != test.delivered {
+				t.Errorf("delivered %d, want %d", len(receiver.events), test.delivered)
+			}
+			// the sunset is due at once, then each retry waits its backoff
+			for i, want := range test.backoff {
+				if i+1 >= len(clock.slept) || clock.slept[i+1] != want {
+					t.Errorf("slept %v, want backoff %v", clock.slept, test.backoff)
+					break
+				}
+			}
+		})
+	}
+}
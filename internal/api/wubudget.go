@@ -0,0 +1,59 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/google/jsonapi"
+	"github.com/tyrm/ph-weather/internal/cache"
+	"github.com/tyrm/ph-weather/internal/provider"
+)
+
+type QuotaResponse struct {
+	ResponseID    string `jsonapi:"primary,quota"`
+	Provider      string `jsonapi:"attr,provider"`
+	Date          string `jsonapi:"attr,date"`
+	Calls         int64  `jsonapi:"attr,calls"`
+	Budget        int64  `jsonapi:"attr,budget"`
+	WarnThreshold int64  `jsonapi:"attr,warn_threshold"`
+	Remaining     *int64 `jsonapi:"attr,remaining"`
+	Exhausted     bool   `jsonapi:"attr,exhausted"`
+	Resets        string `jsonapi:"attr,resets_iso"`
+}
+
+// handleQuota reports today's WU call count against the budget
+func (env *Env) handleQuota(response http.ResponseWriter, request *http.Request) {
+	now := env.now().UTC()
+	calls, err := env.redis.Get(request.Context(), env.weather.CallsKey(now)).Int64()
+	if err != nil && !cache.Miss(err) {
+		logRequest(request, "Error reading WU call count: %s", err)
+		makeErrorResponse(response, 500, err.Error(), 0)
+		return
+	}
+
+	responseObj := &QuotaResponse{
+		ResponseID:    "wu:" + now.Format(dateFormat),
+		Provider:      "wu",
+		Date:          now.Format(dateFormat),
+		Calls:         calls,
+		Budget:        int64(env.config().WUDailyBudget),
+		WarnThreshold: int64(env.config().WUDailyBudgetWarn),
+		Resets:        provider.NextUTCMidnight(now).Format(time.RFC3339),
+	}
+	if responseObj.Budget > 0 {
+		remaining := responseObj.Budget - calls
+		if remaining < 0 {
+			remaining = 0
+		}
+		responseObj.Remaining, responseObj.Exhausted = &remaining, remaining == 0
+	}
+
+	var payload bytes.Buffer
+	if err := jsonapi.MarshalPayload(&payload, responseObj); err != nil {
+		logRequest(request, "Error marshaling response: %s", err)
+		makeErrorResponse(response, 500, err.Error(), 0)
+		return
+	}
+	writeDocument(response, request, payload.Bytes())
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"context"
+	"testing"
+)
+
+func TestWUBudgetRefusedResponse(t *testing.T) {
+	server := newTestServer(t, map[string]string{"WU_DAILY_BUDGET": "1"})
+	server.redis.Set(server.env.weather.CallsKey(testNow.UTC()), "1")
+
+	response := server.get("/weather/sun_phase/v1")
+	if response.Code != 503 {
+		t.Fatalf("status = %d, want 503: %s", response.Code, response.Body)
+	}
+	if got := response.Header().Get("Retry-After"); got != "18000" {
+		t.Errorf("Retry-After = %q, want 18000", got)
+	}
+	if server.wu.calls() != 0 {
+		t.Errorf("WU was called %d times past the budget", server.wu.calls())
+	}
+}
+
+func TestWUBudgetServesStale(t *testing.T) {
+	server := newTestServer(t, map[string]string{"WU_DAILY_BUDGET": "1"})
+	server.get("/weather/sun_phase/v1")
+	calls := server.wu.calls()
+
+	// the fresh copy expires, the stale one outlives it
+	server.env.cache.Delete(context.Background(), server.env.sunPhaseCacheKey("PA/Philadelphia", testNow))
+	server.redis.Del(server.env.cacheKey("wu_astronomy", "PA/Philadelphia", testNow))
+
+	response := server.get("/weather/sun_phase/v1")
+	if response.Code != 200 {
+		t.Fatalf("status = %d, want the stale copy: %s", response.Code, response.Body)
+	}
+	if response.Header().Get("Warning") == "" {
+		t.Error("stale response has no Warning")
+	}
+	if server.wu.calls() != calls {
+		t.Errorf("WU was called past the budget")
+	}
+}
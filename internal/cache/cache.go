@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Cache keeps serialized responses in Redis, where every instance shares
+// them, behind the L1 cache
+type Cache struct {
+	redis RedisCommands
+	l1    *L1
+}
+
+// New caches in redis, keeping up to l1Size entries in process as well
+func New(redis RedisCommands, l1Size int) *Cache {
+	return &Cache{redis: redis, l1: NewL1(l1Size)}
+}
+
+// Get returns nil without an error on a cache miss. The L1 cache is
+// checked first and filled from Redis hits for the key's remaining TTL.
+func (cache *Cache) Get(ctx context.Context, key string) (entry *Entry, resError error) {
+	if entry = cache.l1.Get(key); entry != nil {
+		return
+	}
+
+	cacheVal, err := cache.redis.Get(ctx, key).Result()
+	if Miss(err) {
+		return
+	} else if err != nil {
+		resError = err
+		return
+	}
+
+	entry = &Entry{}
+	if err := json.Unmarshal([]byte(cacheVal), entry); err != nil || entry.ETag == "" {
+		// entries written before ETags existed are treated as misses
+		entry = nil
+		return
+	}
+
+	if cache.l1 != nil {
+		if ttl, err := cache.redis.TTL(ctx, key).Result(); err == nil {
+			cache.l1.Set(key, entry, ttl)
+		}
+	}
+	return
+}
+
+// Set caches body for ttl under key, returning the entry with its ETag
+func (cache *Cache) Set(ctx context.Context, key string, body string, ttl time.Duration) (entry *Entry, resError error) {
+	entry = &Entry{ETag: MakeETag(body), Body: body}
+
+	cacheVal, err := json.Marshal(entry)
+	if err != nil {
+		resError = err
+		return
+	}
+	resError = cache.redis.Set(ctx, key, string(cacheVal), ttl).Err()
+	if resError == nil {
+		cache.l1.Set(key, entry, ttl)
+	}
+	return
+}
+
+// Delete removes keys from Redis and this instance's L1 cache. Other
+// instances may serve them from theirs for up to L1MaxTTL.
+func (cache *Cache) Delete(ctx context.Context, keys ...string) error {
+	cache.l1.Delete(keys...)
+	return cache.redis.Del(ctx, keys...).Err()
+}
+
+// Redis is the client the cache stores in, which the service's other keys
+// share
+func (cache *Cache) Redis() RedisCommands {
+	return cache.redis
+}
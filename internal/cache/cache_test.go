@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// newTestCache is a Cache in miniredis with an L1 cache of l1Size entries
+func newTestCache(t *testing.T, l1Size int) (*Cache, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	return New(redis.NewClient(&redis.Options{Addr: mr.Addr()}), l1Size), mr
+}
+
+func TestMiss(t *testing.T) {
+	cache, _ := newTestCache(t, 0)
+
+	_, err := cache.Redis().Get(context.Background(), "ph:missing").Result()
+	if !Miss(err) {
+		t.Errorf("Miss(%v) = false for a missing key", err)
+	}
+	if !Miss(fmt.Errorf("reading: %w", redis.Nil)) {
+		t.Error("Miss = false for a wrapped redis.Nil")
+	}
+	for _, err := range []error{nil, errors.New("redis: nil"), context.Canceled} {
+		if Miss(err) {
+			t.Errorf("Miss(%v) = true", err)
+		}
+	}
+}
+
+func TestCacheRoundTrip(t *testing.T) {
+	cache, mr := newTestCache(t, 0)
+	ctx := context.Background()
+
+	entry, err := cache.Get(ctx, "ph:weather:test")
+	if entry != nil || err != nil {
+		t.Fatalf("Get on a miss = %v, %v, want nil, nil", entry, err)
+	}
+
+	stored, err := cache.Set(ctx, "ph:weather:test", `{"data":null}`, time.Hour)
+	if err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+	if ttl := mr.TTL("ph:weather:test"); ttl != time.Hour {
+		t.Errorf("TTL = %s, want 1h", ttl)
+	}
+
+	entry, err = cache.Get(ctx, "ph:weather:test")
+	if err != nil || entry == nil {
+		t.Fatalf("Get after Set = %v, %v", entry, err)
+	}
+	if entry.Body != stored.Body || entry.ETag != stored.ETag {
+		t.Errorf("Get = %+v, want %+v", entry, stored)
+	}
+}
+
+func TestCacheContext(t *testing.T) {
+	cache, mr := newTestCache(t, 0)
+	mr.Set("ph:weather:test", `{"etag":"\"x\"","body":"{}"}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// the request's context reaches Redis, a cancelled one isn't a miss
+	entry, err := cache.Get(ctx, "ph:weather:test")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Get with a cancelled context = %v, %v, want context.Canceled", entry, err)
+	}
+	if _, err := cache.Set(ctx, "ph:weather:other", "{}", time.Hour); !errors.Is(err, context.Canceled) {
+		t.Errorf("Set with a cancelled context = %v, want context.Canceled", err)
+	}
+	if mr.Exists("ph:weather:other") {
+		t.Error("Set wrote with a cancelled context")
+	}
+}
@@ -0,0 +1,46 @@
+// Package cache keeps the serialized responses ph-weather serves in Redis,
+// with an in-process cache in front and a lock so only one instance
+// refreshes an entry at a time.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Entry is a serialized response stored in Redis with its ETag, so every
+// instance hands out the same validator for the same body
+type Entry struct {
+	ETag string `json:"etag"`
+	Body string `json:"body"`
+
+	// Stale is set when the entry is a fallback served because WU failed
+	Stale bool `json:"-"`
+}
+
+// StaleKey keeps the long lived fallback copy of a cache entry
+func StaleKey(cacheKey string) string {
+	return cacheKey + ":stale"
+}
+
+// MakeETag is the strong ETag of body
+func MakeETag(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return fmt.Sprintf("\"%s\"", hex.EncodeToString(sum[:16]))
+}
+
+// ETagMatches uses weak comparison so ETags weakened by compression still match
+func ETagMatches(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// L1MaxTTL bounds how long an instance serves an entry without checking
+// Redis, which is how long a purge on another instance can go unnoticed
+const L1MaxTTL = time.Minute
+
+// L1 is a small in-process LRU of cache entries in front of Redis. A nil
+// L1, from L1_CACHE_SIZE=0, caches nothing.
+type L1 struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+type l1Item struct {
+	key     string
+	entry   Entry
+	expires time.Time
+}
+
+// NewL1 keeps up to size entries, nil when size isn't positive
+func NewL1(size int) *L1 {
+	if size <= 0 {
+		return nil
+	}
+	return &L1{size: size, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+// Get returns a copy of the entry, or nil when missing or expired
+func (cache *L1) Get(key string) *Entry {
+	if cache == nil {
+		return nil
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	element, ok := cache.items[key]
+	if !ok {
+		return nil
+	}
+	item := element.Value.(*l1Item)
+	if time.Now().After(item.expires) {
+		cache.order.Remove(element)
+		delete(cache.items, key)
+		return nil
+	}
+
+	cache.order.MoveToFront(element)
+	entry := item.entry
+	return &entry
+}
+
+// Set keeps entry for ttl, capped at L1MaxTTL, evicting the least recently
+// used entry when full
+func (cache *L1) Set(key string, entry *Entry, ttl time.Duration) {
+	if cache == nil || ttl <= 0 {
+		return
+	}
+	if ttl > L1MaxTTL {
+		ttl = L1MaxTTL
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	item := &l1Item{key: key, entry: *entry, expires: time.Now().Add(ttl)}
+	if element, ok := cache.items[key]; ok {
+		element.Value = item
+		cache.order.MoveToFront(element)
+		return
+	}
+
+	cache.items[key] = cache.order.PushFront(item)
+	if cache.order.Len() > cache.size {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.items, oldest.Value.(*l1Item).key)
+	}
+}
+
+func (cache *L1) Delete(keys ...string) {
+	if cache == nil {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	for _, key := range keys {
+		if element, ok := cache.items[key]; ok {
+			cache.order.Remove(element)
+			delete(cache.items, key)
+		}
+	}
+}
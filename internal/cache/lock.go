@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"time"
+)
+
+// lockTTL bounds how long a crashed instance can hold a refresh lock,
+// longer than a WU fetch should take
+const lockTTL = 30 * time.Second
+
+// lockWait is how long an instance waits for another's refresh before
+// fetching itself
+const lockWait = 5 * time.Second
+
+const lockPoll = 100 * time.Millisecond
+
+// releaseLockScript deletes a lock only while it still holds our token, so
+// a lock that expired and was taken by another instance is left alone
+const releaseLockScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) end return 0`
+
+func lockKey(cacheKey string) string {
+	return cacheKey + ":lock"
+}
+
+// Lock takes the cluster wide refresh lock for cacheKey. It
+// returns a release func when the lock was taken. Redis errors are treated
+// as taken, so refreshes still happen without the lock. Releasing isn't
+// cut short by ctx being cancelled.
+func (cache *Cache) Lock(ctx context.Context, cacheKey string) (release func(), acquired bool) {
+	key := lockKey(cacheKey)
+	token := newToken()
+
+	acquired, err := cache.redis.SetNX(ctx, key, token, lockTTL).Result()
+	if err != nil {
+		log.Printf("Error taking cache lock, refreshing without it: %s", err)
+		return func() {}, true
+	}
+	if !acquired {
+		return nil, false
+	}
+
+	releaseCtx := context.WithoutCancel(ctx)
+	release = func() {
+		if err := cache.redis.Eval(releaseCtx, releaseLockScript, []string{key}, token).Err(); err != nil {
+			log.Printf("Error releasing cache lock: %s", err)
+		}
+	}
+	return
+}
+
+// AwaitRefresh waits for another instance holding the lock to fill
+// cacheKey, returning nil once the lock is released without an entry or
+// lockWait or ctx is done
+func (cache *Cache) AwaitRefresh(ctx context.Context, cacheKey string) *Entry {
+	deadline := time.Now().Add(lockWait)
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(lockPoll):
+		}
+
+		if entry, err := cache.Get(ctx, cacheKey); err != nil || entry != nil {
+			return entry
+		}
+		if held, err := cache.redis.Exists(ctx, lockKey(cacheKey)).Result(); err != nil || held == 0 {
+			return nil
+		}
+	}
+	return nil
+}
+
+// newToken is a random lock token, telling this holder's lock from others
+func newToken() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
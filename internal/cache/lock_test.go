@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLock(t *testing.T) {
+	cache, mr := newTestCache(t, 0)
+	ctx := context.Background()
+	key := lockKey("ph:weather:test")
+
+	release, acquired := cache.Lock(ctx, "ph:weather:test")
+	if !acquired {
+		t.Fatal("first Lock wasn't acquired")
+	}
+	if !mr.Exists(key) {
+		t.Fatalf("%s not set", key)
+	}
+	if ttl := mr.TTL(key); ttl != lockTTL {
+		t.Errorf("lock TTL = %s, want %s", ttl, lockTTL)
+	}
+
+	if _, acquired := cache.Lock(ctx, "ph:weather:test"); acquired {
+		t.Error("second Lock was acquired while the lock is held")
+	}
+
+	release()
+	if mr.Exists(key) {
+		t.Error("release left the lock")
+	}
+	if release, acquired := cache.Lock(ctx, "ph:weather:test"); !acquired {
+		t.Error("Lock after release wasn't acquired")
+	} else {
+		release()
+	}
+}
+
+func TestLockReleaseKeepsOthers(t *testing.T) {
+	cache, mr := newTestCache(t, 0)
+	key := lockKey("ph:weather:test")
+
+	release, _ := cache.Lock(context.Background(), "ph:weather:test")
+
+	// our lock expired and another instance took it
+	mr.Set(key, "another-token")
+	release()
+
+	if got, _ := mr.Get(key); got != "another-token" {
+		t.Errorf("release removed another instance's lock, %s = %q", key, got)
+	}
+}
+
+func TestLockReleaseAfterCancel(t *testing.T) {
+	cache, mr := newTestCache(t, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	release, acquired := cache.Lock(ctx, "ph:weather:test")
+	if !acquired {
+		t.Fatal("Lock wasn't acquired")
+	}
+	cancel()
+	release()
+
+	if mr.Exists(lockKey("ph:weather:test")) {
+		t.Error("the lock outlived a cancelled request")
+	}
+}
+
+func TestAwaitRefresh(t *testing.T) {
+	cache, mr := newTestCache(t, 0)
+	ctx := context.Background()
+
+	// another instance holds the lock and fills the cache
+	mr.Set(lockKey("ph:weather:test"), "another-token")
+	if _, err := cache.Set(ctx, "ph:weather:test", "{}", lockTTL); err != nil {
+		t.Fatal(err)
+	}
+	if entry := cache.AwaitRefresh(ctx, "ph:weather:test"); entry == nil || entry.Body != "{}" {
+		t.Errorf("AwaitRefresh = %+v, want the cached entry", entry)
+	}
+
+	// the lock is released without an entry
+	mr.Del(lockKey("ph:weather:other"))
+	if entry := cache.AwaitRefresh(ctx, "ph:weather:other"); entry != nil {
+		t.Errorf("AwaitRefresh = %+v, want nil", entry)
+	}
+}
@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/tyrm/ph-weather/internal/config"
+	"github.com/tyrm/ph-weather/internal/tracing"
+)
+
+// RedisCommands are the Redis commands the service uses. *redis.Client
+// provides them, tests may use a client of miniredis or a fake.
+type RedisCommands interface {
+	Del(ctx context.Context, keys ...string) *redis.IntCmd
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd
+	Exists(ctx context.Context, keys ...string) *redis.IntCmd
+	Expire(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd
+	Get(ctx context.Context, key string) *redis.StringCmd
+	Incr(ctx context.Context, key string) *redis.IntCmd
+	Ping(ctx context.Context) *redis.StatusCmd
+	Publish(ctx context.Context, channel string, message interface{}) *redis.IntCmd
+	SCard(ctx context.Context, key string) *redis.IntCmd
+	SIsMember(ctx context.Context, key string, member interface{}) *redis.BoolCmd
+	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd
+	SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.BoolCmd
+	TTL(ctx context.Context, key string) *redis.DurationCmd
+	ZAdd(ctx context.Context, key string, members ...*redis.Z) *redis.IntCmd
+	ZRangeByScore(ctx context.Context, key string, opt *redis.ZRangeBy) *redis.StringSliceCmd
+	ZRemRangeByScore(ctx context.Context, key, min, max string) *redis.IntCmd
+}
+
+// NewRedisClient connects to REDIS_ADDR, tracing every command
+func NewRedisClient(config config.Config) *redis.Client {
+	client := redis.NewClient(&redis.Options{
+		Addr:     config.RedisAddr,
+		Username: config.RedisUsername,
+		Password: config.RedisPassword, // no password set
+		DB:       config.RedisDB,       // use default DB
+	})
+	client.AddHook(RedisTracing{Tracer: tracing.Tracer})
+	return client
+}
+
+// Miss reports whether err is go-redis's answer for a key that doesn't
+// exist, which callers treat as a miss rather than a failure. go-redis may
+// wrap it, so it's compared with errors.Is here rather than at each call.
+func Miss(err error) bool {
+	return errors.Is(err, redis.Nil)
+}
@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/tyrm/ph-weather/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RedisTracing is a go-redis hook that starts a client span for every
+// command, so each Redis round trip shows up under the request's span
+type RedisTracing struct {
+	Tracer trace.Tracer
+}
+
+func (hook RedisTracing) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	ctx, _ = hook.Tracer.Start(ctx, "redis "+cmd.Name(), trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("db.system", "redis"),
+		attribute.String("db.operation", cmd.Name()),
+		attribute.String("cache.key", redisCommandKey(cmd)),
+	))
+	return ctx, nil
+}
+
+func (hook RedisTracing) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	endRedisSpan(trace.SpanFromContext(ctx), cmd.Err())
+	return nil
+}
+
+func (hook RedisTracing) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	names := make([]string, len(cmds))
+	for i, cmd := range cmds {
+		names[i] = cmd.Name()
+	}
+	ctx, _ = hook.Tracer.Start(ctx, "redis pipeline", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("db.system", "redis"),
+		attribute.String("db.operation", strings.Join(names, " ")),
+	))
+	return ctx, nil
+}
+
+func (hook RedisTracing) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if err = cmd.Err(); err != nil && !Miss(err) {
+			break
+		}
+	}
+	endRedisSpan(trace.SpanFromContext(ctx), err)
+	return nil
+}
+
+// redisCommandKey is the key a command works on, if any. EVAL's first key
+// follows the script and key count.
+func redisCommandKey(cmd redis.Cmder) string {
+	args := cmd.Args()
+	keyPos := 1
+	if name := cmd.Name(); name == "eval" || name == "evalsha" {
+		keyPos = 3
+	}
+	if len(args) <= keyPos {
+		return ""
+	}
+	key, _ := args[keyPos].(string)
+	return key
+}
+
+// endRedisSpan ends a command's span, a miss isn't an error
+func endRedisSpan(span trace.Span, err error) {
+	if Miss(err) {
+		err = nil
+	}
+	tracing.EndSpan(span, err)
+}
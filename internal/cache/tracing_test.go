@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRedisTracingHook(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	client.AddHook(RedisTracing{Tracer: provider.Tracer("test")})
+	ctx := context.Background()
+
+	client.Set(ctx, "ph:sun_phase", "{}", time.Minute)
+	client.Get(ctx, "ph:sun_phase")
+	client.Get(ctx, "ph:missing")
+	client.Eval(ctx, "return 1", []string{"ph:lock"}, "token")
+	mr.Close()
+	client.Get(ctx, "ph:sun_phase")
+
+	want := []struct {
+		name   string
+		key    string
+		failed bool
+	}{
+		{"redis set", "ph:sun_phase", false},
+		{"redis get", "ph:sun_phase", false},
+		{"redis get", "ph:missing", false}, // a miss isn't an error
+		{"redis eval", "ph:lock", false},
+		{"redis get", "ph:sun_phase", true},
+	}
+	spans := recorder.Ended()
+	if len(spans) != len(want) {
+		t.Fatalf("recorded %d spans, want %d", len(spans), len(want))
+	}
+	for i, span := range spans {
+		if span.Name() != want[i].name {
+			t.Errorf("span %d name = %q, want %q", i, span.Name(), want[i].name)
+		}
+		for _, attr := range span.Attributes() {
+			if attr.Key == "cache.key" && attr.Value.AsString() != want[i].key {
+				t.Errorf("span %d cache.key = %q, want %q", i, attr.Value.AsString(), want[i].key)
+			}
+		}
+		if failed := span.Status().Code == codes.Error; failed != want[i].failed {
+			t.Errorf("span %d failed = %t, want %t", i, failed, want[i].failed)
+		}
+	}
+}
@@ -0,0 +1,752 @@
+// Package config collects ph-weather's settings from the environment and
+// the settings file taken alongside it, checking every one.
+package config
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tyrm/ph-weather/units"
+)
+
+// maxDefaultRedisDB is the highest database index of a stock Redis server
+const maxDefaultRedisDB = 15
+
+// defaultWUMaxBodyBytes bounds how much of a WU response is read
+const defaultWUMaxBodyBytes = 1 << 20
+
+// defaultPrewarmInterval is how long current conditions are cached, so by
+// default the pre-warmer refreshes them as they expire
+const defaultPrewarmInterval = 10 * time.Minute
+
+// Weather providers, set with WEATHER_PROVIDER
+const (
+	ProviderWU       = "wu"
+	ProviderMock     = "mock"
+	ProviderFixtures = "fixtures"
+)
+
+// Sun phase sources, set with SUN_PHASE_SOURCE
+const (
+	SunPhaseSourceWU       = "wu"
+	SunPhaseSourceComputed = "computed"
+)
+
+// WUFeatureMembers are the features WU_BUNDLE_FEATURES may name and the
+// top-level members of a WU response each contributes, used to split a
+// bundled response back into features
+var WUFeatureMembers = map[string][]string{
+	"alerts":     {"alerts"},
+	"astronomy":  {"moon_phase", "sun_phase"},
+	"conditions": {"current_observation"},
+	"geolookup":  {"location"},
+	"hourly":     {"hourly_forecast"},
+	"tide":       {"tide"},
+}
+
+// jsonapiVersionPattern matches a JSON:API version such as 1.0 or 1.1
+var jsonapiVersionPattern = regexp.MustCompile(`^\d+\.\d+$`)
+
+// Config is every setting, as Collect read it
+type Config struct {
+	AdminToken            string
+	AllowLocationOverride bool
+	APIKeys               []string
+	BasePath              string
+
+	CORSAllowedOrigins    []string
+
+	DefaultUnits          units.System
+	Environment           string
+
+	ErrorCatalogFile      string
+
+	HourlyTTL             time.Duration
+
+	HTTPAddr              string
+	HTTPPort              string
+	HTTPUserAgent         string
+	HSTS                  bool
+	TLSCertFile           string
+	TLSKeyFile            string
+	TrustProxy            bool
+
+	JSONAPIVersion        string
+
+	L1CacheSize           int
+
+	LocationAllowlist     []string
+	LocationCoordinates   *Coordinates
+	Locations             map[string]string
+	LocationTZ            *time.Location
+
+	MQTTBroker            string
+	MQTTCAFile            string
+	MQTTClientID          string
+	MQTTDiscoveryPrefix   string
+	MQTTPassword          string
+	MQTTTopicPrefix       string
+	MQTTUsername          string
+
+	ObservationRetention  time.Duration
+	PrewarmInterval       time.Duration
+	RateLimitAdmin        int
+	RateLimitWeather      int
+
+	RawPayloadTTL         time.Duration
+
+	RequestTimeout        time.Duration
+
+	RedisAddr             string
+	RedisDB               int
+	RedisEvents           bool
+	RedisPassword         string
+	RedisPrefix           string
+	RedisUsername         string
+
+	StaleTTL              time.Duration
+	StartupCheck          bool
+	StartupCheckFatal     bool
+
+	SunPhaseLegacyIDs     bool
+	SunPhasePastTTL       time.Duration
+	SunPhaseSource        string
+	SunPhaseTTL           time.Duration
+
+	UpstreamProxy         string
+	UpstreamProxyPassword string
+	UpstreamProxyUsername string
+	UpstreamRecordDir     string
+	UpstreamReplayDir     string
+
+	WeatherFixturesDir    string
+	WeatherMetricsFetch   bool
+	WeatherProvider       string
+
+	WebhookSunriseOffset  time.Duration
+	WebhookSunriseURL     string
+	WebhookSunsetOffset   time.Duration
+	WebhookSunsetURL      string
+
+	WUBreakerCooldown     time.Duration
+	WUBreakerFailures     int
+
+	WUBundleFeatures      []string
+	WUBundleTTL           time.Duration
+
+	WUDailyBudget         int
+	WUDailyBudgetWarn     int
+	WUMaxBodyBytes        int
+
+	WUndergroundKey       string
+	WUndergroundLocation  string
+}
+
+// TLSEnabled reports whether a certificate and key were configured
+func (config *Config) TLSEnabled() bool {
+	return config.TLSCertFile != "" && config.TLSKeyFile != ""
+}
+
+// KeyPrefix starts every Redis key: REDIS_PREFIX, then the ENVIRONMENT
+// segment when set so deployments can share a Redis
+func (config *Config) KeyPrefix() string {
+	if config.Environment == "" {
+		return config.RedisPrefix
+	}
+	return config.RedisPrefix + config.Environment + ":"
+}
+
+// RedisKey builds every Redis key the service uses from KeyPrefix and
+// colon separated parts, so the namespace stays the same across features
+func (config *Config) RedisKey(parts ...string) string {
+	return config.KeyPrefix() + strings.Join(parts, ":")
+}
+
+// Collect reads every setting, reporting all the problems it finds at once
+func Collect() (config Config, configError error) {
+	var missingEnv []string
+	var parseErrors []error
+	var err error
+
+	// CONFIG_FILE or --config, whose values environment variables override
+	if err = readConfigFile(); err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+
+	// DEFAULT_UNITS
+	var envDefaultUnits string = getEnv("DEFAULT_UNITS")
+
+	if envDefaultUnits == "" {
+		config.DefaultUnits = units.Metric
+	} else {
+		config.DefaultUnits, err = units.Parse(envDefaultUnits)
+		if err != nil {
+			parseErrors = append(parseErrors, fmt.Errorf("Error parsing DEFAULT_UNITS from %s: %s", configSource("DEFAULT_UNITS"), err))
+		}
+	}
+
+	// ENVIRONMENT
+	config.Environment = getEnv("ENVIRONMENT") // empty shares keys across deployments
+	if config.Environment != "" && !locationNamePattern.MatchString(config.Environment) {
+		parseErrors = append(parseErrors, fmt.Errorf("Error parsing ENVIRONMENT from %s: %q may only contain letters, digits, _ and -", configSource("ENVIRONMENT"), config.Environment))
+	}
+
+	// ERROR_CATALOG_FILE
+	config.ErrorCatalogFile = getEnv("ERROR_CATALOG_FILE") // empty uses the built in codes only
+
+	// HOURLY_TTL
+	config.HourlyTTL, err = getEnvDuration("HOURLY_TTL", 15*time.Minute)
+	if err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+
+	// HTTP_ADDR
+	config.HTTPAddr = getEnv("HTTP_ADDR") // empty binds all interfaces
+
+	// HTTP_PORT
+	var envHTTPPort string = getEnv("HTTP_PORT")
+
+	if envHTTPPort == "" {
+		config.HTTPPort = "8080"
+	} else {
+		config.HTTPPort = envHTTPPort
+	}
+
+	// HTTP_USER_AGENT
+	config.HTTPUserAgent = getEnv("HTTP_USER_AGENT")
+	if config.HTTPUserAgent == "" {
+		config.HTTPUserAgent = "ph-weather/" + Version
+	}
+
+	// TLS_CERT_FILE / TLS_KEY_FILE
+	config.TLSCertFile = getEnv("TLS_CERT_FILE")
+	config.TLSKeyFile = getEnv("TLS_KEY_FILE")
+	if config.TLSCertFile != "" && config.TLSKeyFile == "" {
+		missingEnv = append(missingEnv, "TLS_KEY_FILE")
+	} else if config.TLSCertFile == "" && config.TLSKeyFile != "" {
+		missingEnv = append(missingEnv, "TLS_CERT_FILE")
+	}
+
+	// HSTS
+	var envHSTS string = getEnv("HSTS")
+
+	if envHSTS != "" {
+		b, err := strconv.ParseBool(envHSTS)
+		if err != nil {
+			parseErrors = append(parseErrors, fmt.Errorf("Error parsing HSTS from %s: %s", configSource("HSTS"), err))
+		}
+		config.HSTS = b
+	}
+
+	// TRUST_PROXY, set behind a proxy that sets X-Forwarded-Proto and
+	// X-Forwarded-Host, which links are then built from
+	if envTrustProxy := getEnv("TRUST_PROXY"); envTrustProxy != "" {
+		b, err := strconv.ParseBool(envTrustProxy)
+		if err != nil {
+			parseErrors = append(parseErrors, fmt.Errorf("Error parsing TRUST_PROXY from %s: %s", configSource("TRUST_PROXY"), err))
+		}
+		config.TrustProxy = b
+	}
+
+	// ADMIN_TOKEN
+	config.AdminToken, err = getSecret("ADMIN_TOKEN") // empty disables admin operations
+	if err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+
+	// ALLOW_LOCATION_OVERRIDE
+	var envAllowLocationOverride string = getEnv("ALLOW_LOCATION_OVERRIDE")
+
+	if envAllowLocationOverride != "" {
+		b, err := strconv.ParseBool(envAllowLocationOverride)
+		if err != nil {
+			parseErrors = append(parseErrors, fmt.Errorf("Error parsing ALLOW_LOCATION_OVERRIDE from %s: %s", configSource("ALLOW_LOCATION_OVERRIDE"), err))
+		}
+		config.AllowLocationOverride = b
+	}
+
+	// API_KEYS
+	envAPIKeys, err := getSecret("API_KEYS")
+	if err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+	config.APIKeys = SplitList(envAPIKeys)
+
+	// HTTP_BASE_PATH, or BASE_PATH as it was first called
+	config.BasePath = normalizeBasePath(getEnv("HTTP_BASE_PATH"))
+	if legacyBasePath := normalizeBasePath(getEnv("BASE_PATH")); legacyBasePath != "" {
+		if config.BasePath == "" {
+			config.BasePath = legacyBasePath
+		} else if config.BasePath != legacyBasePath {
+			parseErrors = append(parseErrors, fmt.Errorf("Error parsing HTTP_BASE_PATH from %s: BASE_PATH is set to a different path", configSource("HTTP_BASE_PATH")))
+		}
+	}
+
+	// CORS_ALLOWED_ORIGINS
+	config.CORSAllowedOrigins = SplitList(getEnv("CORS_ALLOWED_ORIGINS"))
+
+	// JSONAPI_VERSION, "none" leaves the jsonapi member out
+	config.JSONAPIVersion = getEnv("JSONAPI_VERSION")
+	if config.JSONAPIVersion == "" {
+		config.JSONAPIVersion = "1.1"
+	} else if config.JSONAPIVersion == "none" {
+		config.JSONAPIVersion = ""
+	} else if !jsonapiVersionPattern.MatchString(config.JSONAPIVersion) {
+		parseErrors = append(parseErrors, fmt.Errorf("Error parsing JSONAPI_VERSION from %s: %q is not a version like 1.1", configSource("JSONAPI_VERSION"), config.JSONAPIVersion))
+	}
+
+	// L1_CACHE_SIZE
+	config.L1CacheSize, err = getEnvInt("L1_CACHE_SIZE", 512) // 0 disables the in-process cache
+	if err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+
+	// LOCATION_ALLOWLIST
+	for _, location := range SplitList(getEnv("LOCATION_ALLOWLIST")) {
+		normalized, err := NormalizeLocation(location)
+		if err != nil {
+			parseErrors = append(parseErrors, fmt.Errorf("Error parsing LOCATION_ALLOWLIST from %s: %s", configSource("LOCATION_ALLOWLIST"), err))
+			continue
+		}
+		config.LocationAllowlist = append(config.LocationAllowlist, normalized)
+	}
+
+	// LOCATIONS
+	config.Locations, err = ParseLocations(getEnv("LOCATIONS"))
+	if err != nil {
+		parseErrors = append(parseErrors, fmt.Errorf("Error parsing LOCATIONS from %s: %s", configSource("LOCATIONS"), err))
+	}
+
+	// LOCATION_LAT / LOCATION_LON
+	var envLocationLat string = getEnv("LOCATION_LAT")
+	var envLocationLon string = getEnv("LOCATION_LON")
+
+	if envLocationLat != "" || envLocationLon != "" {
+		config.LocationCoordinates, err = ParseCoordinates(envLocationLat, envLocationLon)
+		if err != nil {
+			parseErrors = append(parseErrors, fmt.Errorf("Error parsing LOCATION_LAT/LOCATION_LON from %s: %s", configSource("LOCATION_LAT"), err))
+		}
+	}
+
+	// LOCATION_TZ
+	var envLocationTZ string = getEnv("LOCATION_TZ")
+
+	if envLocationTZ == "" {
+		config.LocationTZ = time.Local
+	} else {
+		tz, err := time.LoadLocation(envLocationTZ)
+		if err != nil {
+			parseErrors = append(parseErrors, fmt.Errorf("Error parsing LOCATION_TZ from %s: %s", configSource("LOCATION_TZ"), err))
+			tz = time.Local
+		}
+		config.LocationTZ = tz
+	}
+
+	// MQTT_BROKER
+	config.MQTTBroker = getEnv("MQTT_BROKER") // empty disables MQTT, ssl:// brokers use TLS
+	config.MQTTCAFile = getEnv("MQTT_CA_FILE")
+	config.MQTTUsername = getEnv("MQTT_USERNAME")
+	config.MQTTPassword, err = getSecret("MQTT_PASSWORD")
+	if err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+
+	config.MQTTClientID = getEnv("MQTT_CLIENT_ID")
+	if config.MQTTClientID == "" {
+		config.MQTTClientID = "ph-weather"
+	}
+	config.MQTTTopicPrefix = strings.Trim(getEnv("MQTT_TOPIC_PREFIX"), "/")
+	if config.MQTTTopicPrefix == "" {
+		config.MQTTTopicPrefix = "ph-weather"
+	}
+	config.MQTTDiscoveryPrefix = strings.Trim(getEnv("MQTT_DISCOVERY_PREFIX"), "/")
+	if config.MQTTDiscoveryPrefix == "" {
+		config.MQTTDiscoveryPrefix = "homeassistant"
+	}
+
+	// OBSERVATION_RETENTION
+	config.ObservationRetention, err = getEnvDuration("OBSERVATION_RETENTION", 30*24*time.Hour)
+	if err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+
+	// PREWARM_INTERVAL, 0 disables the pre-warmer
+	if getEnv("PREWARM_INTERVAL") == "0" {
+		config.PrewarmInterval = 0
+	} else {
+		config.PrewarmInterval, err = getEnvDuration("PREWARM_INTERVAL", defaultPrewarmInterval)
+		if err != nil {
+			parseErrors = append(parseErrors, err)
+		}
+	}
+
+	// RATE_LIMIT_WEATHER
+	config.RateLimitWeather, err = getEnvInt("RATE_LIMIT_WEATHER", 0)
+	if err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+
+	// RATE_LIMIT_ADMIN
+	config.RateLimitAdmin, err = getEnvInt("RATE_LIMIT_ADMIN", 0)
+	if err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+
+	// RAW_PAYLOAD_TTL
+	config.RawPayloadTTL, err = getEnvDuration("RAW_PAYLOAD_TTL", time.Hour)
+	if err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+
+	// REQUEST_TIMEOUT bounds the whole of a request, streams excepted
+	config.RequestTimeout, err = getEnvDuration("REQUEST_TIMEOUT", 30*time.Second)
+	if err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+
+	// REDIS_ADDR
+	config.RedisAddr = getEnv("REDIS_ADDR")
+	if config.RedisAddr == "" {
+		missingEnv = append(missingEnv, "REDIS_ADDR")
+	}
+
+	// REDIS_PASSWORD
+	config.RedisPassword, err = getSecret("REDIS_PASSWORD")
+	if err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+
+	// REDIS_USERNAME, for servers with ACLs. Without it the password is for
+	// the default user.
+	config.RedisUsername = getEnv("REDIS_USERNAME")
+
+	// REDIS_DB
+	var envRedisDB string = getEnv("REDIS_DB")
+
+	if envRedisDB == "" {
+		config.RedisDB = 0
+	} else {
+		i, err := strconv.Atoi(envRedisDB)
+		if err != nil {
+			parseErrors = append(parseErrors, fmt.Errorf("Error parsing REDIS_DB from %s: %s", configSource("REDIS_DB"), err))
+		} else if i < 0 {
+			parseErrors = append(parseErrors, fmt.Errorf("Error parsing REDIS_DB from %s: %d is not a valid database index", configSource("REDIS_DB"), i))
+		} else {
+			if i > maxDefaultRedisDB {
+				log.Printf("REDIS_DB %d is above %d, make sure the server's databases setting allows it", i, maxDefaultRedisDB)
+			}
+			config.RedisDB = i
+		}
+	}
+
+	// REDIS_EVENTS
+	var envRedisEvents string = getEnv("REDIS_EVENTS")
+
+	if envRedisEvents != "" {
+		b, err := strconv.ParseBool(envRedisEvents)
+		if err != nil {
+			parseErrors = append(parseErrors, fmt.Errorf("Error parsing REDIS_EVENTS from %s: %s", configSource("REDIS_EVENTS"), err))
+		}
+		config.RedisEvents = b
+	}
+
+	// REDIS_PREFIX
+	var envRedisPrefix string = getEnv("REDIS_PREFIX")
+
+	if envRedisPrefix == "" {
+		config.RedisPrefix = "ph:"
+	} else {
+		config.RedisPrefix = envRedisPrefix
+	}
+
+	// STALE_TTL
+	config.StaleTTL, err = getEnvDuration("STALE_TTL", 30*24*time.Hour)
+	if err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+
+	// STARTUP_CHECK
+	var envStartupCheck string = getEnv("STARTUP_CHECK")
+
+	config.StartupCheck = true
+	if envStartupCheck != "" {
+		b, err := strconv.ParseBool(envStartupCheck)
+		if err != nil {
+			parseErrors = append(parseErrors, fmt.Errorf("Error parsing STARTUP_CHECK from %s: %s", configSource("STARTUP_CHECK"), err))
+		} else {
+			config.StartupCheck = b
+		}
+	}
+
+	// STARTUP_CHECK_FATAL
+	var envStartupCheckFatal string = getEnv("STARTUP_CHECK_FATAL")
+
+	if envStartupCheckFatal != "" {
+		b, err := strconv.ParseBool(envStartupCheckFatal)
+		if err != nil {
+			parseErrors = append(parseErrors, fmt.Errorf("Error parsing STARTUP_CHECK_FATAL from %s: %s", configSource("STARTUP_CHECK_FATAL"), err))
+		}
+		config.StartupCheckFatal = b
+	}
+
+	// SUN_PHASE_SOURCE
+	config.SunPhaseSource = getEnv("SUN_PHASE_SOURCE")
+	if config.SunPhaseSource == "" {
+		config.SunPhaseSource = SunPhaseSourceWU
+	} else if config.SunPhaseSource != SunPhaseSourceWU && config.SunPhaseSource != SunPhaseSourceComputed {
+		parseErrors = append(parseErrors, fmt.Errorf("Error parsing SUN_PHASE_SOURCE from %s: %q is not wu or computed", configSource("SUN_PHASE_SOURCE"), config.SunPhaseSource))
+	}
+
+	// SUN_PHASE_LEGACY_IDS keeps the ids sun phases had before
+	// sun_phase:<date>:<location>, while consumers migrate
+	if envSunPhaseLegacyIDs := getEnv("SUN_PHASE_LEGACY_IDS"); envSunPhaseLegacyIDs != "" {
+		b, err := strconv.ParseBool(envSunPhaseLegacyIDs)
+		if err != nil {
+			parseErrors = append(parseErrors, fmt.Errorf("Error parsing SUN_PHASE_LEGACY_IDS from %s: %s", configSource("SUN_PHASE_LEGACY_IDS"), err))
+		}
+		config.SunPhaseLegacyIDs = b
+	}
+
+	// SUN_PHASE_TTL / SUN_PHASE_PAST_TTL
+	config.SunPhaseTTL, err = getEnvDuration("SUN_PHASE_TTL", 6*time.Hour)
+	if err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+	config.SunPhasePastTTL, err = getEnvDuration("SUN_PHASE_PAST_TTL", 30*24*time.Hour)
+	if err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+
+	// UPSTREAM_PROXY, taking precedence over HTTP_PROXY and HTTPS_PROXY
+	config.UpstreamProxyUsername = getEnv("UPSTREAM_PROXY_USERNAME")
+	config.UpstreamProxyPassword, err = getSecret("UPSTREAM_PROXY_PASSWORD")
+	if err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+
+	if envUpstreamProxy := getEnv("UPSTREAM_PROXY"); envUpstreamProxy != "" {
+		proxy, username, password, err := parseUpstreamProxy(envUpstreamProxy)
+		if err != nil {
+			parseErrors = append(parseErrors, fmt.Errorf("Error parsing UPSTREAM_PROXY from %s: %s", configSource("UPSTREAM_PROXY"), err))
+		}
+		config.UpstreamProxy = proxy
+		if username != "" && config.UpstreamProxyUsername == "" {
+			config.UpstreamProxyUsername, config.UpstreamProxyPassword = username, password
+		}
+	} else if config.UpstreamProxyUsername != "" || config.UpstreamProxyPassword != "" {
+		missingEnv = append(missingEnv, "UPSTREAM_PROXY")
+	}
+
+	// UPSTREAM_RECORD_DIR / UPSTREAM_REPLAY_DIR
+	config.UpstreamRecordDir = getEnv("UPSTREAM_RECORD_DIR")
+	config.UpstreamReplayDir = getEnv("UPSTREAM_REPLAY_DIR")
+	if config.UpstreamRecordDir != "" && config.UpstreamReplayDir != "" {
+		parseErrors = append(parseErrors, fmt.Errorf("Error parsing UPSTREAM_RECORD_DIR from %s: UPSTREAM_REPLAY_DIR is also set", configSource("UPSTREAM_RECORD_DIR")))
+	}
+	for name, dir := range map[string]string{
+		"UPSTREAM_RECORD_DIR": config.UpstreamRecordDir,
+		"UPSTREAM_REPLAY_DIR": config.UpstreamReplayDir,
+	} {
+		if dir != "" {
+			if err := checkRecordingDir(name, dir); err != nil {
+				parseErrors = append(parseErrors, err)
+			}
+		}
+	}
+
+	// WEATHER_PROVIDER / WEATHER_FIXTURES_DIR
+	config.WeatherProvider = getEnv("WEATHER_PROVIDER")
+	config.WeatherFixturesDir = getEnv("WEATHER_FIXTURES_DIR")
+	switch config.WeatherProvider {
+	case "":
+		config.WeatherProvider = ProviderWU
+	case ProviderWU, ProviderMock:
+	case ProviderFixtures:
+		if config.WeatherFixturesDir == "" {
+			missingEnv = append(missingEnv, "WEATHER_FIXTURES_DIR")
+		}
+	default:
+		parseErrors = append(parseErrors, fmt.Errorf("Error parsing WEATHER_PROVIDER from %s: %q is not wu, mock or fixtures", configSource("WEATHER_PROVIDER"), config.WeatherProvider))
+	}
+
+	// WEATHER_METRICS_FETCH
+	var envWeatherMetricsFetch string = getEnv("WEATHER_METRICS_FETCH")
+
+	if envWeatherMetricsFetch != "" {
+		b, err := strconv.ParseBool(envWeatherMetricsFetch)
+		if err != nil {
+			parseErrors = append(parseErrors, fmt.Errorf("Error parsing WEATHER_METRICS_FETCH from %s: %s", configSource("WEATHER_METRICS_FETCH"), err))
+		}
+		config.WeatherMetricsFetch = b
+	}
+
+	// WEBHOOK_SUNRISE_URL / WEBHOOK_SUNSET_URL
+	config.WebhookSunriseURL = getEnv("WEBHOOK_SUNRISE_URL")
+	config.WebhookSunsetURL = getEnv("WEBHOOK_SUNSET_URL")
+
+	// WEBHOOK_SUNRISE_OFFSET / WEBHOOK_SUNSET_OFFSET, negative fires early
+	for name, offset := range map[string]*time.Duration{
+		"WEBHOOK_SUNRISE_OFFSET": &config.WebhookSunriseOffset,
+		"WEBHOOK_SUNSET_OFFSET":  &config.WebhookSunsetOffset,
+	} {
+		if value := getEnv(name); value != "" {
+			*offset, err = time.ParseDuration(value)
+			if err != nil {
+				parseErrors = append(parseErrors, fmt.Errorf("Error parsing %s from %s: %s", name, configSource(name), err))
+			}
+		}
+	}
+
+	// WU_BREAKER_FAILURES, 0 disables the circuit breaker
+	config.WUBreakerFailures, err = getEnvInt("WU_BREAKER_FAILURES", 5)
+	if err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+
+	// WU_BREAKER_COOLDOWN
+	config.WUBreakerCooldown, err = getEnvDuration("WU_BREAKER_COOLDOWN", 30*time.Second)
+	if err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+
+	// WU_BUNDLE_FEATURES
+	config.WUBundleFeatures = SplitList(getEnv("WU_BUNDLE_FEATURES"))
+	for _, feature := range config.WUBundleFeatures {
+		if WUFeatureMembers[feature] == nil {
+			parseErrors = append(parseErrors, fmt.Errorf("Error parsing WU_BUNDLE_FEATURES from %s: unknown feature %q", configSource("WU_BUNDLE_FEATURES"), feature))
+		}
+	}
+
+	// WU_BUNDLE_TTL
+	config.WUBundleTTL, err = getEnvDuration("WU_BUNDLE_TTL", 5*time.Minute)
+	if err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+
+	// WU_DAILY_BUDGET
+	config.WUDailyBudget, err = getEnvInt("WU_DAILY_BUDGET", 0)
+	if err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+
+	// WU_DAILY_BUDGET_WARN
+	config.WUDailyBudgetWarn, err = getEnvInt("WU_DAILY_BUDGET_WARN", 0)
+	if err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+
+	// WU_MAX_BODY_BYTES
+	config.WUMaxBodyBytes, err = getEnvInt("WU_MAX_BODY_BYTES", defaultWUMaxBodyBytes)
+	if err != nil {
+		parseErrors = append(parseErrors, err)
+	} else if config.WUMaxBodyBytes == 0 {
+		parseErrors = append(parseErrors, fmt.Errorf("Error parsing WU_MAX_BODY_BYTES from %s: must be positive", configSource("WU_MAX_BODY_BYTES")))
+	}
+
+	// WU_KEY
+	config.WUndergroundKey, err = getSecret("WU_KEY")
+	if err != nil {
+		parseErrors = append(parseErrors, err)
+	} else if config.WUndergroundKey == "" && config.WeatherProvider == ProviderWU && config.UpstreamReplayDir == "" {
+		missingEnv = append(missingEnv, "WU_KEY")
+	}
+
+	// WU_LOCATION
+	config.WUndergroundLocation = getEnv("WU_LOCATION")
+	if config.WUndergroundLocation == "" {
+		missingEnv = append(missingEnv, "WU_LOCATION")
+	}
+
+	// Validation, reporting every problem at once
+	if unknown := unknownConfigKeys(); len(unknown) > 0 {
+		parseErrors = append(parseErrors, fmt.Errorf("Unknown settings in %s: %v", FilePath, unknown))
+	}
+	if len(missingEnv) > 0 {
+		if FilePath != "" {
+			parseErrors = append([]error{fmt.Errorf("Settings missing from the environment and %s: %v", FilePath, missingEnv)}, parseErrors...)
+		} else {
+			parseErrors = append([]error{fmt.Errorf("Environment variables missing: %v", missingEnv)}, parseErrors...)
+		}
+	}
+	if len(parseErrors) > 0 {
+		configError = errorList(parseErrors)
+	}
+
+	return
+}
+
+// normalizeBasePath returns "" or a path with a leading slash and no trailing slash
+func normalizeBasePath(basePath string) string {
+	basePath = strings.Trim(strings.TrimSpace(basePath), "/")
+	if basePath == "" {
+		return ""
+	}
+	return "/" + basePath
+}
+
+// SplitList splits a comma-separated value, dropping empty entries
+func SplitList(value string) (list []string) {
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return
+}
+
+// getEnvInt reads a non-negative integer from the environment
+func getEnvInt(name string, defaultValue int) (value int, resError error) {
+	var env string = getEnv(name)
+
+	if env == "" {
+		value = defaultValue
+		return
+	}
+
+	value, resError = strconv.Atoi(env)
+	if resError != nil {
+		resError = fmt.Errorf("Error parsing %s from %s: %s", name, configSource(name), resError)
+		return
+	}
+	if value < 0 {
+		resError = fmt.Errorf("Error parsing %s from %s: must not be negative", name, configSource(name))
+	}
+	return
+}
+
+// getEnvDuration reads a positive duration such as 15m from the environment
+func getEnvDuration(name string, defaultValue time.Duration) (value time.Duration, resError error) {
+	var env string = getEnv(name)
+
+	if env == "" {
+		value = defaultValue
+		return
+	}
+
+	value, resError = time.ParseDuration(env)
+	if resError != nil {
+		resError = fmt.Errorf("Error parsing %s from %s: %s", name, configSource(name), resError)
+		return
+	}
+	if value <= 0 {
+		resError = fmt.Errorf("Error parsing %s from %s: must be positive", name, configSource(name))
+	}
+	return
+}
+
+// errorList is every problem Collect found, reported together so one run
+// shows everything to fix
+type errorList []error
+
+func (errs errorList) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
@@ -0,0 +1,199 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setConfigEnv sets the required settings and then settings, "" leaving
+// one unset as far as Collect is concerned
+func setConfigEnv(t *testing.T, settings map[string]string) {
+	t.Helper()
+	FilePath = ""
+	t.Cleanup(func() { FilePath = "" })
+
+	base := map[string]string{
+		"CONFIG_FILE": "",
+		"REDIS_ADDR":  "localhost:6379",
+		"WU_KEY":      "wu-test-key-0123",
+		"WU_LOCATION": "PA/Philadelphia",
+	}
+	for name, value := range settings {
+		base[name] = value
+	}
+	for name, value := range base {
+		t.Setenv(name, value)
+	}
+}
+
+func TestCollectInvalid(t *testing.T) {
+	for name, value := range map[string]string{
+		"REDIS_DB":         "zero",
+		"SUN_PHASE_TTL":    "soon",
+		"STALE_TTL":        "-1h",
+		"L1_CACHE_SIZE":    "-5",
+		"LOCATION_TZ":      "Mars/Olympus_Mons",
+		"LOCATION_LAT":     "north",
+		"WEATHER_PROVIDER": "almanac",
+		"SUN_PHASE_SOURCE": "guess",
+		"HSTS":             "sometimes",
+	} {
+		t.Run(name, func(t *testing.T) {
+			setConfigEnv(t, map[string]string{name: value})
+
+			// an invalid value is an error to report, never a panic
+			_, err := Collect()
+			if err == nil {
+				t.Fatalf("%s=%s accepted", name, value)
+			}
+			if !strings.Contains(err.Error(), name) {
+				t.Errorf("error doesn't name %s: %s", name, err)
+			}
+		})
+	}
+}
+
+func TestCollectDefaults(t *testing.T) {
+	setConfigEnv(t, nil)
+
+	config, err := Collect()
+	if err != nil {
+		t.Fatalf("Collect: %s", err)
+	}
+	if config.WeatherProvider != ProviderWU || config.RedisPrefix == "" || config.LocationTZ == nil {
+		t.Errorf("defaults = provider %q, prefix %q, zone %v", config.WeatherProvider, config.RedisPrefix, config.LocationTZ)
+	}
+	if config.RequestTimeout <= 0 || config.SunPhaseTTL <= 0 || config.StaleTTL <= 0 {
+		t.Errorf("default durations = %s, %s, %s, want positive", config.RequestTimeout, config.SunPhaseTTL, config.StaleTTL)
+	}
+}
+
+func TestCollectProviders(t *testing.T) {
+	// only WU needs a key
+	setConfigEnv(t, map[string]string{"WU_KEY": "", "WEATHER_PROVIDER": "mock"})
+	if _, err := Collect(); err != nil {
+		t.Errorf("mock provider without WU_KEY: %s", err)
+	}
+
+	setConfigEnv(t, map[string]string{"WU_KEY": "", "WEATHER_PROVIDER": "wu"})
+	if _, err := Collect(); err == nil || !strings.Contains(err.Error(), "WU_KEY") {
+		t.Errorf("wu provider without WU_KEY: %v", err)
+	}
+
+	setConfigEnv(t, map[string]string{"WEATHER_PROVIDER": "fixtures", "WEATHER_FIXTURES_DIR": ""})
+	if _, err := Collect(); err == nil || !strings.Contains(err.Error(), "WEATHER_FIXTURES_DIR") {
+		t.Errorf("fixtures provider without WEATHER_FIXTURES_DIR: %v", err)
+	}
+}
+
+func TestCollectSecretFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wu_key")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	setConfigEnv(t, map[string]string{"WU_KEY": "from-env", "WU_KEY_FILE": path})
+
+	config, err := Collect()
+	if err != nil {
+		t.Fatalf("Collect: %s", err)
+	}
+	if config.WUndergroundKey != "from-file" {
+		t.Errorf("WU_KEY = %q, want the file's from-file", config.WUndergroundKey)
+	}
+
+	setConfigEnv(t, map[string]string{"WU_KEY_FILE": filepath.Join(t.TempDir(), "missing")})
+	if _, err := Collect(); err == nil || !strings.Contains(err.Error(), "WU_KEY_FILE") {
+		t.Errorf("missing WU_KEY_FILE: %v", err)
+	}
+}
+
+func TestCollectFileAndEnvironment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	contents := "HTTP_PORT: 8080\nREDIS_PREFIX: \"file:\"\n"
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setConfigEnv(t, map[string]string{"CONFIG_FILE": path, "REDIS_PREFIX": "env:"})
+
+	config, err := Collect()
+	if err != nil {
+		t.Fatalf("Collect: %s", err)
+	}
+	// the environment overrides the file
+	if config.RedisPrefix != "env:" {
+		t.Errorf("REDIS_PREFIX = %q, want the environment's env:", config.RedisPrefix)
+	}
+	if config.HTTPPort != "8080" {
+		t.Errorf("HTTP_PORT = %q, want the file's 8080", config.HTTPPort)
+	}
+}
+
+func TestCollectReportsEverything(t *testing.T) {
+	setConfigEnv(t, map[string]string{
+		"REDIS_ADDR":    "",
+		"WU_LOCATION":   "",
+		"REDIS_DB":      "zero",
+		"SUN_PHASE_TTL": "soon",
+		"LOCATION_TZ":   "Mars/Olympus_Mons",
+	})
+
+	_, err := Collect()
+	var errs errorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("Collect = %v, want errorList", err)
+	}
+	if len(errs) != 4 {
+		t.Errorf("%d problems reported, want the missing settings and 3 invalid ones: %s", len(errs), err)
+	}
+	if missing := errs[0].Error(); !strings.Contains(missing, "REDIS_ADDR") || !strings.Contains(missing, "WU_LOCATION") {
+		t.Errorf("first problem = %s, want both missing settings", missing)
+	}
+	for _, name := range []string{"REDIS_DB", "SUN_PHASE_TTL", "LOCATION_TZ"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("%s not reported: %s", name, err)
+		}
+	}
+}
+
+func TestCollectErrorOrder(t *testing.T) {
+	setConfigEnv(t, map[string]string{
+		"STARTUP_CHECK":       "maybe",
+		"STARTUP_CHECK_FATAL": "sometimes",
+		"SUN_PHASE_SOURCE":    "moon",
+	})
+
+	_, first := Collect()
+	if first == nil {
+		t.Fatal("Collect accepted invalid startup checks")
+	}
+	check, fatal := strings.Index(first.Error(), "STARTUP_CHECK from"), strings.Index(first.Error(), "STARTUP_CHECK_FATAL")
+	if check < 0 || fatal < check {
+		t.Errorf("STARTUP_CHECK isn't reported before STARTUP_CHECK_FATAL: %s", first)
+	}
+	for i := 0; i < 20; i++ {
+		if _, err := Collect(); err.Error() != first.Error() {
+			t.Fatalf("errors changed order:\n%s\n%s", first, err)
+		}
+	}
+}
+
+func TestCollectUnknownFileKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("REDIS_PREFX: \"ph:\"\nREDIS_DB: zero\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setConfigEnv(t, map[string]string{"CONFIG_FILE": path, "WU_KEY": ""})
+
+	_, err := Collect()
+	if err == nil {
+		t.Fatal("Collect accepted a typo")
+	}
+	for _, want := range []string{"REDIS_PREFX", "REDIS_DB from " + path, "missing from the environment and " + path} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error doesn't mention %q: %s", want, err)
+		}
+	}
+}
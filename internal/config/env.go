@@ -0,0 +1,100 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// FilePath is the file given with --config, CONFIG_FILE otherwise
+var FilePath string
+
+// configFileValues holds the settings read from the config file by Collect
+var configFileValues map[string]string
+
+// configNamesRead records every setting Collect looks up, so keys in
+// the config file it never read can be reported as unknown
+var configNamesRead = map[string]bool{}
+
+// getEnv returns a setting from the environment, falling back to the config file
+func getEnv(name string) string {
+	configNamesRead[name] = true
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return configFileValues[name]
+}
+
+// getSecret returns a sensitive setting, either set directly or read from
+// the file named by its _FILE variant, such as a mounted Docker or
+// Kubernetes secret. The file wins when both are set, so a secret can be
+// moved to a file before the variable is removed. Trailing whitespace is
+// trimmed from the file. Errors never include the secret itself.
+func getSecret(name string) (value string, resError error) {
+	value = getEnv(name)
+	path := getEnv(name + "_FILE")
+	if path == "" {
+		return
+	}
+	if value != "" {
+		log.Printf("%s and %s_FILE are both set, using %s_FILE", name, name, name)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		value = ""
+		resError = fmt.Errorf("Error reading %s_FILE: %s", name, err)
+		return
+	}
+	value = strings.TrimRightFunc(string(data), unicode.IsSpace)
+	return
+}
+
+// configSource names where a setting's value came from, for error messages
+func configSource(name string) string {
+	if os.Getenv(name+"_FILE") != "" || configFileValues[name+"_FILE"] != "" {
+		return name + "_FILE"
+	}
+	if _, ok := os.LookupEnv(name); ok {
+		return "environment"
+	}
+	if _, ok := configFileValues[name]; ok {
+		return FilePath
+	}
+	return "default"
+}
+
+// readConfigFile loads CONFIG_FILE, or the file given with --config, whose
+// values environment variables override
+func readConfigFile() (resError error) {
+	configFileValues = nil
+	configNamesRead = map[string]bool{}
+	if FilePath == "" {
+		FilePath = os.Getenv("CONFIG_FILE")
+	}
+	if FilePath == "" {
+		return
+	}
+
+	configFileValues, resError = LoadFile(FilePath)
+	if resError != nil {
+		resError = fmt.Errorf("Error reading %s: %s", FilePath, resError)
+	}
+	return
+}
+
+// unknownConfigKeys returns the config file keys Collect never read,
+// most likely typos
+func unknownConfigKeys() (unknown []string) {
+	for name := range configFileValues {
+		if !configNamesRead[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ExtractFlag removes --config path or --config=path from args
+func ExtractFlag(args []string) (path string, rest []string, resError error) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--config" || arg == "-config":
+			if i+1 == len(args) {
+				resError = fmt.Errorf("%s needs a file path", arg)
+				return
+			}
+			path = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--config="):
+			path = strings.TrimPrefix(arg, "--config=")
+		case strings.HasPrefix(arg, "-config="):
+			path = strings.TrimPrefix(arg, "-config=")
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return
+}
+
+// LoadFile reads a JSON or YAML file, chosen by extension, keyed by the
+// environment variable names, for example
+//
+//	WU_KEY: abc123
+//	LOCATIONS: [home:PHL, cabin:pws:KPAPOCON2]
+//
+// Lists are joined with commas like the environment variables expect.
+func LoadFile(path string) (values map[string]string, resError error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		resError = err
+		return
+	}
+
+	var raw map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		// numbers are kept as written, float64 would turn 2000000 into 2e+06
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err = decoder.Decode(&raw)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	default:
+		err = fmt.Errorf("unknown format, use .json, .yaml or .yml")
+	}
+	if err != nil {
+		resError = err
+		return
+	}
+
+	values = make(map[string]string)
+	for name, value := range raw {
+		switch v := value.(type) {
+		case nil:
+			values[name] = ""
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fileValue(item)
+			}
+			values[name] = strings.Join(items, ",")
+		case map[string]interface{}, map[interface{}]interface{}:
+			resError = fmt.Errorf("%s must be a value or a list", name)
+			return
+		default:
+			values[name] = fileValue(v)
+		}
+	}
+	return
+}
+
+// fileValue formats a scalar from the config file as it would be set in the
+// environment. YAML floats are written out in full rather than in exponent
+// form, which integer settings couldn't be parsed from.
+func fileValue(value interface{}) string {
+	if f, ok := value.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadFileLargeIntegers(t *testing.T) {
+	for name, contents := range map[string]string{
+		"config.json": `{"WU_MAX_BODY_BYTES": 2000000, "LOCATIONS": ["home:PHL", 12000000]}`,
+		"config.yaml": "WU_MAX_BODY_BYTES: 2000000\nLOCATIONS: [home:PHL, 12000000]\n",
+		"float.yaml":  "WU_MAX_BODY_BYTES: 2000000.0\nLOCATIONS: [home:PHL, 12000000]\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			values, err := LoadFile(path)
+			if err != nil {
+				t.Fatalf("LoadFile: %s", err)
+			}
+			if got := values["WU_MAX_BODY_BYTES"]; got != "2000000" {
+				t.Errorf("WU_MAX_BODY_BYTES = %q, want 2000000", got)
+			}
+			if got := values["LOCATIONS"]; got != "home:PHL,12000000" {
+				t.Errorf("LOCATIONS = %q, want home:PHL,12000000", got)
+			}
+		})
+	}
+}
+
+func TestLoadFileRejectsObjects(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"LOCATIONS": {"home": "PHL"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFile(path); err == nil {
+		t.Error("LoadFile accepted an object value")
+	}
+}
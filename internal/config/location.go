@@ -0,0 +1,86 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const maxLocationLength = 64
+
+var (
+	locationPattern     = regexp.MustCompile(`^[A-Za-z0-9_.,:/-]+$`)
+	locationNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// ParseLocations parses name:query pairs such as home:PHL,cabin:pws:KPAPOCON2
+func ParseLocations(value string) (locations map[string]string, resError error) {
+	locations = make(map[string]string)
+
+	for _, item := range SplitList(value) {
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 || !locationNamePattern.MatchString(parts[0]) {
+			resError = fmt.Errorf("%q is not a name:location pair", item)
+			return
+		}
+		query, err := NormalizeLocation(parts[1])
+		if err != nil {
+			resError = err
+			return
+		}
+		locations[parts[0]] = query
+	}
+	return
+}
+
+// NormalizeLocation checks a WU location query such as CA/San_Francisco,
+// 94107 or pws:KCASANFR70
+func NormalizeLocation(location string) (normalized string, resError error) {
+	normalized = strings.Trim(strings.TrimSpace(location), "/")
+	if normalized == "" || len(normalized) > maxLocationLength || !locationPattern.MatchString(normalized) || strings.Contains(normalized, "..") {
+		resError = fmt.Errorf("location %q is not a valid location", location)
+	}
+	return
+}
+
+// Coordinates of a location in degrees
+type Coordinates struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// ParseCoordinates parses a latitude and longitude given as text
+func ParseCoordinates(lat string, lon string) (coordinates *Coordinates, resError error) {
+	latitude, err := ParseCoordinate(lat, 90)
+	if err != nil {
+		resError = fmt.Errorf("latitude %s", err)
+		return
+	}
+	longitude, err := ParseCoordinate(lon, 180)
+	if err != nil {
+		resError = fmt.Errorf("longitude %s", err)
+		return
+	}
+
+	coordinates = &Coordinates{Latitude: latitude, Longitude: longitude}
+	return
+}
+
+// ParseCoordinate parses a latitude or longitude bounded by ±limit
+func ParseCoordinate(value string, limit float64) (coordinate float64, resError error) {
+	if value == "" {
+		resError = fmt.Errorf("is required")
+		return
+	}
+	coordinate, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(coordinate) {
+		resError = fmt.Errorf("must be a number")
+		return
+	}
+	if coordinate < -limit || coordinate > limit {
+		resError = fmt.Errorf("must be between %g and %g", -limit, limit)
+	}
+	return
+}
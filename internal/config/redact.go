@@ -0,0 +1,78 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+)
+
+// secretConfigFields are redacted wherever the configuration is shown,
+// mapped to the setting they are read from
+var secretConfigFields = map[string]string{
+	"AdminToken":            "ADMIN_TOKEN",
+	"APIKeys":               "API_KEYS",
+	"MQTTPassword":          "MQTT_PASSWORD",
+	"RedisPassword":         "REDIS_PASSWORD",
+	"UpstreamProxyPassword": "UPSTREAM_PROXY_PASSWORD",
+	"WUndergroundKey":       "WU_KEY",
+}
+
+// Redacted returns the configuration by field name with secrets masked and
+// durations and timezones in their readable form
+func (config Config) Redacted() map[string]interface{} {
+	fields := map[string]interface{}{}
+
+	value := reflect.ValueOf(config)
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Name
+		field := value.Field(i).Interface()
+
+		switch field := field.(type) {
+		case string:
+			if setting, ok := secretConfigFields[name]; ok {
+				fields[name] = redactSecret(field, setting)
+				continue
+			}
+		case []string:
+			if setting, ok := secretConfigFields[name]; ok {
+				masked := make([]string, len(field))
+				for j, secret := range field {
+					masked[j] = redactSecret(secret, setting)
+				}
+				fields[name] = masked
+				continue
+			}
+		case time.Duration:
+			fields[name] = field.String()
+			continue
+		case *time.Location:
+			if field != nil {
+				fields[name] = field.String()
+				continue
+			}
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+// redactSecret hides a secret entirely, naming only the variable it was
+// read from so operators can tell which one is in effect
+func redactSecret(secret string, setting string) string {
+	if secret == "" {
+		return ""
+	}
+	if source := configSource(setting); source == setting+"_FILE" {
+		setting = source
+	}
+	return "*** (" + setting + ")"
+}
+
+// RedactedJSON is the configuration logged at startup
+func (config Config) RedactedJSON() string {
+	body, err := json.Marshal(config.Redacted())
+	if err != nil {
+		return err.Error()
+	}
+	return string(body)
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// parseUpstreamProxy checks UPSTREAM_PROXY, returning it without any
+// user:password@ so the credentials are only kept where they're redacted
+func parseUpstreamProxy(value string) (proxy string, username string, password string, resError error) {
+	// url.Parse errors quote the value, which may hold a password
+	proxyURL, err := url.Parse(value)
+	if err != nil {
+		resError = fmt.Errorf("not a valid proxy URL")
+		return
+	}
+	if proxyURL.Host == "" || (proxyURL.Scheme != "http" && proxyURL.Scheme != "https" && proxyURL.Scheme != "socks5") {
+		resError = fmt.Errorf("%q is not an http://, https:// or socks5:// proxy URL", proxyURL.Redacted())
+		return
+	}
+
+	if proxyURL.User != nil {
+		username = proxyURL.User.Username()
+		password, _ = proxyURL.User.Password()
+		proxyURL.User = nil
+	}
+	proxy = proxyURL.String()
+	return
+}
+
+// checkRecordingDir makes sure a record or replay directory exists
+func checkRecordingDir(name string, dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("Error parsing %s from %s: %s", name, configSource(name), err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("Error parsing %s from %s: %s is not a directory", name, configSource(name), dir)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package config
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Build information, set at build time with -ldflags
+// "-X github.com/tyrm/ph-weather/internal/config.Version=..." and likewise
+// for Commit and BuildDate
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// VersionString is the one line --version and the startup log print
+func VersionString() string {
+	return fmt.Sprintf("ph-weather %s (commit %s, built %s, %s)", Version, Commit, BuildDate, runtime.Version())
+}
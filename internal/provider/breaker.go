@@ -0,0 +1,121 @@
+package provider
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/status"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+var wuBreakerOpens = expvar.NewInt("wu_breaker_opens")
+
+// circuitBreaker stops calling an upstream after consecutive failures. Once
+// open it refuses calls for the cooldown, then lets a single trial call
+// through: success closes it, failure opens it for another cooldown. The
+// state is per instance, the WU breaker lives on Provider.
+type circuitBreaker struct {
+	sync.Mutex
+	failures int
+	openedAt time.Time
+	trial    bool // a half-open trial call is in flight
+}
+
+// BreakerState is the WU breaker's state now, closed, open or half_open
+func (provider *Provider) BreakerState() string {
+	return provider.breaker.state(provider.now(), provider.config().WUBreakerCooldown)
+}
+
+// PublishBreakerState reports the WU breaker as the wu_breaker_state
+// expvar. It can only be called once.
+func (provider *Provider) PublishBreakerState() {
+	expvar.Publish("wu_breaker_state", expvar.Func(func() interface{} {
+		return provider.BreakerState()
+	}))
+}
+
+// state is closed, open or half_open at now
+func (breaker *circuitBreaker) state(now time.Time, cooldown time.Duration) string {
+	breaker.Lock()
+	defer breaker.Unlock()
+	return breaker.stateLocked(now, cooldown)
+}
+
+func (breaker *circuitBreaker) stateLocked(now time.Time, cooldown time.Duration) string {
+	if breaker.openedAt.IsZero() {
+		return BreakerClosed
+	}
+	if now.Sub(breaker.openedAt) < cooldown {
+		return BreakerOpen
+	}
+	return BreakerHalfOpen
+}
+
+// allow refuses a call with a 503 while the breaker is open or its trial
+// call is in flight, telling the client when to retry. A threshold of 0
+// disables the breaker.
+func (breaker *circuitBreaker) allow(now time.Time, threshold int, cooldown time.Duration) error {
+	if threshold <= 0 {
+		return nil
+	}
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	switch breaker.stateLocked(now, cooldown) {
+	case BreakerOpen:
+		return &status.Error{
+			Status:     503,
+			Err:        fmt.Errorf("upstream circuit breaker is open after %d consecutive failures", breaker.failures),
+			RetryAfter: breaker.openedAt.Add(cooldown).Sub(now).Round(time.Second),
+		}
+	case BreakerHalfOpen:
+		if breaker.trial {
+			return status.Errorf(503, "upstream circuit breaker is testing recovery")
+		}
+		breaker.trial = true
+	}
+	return nil
+}
+
+// release gives back an allowed call that was never made
+func (breaker *circuitBreaker) release() {
+	breaker.Lock()
+	breaker.trial = false
+	breaker.Unlock()
+}
+
+// record counts the outcome of an allowed call, opening the breaker on the
+// threshold'th consecutive failure or a failed trial
+func (breaker *circuitBreaker) record(err error, now time.Time, threshold int) {
+	if threshold <= 0 {
+		return
+	}
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	trial := breaker.trial
+	breaker.trial = false
+	if err == nil {
+		if !breaker.openedAt.IsZero() {
+			log.Printf("WU circuit breaker closed, upstream recovered")
+		}
+		breaker.failures, breaker.openedAt = 0, time.Time{}
+		return
+	}
+
+	breaker.failures++
+	if trial || breaker.failures == threshold {
+		breaker.openedAt = now
+		wuBreakerOpens.Add(1)
+		log.Printf("Warning: WU circuit breaker opened after %d consecutive failures: %s", breaker.failures, err)
+	}
+}
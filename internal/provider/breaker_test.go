@@ -0,0 +1,98 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/status"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	var breaker circuitBreaker
+	cooldown := 30 * time.Second
+	now := testNow
+	failure := errors.New("upstream returned 500")
+
+	// closed until the threshold'th consecutive failure
+	for i := 1; i <= 2; i++ {
+		if err := breaker.allow(now, 2, cooldown); err != nil {
+			t.Fatalf("call %d refused while closed: %s", i, err)
+		}
+		breaker.record(failure, now, 2)
+	}
+	if got := breaker.state(now, cooldown); got != BreakerOpen {
+		t.Fatalf("state after 2 failures = %s, want open", got)
+	}
+
+	// open refuses with the time left in the cooldown
+	err := breaker.allow(now.Add(10*time.Second), 2, cooldown)
+	var statusErr *status.Error
+	if !errors.As(err, &statusErr) || statusErr.Status != 503 || statusErr.RetryAfter != 20*time.Second {
+		t.Fatalf("open allow = %v, want a 503 retrying after 20s", err)
+	}
+
+	// half-open lets one trial through, a failed trial opens it again
+	now = now.Add(cooldown)
+	if got := breaker.state(now, cooldown); got != BreakerHalfOpen {
+		t.Fatalf("state after the cooldown = %s, want half_open", got)
+	}
+	if err := breaker.allow(now, 2, cooldown); err != nil {
+		t.Fatalf("trial refused: %s", err)
+	}
+	if err := breaker.allow(now, 2, cooldown); err == nil {
+		t.Fatal("second call allowed while the trial is in flight")
+	}
+	breaker.record(failure, now, 2)
+	if got := breaker.state(now, cooldown); got != BreakerOpen {
+		t.Fatalf("state after a failed trial = %s, want open", got)
+	}
+
+	// a released trial can be retried, a successful one closes it
+	now = now.Add(cooldown)
+	breaker.allow(now, 2, cooldown)
+	breaker.release()
+	if err := breaker.allow(now, 2, cooldown); err != nil {
+		t.Fatalf("trial refused after a release: %s", err)
+	}
+	breaker.record(nil, now, 2)
+	if got := breaker.state(now, cooldown); got != BreakerClosed {
+		t.Fatalf("state after a good trial = %s, want closed", got)
+	}
+	if err := breaker.allow(now, 2, cooldown); err != nil {
+		t.Errorf("closed breaker refused: %s", err)
+	}
+}
+
+func TestBreakerPerProvider(t *testing.T) {
+	settings := map[string]string{"WU_BREAKER_FAILURES": "1", "WU_BREAKER_COOLDOWN": "30s"}
+	failing, _ := newTestProvider(t, settings)
+	calls := 0
+	failing.SetTransport(fakeWU(func(string, string) (int, string) {
+		calls++
+		return 500, ""
+	}))
+	healthy, _ := newTestProvider(t, settings)
+
+	if _, err := failing.Fetch(context.Background(), "conditions", "PA/Philadelphia"); err == nil {
+		t.Fatal("Fetch succeeded against a failing WU")
+	}
+	if _, err := failing.Fetch(context.Background(), "conditions", "PA/Philadelphia"); status.Code(err) != 503 {
+		t.Errorf("Fetch with the breaker open = %v, want a 503", err)
+	}
+	if calls != 1 {
+		t.Errorf("WU called %d times, want 1 before the breaker opened", calls)
+	}
+	if got := failing.BreakerState(); got != BreakerOpen {
+		t.Errorf("BreakerState = %s, want open", got)
+	}
+
+	// another Provider's breaker is its own
+	if _, err := healthy.Fetch(context.Background(), "conditions", "PA/Philadelphia"); err != nil {
+		t.Errorf("Fetch on another Provider = %s, want it closed", err)
+	}
+	if got := healthy.BreakerState(); got != BreakerClosed {
+		t.Errorf("BreakerState = %s, want closed", got)
+	}
+}
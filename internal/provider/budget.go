@@ -0,0 +1,67 @@
+package provider
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/status"
+)
+
+var (
+	wuBudgetRefused = expvar.NewInt("wu_budget_refused")
+	wuCallsToday    = expvar.NewInt("wu_calls_today")
+	wuBudget        = expvar.NewInt("wu_daily_budget")
+)
+
+// CallsKey counts the WU calls made on a UTC day
+func (provider *Provider) CallsKey(day time.Time) string {
+	return provider.config().RedisKey("upstream_calls", "wu", day.Format("2006-01-02"))
+}
+
+// NextUTCMidnight is when the day's call count starts over
+func NextUTCMidnight(now time.Time) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+// spendBudget counts a WU call against the UTC day's WU_DAILY_BUDGET and
+// refuses it once the budget is spent, so traffic spikes fall back to stale
+// data instead of getting the key suspended. The count lives in Redis so
+// replicas share it. Every call is counted, and a budget of 0 only disables
+// refusing. Redis errors let the call through.
+func (provider *Provider) spendBudget(ctx context.Context) error {
+	budget := int64(provider.config().WUDailyBudget)
+	warn := int64(provider.config().WUDailyBudgetWarn)
+
+	now := provider.now().UTC()
+	key := provider.CallsKey(now)
+	calls, err := provider.redis.Incr(ctx, key).Result()
+	if err != nil {
+		log.Printf("Error updating WU call count, allowing call: %s", err)
+		return nil
+	}
+	if calls == 1 {
+		provider.redis.Expire(ctx, key, 48*time.Hour)
+	}
+	wuCallsToday.Set(calls)
+
+	if warn > 0 && calls == warn {
+		log.Printf("Warning: %d WU calls made today, WU_DAILY_BUDGET_WARN is %d of a budget of %d", calls, warn, budget)
+	}
+	if budget <= 0 || calls <= budget {
+		return nil
+	}
+
+	if calls == budget+1 {
+		log.Printf("Warning: WU daily budget of %d calls is spent, refusing calls until midnight UTC", budget)
+	}
+	wuBudgetRefused.Add(1)
+	return &status.Error{
+		Status:     503,
+		Err:        fmt.Errorf("WU daily budget of %d calls is spent, calls resume at midnight UTC", budget),
+		RetryAfter: NextUTCMidnight(now).Sub(now).Round(time.Second),
+	}
+}
@@ -0,0 +1,84 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/status"
+)
+
+func TestBudgetCounts(t *testing.T) {
+	provider, mr := newTestProvider(t, map[string]string{"WU_DAILY_BUDGET": "5"})
+	key := provider.CallsKey(testNow.UTC())
+
+	for i := 1; i <= 3; i++ {
+		if err := provider.spendBudget(context.Background()); err != nil {
+			t.Fatalf("call %d: %s", i, err)
+		}
+	}
+	if got, _ := mr.Get(key); got != "3" {
+		t.Errorf("count = %q, want 3", got)
+	}
+	if got := wuCallsToday.Value(); got != 3 {
+		t.Errorf("wu_calls_today = %d, want 3", got)
+	}
+	// the count outlives its UTC day so the quota report can still read it
+	if got := mr.TTL(key); got != 48*time.Hour {
+		t.Errorf("TTL = %s, want 48h", got)
+	}
+}
+
+func TestBudgetWarn(t *testing.T) {
+	provider, _ := newTestProvider(t, map[string]string{"WU_DAILY_BUDGET": "5", "WU_DAILY_BUDGET_WARN": "2"})
+	logged := captureLog(t)
+
+	provider.spendBudget(context.Background())
+	if strings.Contains(logged.String(), "WU_DAILY_BUDGET_WARN") {
+		t.Errorf("warned below the threshold: %s", logged)
+	}
+	provider.spendBudget(context.Background())
+	provider.spendBudget(context.Background())
+	if got := strings.Count(logged.String(), "WU_DAILY_BUDGET_WARN"); got != 1 {
+		t.Errorf("warned %d times, want once at the threshold: %s", got, logged)
+	}
+}
+
+func TestBudgetRefuses(t *testing.T) {
+	provider, _ := newTestProvider(t, map[string]string{"WU_DAILY_BUDGET": "2"})
+	before := wuBudgetRefused.Value()
+
+	for i := 1; i <= 2; i++ {
+		if err := provider.spendBudget(context.Background()); err != nil {
+			t.Fatalf("call %d within the budget: %s", i, err)
+		}
+	}
+	err := provider.spendBudget(context.Background())
+	var statusErr *status.Error
+	if !errors.As(err, &statusErr) || statusErr.Status != 503 {
+		t.Fatalf("err = %v, want a 503", err)
+	}
+	// testNow is 19:00 UTC
+	if statusErr.RetryAfter != 5*time.Hour {
+		t.Errorf("RetryAfter = %s, want 5h to midnight UTC", statusErr.RetryAfter)
+	}
+	if got := wuBudgetRefused.Value() - before; got != 1 {
+		t.Errorf("wu_budget_refused grew by %d, want 1", got)
+	}
+}
+
+func TestBudgetMetric(t *testing.T) {
+	provider, _ := newTestProvider(t, map[string]string{"WU_DAILY_BUDGET": "7"})
+	if got := wuBudget.Value(); got != 7 {
+		t.Errorf("wu_daily_budget at startup = %d, want 7", got)
+	}
+
+	settings := *provider.config()
+	settings.WUDailyBudget = 9
+	provider.SetConfig(settings)
+	if got := wuBudget.Value(); got != 9 {
+		t.Errorf("wu_daily_budget after reload = %d, want 9", got)
+	}
+}
@@ -0,0 +1,155 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/config"
+	"github.com/tyrm/ph-weather/internal/status"
+)
+
+// callLocal answers a WU call from the mock or fixtures provider, which
+// need no key or network and don't count against the budget
+func (provider *Provider) callLocal(feature string, location string) (resString string, resError error) {
+	settings := provider.config()
+	switch settings.WeatherProvider {
+	case config.ProviderMock:
+		return MockWUResponse(feature, location, provider.now().In(settings.LocationTZ))
+	case config.ProviderFixtures:
+		return fixtureWUResponse(settings.WeatherFixturesDir, feature)
+	}
+	return "", fmt.Errorf("unknown weather provider %q", settings.WeatherProvider)
+}
+
+// fixtureWUResponse combines the canned responses in dir named after each
+// feature, such as astronomy.json, into one WU response
+func fixtureWUResponse(dir string, feature string) (resString string, resError error) {
+	combined := map[string]json.RawMessage{}
+	for _, name := range strings.Split(feature, "/") {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name+".json"))
+		if os.IsNotExist(err) {
+			resError = status.Errorf(502, "no fixture for %s in %s", name, dir)
+			return
+		} else if err != nil {
+			resError = status.Errorf(502, "Error reading fixture for %s: %s", name, err)
+			return
+		}
+
+		members := map[string]json.RawMessage{}
+		if err := json.Unmarshal(data, &members); err != nil {
+			resError = status.Errorf(502, "Error parsing fixture for %s: %s", name, err)
+			return
+		}
+		for member, value := range members {
+			combined[member] = value
+		}
+	}
+
+	body, err := json.Marshal(combined)
+	if err != nil {
+		resError = err
+		return
+	}
+	resString = string(body)
+	return
+}
+
+// mockCoordinates stand in for locations that aren't given as lat,lon
+var mockCoordinates = config.Coordinates{Latitude: 39.952, Longitude: -75.164}
+
+// MockWUResponse builds a WU response for feature from now alone, so the
+// same time always gives the same data: the sun rises at 06:30 and sets at
+// 18:45, conditions are fixed and there are no alerts. It is the mock
+// provider and can stand in for WU in handler tests.
+func MockWUResponse(feature string, location string, now time.Time) (string, error) {
+	coordinates := mockCoordinates
+	if parts := strings.Split(location, ","); len(parts) == 2 {
+		if parsed, err := config.ParseCoordinates(parts[0], parts[1]); err == nil {
+			coordinates = *parsed
+		}
+	}
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	members := map[string]interface{}{
+		"response": map[string]string{"version": "0.1"},
+	}
+	for _, name := range strings.Split(feature, "/") {
+		switch name {
+		case "alerts":
+			members["alerts"] = []WUAlert{}
+		case "astronomy":
+			members["sun_phase"] = WUSunPhase{
+				Sunrise: &WUTime{Hour: "6", Minute: "30"},
+				Sunset:  &WUTime{Hour: "18", Minute: "45"},
+			}
+			members["moon_phase"] = WUMoonPhase{
+				PercentIlluminated: "50",
+				AgeOfMoon:          "7",
+				PhaseOfMoon:        "First Quarter",
+				Hemisphere:         "North",
+				Moonrise:           &WUTime{Hour: "12", Minute: "15"},
+				Moonset:            &WUTime{Hour: "0", Minute: "40"},
+			}
+		case "conditions":
+			members["current_observation"] = WUObservation{
+				TempC:            "18.5",
+				RelativeHumidity: "65%",
+				ObservationEpoch: strconv.FormatInt(now.Truncate(5*time.Minute).Unix(), 10),
+				PressureMB:       "1015",
+				WindKPH:          "12",
+				WindDegrees:      "270",
+			}
+		case "geolookup":
+			members["location"] = WULocation{
+				Lat:    strconv.FormatFloat(coordinates.Latitude, 'f', -1, 64),
+				Lon:    strconv.FormatFloat(coordinates.Longitude, 'f', -1, 64),
+				TZLong: now.Location().String(),
+			}
+		case "hourly":
+			var hours []WUHour
+			start := now.Truncate(time.Hour).Add(time.Hour)
+			for i := 0; i < 36; i++ {
+				at := start.Add(time.Duration(i) * time.Hour)
+				tempC := 12 + at.Hour()/2
+				hour := WUHour{
+					Temp:      WUMeasurement{English: strconv.Itoa(tempC*9/5 + 32), Metric: strconv.Itoa(tempC)},
+					Condition: "Partly Cloudy",
+					Pop:       "10",
+					QPF:       WUMeasurement{English: "0.0", Metric: "0"},
+				}
+				hour.FCTTime.Epoch = strconv.FormatInt(at.Unix(), 10)
+				hours = append(hours, hour)
+			}
+			members["hourly_forecast"] = hours
+		case "tide":
+			var tide WUTide
+			for _, event := range []struct {
+				at     time.Duration
+				kind   string
+				height string
+			}{
+				{3*time.Hour + 10*time.Minute, "Low Tide", "0.2 ft"},
+				{9*time.Hour + 25*time.Minute, "High Tide", "5.8 ft"},
+				{15*time.Hour + 35*time.Minute, "Low Tide", "0.4 ft"},
+				{21*time.Hour + 50*time.Minute, "High Tide", "5.5 ft"},
+			} {
+				var summary WUTideSummary
+				summary.Date.Epoch = strconv.FormatInt(day.Add(event.at).Unix(), 10)
+				summary.Data.Type, summary.Data.Height = event.kind, event.height
+				tide.Tide.TideSummary = append(tide.Tide.TideSummary, summary)
+			}
+			members["tide"] = tide.Tide
+		default:
+			return "", status.Errorf(502, "the mock provider has no %s", name)
+		}
+	}
+
+	body, err := json.Marshal(members)
+	return string(body), err
+}
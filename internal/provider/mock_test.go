@@ -0,0 +1,65 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tyrm/ph-weather/internal/status"
+)
+
+func TestMockWUResponseStable(t *testing.T) {
+	first, err := MockWUResponse("astronomy/geolookup/conditions", "PA/Philadelphia", testNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := MockWUResponse("astronomy/geolookup/conditions", "PA/Philadelphia", testNow)
+	if first != second {
+		t.Errorf("MockWUResponse differs for the same time:\n%s\n%s", first, second)
+	}
+
+	var astronomy WUAstronomy
+	if err := json.Unmarshal([]byte(first), &astronomy); err != nil {
+		t.Fatal(err)
+	}
+	if astronomy.Location.Lat != "39.952" || astronomy.Location.TZLong != "America/New_York" {
+		t.Errorf("location = %+v, want the mock coordinates in the clock's zone", astronomy.Location)
+	}
+}
+
+func TestFixturesProvider(t *testing.T) {
+	dir := t.TempDir()
+	astronomy := `{"sun_phase":{"sunrise":{"hour":"5","minute":"32"},"sunset":{"hour":"20","minute":"33"}}}`
+	if err := os.WriteFile(filepath.Join(dir, "astronomy.json"), []byte(astronomy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	geolookup := `{"location":{"lat":"39.952","lon":"-75.164","tz_long":"America/New_York"}}`
+	if err := os.WriteFile(filepath.Join(dir, "geolookup.json"), []byte(geolookup), 0644); err != nil {
+		t.Fatal(err)
+	}
+	provider, _ := newTestProvider(t, map[string]string{"WEATHER_PROVIDER": "fixtures", "WEATHER_FIXTURES_DIR": dir})
+	provider.SetTransport(fakeWU(func(string, string) (int, string) {
+		t.Error("WU called with the fixtures provider")
+		return 500, ""
+	}))
+
+	// the features' fixtures are combined into one response
+	body, err := provider.Fetch(context.Background(), "astronomy/geolookup", "PA/Philadelphia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response WUAstronomy
+	if err := json.Unmarshal([]byte(body), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.SunPhase.Sunrise == nil || response.SunPhase.Sunrise.Hour != "5" || response.Location.TZLong != "America/New_York" {
+		t.Errorf("response = %s, want both fixtures", body)
+	}
+
+	// a feature without a fixture is an upstream error
+	if _, err := provider.Fetch(context.Background(), "tide", "PA/Philadelphia"); status.Code(err) != 502 {
+		t.Errorf("tide without a fixture = %v, want a 502", err)
+	}
+}
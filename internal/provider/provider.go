@@ -0,0 +1,203 @@
+// Package provider fetches weather from Weather Underground, or from the
+// mock and fixtures providers that stand in for it. WU calls are counted
+// against a daily budget and refused while the circuit breaker is open.
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/cache"
+	"github.com/tyrm/ph-weather/internal/config"
+	"github.com/tyrm/ph-weather/internal/status"
+	"github.com/tyrm/ph-weather/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// wuTimeout bounds a whole WU call, including reading the body
+const wuTimeout = 15 * time.Second
+
+// Provider makes every upstream weather call. A reload replaces its
+// configuration, the breaker and the day's call count carry over.
+type Provider struct {
+	settings  atomic.Value        // *config.Config
+	redis     cache.RedisCommands // holds the day's WU call count
+	transport http.RoundTripper   // routes through the upstream proxy
+	client    *http.Client        // the transport, recording when configured
+	now       func() time.Time
+
+	// breaker guards WU calls, set up from WU_BREAKER_FAILURES and
+	// WU_BREAKER_COOLDOWN
+	breaker circuitBreaker
+}
+
+// New sets up a Provider calling WU through transport, which NewTransport
+// builds, and counting the calls in redis. It reads the time from the system
+// clock, tests may replace the clock and the transport.
+func New(settings config.Config, redis cache.RedisCommands, transport http.RoundTripper) *Provider {
+	provider := &Provider{redis: redis, transport: transport, now: time.Now}
+	provider.client = &http.Client{Timeout: wuTimeout, Transport: withRecording(settings, transport)}
+	provider.SetConfig(settings)
+	return provider
+}
+
+func (provider *Provider) config() *config.Config {
+	return provider.settings.Load().(*config.Config)
+}
+
+// SetConfig replaces the configuration on reload
+func (provider *Provider) SetConfig(settings config.Config) {
+	provider.settings.Store(&settings)
+	wuBudget.Set(int64(settings.WUDailyBudget))
+}
+
+// SetClock replaces time.Now, so tests can fix the time
+func (provider *Provider) SetClock(now func() time.Time) {
+	provider.now = now
+}
+
+// SetTransport sends WU calls through transport as it is, for tests that
+// stand in for WU
+func (provider *Provider) SetTransport(transport http.RoundTripper) {
+	provider.client = &http.Client{Transport: transport}
+}
+
+// Transport is the proxied transport given to New, without recording, for
+// other upstream requests such as webhooks
+func (provider *Provider) Transport() http.RoundTripper {
+	return provider.transport
+}
+
+// Fetch makes one call to WU, counted against the budget and refused while
+// the circuit breaker is open. Other WEATHER_PROVIDERs answer instead of WU.
+func (provider *Provider) Fetch(ctx context.Context, feature string, location string) (resString string, resError error) {
+	settings := provider.config()
+
+	// a client span of its own rather than otelhttp's, which would record
+	// the URL and with it the key
+	ctx, span := tracing.Tracer.Start(ctx, "wu "+feature, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("provider", settings.WeatherProvider),
+		attribute.String("wu.feature", feature),
+		attribute.String("wu.location", location),
+	))
+	defer func() { tracing.EndSpan(span, resError) }()
+
+	if settings.WeatherProvider != config.ProviderWU {
+		return provider.callLocal(feature, location)
+	}
+
+	if resError = provider.breaker.allow(provider.now(), settings.WUBreakerFailures, settings.WUBreakerCooldown); resError != nil {
+		return
+	}
+	if resError = provider.spendBudget(ctx); resError != nil {
+		provider.breaker.release()
+		return
+	}
+
+	resString, resError = provider.call(ctx, feature, location)
+	if ctx.Err() != nil {
+		// a call cut short by the client says nothing about WU
+		provider.breaker.release()
+		return
+	}
+	provider.breaker.record(resError, provider.now(), settings.WUBreakerFailures)
+	return
+}
+
+// call sends one request to WU, recording the status on ctx's span
+func (provider *Provider) call(ctx context.Context, feature string, location string) (resString string, resError error) {
+	settings := provider.config()
+	wuURL := string("https://api.wunderground.com/api/" + settings.WUndergroundKey + "/" + feature + "/q/" + location + ".json")
+	request, err := http.NewRequestWithContext(ctx, "GET", wuURL, nil)
+	if err != nil {
+		resError = provider.redactError(err)
+		return
+	}
+	request.Header.Set("User-Agent", settings.HTTPUserAgent)
+	request = withUpstreamCall(request, upstreamCall{Provider: config.ProviderWU, Feature: feature, Location: location})
+
+	response, err := provider.client.Do(request)
+	if err != nil {
+		if resError = status.FromContext(ctx); resError == nil {
+			resError = provider.redactError(err)
+		}
+		return
+	}
+	defer response.Body.Close()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("http.response.status_code", response.StatusCode))
+
+	if response.StatusCode == http.StatusTooManyRequests {
+		retryAfter := parseRetryAfter(response.Header.Get("Retry-After"))
+		log.Printf("Warning: WU rate limit reached fetching %s, retry after %s", feature, retryAfter)
+		resError = &status.Error{Status: 503, Err: fmt.Errorf("upstream rate limit reached"), RetryAfter: retryAfter}
+		return
+	}
+	if response.StatusCode != http.StatusOK {
+		resError = status.Errorf(502, "upstream returned %s", response.Status)
+		return
+	}
+
+	// read one byte past the limit to tell a body of exactly the limit from a larger one
+	maxBodyBytes := int64(settings.WUMaxBodyBytes)
+	responseData, err := ioutil.ReadAll(io.LimitReader(response.Body, maxBodyBytes+1))
+	if err != nil {
+		resError = err
+		return
+	}
+	if int64(len(responseData)) > maxBodyBytes {
+		resError = status.Errorf(502, "upstream response exceeds %d bytes", maxBodyBytes)
+		return
+	}
+
+	resString = string(responseData)
+	return
+}
+
+// redactKey hides the WU key in an upstream URL or message
+func (provider *Provider) redactKey(value string) string {
+	key := provider.config().WUndergroundKey
+	if key == "" {
+		return value
+	}
+	return strings.Replace(value, key, "***", -1)
+}
+
+// redactError hides the WU key in HTTP client errors, in their URL and the
+// error they wrap, since errors reach logs and error responses
+func (provider *Provider) redactError(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		redacted := *urlErr
+		redacted.URL = provider.redactKey(urlErr.URL)
+		redacted.Err = provider.redactError(urlErr.Err)
+		return &redacted
+	}
+	if message := err.Error(); message != provider.redactKey(message) {
+		return errors.New(provider.redactKey(message))
+	}
+	return err
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP
+// date, 0 when it's absent or malformed
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait.Round(time.Second)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,94 @@
+package provider
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/tyrm/ph-weather/internal/cache"
+	"github.com/tyrm/ph-weather/internal/config"
+)
+
+// testTZ and testNow are where and when every test runs, a summer afternoon
+// in Philadelphia
+var testTZ, _ = time.LoadLocation("America/New_York")
+var testNow = time.Date(2024, 6, 20, 15, 0, 0, 0, testTZ)
+
+const testWUKey = "wu-test-key-0123"
+
+// roundTripFunc stands in for the network in a test
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+// fakeWU answers requests for /api/<key>/<feature>/q/<location>.json with
+// respond's status and body
+func fakeWU(respond func(feature string, location string) (status int, body string)) roundTripFunc {
+	return func(request *http.Request) (*http.Response, error) {
+		_, rest, _ := strings.Cut(strings.TrimPrefix(request.URL.Path, "/api/"), "/")
+		feature, location, _ := strings.Cut(rest, "/q/")
+		status, body := respond(feature, strings.TrimSuffix(location, ".json"))
+		return &http.Response{
+			StatusCode: status,
+			Status:     http.StatusText(status),
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(body)),
+			Request:    request,
+		}, nil
+	}
+}
+
+// mockWU answers every call with MockWUResponse at testNow
+func mockWU(feature string, location string) (int, string) {
+	body, _ := MockWUResponse(feature, location, testNow)
+	return 200, body
+}
+
+// newTestProvider configures a Provider from the environment like main
+// does, with settings overriding the test defaults. It counts calls in
+// miniredis, answers from mockWU and reads the time as testNow.
+func newTestProvider(t *testing.T, settings map[string]string) (*Provider, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+
+	defaults := map[string]string{
+		"CONFIG_FILE":         "",
+		"REDIS_ADDR":          mr.Addr(),
+		"REDIS_PREFIX":        "ph:",
+		"WU_KEY":              testWUKey,
+		"WU_LOCATION":         "PA/Philadelphia",
+		"LOCATION_TZ":         "America/New_York",
+		"WU_BREAKER_FAILURES": "0",
+	}
+	for name, value := range settings {
+		defaults[name] = value
+	}
+	for name, value := range defaults {
+		t.Setenv(name, value)
+	}
+
+	loaded, err := config.Collect()
+	if err != nil {
+		t.Fatalf("config.Collect: %s", err)
+	}
+	provider := New(loaded, cache.NewRedisClient(loaded), http.DefaultTransport)
+	provider.SetClock(func() time.Time { return testNow })
+	provider.SetTransport(fakeWU(mockWU))
+	return provider, mr
+}
+
+// captureLog collects what is logged until the test ends
+func captureLog(t *testing.T) *bytes.Buffer {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &logged
+}
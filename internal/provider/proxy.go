@@ -0,0 +1,46 @@
+package provider
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/tyrm/ph-weather/internal/config"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// NewTransport sends upstream requests, WU calls and webhooks, through
+// UPSTREAM_PROXY, or else the proxy named by HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY
+func NewTransport(config config.Config) (http.RoundTripper, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if config.UpstreamProxy != "" {
+		proxyURL, err := url.Parse(config.UpstreamProxy)
+		if err != nil {
+			return nil, err
+		}
+		if config.UpstreamProxyUsername != "" {
+			proxyURL.User = url.UserPassword(config.UpstreamProxyUsername, config.UpstreamProxyPassword)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+		log.Printf("Upstream requests use proxy %s from UPSTREAM_PROXY", proxyURL.Redacted())
+	} else {
+		// read now rather than through http.ProxyFromEnvironment, which only
+		// reads the environment on its first call in the process
+		proxyFunc := httpproxy.FromEnvironment().ProxyFunc()
+		transport.Proxy = func(request *http.Request) (*url.URL, error) {
+			return proxyFunc(request.URL)
+		}
+		wuURL, _ := url.Parse("https://api.wunderground.com/")
+		if proxyURL, err := proxyFunc(wuURL); err != nil {
+			return nil, err
+		} else if proxyURL != nil {
+			log.Printf("Upstream requests use proxy %s from the environment", proxyURL.Redacted())
+		} else {
+			log.Printf("Upstream requests are not proxied")
+		}
+	}
+
+	return transport, nil
+}
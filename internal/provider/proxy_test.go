@@ -0,0 +1,82 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// recordingProxy stands in for an egress proxy, refusing every tunnel after
+// noting where it was asked to go and with what credentials
+type recordingProxy struct {
+	sync.Mutex
+	targets        []string
+	authorizations []string
+}
+
+func (proxy *recordingProxy) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	proxy.Lock()
+	proxy.targets = append(proxy.targets, request.Method+" "+request.Host)
+	proxy.authorizations = append(proxy.authorizations, request.Header.Get("Proxy-Authorization"))
+	proxy.Unlock()
+	response.WriteHeader(http.StatusForbidden)
+}
+
+// proxiedProvider is a Provider calling WU through NewTransport
+func proxiedProvider(t *testing.T, settings map[string]string) *Provider {
+	t.Helper()
+	provider, _ := newTestProvider(t, settings)
+	transport, err := NewTransport(*provider.config())
+	if err != nil {
+		t.Fatalf("NewTransport: %s", err)
+	}
+	provider.SetTransport(transport)
+	return provider
+}
+
+func TestUpstreamProxy(t *testing.T) {
+	proxy := &recordingProxy{}
+	proxyServer := httptest.NewServer(proxy)
+	defer proxyServer.Close()
+
+	// UPSTREAM_PROXY wins over the environment
+	t.Setenv("HTTPS_PROXY", "http://127.0.0.1:1")
+	provider := proxiedProvider(t, map[string]string{
+		"UPSTREAM_PROXY":          "http://egress:s3cret@" + proxyServer.Listener.Addr().String(),
+		"UPSTREAM_PROXY_PASSWORD": "",
+	})
+
+	if _, err := provider.call(context.Background(), "astronomy", "PA/Philadelphia"); err == nil {
+		t.Fatal("call succeeded through a proxy that refuses every tunnel")
+	}
+
+	proxy.Lock()
+	defer proxy.Unlock()
+	if len(proxy.targets) != 1 || proxy.targets[0] != "CONNECT api.wunderground.com:443" {
+		t.Fatalf("proxy saw %v, want one CONNECT to WU", proxy.targets)
+	}
+	if want := "Basic " + base64.StdEncoding.EncodeToString([]byte("egress:s3cret")); proxy.authorizations[0] != want {
+		t.Errorf("Proxy-Authorization = %q, want %q", proxy.authorizations[0], want)
+	}
+}
+
+func TestUpstreamProxyFromEnvironment(t *testing.T) {
+	proxy := &recordingProxy{}
+	proxyServer := httptest.NewServer(proxy)
+	defer proxyServer.Close()
+
+	t.Setenv("HTTPS_PROXY", proxyServer.URL)
+	t.Setenv("NO_PROXY", "")
+	provider := proxiedProvider(t, nil)
+
+	provider.call(context.Background(), "astronomy", "PA/Philadelphia")
+
+	proxy.Lock()
+	defer proxy.Unlock()
+	if len(proxy.targets) != 1 || proxy.targets[0] != "CONNECT api.wunderground.com:443" {
+		t.Errorf("proxy saw %v, want one CONNECT to WU", proxy.targets)
+	}
+}
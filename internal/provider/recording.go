@@ -0,0 +1,158 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tyrm/ph-weather/internal/config"
+	"github.com/tyrm/ph-weather/internal/status"
+)
+
+// recordingTimeFormat sorts recordings of the same call oldest first
+const recordingTimeFormat = "20060102T150405.000Z"
+
+var (
+	recordingNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+	recordingSuffix     = regexp.MustCompile(`^\d{8}T\d{6}\.\d{3}Z_(\d{3})\.json$`)
+)
+
+// upstreamCallKey tags a request with its upstreamCall
+type upstreamCallKey struct{}
+
+// upstreamCall describes an upstream request for recordingTransport, which
+// can't tell the provider and feature from the URL alone
+type upstreamCall struct {
+	Provider string
+	Feature  string
+	Location string
+}
+
+// withUpstreamCall tags an upstream request with what it fetches
+func withUpstreamCall(request *http.Request, call upstreamCall) *http.Request {
+	return request.WithContext(context.WithValue(request.Context(), upstreamCallKey{}, call))
+}
+
+// recordingTransport writes every tagged upstream response body to
+// recordDir, or with replayDir set answers from the latest recording there
+// without touching the network. redact strips the key from bodies that echo
+// the request URL. Recorded bodies are read up to maxBodyBytes.
+type recordingTransport struct {
+	next         http.RoundTripper
+	recordDir    string
+	replayDir    string
+	maxBodyBytes int64
+	redact       func(string) string
+}
+
+// withRecording wraps next for UPSTREAM_RECORD_DIR or UPSTREAM_REPLAY_DIR,
+// returning it as it is when neither is set
+func withRecording(config config.Config, next http.RoundTripper) http.RoundTripper {
+	if config.UpstreamRecordDir == "" && config.UpstreamReplayDir == "" {
+		return next
+	}
+
+	key := config.WUndergroundKey
+	transport := &recordingTransport{
+		next:         next,
+		recordDir:    config.UpstreamRecordDir,
+		replayDir:    config.UpstreamReplayDir,
+		maxBodyBytes: int64(config.WUMaxBodyBytes),
+		redact: func(value string) string {
+			if key == "" {
+				return value
+			}
+			return strings.Replace(value, key, "***", -1)
+		},
+	}
+
+	if config.UpstreamReplayDir != "" {
+		log.Printf("Replaying upstream responses from %s, the network will not be used", config.UpstreamReplayDir)
+	} else {
+		log.Printf("Recording upstream responses to %s", config.UpstreamRecordDir)
+	}
+	return transport
+}
+
+func (transport *recordingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	call, ok := request.Context().Value(upstreamCallKey{}).(upstreamCall)
+	if !ok {
+		return transport.next.RoundTrip(request)
+	}
+	if transport.replayDir != "" {
+		return transport.replay(request, call)
+	}
+
+	response, err := transport.next.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// bounded like call's own read, which refuses anything longer
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, transport.maxBodyBytes+1))
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	response.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	name := recordingPrefix(call) + time.Now().UTC().Format(recordingTimeFormat) + "_" + strconv.Itoa(response.StatusCode) + ".json"
+	if err := ioutil.WriteFile(filepath.Join(transport.recordDir, name), []byte(transport.redact(string(body))), 0644); err != nil {
+		log.Printf("Error recording upstream response: %s", err)
+	}
+	return response, nil
+}
+
+// replay answers with the latest recording of call, keeping its status
+func (transport *recordingTransport) replay(request *http.Request, call upstreamCall) (*http.Response, error) {
+	prefix := recordingPrefix(call)
+	matches, err := filepath.Glob(filepath.Join(transport.replayDir, prefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+
+	for i := len(matches) - 1; i >= 0; i-- {
+		suffix := recordingSuffix.FindStringSubmatch(strings.TrimPrefix(filepath.Base(matches[i]), prefix))
+		if suffix == nil {
+			continue
+		}
+		body, err := ioutil.ReadFile(matches[i])
+		if err != nil {
+			return nil, err
+		}
+
+		status, _ := strconv.Atoi(suffix[1])
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+			StatusCode:    status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": {"application/json"}},
+			Body:          ioutil.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       request,
+		}, nil
+	}
+
+	return nil, status.Errorf(502, "no recording of %s %s for %s in %s", call.Provider, call.Feature, call.Location, transport.replayDir)
+}
+
+// recordingPrefix starts the file names of a call's recordings, which go on
+// with the time they were made and the response status
+func recordingPrefix(call upstreamCall) string {
+	return recordingNameUnsafe.ReplaceAllString(call.Provider, "-") + "_" +
+		recordingNameUnsafe.ReplaceAllString(call.Feature, "-") + "_" +
+		recordingNameUnsafe.ReplaceAllString(call.Location, "-") + "_"
+}
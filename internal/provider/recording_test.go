@@ -0,0 +1,65 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tyrm/ph-weather/internal/status"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	provider, _ := newTestProvider(t, nil)
+	dir := t.TempDir()
+	redact := func(value string) string { return strings.Replace(value, testWUKey, "***", -1) }
+
+	// WU echoes the request URL, key and all, in its body
+	echoing := fakeWU(func(feature string, location string) (int, string) {
+		_, body := mockWU(feature, location)
+		return 200, `{"echo":"/api/` + testWUKey + `/",` + body[1:]
+	})
+	provider.SetTransport(&recordingTransport{next: echoing, recordDir: dir, maxBodyBytes: 1 << 20, redact: redact})
+
+	recorded, err := provider.call(context.Background(), "astronomy", "PA/Philadelphia")
+	if err != nil {
+		t.Fatalf("call while recording: %s", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "wu_astronomy_PA-Philadelphia_*_200.json"))
+	if len(files) != 1 {
+		t.Fatalf("recordings = %v, want one", files)
+	}
+	saved, _ := os.ReadFile(files[0])
+	if strings.Contains(string(saved), testWUKey) {
+		t.Errorf("recording holds the key: %s", saved)
+	}
+
+	// replaying never reaches the network
+	offline := roundTripFunc(func(request *http.Request) (*http.Response, error) {
+		return nil, errors.New("network used while replaying")
+	})
+	provider.SetTransport(&recordingTransport{next: offline, replayDir: dir, redact: redact})
+
+	replayed, err := provider.call(context.Background(), "astronomy", "PA/Philadelphia")
+	if err != nil {
+		t.Fatalf("call while replaying: %s", err)
+	}
+	if replayed != redact(recorded) {
+		t.Errorf("replayed %s, want the recording %s", replayed, redact(recorded))
+	}
+
+	// the status is kept, and the latest recording wins
+	if err := os.WriteFile(filepath.Join(dir, "wu_astronomy_PA-Philadelphia_29990101T000000.000Z_503.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.call(context.Background(), "astronomy", "PA/Philadelphia"); err == nil || !strings.Contains(err.Error(), "returned 503") {
+		t.Errorf("replaying a 503 = %v, want the recorded 503", err)
+	}
+
+	if _, err := provider.call(context.Background(), "hourly", "PA/Philadelphia"); status.Code(err) != 502 || !strings.Contains(err.Error(), "no recording") {
+		t.Errorf("replaying without a recording = %v, want a 502", err)
+	}
+}
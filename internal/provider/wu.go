@@ -0,0 +1,117 @@
+package provider
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+type WUAstronomy struct {
+	Response  json.RawMessage `json:"response"`
+	Location  WULocation      `json:"location"`
+	MoonPhase WUMoonPhase     `json:"moon_phase"`
+	SunPhase  WUSunPhase      `json:"sun_phase"`
+}
+
+type WULocation struct {
+	Lat    string `json:"lat"`
+	Lon    string `json:"lon"`
+	TZLong string `json:"tz_long"`
+}
+
+// WUSunPhase times are nil when WU omitted the object entirely, which is
+// not the same as the blank times sent for polar days
+type WUSunPhase struct {
+	Sunrise *WUTime `json:"sunrise"`
+	Sunset  *WUTime `json:"sunset"`
+}
+
+type WUTime struct {
+	Hour   string `json:"hour"`
+	Minute string `json:"minute"`
+}
+
+type WUMoonPhase struct {
+	PercentIlluminated string  `json:"percentIlluminated"`
+	AgeOfMoon          string  `json:"ageOfMoon"`
+	PhaseOfMoon        string  `json:"phaseofMoon"`
+	Hemisphere         string  `json:"hemisphere"`
+	Moonrise           *WUTime `json:"moonrise"`
+	Moonset            *WUTime `json:"moonset"`
+}
+
+type WUAlerts struct {
+	Alerts []WUAlert `json:"alerts"`
+}
+
+type WUAlert struct {
+	Type         string `json:"type"`
+	Description  string `json:"description"`
+	Significance string `json:"significance"`
+	Message      string `json:"message"`
+	DateEpoch    string `json:"date_epoch"`
+	ExpiresEpoch string `json:"expires_epoch"`
+}
+
+type WUConditions struct {
+	CurrentObservation WUObservation `json:"current_observation"`
+}
+
+type WUObservation struct {
+	TempC            json.Number `json:"temp_c"`
+	RelativeHumidity string      `json:"relative_humidity"`
+	ObservationEpoch string      `json:"observation_epoch"`
+	PressureMB       json.Number `json:"pressure_mb"`
+	WindKPH          json.Number `json:"wind_kph"`
+	WindDegrees      json.Number `json:"wind_degrees"`
+}
+
+// Humidity parses WU's relative humidity, sent like "65%"
+func (observation WUObservation) Humidity() *float64 {
+	return ParseWUFloat(strings.TrimSuffix(observation.RelativeHumidity, "%"))
+}
+
+type WUHourly struct {
+	HourlyForecast []WUHour `json:"hourly_forecast"`
+}
+
+type WUHour struct {
+	FCTTime struct {
+		Epoch string `json:"epoch"`
+	} `json:"FCTTIME"`
+	Temp      WUMeasurement `json:"temp"`
+	Condition string        `json:"condition"`
+	Pop       string        `json:"pop"`
+	QPF       WUMeasurement `json:"qpf"`
+}
+
+// WUMeasurement is a value WU reports in both unit systems
+type WUMeasurement struct {
+	English string `json:"english"`
+	Metric  string `json:"metric"`
+}
+
+type WUTide struct {
+	Tide struct {
+		TideSummary []WUTideSummary `json:"tideSummary"`
+	} `json:"tide"`
+}
+
+type WUTideSummary struct {
+	Date struct {
+		Epoch string `json:"epoch"`
+	} `json:"date"`
+	Data struct {
+		Type   string `json:"type"`
+		Height string `json:"height"`
+	} `json:"data"`
+}
+
+// ParseWUFloat returns nil for values WU leaves blank or marks missing
+func ParseWUFloat(value string) *float64 {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f <= -999 {
+		return nil
+	}
+	return &f
+}
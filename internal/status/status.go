@@ -0,0 +1,61 @@
+// Package status carries the HTTP status a failure should be reported with
+// from wherever it happens to the response.
+package status
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Error carries the HTTP status a failure should be reported with.
+// RetryAfter, when set, is passed on to the client as Retry-After.
+type Error struct {
+	Status     int
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func Errorf(status int, format string, v ...interface{}) error {
+	return &Error{Status: status, Err: fmt.Errorf(format, v...)}
+}
+
+// FromContext reports a request whose client went away as a 499 and one
+// that ran past REQUEST_TIMEOUT as a 504, nil while ctx is live
+func FromContext(ctx context.Context) error {
+	switch ctx.Err() {
+	case context.Canceled:
+		return Errorf(499, "client closed the request")
+	case context.DeadlineExceeded:
+		return Errorf(504, "request took longer than the allowed time")
+	}
+	return nil
+}
+
+// Code is the HTTP status for err, 500 unless it wraps an Error
+func Code(err error) int {
+	var statusErr *Error
+	if errors.As(err, &statusErr) {
+		return statusErr.Status
+	}
+	return 500
+}
+
+// RetryAfter is how long a client should wait before retrying after err,
+// 0 when it doesn't say
+func RetryAfter(err error) time.Duration {
+	var statusErr *Error
+	if errors.As(err, &statusErr) {
+		return statusErr.RetryAfter
+	}
+	return 0
+}
@@ -0,0 +1,81 @@
+// Package tracing sets up OpenTelemetry tracing for ph-weather, exported
+// over OTLP when the standard OTEL_* variables configure it.
+package tracing
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/tyrm/ph-weather/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer makes the service's own spans. Until Setup installs a provider it
+// is a no-op, so spans cost nothing when tracing is off.
+var Tracer = otel.Tracer("github.com/tyrm/ph-weather")
+
+// enabled reports whether an OTLP endpoint is configured through the
+// standard OTEL_* environment variables
+func enabled() bool {
+	if disabled, _ := strconv.ParseBool(os.Getenv("OTEL_SDK_DISABLED")); disabled {
+		return false
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// Setup exports spans over OTLP/HTTP and accepts W3C traceparent from
+// callers. The exporter reads its endpoint, headers and timeouts from the
+// OTEL_EXPORTER_OTLP_* variables and OTEL_SERVICE_NAME overrides the
+// service name. The returned func flushes pending spans.
+func Setup(ctx context.Context) (shutdown func(context.Context) error, resError error) {
+	shutdown = func(context.Context) error { return nil }
+	if !enabled() {
+		log.Printf("Tracing disabled, OTEL_EXPORTER_OTLP_ENDPOINT is not set")
+		return
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		resError = err
+		return
+	}
+
+	// later options win, so OTEL_SERVICE_NAME replaces the default name
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			attribute.String("service.name", "ph-weather"),
+			attribute.String("service.version", config.Version),
+		),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		resError = err
+		return
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	log.Printf("Tracing enabled, exporting spans over OTLP")
+	shutdown = provider.Shutdown
+	return
+}
+
+// EndSpan records err, if any, on span and ends it
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -1,278 +1,167 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
+	_ "time/tzdata" // the scratch image has no zoneinfo
 
-	"github.com/go-redis/redis"
-	"github.com/google/jsonapi"
+	"github.com/tyrm/ph-weather/internal/api"
+	"github.com/tyrm/ph-weather/internal/cache"
+	"github.com/tyrm/ph-weather/internal/config"
+	"github.com/tyrm/ph-weather/internal/provider"
+	"github.com/tyrm/ph-weather/internal/tracing"
 )
 
-type Config struct {
-	HTTPPort             string
+// shutdownTimeout is how long in-flight requests get to finish on shutdown
+const shutdownTimeout = 10 * time.Second
 
-	RedisAddr            string
-	RedisDB              int
-	RedisPassword        string
-	RedisPrefix          string
-
-	WUndergroundKey      string
-	WUndergroundLocation string
-}
-
-type Env struct {
-	config *Config
-	redis  *redis.Client
-}
-
-type SunPhaseRespose struct {
-	ResponseID string `jsonapi:"primary,sun_phase"`
-	SunriseM   int    `jsonapi:"attr,sunrise_m"`
-	SunriseH   int    `jsonapi:"attr,sunrise_h"`
-	SunsetM    int    `jsonapi:"attr,sunset_m"`
-	SunsetH    int    `jsonapi:"attr,sunset_h"`
-}
-
-type WUAstronomy struct {
-	Response  json.RawMessage `json:"response"`
-	MoonPhase json.RawMessage `json:"moon_phase"`
-	SunPhase  WUSunPhase      `json:"sun_phase"`
-}
-
-type WUSunPhase struct {
-	Sunrise WUTime `json:"sunrise"`
-	Sunset  WUTime `json:"sunset"`
-}
-
-type WUTime struct {
-	Hour   string `json:"hour"`
-	Minute string `json:"minute"`
-}
-
-func collectConfig() (config Config) {
-	var missingEnv []string
-
-	// REDIS_DB
-	var envHTTPPort string = os.Getenv("HTTP_PORT")
-
-	if envHTTPPort == "" {
-		config.HTTPPort = ":8080"
-	} else {
-		config.HTTPPort = string(":" + envHTTPPort)
-	}
-
-	// REDIS_ADDR
-	config.RedisAddr = os.Getenv("REDIS_ADDR")
-	if config.RedisAddr == "" {
-		missingEnv = append(missingEnv, "REDIS_ADDR")
-	}
-
-	// REDIS_PASSWORD
-	config.RedisPassword = os.Getenv("REDIS_PASSWORD")
-
-	// REDIS_DB
-	var envRedisDB string = os.Getenv("REDIS_DB")
-
-	if envRedisDB == "" {
-		config.RedisDB = 0
-	} else {
-		i, err := strconv.Atoi(envRedisDB)
-		panicOnError(err, "Error parsing REDIS_DB")
-		config.RedisDB = i
-	}
-
-	// REDIS_PREFIX
-	var envRedisPrefix string = os.Getenv("REDIS_PREFIX")
-
-	if envRedisPrefix == "" {
-		config.RedisPrefix = "ph:"
-	} else {
-		config.RedisPrefix = envRedisPrefix
+func fatalOnError(err error, msg string) {
+	if err != nil {
+		log.Fatalf("%s: %s", msg, err)
 	}
+}
 
-	// WU_KEY
-	config.WUndergroundKey = os.Getenv("WU_KEY")
-	if config.WUndergroundKey == "" {
-		missingEnv = append(missingEnv, "WU_KEY")
+func main() {
+	configPath, args, err := config.ExtractFlag(os.Args[1:])
+	fatalOnError(err, "Invalid arguments")
+	config.FilePath = configPath
+
+	// the first argument names a subcommand, serving is the default
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
 	}
-
-	// WU_LOCATION
-	config.WUndergroundLocation = os.Getenv("WU_LOCATION")
-	if config.WUndergroundLocation == "" {
-		missingEnv = append(missingEnv, "WU_LOCATION")
+	if command == "serve" && len(args) > 0 && (args[0] == "--version" || args[0] == "-version") {
+		fmt.Println(config.VersionString())
+		return
 	}
 
-	// Validation
-	if len(missingEnv) > 0 {
-		var msg string = fmt.Sprintf("Environment variables missing: %v", missingEnv)
-		log.Fatal(msg)
-		panic(fmt.Sprint(msg))
+	config, err := config.Collect()
+	fatalOnError(err, "Invalid configuration")
+	transport, err := provider.NewTransport(config)
+	fatalOnError(err, "Invalid UPSTREAM_PROXY")
+
+	switch command {
+	case "serve":
+		serve(config, transport)
+	case "get":
+		os.Exit(api.RunGet(config, transport, args))
+	case "config":
+		os.Exit(runConfig(config, args))
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q, expected serve, get or config\n", command)
+		os.Exit(2)
 	}
-
-	return
 }
 
-func getWUApiRepose(key string, feature string, location string) (resString string, resError error) {
-	url := string("https://api.wunderground.com/api/" + key + "/" + feature + "/q/" + location + ".json")
-	response, err := http.Get(url)
-	if err != nil {
-		resError = err
-		log.Fatal(err)
-		return
-	}
-	defer response.Body.Close()
-
-	responseData, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		resError = err
-		log.Fatal(err)
-		return
+// runConfig handles config subcommands. validate prints the effective
+// configuration with secrets redacted; an invalid one already failed to load.
+func runConfig(config config.Config, args []string) int {
+	if len(args) != 1 || args[0] != "validate" {
+		fmt.Fprintf(os.Stderr, "Usage: ph-weather config validate [--config path]\n")
+		return 2
 	}
 
-	resString = string(responseData)
-	return
-}
-
-func getWUAstronomy(key string, feature string, location string) (response WUAstronomy, resError error) {
-	astronomy, err := getWUApiRepose(key, feature, location)
+	body, err := json.MarshalIndent(config.Redacted(), "", "  ")
 	if err != nil {
-		resError = err
-		return
+		fmt.Fprintf(os.Stderr, "Error printing configuration: %s\n", err)
+		return 1
 	}
-	json.Unmarshal([]byte(astronomy), &response)
-	return
+	fmt.Println(string(body))
+	return 0
 }
 
-func (env *Env) handleSunPhase(response http.ResponseWriter, request *http.Request) {
-	if request.Method == "GET" {
-		today := time.Now()
-		cacheKey := fmt.Sprintf("%sweather:sun_phase:%d-%s-%d", env.config.RedisPrefix, today.Year(), today.Month(), today.Day())
+// serve runs the HTTP server until SIGINT or SIGTERM, making upstream
+// requests through transport
+func serve(settings config.Config, transport http.RoundTripper) {
+	log.Printf("Starting %s", config.VersionString())
+	log.Printf("Configuration: %s", settings.RedactedJSON())
 
-		cacheVal, err := env.redis.Get(cacheKey).Result()
-		if err != nil && err != redis.Nil {
-			log.Println("Error reading cache: %s", err)
-		} else if err == nil {
-			// Send response'
-			response.Header().Set("Content-Type", jsonapi.MediaType)
-			fmt.Fprint(response, cacheVal)
-			return
-		}
-
-		astronomy, err := getWUAstronomy(env.config.WUndergroundKey,"astronomy", env.config.WUndergroundLocation)
-		if err != nil {
-			makeErrorResponse(response, 500, err.Error(), 0)
-		}
+	if settings.WeatherProvider != config.ProviderWU {
+		log.Printf("Using the %s weather provider, WU will not be called", settings.WeatherProvider)
+	}
 
-		SunriseH, err := strconv.Atoi(astronomy.SunPhase.Sunrise.Hour)
-		SunriseM, err := strconv.Atoi(astronomy.SunPhase.Sunrise.Minute)
-		SunsetH, err := strconv.Atoi(astronomy.SunPhase.Sunset.Hour)
-		SunsetM, err := strconv.Atoi(astronomy.SunPhase.Sunset.Minute)
+	// Connect to Redis
+	client := cache.NewRedisClient(settings)
 
-		responseObj := &SunPhaseRespose{ResponseID: cacheKey, SunriseH: SunriseH, SunriseM: SunriseM,
-			SunsetH: SunsetH, SunsetM: SunsetM}
+	pong, err := client.Ping(context.Background()).Result()
+	log.Printf("redis ping: %s", pong)
+	fatalOnError(err, "Failed to connect to Redis")
+	log.Println("Connected to Redis")
 
-		// Build Response
-		var eventPayload bytes.Buffer
-		if err := jsonapi.MarshalPayload(&eventPayload, responseObj); err != nil {
-			makeErrorResponse(response, 500, err.Error(), 0)
-			return
-		}
+	// Build the API
+	weather := provider.New(settings, client, transport)
+	weather.PublishBreakerState()
+	handler := api.New(settings, weather, cache.New(client, settings.L1CacheSize))
 
-		// Cache event for Pollers
-		var ttl time.Duration = time.Duration(168) * time.Hour
-		cacheErr := env.redis.Set(cacheKey, eventPayload.String(), ttl).Err()
-		if cacheErr != nil {
-			log.Println("Error commiting to cache: %s", cacheErr)
+	shutdownTracing, err := tracing.Setup(context.Background())
+	fatalOnError(err, "Failed to set up tracing")
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Printf("Error flushing traces: %s", err)
 		}
+	}()
 
-		// Send response
-		response.Header().Set("Content-Type", jsonapi.MediaType)
-		fmt.Fprint(response, eventPayload.String())
-		return
-	} else {
-		makeErrorResponse(response, 405, request.Method, 0)
-		return
+	// Background work runs until shutdown
+	background, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	if err := handler.Start(background); err != nil {
+		log.Fatal(err)
 	}
-}
-
-func makeErrorResponse(response http.ResponseWriter, status int, detail string, code int) {
-	var codeTitle map[int]string
-	codeTitle = make(map[int]string)
-	codeTitle[1] = "Malformed JSON Body"
-	codeTitle[2201] = "Missing Required Attribute"
-	codeTitle[2202] = "Requested Relationship Not Found"
-
-	var statusTitle map[int]string
-	statusTitle = make(map[int]string)
-	statusTitle[400] = "Bad Request"
-	statusTitle[401] = "Unauthorized"
-	statusTitle[404] = "Not Found"
-	statusTitle[405] = "Method Not Allowed"
-	statusTitle[406] = "Not Acceptable"
-	statusTitle[409] = "Conflict"
-	statusTitle[415] = "Unsupported Media Type"
-	statusTitle[422] = "Unprocessable Entity"
-	statusTitle[500] = "Internal Server Error"
-
-	var title string
-	var statusStr string = strconv.Itoa(status)
-	var codeStr string
+	defer handler.Close()
+
+	// Validate listen address
+	listenAddr := net.JoinHostPort(settings.HTTPAddr, settings.HTTPPort)
+	_, err = net.ResolveTCPAddr("tcp", listenAddr)
+	fatalOnError(err, "Invalid HTTP_ADDR/HTTP_PORT")
+
+	server := &http.Server{Addr: listenAddr, Handler: handler}
+
+	// Reload the configuration on SIGHUP
+	go func() {
+		reloads := make(chan os.Signal, 1)
+		signal.Notify(reloads, syscall.SIGHUP)
+		for range reloads {
+			log.Printf("Received SIGHUP, reloading configuration")
+			handler.Reload()
+		}
+	}()
+
+	// Shut down cleanly on SIGINT or SIGTERM
+	stopped := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		log.Printf("Received %s, shutting down", <-signals)
+		stopBackground()
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down HTTP server: %s", err)
+		}
+		close(stopped)
+	}()
 
-	// Get Title
-	if code == 0 { // code 0 means no code
-		title = statusTitle[status]
+	if settings.TLSEnabled() {
+		log.Printf("Ready, listening on %s (TLS)", listenAddr)
+		err = server.ListenAndServeTLS(settings.TLSCertFile, settings.TLSKeyFile)
 	} else {
-		title = codeTitle[code]
-		codeStr = strconv.Itoa(code)
+		log.Printf("Ready, listening on %s", listenAddr)
+		err = server.ListenAndServe()
 	}
-
-	// Send Response
-	response.WriteHeader(status)
-	response.Header().Set("Content-Type", jsonapi.MediaType)
-	jsonapi.MarshalErrors(response, []*jsonapi.ErrorObject{{
-		Title:  title,
-		Detail: detail,
-		Status: statusStr,
-		Code:   codeStr,
-	}})
-
-	return
-}
-
-func panicOnError(err error, msg string) {
-	if err != nil {
-		log.Fatalf("%s: %s", msg, err)
-		panic(fmt.Sprintf("%s: %s", msg, err))
+	if err != http.ErrServerClosed {
+		handler.Close()
+		fatalOnError(err, "HTTP server stopped")
 	}
-}
-
-func main() {
-	config := collectConfig()
-
-	// Connect to Redis
-	client := redis.NewClient(&redis.Options{
-		Addr:     config.RedisAddr,
-		Password: config.RedisPassword, // no password set
-		DB:       config.RedisDB,       // use default DB
-	})
-
-	pong, err := client.Ping().Result()
-	log.Printf("redis ping: %s", pong)
-	panicOnError(err, "Failed to connect to Redis")
-	log.Println("Connected to Redis")
-
-	// Build Environment
-	env := &Env{redis: client, config: &config}
-
-	http.HandleFunc("/weather/sun_phase/v1", env.handleSunPhase)
-	http.ListenAndServe(config.HTTPPort, nil)
-
-	log.Println("Ready")
+	<-stopped
 }
\ No newline at end of file
@@ -0,0 +1,99 @@
+// Package units converts weather measurements between unit systems. Values
+// are stored canonically in metric: °C, km/h, hPa and mm.
+package units
+
+import "fmt"
+
+type System string
+
+const (
+	Metric   System = "metric"
+	Imperial System = "imperial"
+	SI       System = "si"
+)
+
+// Kind is the kind of quantity a value measures
+type Kind int
+
+const (
+	Temperature Kind = iota
+	Speed
+	Pressure
+	Precipitation
+)
+
+// Parse returns the System named by s
+func Parse(s string) (System, error) {
+	switch System(s) {
+	case Metric, Imperial, SI:
+		return System(s), nil
+	}
+	return "", fmt.Errorf("units must be one of metric, imperial or si")
+}
+
+// Convert converts a canonical metric value of kind into system
+func (system System) Convert(kind Kind, value float64) float64 {
+	switch kind {
+	case Temperature:
+		if system == Imperial {
+			return value*9/5 + 32
+		}
+	case Speed:
+		switch system {
+		case Imperial:
+			return value / 1.609344
+		case SI:
+			return value / 3.6
+		}
+	case Pressure:
+		if system == Imperial {
+			return value / 33.8638866667
+		}
+	case Precipitation:
+		if system == Imperial {
+			return value / 25.4
+		}
+	}
+	return value
+}
+
+// Unit is the symbol for kind in system
+func (system System) Unit(kind Kind) string {
+	switch kind {
+	case Temperature:
+		if system == Imperial {
+			return "F"
+		}
+		return "C"
+	case Speed:
+		switch system {
+		case Imperial:
+			return "mph"
+		case SI:
+			return "m/s"
+		}
+		return "km/h"
+	case Pressure:
+		if system == Imperial {
+			return "inHg"
+		}
+		return "hPa"
+	case Precipitation:
+		if system == Imperial {
+			return "in"
+		}
+		return "mm"
+	}
+	return ""
+}
+
+// Units lists the symbol of every kind, for response meta
+func (system System) Units() map[string]string {
+	return map[string]string{
+		"system":        string(system),
+		"temperature":   system.Unit(Temperature),
+		"speed":         system.Unit(Speed),
+		"pressure":      system.Unit(Pressure),
+		"precipitation": system.Unit(Precipitation),
+	}
+}
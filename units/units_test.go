@@ -0,0 +1,63 @@
+package units
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, name := range []string{"metric", "imperial", "si"} {
+		if system, err := Parse(name); err != nil || string(system) != name {
+			t.Errorf("Parse(%q) = %q, %v", name, system, err)
+		}
+	}
+	for _, name := range []string{"", "Metric", "kelvin"} {
+		if _, err := Parse(name); err == nil {
+			t.Errorf("Parse(%q) accepted", name)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	for _, test := range []struct {
+		system System
+		kind   Kind
+		value  float64
+		want   float64
+		unit   string
+	}{
+		{Metric, Temperature, 20, 20, "C"},
+		{Imperial, Temperature, 100, 212, "F"},
+		{Imperial, Temperature, -40, -40, "F"},
+		{SI, Temperature, 20, 20, "C"},
+		{Metric, Speed, 36, 36, "km/h"},
+		{Imperial, Speed, 160.9344, 100, "mph"},
+		{SI, Speed, 36, 10, "m/s"},
+		{Metric, Pressure, 1013.25, 1013.25, "hPa"},
+		{Imperial, Pressure, 1013.25, 29.92, "inHg"},
+		{SI, Pressure, 1013.25, 1013.25, "hPa"},
+		{Metric, Precipitation, 25.4, 25.4, "mm"},
+		{Imperial, Precipitation, 25.4, 1, "in"},
+		{SI, Precipitation, 25.4, 25.4, "mm"},
+	} {
+		if got := test.system.Convert(test.kind, test.value); math.Abs(got-test.want) > 0.005 {
+			t.Errorf("%s.Convert(%d, %g) = %g, want %g", test.system, test.kind, test.value, got, test.want)
+		}
+		if got := test.system.Unit(test.kind); got != test.unit {
+			t.Errorf("%s.Unit(%d) = %q, want %q", test.system, test.kind, got, test.unit)
+		}
+	}
+}
+
+func TestUnits(t *testing.T) {
+	got := Imperial.Units()
+	want := map[string]string{"system": "imperial", "temperature": "F", "speed": "mph", "pressure": "inHg", "precipitation": "in"}
+	for name, unit := range want {
+		if got[name] != unit {
+			t.Errorf("Units()[%q] = %q, want %q", name, got[name], unit)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("Units() = %v, want %v", got, want)
+	}
+}